@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type AddTermResponse struct {
+	Success bool                    `json:"success"`
+	Message string                  `json:"message"`
+	EMITerm int                     `json:"emi_term"`
+	Term    TermWithLockDateAndCode `json:"term"`
+}
+
+// addTerm appends one installment to a device's schedule, for a customer
+// whose loan has been restructured. The new term gets the next term number,
+// a fresh activation code, and a lock date one term_duration after the last
+// term's scheduled lock date (ignoring any extension of that term), and
+// emi_term grows by one, all in one transaction.
+func addTerm(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "add_term_failed", "Failed to add term")
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the device so concurrent calls cannot pick the same term number
+	var deviceID string
+	var emiTerm, termDuration int
+	var emiStartDate time.Time
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, emi_term, term_duration, emi_start_date FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2) FOR UPDATE",
+		serialNumber, dealerArg(r),
+	).Scan(&deviceID, &emiTerm, &termDuration, &emiStartDate)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	var lastTerm int
+	var lastLockDate time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(term_number), 0),
+			COALESCE((SELECT lock_date - extension_days FROM lock_dates WHERE device_id = $1 ORDER BY term_number DESC LIMIT 1), $2::date)
+		FROM lock_dates WHERE device_id = $1
+	`, deviceID, emiStartDate).Scan(&lastTerm, &lastLockDate)
+	if err != nil {
+		errorf(ctx, "Error fetching last term for device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "add_term_failed", "Failed to add term")
+		return
+	}
+	if lastTerm >= maxEMITerm {
+		writeErrorWithDetails(w, http.StatusConflict, "term_limit_reached",
+			fmt.Sprintf("A device can have at most %d terms", maxEMITerm),
+			map[string]interface{}{"max_emi_term": maxEMITerm})
+		return
+	}
+
+	termNumber := lastTerm + 1
+	lockDate := lastLockDate.AddDate(0, 0, termDuration)
+
+	format, err := deviceCodeFormat(ctx, tx, deviceID)
+	if err != nil {
+		errorf(ctx, "Error fetching code format for device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "add_term_failed", "Failed to add term")
+		return
+	}
+	code, err := insertActivationCode(ctx, tx, deviceID, termNumber, format, activationCodeExpiry(emiStartDate))
+	if err != nil {
+		errorf(ctx, "Error inserting activation code: %v", err)
+		writeAPIError(w, r, err, "add_term_failed", "Failed to add term")
+		return
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO lock_dates (id, device_id, term_number, lock_date, is_locked, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		uuid.New().String(), deviceID, termNumber, lockDate, false, time.Now(),
+	)
+	if err != nil {
+		errorf(ctx, "Error inserting lock date: %v", err)
+		writeDBError(w, r, err, "add_term_failed", "Failed to add term")
+		return
+	}
+
+	if err = tx.QueryRowContext(ctx,
+		"UPDATE devices SET emi_term = emi_term + 1, version = version + 1 WHERE id = $1 RETURNING emi_term",
+		deviceID,
+	).Scan(&emiTerm); err != nil {
+		errorf(ctx, "Error updating device: %v", err)
+		writeDBError(w, r, err, "add_term_failed", "Failed to add term")
+		return
+	}
+
+	details := fmt.Sprintf("Added term %d, lock date %s", termNumber, lockDate.Format("2006-01-02"))
+	if err = appendAudit(ctx, tx, deviceID, "add_term", actorFromRequest(r), details); err != nil {
+		errorf(ctx, "Error writing audit log: %v", err)
+		writeDBError(w, r, err, "add_term_failed", "Failed to add term")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing new term: %v", err)
+		writeDBError(w, r, err, "add_term_failed", "Failed to add term")
+		return
+	}
+
+	response := AddTermResponse{
+		Success: true,
+		Message: fmt.Sprintf("Term %d added", termNumber),
+		EMITerm: emiTerm,
+		Term: TermWithLockDateAndCode{
+			Term:           termNumber,
+			LockDate:       lockDate.Format("2006-01-02"),
+			ActivationCode: code,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+// expectAddTermStart expects add-term to lock the device and find its last
+// term, lastTerm, scheduled on lastLockDate
+func expectAddTermStart(mock sqlmock.Sqlmock, emiTerm, lastTerm int, lastLockDate time.Time) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, emi_term, term_duration, emi_start_date FROM devices").WithArgs("TV100001", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "emi_term", "term_duration", "emi_start_date"}).AddRow("d1", emiTerm, 30, lastLockDate.AddDate(0, 0, -30*lastTerm)))
+	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(term_number\\), 0\\)").WithArgs("d1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"max", "lock_date"}).AddRow(lastTerm, lastLockDate))
+}
+
+func TestAddTermAppendsOneTerm(t *testing.T) {
+	mock := mockDB(t)
+	lastLockDate := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	newLockDate := lastLockDate.AddDate(0, 0, 30)
+
+	expectAddTermStart(mock, 6, 6, lastLockDate)
+	mock.ExpectQuery("SELECT dl.code_alphabet, dl.code_length").WithArgs("d1").
+		WillReturnRows(sqlmock.NewRows([]string{"code_alphabet", "code_length"}).AddRow(nil, nil))
+	mock.ExpectExec("SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO activation_codes").WithArgs(sqlmock.AnyArg(), "d1", sqlmock.AnyArg(), 7, false, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO lock_dates").WithArgs(sqlmock.AnyArg(), "d1", 7, newLockDate, false, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("UPDATE devices SET emi_term = emi_term \\+ 1").WithArgs("d1").
+		WillReturnRows(sqlmock.NewRows([]string{"emi_term"}).AddRow(7))
+	mock.ExpectExec("INSERT INTO audit_logs").WithArgs(sqlmock.AnyArg(), "d1", "add_term", sqlmock.AnyArg(), "Added term 7, lock date 2026-07-31", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/add-term", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body AddTermResponse
+	decodeResponse(t, rec, &body)
+	if body.EMITerm != 7 || body.Term.Term != 7 || body.Term.LockDate != "2026-07-31" || len(body.Term.ActivationCode) != defaultActivationCodeLength {
+		t.Errorf("response = %+v, want term 7 locking 2026-07-31 with a new code", body)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestAddTermRejectedAtTermLimit(t *testing.T) {
+	mock := mockDB(t)
+	expectAddTermStart(mock, maxEMITerm, maxEMITerm, time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC))
+	mock.ExpectRollback()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/add-term", ""))
+
+	assertStatus(t, rec, http.StatusConflict)
+	var body ErrorResponse
+	decodeResponse(t, rec, &body)
+	if body.Error.Code != "term_limit_reached" {
+		t.Errorf("error = %+v, want term_limit_reached", body.Error)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestAddedTermGrowsSchedule(t *testing.T) {
+	conn := integrationDB(t)
+	serialNumber := "IT" + strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+	t.Cleanup(func() {
+		conn.Exec("DELETE FROM devices WHERE serial_number = $1", serialNumber)
+	})
+	schedule := func() (terms, codes int, lastLockDate time.Time) {
+		t.Helper()
+		err := conn.QueryRow(`
+			SELECT (SELECT COUNT(*) FROM lock_dates WHERE device_id = d.id),
+				(SELECT COUNT(*) FROM activation_codes WHERE device_id = d.id),
+				(SELECT MAX(lock_date) FROM lock_dates WHERE device_id = d.id)
+			FROM devices d WHERE d.serial_number = $1
+		`, serialNumber).Scan(&terms, &codes, &lastLockDate)
+		if err != nil {
+			t.Fatalf("reading schedule: %v", err)
+		}
+		return terms, codes, lastLockDate
+	}
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", registrationBody(serialNumber, 3)))
+	assertStatus(t, rec, http.StatusOK)
+	terms, codes, lastLockDate := schedule()
+
+	rec = serve(apiRequest(t, http.MethodPost, "/api/device/"+serialNumber+"/add-term", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body AddTermResponse
+	decodeResponse(t, rec, &body)
+	newTerms, newCodes, newLastLockDate := schedule()
+	if newTerms != terms+1 || newCodes != codes+1 {
+		t.Errorf("schedule went from %d terms and %d codes to %d and %d, want one more of each", terms, codes, newTerms, newCodes)
+	}
+	if want := lastLockDate.AddDate(0, 0, 30); !newLastLockDate.Equal(want) {
+		t.Errorf("new last lock date = %v, want %v", newLastLockDate, want)
+	}
+	if body.EMITerm != 4 || body.Term.Term != 4 {
+		t.Errorf("response = %+v, want term 4 of 4", body)
+	}
+}
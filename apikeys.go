@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// APIKeyInfo describes one configured operator key without revealing it.
+// KeyID is the identifier recorded as the actor in audit logs, and Current
+// marks the key the request was made with.
+type APIKeyInfo struct {
+	Index   int    `json:"index"`
+	KeyID   string `json:"key_id"`
+	Primary bool   `json:"primary"`
+	Current bool   `json:"current"`
+}
+
+type APIKeysResponse struct {
+	Success bool         `json:"success"`
+	Total   int          `json:"total"`
+	Keys    []APIKeyInfo `json:"keys"`
+}
+
+// getAPIKeys lists the operator keys configured in API_KEYS by index and
+// identifier, for checking a rotation: after a new key is made primary and
+// clients have switched, the audit log and the api_key_auth log events show
+// whether the old key is still in use before it is removed.
+func getAPIKeys(w http.ResponseWriter, r *http.Request) {
+	actor := actorFromRequest(r)
+	keys := make([]APIKeyInfo, 0)
+	for i, key := range operatorAPIKeys() {
+		identifier := apiKeyIdentifier(key)
+		keys = append(keys, APIKeyInfo{
+			Index:   i,
+			KeyID:   identifier,
+			Primary: i == 0,
+			Current: identifier == actor,
+		})
+	}
+
+	response := APIKeysResponse{
+		Success: true,
+		Total:   len(keys),
+		Keys:    keys,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
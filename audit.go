@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type AuditLog struct {
+	ID        string    `json:"id"`
+	DeviceID  string    `json:"device_id"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	Details   string    `json:"details"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Page size bounds for the audit log
+const (
+	defaultAuditLogLimit = 50
+	maxAuditLogLimit     = 200
+)
+
+type AuditLogResponse struct {
+	Success bool       `json:"success"`
+	Total   int        `json:"total"` // Entries matching the filters, across all pages
+	Limit   int        `json:"limit"`
+	Offset  int        `json:"offset"`
+	Logs    []AuditLog `json:"logs"`
+}
+
+// appendAudit records an action against a device as part of the caller's
+// transaction, so the log entry commits or rolls back with the change itself
+func appendAudit(ctx context.Context, tx *sql.Tx, deviceID, action, actor, details string) error {
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO audit_logs (id, device_id, action, actor, details, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		uuid.New().String(), deviceID, action, actor, details, time.Now(),
+	)
+	return err
+}
+
+// parseDateParam reads an optional YYYY-MM-DD query parameter, reporting
+// false when it is present but not a valid date
+func parseDateParam(r *http.Request, name string) (*time.Time, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, true
+	}
+	date, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		return nil, false
+	}
+	return &date, true
+}
+
+// getDeviceAudit lists a device's audit log newest first, a page at a time.
+// It can be narrowed to one action and to a from/to date range, both ends
+// inclusive and taken in UTC.
+func getDeviceAudit(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	limit, ok := parseNonNegativeInt(r, "limit", defaultAuditLogLimit)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_limit", "limit must be a non-negative integer")
+		return
+	}
+	if limit > maxAuditLogLimit {
+		limit = maxAuditLogLimit
+	}
+	offset, ok := parseNonNegativeInt(r, "offset", 0)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_offset", "offset must be a non-negative integer")
+		return
+	}
+
+	from, ok := parseDateParam(r, "from")
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_date", "from must be a date in YYYY-MM-DD format")
+		return
+	}
+	to, ok := parseDateParam(r, "to")
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_date", "to must be a date in YYYY-MM-DD format")
+		return
+	}
+	if from != nil && to != nil && from.After(*to) {
+		writeError(w, http.StatusBadRequest, "invalid_date_range", "from must not be after to")
+		return
+	}
+	// to covers its whole day
+	var before *time.Time
+	if to != nil {
+		next := to.AddDate(0, 0, 1)
+		before = &next
+	}
+	action := sql.NullString{String: r.URL.Query().Get("action"), Valid: r.URL.Query().Get("action") != ""}
+
+	// Find device
+	var deviceID string
+	err := db.QueryRowContext(ctx,
+		"SELECT id FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2)",
+		serialNumber, dealerArg(r),
+	).Scan(&deviceID)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	filter := `
+		WHERE device_id = $1
+		  AND ($2::text IS NULL OR action = $2)
+		  AND ($3::timestamptz IS NULL OR created_at >= $3)
+		  AND ($4::timestamptz IS NULL OR created_at < $4)`
+	args := []interface{}{deviceID, action, from, before}
+
+	var total int
+	if err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_logs"+filter, args...).Scan(&total); err != nil {
+		errorf(ctx, "Error counting audit logs for device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch audit log")
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, device_id, action, actor, COALESCE(details, ''), created_at
+		FROM audit_logs`+filter+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT $5 OFFSET $6
+	`, append(args, limit, offset)...)
+	if err != nil {
+		errorf(ctx, "Error fetching audit logs for device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch audit log")
+		return
+	}
+	defer rows.Close()
+
+	logs := make([]AuditLog, 0)
+	for rows.Next() {
+		var entry AuditLog
+		if err := rows.Scan(&entry.ID, &entry.DeviceID, &entry.Action, &entry.Actor, &entry.Details, &entry.CreatedAt); err != nil {
+			errorf(ctx, "Error scanning audit log: %v", err)
+			continue
+		}
+		logs = append(logs, entry)
+	}
+
+	response := AuditLogResponse{
+		Success: true,
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+		Logs:    logs,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestLockThenUnlockWritesOrderedAuditRows(t *testing.T) {
+	mock := mockDB(t)
+	var lockAction, lockAt, unlockAction, unlockAt captured
+
+	expectDeviceLookup(mock, "d1")
+	mock.ExpectBegin()
+	expectRemoteLockWrite(mock, "d1", true, &lockAction, &lockAt)
+	mock.ExpectCommit()
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/lock", ""))
+	assertStatus(t, rec, http.StatusOK)
+
+	expectDeviceLookup(mock, "d1")
+	mock.ExpectBegin()
+	expectRemoteLockWrite(mock, "d1", false, &unlockAction, &unlockAt)
+	mock.ExpectCommit()
+	rec = serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/unlock", ""))
+	assertStatus(t, rec, http.StatusOK)
+	assertExpectations(t, mock)
+
+	if lockAction.value != "lock" || unlockAction.value != "unlock" {
+		t.Fatalf("audit actions = %v, %v, want lock, unlock", lockAction.value, unlockAction.value)
+	}
+
+	// Serve the two rows back as stored and check they are listed newest first
+	expectDeviceLookup(mock, "d1")
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM audit_logs").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery("ORDER BY created_at DESC, id DESC").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "device_id", "action", "actor", "details", "created_at"}).
+			AddRow("a2", "d1", unlockAction.value, "api_key", "", unlockAt.value).
+			AddRow("a1", "d1", lockAction.value, "api_key", "", lockAt.value))
+
+	rec = serve(apiRequest(t, http.MethodGet, "/api/device/TV100001/audit", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body AuditLogResponse
+	decodeResponse(t, rec, &body)
+	if len(body.Logs) != 2 || body.Logs[0].Action != "unlock" || body.Logs[1].Action != "lock" {
+		t.Fatalf("logs = %+v, want unlock then lock", body.Logs)
+	}
+	if !body.Logs[0].CreatedAt.After(body.Logs[1].CreatedAt) {
+		t.Errorf("unlock at %s is not after lock at %s", body.Logs[0].CreatedAt, body.Logs[1].CreatedAt)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestAuditLogFilters(t *testing.T) {
+	mock := mockDB(t)
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)
+	loggedAt := time.Date(2026, 3, 7, 23, 0, 0, 0, time.UTC)
+
+	expectDeviceLookup(mock, "d1")
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM audit_logs").WithArgs("d1", "lock", from, before).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery("ORDER BY created_at DESC, id DESC").WithArgs("d1", "lock", from, before, 1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "device_id", "action", "actor", "details", "created_at"}).
+			AddRow("a1", "d1", "lock", "api_key", "", loggedAt))
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/device/TV100001/audit?action=lock&from=2026-03-01&to=2026-03-07&limit=1&offset=2", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body AuditLogResponse
+	decodeResponse(t, rec, &body)
+	if body.Total != 3 || body.Limit != 1 || body.Offset != 2 || len(body.Logs) != 1 {
+		t.Errorf("response = %+v, want the third of three lock entries", body)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestAuditLogRejectsInvalidDates(t *testing.T) {
+	for query, wantCode := range map[string]string{
+		"from=03/01/2026":               "invalid_date",
+		"to=2026-02-30":                 "invalid_date",
+		"from=2026-03-08&to=2026-03-07": "invalid_date_range",
+	} {
+		mock := mockDB(t)
+
+		rec := serve(apiRequest(t, http.MethodGet, "/api/device/TV100001/audit?"+query, ""))
+
+		assertStatus(t, rec, http.StatusBadRequest)
+		var body ErrorResponse
+		decodeResponse(t, rec, &body)
+		if body.Error.Code != wantCode {
+			t.Errorf("%s: error code = %q, want %q", query, body.Error.Code, wantCode)
+		}
+		assertExpectations(t, mock)
+	}
+}
+
+// Seeds varied actions in a real database, so the filter SQL itself is
+// exercised
+func TestAuditLogFiltersSeededEntries(t *testing.T) {
+	conn := integrationDB(t)
+	serialNumber := "IT" + strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+	var deviceID string
+	err := conn.QueryRow(`
+		INSERT INTO devices (serial_number, customer_name, phone_number, emi_term, emi_start_date, term_duration)
+		VALUES ($1, 'Audit Customer', '+15551234567', 1, CURRENT_DATE, 30) RETURNING id
+	`, serialNumber).Scan(&deviceID)
+	if err != nil {
+		t.Fatalf("seeding device: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Exec("DELETE FROM devices WHERE id = $1", deviceID)
+	})
+	for _, seed := range []struct {
+		action string
+		at     string
+	}{
+		{"lock", "2026-02-28T12:00:00Z"},
+		{"lock", "2026-03-01T00:00:00Z"},
+		{"unlock", "2026-03-03T12:00:00Z"},
+		{"lock", "2026-03-07T23:59:00Z"},
+		{"lock", "2026-03-08T00:00:00Z"},
+	} {
+		_, err := conn.Exec("INSERT INTO audit_logs (id, device_id, action, actor, details, created_at) VALUES ($1, $2, $3, 'test', '', $4)",
+			uuid.New().String(), deviceID, seed.action, seed.at)
+		if err != nil {
+			t.Fatalf("seeding audit log: %v", err)
+		}
+	}
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/device/"+serialNumber+"/audit?action=lock&from=2026-03-01&to=2026-03-07", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body AuditLogResponse
+	decodeResponse(t, rec, &body)
+	if body.Total != 2 || len(body.Logs) != 2 {
+		t.Fatalf("logs = %+v, want the two locks inside the range", body.Logs)
+	}
+	if got := body.Logs[0].CreatedAt.UTC().Format(time.RFC3339); got != "2026-03-07T23:59:00Z" {
+		t.Errorf("first entry at %s, want the newest lock first", got)
+	}
+}
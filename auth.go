@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type contextKey string
+
+const (
+	actorContextKey  contextKey = "actor"
+	dealerContextKey contextKey = "dealer"
+)
+
+// operatorAPIKeys returns the operator keys from the comma-separated
+// API_KEYS, or the single API_KEY when API_KEYS is unset. The first key is the
+// primary, the one to hand out to new clients; the others stay valid so an
+// old key can be retired once nothing uses it any more.
+func operatorAPIKeys() []string {
+	raw, ok := os.LookupEnv("API_KEYS")
+	if !ok {
+		raw = os.Getenv("API_KEY")
+	}
+	keys := make([]string, 0)
+	for _, key := range strings.Split(raw, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// matchOperatorKey returns the index in operatorAPIKeys of the key provided,
+// or -1 when it is none of them
+func matchOperatorKey(keys []string, provided string) int {
+	for i, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(key)) == 1 {
+			return i
+		}
+	}
+	return -1
+}
+
+// authMiddleware requires a valid X-API-Key header. The keys in API_KEYS (or
+// API_KEY) are operator keys and see every device; any other key must belong
+// to a dealer, whose requests are scoped to that dealer's devices. The index
+// of the operator key used is logged, never the key, so clients still on a
+// key being retired can be found. When no operator key is configured and the
+// key is not a dealer's, the protected routes fail closed so the admin
+// surface is never left open by accident.
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		apiKeys := operatorAPIKeys()
+		provided := r.Header.Get("X-API-Key")
+
+		if index := matchOperatorKey(apiKeys, provided); index >= 0 {
+			identifier := apiKeyIdentifier(apiKeys[index])
+			level := slog.LevelDebug
+			if index > 0 {
+				level = slog.LevelInfo
+			}
+			logEvent(ctx, level, "api_key_auth", fmt.Sprintf("Authenticated with operator API key %d", index),
+				slog.Int("key_index", index), slog.String("key_id", identifier), slog.Bool("primary", index == 0))
+			ctx = context.WithValue(ctx, actorContextKey, identifier)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		if provided != "" {
+			var dealerID string
+			err := db.QueryRowContext(ctx, "SELECT id FROM dealers WHERE api_key_hash = $1", hashAPIKey(provided)).Scan(&dealerID)
+			if err == nil {
+				ctx = context.WithValue(ctx, dealerContextKey, dealerID)
+				ctx = context.WithValue(ctx, actorContextKey, "dealer:"+dealerID)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+			if err != sql.ErrNoRows {
+				errorf(ctx, "Error looking up dealer API key: %v", err)
+				writeDBError(w, r, err, "auth_failed", "Failed to authenticate request")
+				return
+			}
+		}
+
+		if len(apiKeys) == 0 {
+			warnf(ctx, "Neither API_KEYS nor API_KEY is set, rejecting request to %s", r.URL.Path)
+			writeError(w, http.StatusServiceUnavailable, "auth_not_configured", "API authentication is not configured")
+			return
+		}
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing or invalid API key")
+	})
+}
+
+// hashAPIKey returns the hex SHA-256 of an API key, which is what the dealers
+// table stores instead of the key itself
+func hashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyIdentifier derives a short, non-secret identifier for an API key so
+// it can be recorded as an actor without storing the key itself
+func apiKeyIdentifier(apiKey string) string {
+	return "api_key:" + hashAPIKey(apiKey)[:8]
+}
+
+// actorFromRequest returns the authenticated actor for a request, or
+// "anonymous" when the route is not behind authMiddleware
+func actorFromRequest(r *http.Request) string {
+	if actor, ok := r.Context().Value(actorContextKey).(string); ok && actor != "" {
+		return actor
+	}
+	return "anonymous"
+}
+
+// dealerFromRequest returns the dealer a request is scoped to, or "" for the
+// operator key and unauthenticated routes
+func dealerFromRequest(r *http.Request) string {
+	dealerID, _ := r.Context().Value(dealerContextKey).(string)
+	return dealerID
+}
+
+// dealerArg is the query argument for a dealer_id filter written as
+// "($n::uuid IS NULL OR dealer_id = $n)": NULL matches every device, so the
+// operator key is unscoped.
+func dealerArg(r *http.Request) interface{} {
+	if dealerID := dealerFromRequest(r); dealerID != "" {
+		return dealerID
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestOperatorAPIKeys(t *testing.T) {
+	t.Setenv("API_KEY", "single-key")
+	t.Setenv("API_KEYS", " new-key ,, old-key ")
+	if got := operatorAPIKeys(); !slices.Equal(got, []string{"new-key", "old-key"}) {
+		t.Errorf("with API_KEYS, keys = %q, want new-key then old-key", got)
+	}
+
+	os.Unsetenv("API_KEYS")
+	if got := operatorAPIKeys(); !slices.Equal(got, []string{"single-key"}) {
+		t.Errorf("without API_KEYS, keys = %q, want the API_KEY", got)
+	}
+}
+
+func TestMatchOperatorKey(t *testing.T) {
+	keys := []string{"new-key", "old-key"}
+	for provided, want := range map[string]int{"new-key": 0, "old-key": 1, "other-key": -1, "": -1, "new-key ": -1} {
+		if got := matchOperatorKey(keys, provided); got != want {
+			t.Errorf("matchOperatorKey(%q) = %d, want %d", provided, got, want)
+		}
+	}
+}
+
+func TestTwoOperatorKeysBothAuthenticate(t *testing.T) {
+	const primary, retiring = "new-operator-key", "old-operator-key"
+	for index, key := range []string{primary, retiring} {
+		t.Run(key, func(t *testing.T) {
+			buf := captureLogs(t, slog.LevelDebug)
+			mock := mockDB(t)
+			r := apiRequest(t, http.MethodGet, "/api/admin/api-keys", "")
+			t.Setenv("API_KEYS", primary+","+retiring)
+			r.Header.Set("X-API-Key", key)
+
+			rec := serve(r)
+
+			assertStatus(t, rec, http.StatusOK)
+			var body APIKeysResponse
+			decodeResponse(t, rec, &body)
+			if body.Total != 2 || !body.Keys[index].Current || body.Keys[1-index].Current || !body.Keys[0].Primary {
+				t.Errorf("keys = %+v, want key %d marked current and key 0 primary", body.Keys, index)
+			}
+
+			var auth map[string]interface{}
+			for _, fields := range logLines(t, buf) {
+				if fields["event"] == "api_key_auth" {
+					auth = fields
+				}
+			}
+			if auth == nil || auth["key_index"] != float64(index) || auth["primary"] != (index == 0) {
+				t.Errorf("api_key_auth event = %v, want key_index %d", auth, index)
+			}
+			if strings.Contains(buf.String(), key) {
+				t.Errorf("logs contain the API key itself: %s", buf.String())
+			}
+			assertExpectations(t, mock)
+		})
+	}
+}
+
+func TestRetiredOperatorKeyIsRejected(t *testing.T) {
+	mock := mockDB(t)
+	r := apiRequest(t, http.MethodGet, "/api/admin/api-keys", "")
+	t.Setenv("API_KEYS", "new-operator-key")
+	r.Header.Set("X-API-Key", "old-operator-key")
+	mock.ExpectQuery("SELECT id FROM dealers WHERE api_key_hash").WithArgs(hashAPIKey("old-operator-key")).
+		WillReturnError(sql.ErrNoRows)
+
+	rec := serve(r)
+
+	assertStatus(t, rec, http.StatusUnauthorized)
+	assertExpectations(t, mock)
+}
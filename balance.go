@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxInstallmentAmount is the largest amount devices.installment_amount,
+// a NUMERIC(12, 2), can hold
+const maxInstallmentAmount = 9999999999.99
+
+// validateInstallmentAmount checks that an installment is a positive amount
+// with at most two decimal places that fits the column
+func validateInstallmentAmount(amount float64) error {
+	if amount <= 0 || math.IsNaN(amount) {
+		return errors.New("installment_amount must be positive")
+	}
+	if amount > maxInstallmentAmount {
+		return fmt.Errorf("installment_amount must be at most %.2f", maxInstallmentAmount)
+	}
+	if cents := amount * 100; math.Abs(cents-math.Round(cents)) > 1e-6 {
+		return errors.New("installment_amount may have at most two decimal places")
+	}
+	return nil
+}
+
+// outstandingBalance is what a customer still owes: the unpaid terms times
+// the installment amount, rounded to cents. It is nil when the device has no
+// installment amount.
+func outstandingBalance(installmentAmount *float64, unpaidTerms int) *float64 {
+	if installmentAmount == nil {
+		return nil
+	}
+	cents := math.Round(*installmentAmount*100) * float64(unpaidTerms)
+	balance := cents / 100
+	return &balance
+}
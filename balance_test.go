@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestBalanceDecreasesAsTermsArePaid(t *testing.T) {
+	device := testDevice("d1", "TV100001")
+	device.EMITerm = 3
+	amount := 1499.99
+	device.InstallmentAmount = &amount
+
+	tests := []struct {
+		paid []bool
+		want float64
+	}{
+		{[]bool{false, false, false}, 4499.97},
+		{[]bool{true, false, false}, 2999.98},
+		{[]bool{true, true, false}, 1499.99},
+		{[]bool{true, true, true}, 0},
+	}
+	previous := math.Inf(1)
+	for _, tt := range tests {
+		mock := mockDB(t)
+		expectDeviceDetail(mock, device, tt.paid...)
+
+		rec := serve(apiRequest(t, http.MethodGet, "/api/device/TV100001", ""))
+
+		assertStatus(t, rec, http.StatusOK)
+		var body DeviceDetailResponse
+		decodeResponse(t, rec, &body)
+		if body.OutstandingBalance == nil || *body.OutstandingBalance != tt.want {
+			t.Errorf("paid %v: outstanding_balance = %v, want %.2f", tt.paid, body.OutstandingBalance, tt.want)
+		} else if *body.OutstandingBalance >= previous {
+			t.Errorf("paid %v: outstanding_balance %.2f did not decrease from %.2f", tt.paid, *body.OutstandingBalance, previous)
+		} else {
+			previous = *body.OutstandingBalance
+		}
+		assertExpectations(t, mock)
+	}
+}
+
+func TestBalanceWithoutInstallmentAmount(t *testing.T) {
+	mock := mockDB(t)
+	expectDeviceDetail(mock, testDevice("d1", "TV100001"), false, false)
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/device/TV100001", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body DeviceDetailResponse
+	decodeResponse(t, rec, &body)
+	if body.OutstandingBalance != nil {
+		t.Errorf("outstanding_balance = %v, want null without an installment amount", *body.OutstandingBalance)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestInstallmentAmountIsValidated(t *testing.T) {
+	tests := []struct {
+		amount float64
+		valid  bool
+	}{
+		{1500, true},
+		{0.01, true},
+		{maxInstallmentAmount, true},
+		{0, false},
+		{-10, false},
+		{math.NaN(), false},
+		{maxInstallmentAmount + 1, false},
+		{10.005, false},
+	}
+	for _, tt := range tests {
+		req := validRegistration()
+		req.InstallmentAmount = &tt.amount
+
+		fields := fieldErrorsFor(req)
+
+		if valid := len(fields) == 0; valid != tt.valid {
+			t.Errorf("installment_amount %v: errors on %v, want valid = %v", tt.amount, fields, tt.valid)
+		} else if !tt.valid && !slices.Equal(fields, []string{"installment_amount"}) {
+			t.Errorf("installment_amount %v: errors on %v, want installment_amount", tt.amount, fields)
+		}
+	}
+}
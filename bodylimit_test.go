@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOversizedBodyIsRejected(t *testing.T) {
+	t.Setenv("MAX_BODY_BYTES", "64")
+	body := registrationBody("TV100001", 1)
+	if len(body) <= 64 {
+		t.Fatalf("test body is only %d bytes", len(body))
+	}
+
+	t.Run("declared length", func(t *testing.T) {
+		mock := mockDB(t)
+
+		rec := serve(apiRequest(t, http.MethodPost, "/api/register", body))
+
+		assertStatus(t, rec, http.StatusRequestEntityTooLarge)
+		var errBody ErrorResponse
+		decodeResponse(t, rec, &errBody)
+		if errBody.Error.Code != "request_body_too_large" {
+			t.Errorf("error code = %q, want request_body_too_large", errBody.Error.Code)
+		}
+		assertExpectations(t, mock)
+	})
+
+	t.Run("streamed", func(t *testing.T) {
+		mock := mockDB(t)
+		r := apiRequest(t, http.MethodPost, "/api/register", body)
+		r.ContentLength = -1
+
+		rec := serve(r)
+
+		assertStatus(t, rec, http.StatusRequestEntityTooLarge)
+		assertExpectations(t, mock)
+	})
+}
+
+func TestBulkBodiesGetTheirOwnLimit(t *testing.T) {
+	t.Setenv("MAX_BODY_BYTES", "64")
+	t.Setenv("MAX_BULK_BODY_BYTES", "128")
+	mock := mockDB(t)
+
+	// Larger than MAX_BODY_BYTES but within MAX_BULK_BODY_BYTES, so it is
+	// read and rejected only as an empty batch
+	rec := serve(apiRequest(t, http.MethodPost, "/api/remote-lock/batch", "[]"+strings.Repeat(" ", 100)))
+	assertStatus(t, rec, http.StatusBadRequest)
+
+	rec = serve(apiRequest(t, http.MethodPost, "/api/remote-lock/batch", "[]"+strings.Repeat(" ", 200)))
+	assertStatus(t, rec, http.StatusRequestEntityTooLarge)
+	assertExpectations(t, mock)
+}
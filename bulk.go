@@ -0,0 +1,255 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// maxBulkRegistrations caps the number of devices in one bulk request
+const maxBulkRegistrations = 500
+
+type BulkRegisterResult struct {
+	Index        int                       `json:"index"`
+	SerialNumber string                    `json:"serial_number"`
+	Success      bool                      `json:"success"`
+	DeviceID     string                    `json:"device_id,omitempty"`
+	DeviceToken  string                    `json:"device_token,omitempty"`
+	Terms        []TermWithLockDateAndCode `json:"terms,omitempty"`
+	Error        *ErrorDetail              `json:"error,omitempty"`
+}
+
+type BulkRegisterResponse struct {
+	Success   bool                 `json:"success"`
+	Total     int                  `json:"total"`
+	Succeeded int                  `json:"succeeded"`
+	Failed    int                  `json:"failed"`
+	Results   []BulkRegisterResult `json:"results"`
+}
+
+// registerDevicesBulk registers many devices in one transaction. Each device
+// runs inside its own savepoint, so an invalid or duplicate entry is reported
+// in its result without aborting the rest of the batch.
+func registerDevicesBulk(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Decode as strictly as a single registration, so a misspelled field
+	// fails the request instead of silently registering without it
+	var reqs []RegisterDeviceRequest
+	if bodyErr := decodeJSONBody(r.Body, &reqs); bodyErr != nil {
+		writeBodyError(w, bodyErr)
+		return
+	}
+	if len(reqs) == 0 {
+		writeError(w, http.StatusBadRequest, "empty_batch", "At least one device is required")
+		return
+	}
+	if len(reqs) > maxBulkRegistrations {
+		writeError(w, http.StatusRequestEntityTooLarge, "batch_too_large", fmt.Sprintf("A batch may contain at most %d devices", maxBulkRegistrations))
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "registration_failed", "Failed to register devices")
+		return
+	}
+	defer tx.Rollback()
+
+	results := make([]BulkRegisterResult, 0, len(reqs))
+	succeeded := 0
+	for i, req := range reqs {
+		result := BulkRegisterResult{Index: i, SerialNumber: req.SerialNumber}
+
+		emiStartDate, fieldErrs := validateRegistration(&req)
+		if fieldErrs != nil {
+			detail := validationErrorDetail(fieldErrs)
+			detail.Details = map[string]interface{}{"errors": fieldErrs}
+			result.Error = &detail
+			results = append(results, result)
+			continue
+		}
+		result.SerialNumber = req.SerialNumber
+
+		if _, err = tx.ExecContext(ctx, "SAVEPOINT bulk_item"); err != nil {
+			errorf(ctx, "Error creating savepoint: %v", err)
+			writeDBError(w, r, err, "registration_failed", "Failed to register devices")
+			return
+		}
+
+		deviceID, terms, err := createDevice(ctx, tx, req, emiStartDate, dealerFromRequest(r), actorFromRequest(r))
+		if err != nil {
+			if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT bulk_item"); rbErr != nil {
+				errorf(ctx, "Error rolling back savepoint: %v", rbErr)
+				writeDBError(w, r, rbErr, "registration_failed", "Failed to register devices")
+				return
+			}
+			var apiErr *apiError
+			if errors.As(err, &apiErr) {
+				result.Error = &ErrorDetail{Code: apiErr.code, Message: apiErr.message}
+			} else {
+				errorf(ctx, "Error registering device %s in bulk: %v", req.SerialNumber, err)
+				result.Error = &ErrorDetail{Code: "registration_failed", Message: "Failed to register device"}
+			}
+			results = append(results, result)
+			continue
+		}
+
+		if _, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT bulk_item"); err != nil {
+			errorf(ctx, "Error releasing savepoint: %v", err)
+			writeDBError(w, r, err, "registration_failed", "Failed to register devices")
+			return
+		}
+
+		result.Success = true
+		result.DeviceID = deviceID
+		result.DeviceToken = deviceToken(deviceID)
+		result.Terms = terms
+		results = append(results, result)
+		succeeded++
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing bulk registration: %v", err)
+		writeDBError(w, r, err, "registration_failed", "Failed to register devices")
+		return
+	}
+	registrationsTotal.Add(uint64(succeeded))
+
+	response := BulkRegisterResponse{
+		Success:   true,
+		Total:     len(reqs),
+		Succeeded: succeeded,
+		Failed:    len(reqs) - succeeded,
+		Results:   results,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// maxBulkRemoteLocks caps the number of devices in one remote lock batch
+const maxBulkRemoteLocks = 500
+
+type BulkRemoteLockResult struct {
+	Index        int          `json:"index"`
+	SerialNumber string       `json:"serial_number"`
+	Success      bool         `json:"success"`
+	IsLocked     bool         `json:"is_locked"`
+	Error        *ErrorDetail `json:"error,omitempty"`
+}
+
+type BulkRemoteLockResponse struct {
+	Success   bool                   `json:"success"`
+	Total     int                    `json:"total"`
+	Succeeded int                    `json:"succeeded"`
+	Failed    int                    `json:"failed"`
+	Results   []BulkRemoteLockResult `json:"results"`
+}
+
+// setRemoteLockBulk sets the remote lock of many devices in one transaction,
+// for example to unlock a dealer's devices while payments cannot be taken.
+// Unknown serial numbers are reported in their own result without aborting
+// the rest of the batch.
+func setRemoteLockBulk(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var reqs []RemoteLockRequest
+	if bodyErr := decodeJSONBody(r.Body, &reqs); bodyErr != nil {
+		writeBodyError(w, bodyErr)
+		return
+	}
+	if len(reqs) == 0 {
+		writeError(w, http.StatusBadRequest, "empty_batch", "At least one device is required")
+		return
+	}
+	if len(reqs) > maxBulkRemoteLocks {
+		writeError(w, http.StatusRequestEntityTooLarge, "batch_too_large", fmt.Sprintf("A batch may contain at most %d devices", maxBulkRemoteLocks))
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "remote_lock_failed", "Failed to update remote locks")
+		return
+	}
+	defer tx.Rollback()
+
+	results := make([]BulkRemoteLockResult, 0, len(reqs))
+	deviceIDs := make([]string, len(reqs))
+	succeeded := 0
+	for i, req := range reqs {
+		serialNumber := normalizeSerialNumber(req.SerialNumber)
+		result := BulkRemoteLockResult{Index: i, SerialNumber: serialNumber, IsLocked: req.IsLocked}
+		if serialNumber == "" {
+			result.Error = &ErrorDetail{Code: "missing_serial_number", Message: "serial_number is required"}
+			results = append(results, result)
+			continue
+		}
+
+		var deviceID string
+		err = tx.QueryRowContext(ctx,
+			"SELECT id FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2)",
+			serialNumber, dealerArg(r),
+		).Scan(&deviceID)
+		if err == sql.ErrNoRows {
+			result.Error = &ErrorDetail{Code: "device_not_found", Message: "Device not found"}
+			results = append(results, result)
+			continue
+		}
+		if err != nil {
+			errorf(ctx, "Error finding device %s in bulk remote lock: %v", serialNumber, err)
+			writeDBError(w, r, err, "remote_lock_failed", "Failed to update remote locks")
+			return
+		}
+
+		if err = writeRemoteLock(ctx, tx, deviceID, req.IsLocked, actorFromRequest(r)); err != nil {
+			errorf(ctx, "Error updating remote lock of device %s in bulk: %v", serialNumber, err)
+			writeDBError(w, r, err, "remote_lock_failed", "Failed to update remote locks")
+			return
+		}
+
+		deviceIDs[i] = deviceID
+		result.Success = true
+		results = append(results, result)
+		succeeded++
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing bulk remote lock: %v", err)
+		writeDBError(w, r, err, "remote_lock_failed", "Failed to update remote locks")
+		return
+	}
+
+	lockedIDs := make([]string, 0, succeeded)
+	for i, result := range results {
+		if !result.Success {
+			continue
+		}
+		if result.IsLocked {
+			locksTotal.Add(1)
+			lockedIDs = append(lockedIDs, deviceIDs[i])
+			dispatchWebhook(webhookEventLocked, deviceIDs[i], result.SerialNumber, map[string]interface{}{"source": "remote"})
+		} else {
+			unlocksTotal.Add(1)
+			dispatchWebhook(webhookEventUnlocked, deviceIDs[i], result.SerialNumber, map[string]interface{}{"source": "remote"})
+		}
+	}
+	// Only committed locks are texted, and the texts go out in the background
+	notifyDevicesLocked(lockedIDs)
+
+	response := BulkRemoteLockResponse{
+		Success:   true,
+		Total:     len(reqs),
+		Succeeded: succeeded,
+		Failed:    len(reqs) - succeeded,
+		Results:   results,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
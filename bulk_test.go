@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRegisterDevicesBulkReportsPartialSuccess(t *testing.T) {
+	mock := mockDB(t)
+	body := fmt.Sprintf("[%s,%s,%s]",
+		registrationBody("TV100001", 2),
+		registrationBody("TV100002", 2),
+		registrationBody("TV100003", 2))
+
+	mock.ExpectBegin()
+	// The first item registers
+	mock.ExpectExec("SAVEPOINT bulk_item").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDeviceInsert(mock)
+	expectTermInserts(mock, 2)
+	mock.ExpectExec("INSERT INTO remote_locks").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT bulk_item").WillReturnResult(sqlmock.NewResult(0, 0))
+	// The second is a serial that is already registered
+	mock.ExpectExec("SAVEPOINT bulk_item").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT id FROM devices WHERE serial_number").WithArgs("TV100002").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("existing"))
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT bulk_item").WillReturnResult(sqlmock.NewResult(0, 0))
+	// The third registers too
+	mock.ExpectExec("SAVEPOINT bulk_item").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectDeviceInsert(mock)
+	expectTermInserts(mock, 2)
+	mock.ExpectExec("INSERT INTO remote_locks").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT bulk_item").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register/bulk", body))
+
+	assertStatus(t, rec, http.StatusOK)
+	var resp BulkRegisterResponse
+	decodeResponse(t, rec, &resp)
+	if resp.Total != 3 || resp.Succeeded != 2 || resp.Failed != 1 {
+		t.Fatalf("totals = %d/%d/%d, want 3 total, 2 succeeded, 1 failed", resp.Total, resp.Succeeded, resp.Failed)
+	}
+	for i, wantSuccess := range []bool{true, false, true} {
+		result := resp.Results[i]
+		if result.Success != wantSuccess {
+			t.Errorf("result %d success = %v, want %v", i, result.Success, wantSuccess)
+		}
+		if wantSuccess && (result.DeviceID == "" || len(result.Terms) != 2) {
+			t.Errorf("result %d = %+v, want a device id and two terms", i, result)
+		}
+	}
+	if err := resp.Results[1].Error; err == nil || err.Code != "duplicate_serial" {
+		t.Errorf("result 1 error = %+v, want duplicate_serial", err)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestRegisterDevicesBulkDecodesStrictly(t *testing.T) {
+	tests := map[string]string{
+		"unknown field": `[{"serial_number":"TV100001","serial":"TV100001"}]`,
+		"wrong type":    `[{"serial_number":"TV100001","emi_term":"6"}]`,
+		"not an array":  `{"serial_number":"TV100001"}`,
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			mock := mockDB(t)
+
+			rec := serve(apiRequest(t, http.MethodPost, "/api/register/bulk", body))
+
+			assertStatus(t, rec, http.StatusBadRequest)
+			var errBody ErrorResponse
+			decodeResponse(t, rec, &errBody)
+			if errBody.Error.Code != "invalid_request_body" {
+				t.Errorf("error code = %q, want invalid_request_body", errBody.Error.Code)
+			}
+			assertExpectations(t, mock)
+		})
+	}
+}
+
+func TestSetRemoteLockBulkWithMixedEntries(t *testing.T) {
+	mock := mockDB(t)
+	fake := useFakeSender(t)
+	body := `[{"serial_number":"tv100001","is_locked":true},{"serial_number":"TV404","is_locked":true},{"serial_number":" ","is_locked":true},{"serial_number":"TV100002","is_locked":false}]`
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM devices WHERE serial_number").WithArgs("TV100001", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("d1"))
+	expectRemoteLockWrite(mock, "d1", true, &captured{}, &captured{})
+	mock.ExpectQuery("SELECT id FROM devices WHERE serial_number").WithArgs("TV404", nil).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT id FROM devices WHERE serial_number").WithArgs("TV100002", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("d2"))
+	expectRemoteLockWrite(mock, "d2", false, &captured{}, &captured{})
+	mock.ExpectCommit()
+	// Only the committed lock is texted, after the transaction
+	mock.ExpectQuery("SELECT d.customer_name, d.phone_number").WithArgs("d1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"customer_name", "phone_number", "overdue_term"}).
+			AddRow("Jane Doe", "+15551234567", nil))
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/remote-lock/batch", body))
+
+	assertStatus(t, rec, http.StatusOK)
+	var resp BulkRemoteLockResponse
+	decodeResponse(t, rec, &resp)
+	if resp.Total != 4 || resp.Succeeded != 2 || resp.Failed != 2 {
+		t.Fatalf("totals = %d/%d/%d, want 4 total, 2 succeeded, 2 failed", resp.Total, resp.Succeeded, resp.Failed)
+	}
+	for i, wantCode := range []string{"", "device_not_found", "missing_serial_number", ""} {
+		result := resp.Results[i]
+		if wantCode == "" {
+			if !result.Success || result.Error != nil {
+				t.Errorf("result %d = %+v, want success", i, result)
+			}
+		} else if result.Success || result.Error == nil || result.Error.Code != wantCode {
+			t.Errorf("result %d = %+v, want %s", i, result, wantCode)
+		}
+	}
+
+	select {
+	case msg := <-fake.sent:
+		if msg.to != "+15551234567" {
+			t.Errorf("SMS sent to %q, want the locked device's customer", msg.to)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no SMS was queued for the locked device")
+	}
+	select {
+	case msg := <-fake.sent:
+		t.Errorf("unexpected second SMS to %q", msg.to)
+	case <-time.After(50 * time.Millisecond):
+	}
+	assertExpectations(t, mock)
+}
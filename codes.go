@@ -0,0 +1,424 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+type DeviceCodesResponse struct {
+	Success      bool             `json:"success"`
+	SerialNumber string           `json:"serial_number"`
+	Codes        []ActivationCode `json:"codes"`
+}
+
+type RegenerateCodesResponse struct {
+	Success bool                      `json:"success"`
+	Message string                    `json:"message"`
+	Terms   []TermWithLockDateAndCode `json:"terms"`
+}
+
+// activationCodeAlphabet leaves out characters that are easy to confuse when
+// read off a sticker (0/O and 1/I)
+const activationCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const (
+	defaultActivationCodeLength = 10
+	minActivationCodeLength     = 6
+	maxActivationCodeLength     = 32
+)
+
+// codeSpaceFactor is how many times larger than the codes a dealer expects
+// to issue the code space must be, keeping the chance that a fresh code
+// collides with an existing one at 1% or less
+const codeSpaceFactor = 100
+
+// defaultExpectedDevices is the device count assumed when a dealer's code
+// format is set without expected_devices
+const defaultExpectedDevices = 1000
+
+// maxActivationCodeAttempts bounds retries after a code collides with an
+// existing one
+const maxActivationCodeAttempts = 5
+
+// activationCodeExpiry returns when codes of a schedule starting on
+// emiStartDate expire: ACTIVATION_CODE_EXPIRY_DAYS after the start date. It
+// returns nil, meaning codes never expire, when the variable is unset or 0.
+func activationCodeExpiry(emiStartDate time.Time) *time.Time {
+	raw := os.Getenv("ACTIVATION_CODE_EXPIRY_DAYS")
+	if raw == "" {
+		return nil
+	}
+	days, err := strconv.Atoi(raw)
+	if err != nil || days < 0 {
+		warnf(context.Background(), "Invalid ACTIVATION_CODE_EXPIRY_DAYS %q, codes will not expire", raw)
+		return nil
+	}
+	if days == 0 {
+		return nil
+	}
+	expiresAt := emiStartDate.AddDate(0, 0, days)
+	return &expiresAt
+}
+
+// codeFormat is the alphabet and length activation codes are generated with
+type codeFormat struct {
+	alphabet string
+	length   int
+}
+
+// activationCodeLength reads ACTIVATION_CODE_LENGTH, falling back to the
+// default when it is unset or not a sensible length
+func activationCodeLength() int {
+	raw := os.Getenv("ACTIVATION_CODE_LENGTH")
+	if raw == "" {
+		return defaultActivationCodeLength
+	}
+	length, err := strconv.Atoi(raw)
+	if err != nil || length < minActivationCodeLength || length > maxActivationCodeLength {
+		warnf(context.Background(), "Invalid ACTIVATION_CODE_LENGTH %q, using %d", raw, defaultActivationCodeLength)
+		return defaultActivationCodeLength
+	}
+	return length
+}
+
+// activationCodeAlphabetSetting reads ACTIVATION_CODE_ALPHABET, falling back
+// to the unambiguous alphabet when it is unset or invalid
+func activationCodeAlphabetSetting() string {
+	raw := os.Getenv("ACTIVATION_CODE_ALPHABET")
+	if raw == "" {
+		return activationCodeAlphabet
+	}
+	if err := validateCodeAlphabet(raw); err != nil {
+		warnf(context.Background(), "Invalid ACTIVATION_CODE_ALPHABET %q (%v), using %s", raw, err, activationCodeAlphabet)
+		return activationCodeAlphabet
+	}
+	return raw
+}
+
+// defaultCodeFormat is the server-wide code format, used for devices without
+// a dealer and for dealers that have not set their own
+func defaultCodeFormat() codeFormat {
+	return codeFormat{alphabet: activationCodeAlphabetSetting(), length: activationCodeLength()}
+}
+
+// validateCodeAlphabet accepts 2-36 distinct uppercase letters and digits.
+// Codes are matched exactly, so lowercase and punctuation are left out to
+// keep them easy to type on a TV remote.
+func validateCodeAlphabet(alphabet string) error {
+	if len(alphabet) < 2 || len(alphabet) > 36 {
+		return errors.New("code_alphabet must have 2-36 characters")
+	}
+	seen := make(map[rune]bool)
+	for _, c := range alphabet {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			return errors.New("code_alphabet may only contain uppercase letters and digits")
+		}
+		if seen[c] {
+			return fmt.Errorf("code_alphabet repeats %q", c)
+		}
+		seen[c] = true
+	}
+	return nil
+}
+
+// codeSpaceSufficient reports whether format has room for the codes of
+// expectedDevices devices with the longest schedule, with codeSpaceFactor to
+// spare. It also returns the size of the code space and the size required.
+func codeSpaceSufficient(format codeFormat, expectedDevices int) (bool, *big.Int, *big.Int) {
+	space := new(big.Int).Exp(big.NewInt(int64(len(format.alphabet))), big.NewInt(int64(format.length)), nil)
+	required := big.NewInt(int64(expectedDevices) * maxEMITerm * codeSpaceFactor)
+	return space.Cmp(required) >= 0, space, required
+}
+
+// deviceCodeFormat returns the code format of the dealer a device belongs
+// to, with the server-wide default filling in whatever the dealer has not set
+func deviceCodeFormat(ctx context.Context, tx *sql.Tx, deviceID string) (codeFormat, error) {
+	format := defaultCodeFormat()
+	var alphabet sql.NullString
+	var length sql.NullInt64
+	err := tx.QueryRowContext(ctx, `
+		SELECT dl.code_alphabet, dl.code_length
+		FROM devices d LEFT JOIN dealers dl ON dl.id = d.dealer_id
+		WHERE d.id = $1
+	`, deviceID).Scan(&alphabet, &length)
+	if err != nil {
+		return format, err
+	}
+	if alphabet.Valid {
+		format.alphabet = alphabet.String
+	}
+	if length.Valid {
+		format.length = int(length.Int64)
+	}
+	return format, nil
+}
+
+// generateActivationCode returns a random code in format drawn from a
+// cryptographic source
+func generateActivationCode(format codeFormat) (string, error) {
+	alphabetSize := big.NewInt(int64(len(format.alphabet)))
+
+	code := make([]byte, format.length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", err
+		}
+		code[i] = format.alphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// isActivationCodeCollision reports whether err is a violation of the global
+// uniqueness of activation_codes.code (either the column constraint or
+// idx_activation_codes_code_unique), as opposed to another unique constraint
+// such as one code per device and term, which a fresh code cannot fix
+func isActivationCodeCollision(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	if !ok || pqErr.Code != "23505" {
+		return false
+	}
+	return pqErr.Constraint == "activation_codes_code_key" || pqErr.Constraint == "idx_activation_codes_code_unique"
+}
+
+// isDuplicateTermCode reports whether err is a violation of the one code per
+// device and term rule (either the table constraint or
+// idx_activation_codes_device_term)
+func isDuplicateTermCode(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	if !ok || pqErr.Code != "23505" {
+		return false
+	}
+	return pqErr.Constraint == "activation_codes_device_id_term_number_key" || pqErr.Constraint == "idx_activation_codes_device_term"
+}
+
+// insertActivationCode generates and stores the activation code for one term,
+// retrying with a fresh code if it collides with an existing one. Each attempt
+// runs inside a savepoint so a collision does not abort the transaction. A
+// term that already has a code is reported as a 409 *apiError. expiresAt
+// may be nil for a code that never expires.
+func insertActivationCode(ctx context.Context, tx *sql.Tx, deviceID string, termNumber int, format codeFormat, expiresAt *time.Time) (string, error) {
+	for attempt := 1; attempt <= maxActivationCodeAttempts; attempt++ {
+		code, err := generateActivationCode(format)
+		if err != nil {
+			return "", err
+		}
+
+		if _, err = tx.ExecContext(ctx, "SAVEPOINT activation_code"); err != nil {
+			return "", err
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO activation_codes (id, device_id, code, term_number, is_used, expires_at, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+			uuid.New().String(), deviceID, code, termNumber, false, expiresAt, time.Now(),
+		)
+		if err == nil {
+			if _, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT activation_code"); err != nil {
+				return "", err
+			}
+			return code, nil
+		}
+		if isDuplicateTermCode(err) {
+			if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT activation_code"); rbErr != nil {
+				return "", rbErr
+			}
+			return "", &apiError{http.StatusConflict, "duplicate_term", fmt.Sprintf("Term %d already has an activation code", termNumber)}
+		}
+		if !isActivationCodeCollision(err) {
+			return "", err
+		}
+
+		debugf(ctx, "Activation code collision on attempt %d, retrying", attempt)
+		if _, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT activation_code"); err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("could not generate a unique activation code after %d attempts", maxActivationCodeAttempts)
+}
+
+// regenerateCodes replaces every unused activation code of a device with a
+// fresh one for the same term, so leaked codes can be rotated. Used codes are
+// left untouched.
+func regenerateCodes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	var deviceID string
+	var emiStartDate time.Time
+	err := db.QueryRowContext(ctx,
+		"SELECT id, emi_start_date FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2)",
+		serialNumber, dealerArg(r),
+	).Scan(&deviceID, &emiStartDate)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "regenerate_failed", "Failed to regenerate activation codes")
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the unused codes so a concurrent activation cannot use one while
+	// it is being replaced
+	rows, err := tx.QueryContext(ctx, `
+		SELECT ac.term_number, ld.lock_date, ld.paid_at IS NOT NULL
+		FROM activation_codes ac
+		JOIN lock_dates ld ON ld.device_id = ac.device_id AND ld.term_number = ac.term_number
+		WHERE ac.device_id = $1 AND ac.is_used = false
+		ORDER BY ac.term_number
+		FOR UPDATE OF ac
+	`, deviceID)
+	if err != nil {
+		errorf(ctx, "Error fetching unused activation codes for device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "regenerate_failed", "Failed to regenerate activation codes")
+		return
+	}
+	terms := make([]TermWithLockDateAndCode, 0)
+	for rows.Next() {
+		var termNumber int
+		var lockDate time.Time
+		var isPaid bool
+		if err := rows.Scan(&termNumber, &lockDate, &isPaid); err != nil {
+			rows.Close()
+			errorf(ctx, "Error scanning activation code for device %s: %v", deviceID, err)
+			writeDBError(w, r, err, "regenerate_failed", "Failed to regenerate activation codes")
+			return
+		}
+		terms = append(terms, TermWithLockDateAndCode{
+			Term:     termNumber,
+			LockDate: lockDate.Format("2006-01-02"),
+			IsPaid:   isPaid,
+		})
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		errorf(ctx, "Error fetching unused activation codes for device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "regenerate_failed", "Failed to regenerate activation codes")
+		return
+	}
+	if len(terms) == 0 {
+		writeError(w, http.StatusConflict, "no_unused_codes", "Device has no unused activation codes")
+		return
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM activation_codes WHERE device_id = $1 AND is_used = false", deviceID); err != nil {
+		errorf(ctx, "Error deleting unused activation codes for device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "regenerate_failed", "Failed to regenerate activation codes")
+		return
+	}
+
+	format, err := deviceCodeFormat(ctx, tx, deviceID)
+	if err != nil {
+		errorf(ctx, "Error fetching code format for device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "regenerate_failed", "Failed to regenerate activation codes")
+		return
+	}
+	expiresAt := activationCodeExpiry(emiStartDate)
+	for i := range terms {
+		code, err := insertActivationCode(ctx, tx, deviceID, terms[i].Term, format, expiresAt)
+		if err != nil {
+			errorf(ctx, "Error inserting activation code: %v", err)
+			writeAPIError(w, r, err, "regenerate_failed", "Failed to regenerate activation codes")
+			return
+		}
+		terms[i].ActivationCode = code
+	}
+
+	details := fmt.Sprintf("Regenerated %d unused activation code(s)", len(terms))
+	if err = appendAudit(ctx, tx, deviceID, "regenerate_codes", actorFromRequest(r), details); err != nil {
+		errorf(ctx, "Error writing audit log: %v", err)
+		writeDBError(w, r, err, "regenerate_failed", "Failed to regenerate activation codes")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing regenerated codes: %v", err)
+		writeDBError(w, r, err, "regenerate_failed", "Failed to regenerate activation codes")
+		return
+	}
+
+	response := RegenerateCodesResponse{
+		Success: true,
+		Message: "Activation codes regenerated successfully",
+		Terms:   terms,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// fetchActivationCodes returns every activation code of a device, ordered by
+// term number
+func fetchActivationCodes(ctx context.Context, deviceID string) ([]ActivationCode, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, device_id, code, term_number, is_used, used_at, expires_at, created_at
+		FROM activation_codes
+		WHERE device_id = $1
+		ORDER BY term_number
+	`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := make([]ActivationCode, 0)
+	for rows.Next() {
+		var code ActivationCode
+		if err := rows.Scan(&code.ID, &code.DeviceID, &code.Code, &code.TermNumber, &code.IsUsed, &code.UsedAt, &code.ExpiresAt, &code.CreatedAt); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, rows.Err()
+}
+
+// getDeviceCodes lists a device's activation codes with their usage, so
+// dealers can reconcile which codes have been used
+func getDeviceCodes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	var deviceID string
+	err := db.QueryRowContext(ctx,
+		"SELECT id FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2)",
+		serialNumber, dealerArg(r),
+	).Scan(&deviceID)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	codes, err := fetchActivationCodes(ctx, deviceID)
+	if err != nil {
+		errorf(ctx, "Error fetching activation codes for device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch activation codes")
+		return
+	}
+
+	response := DeviceCodesResponse{
+		Success:      true,
+		SerialNumber: serialNumber,
+		Codes:        codes,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,296 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+func TestInsertActivationCodeRetriesCollision(t *testing.T) {
+	mock := mockDB(t)
+	var first, second captured
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO activation_codes").
+		WithArgs(sqlmock.AnyArg(), "d1", &first, 1, false, nil, sqlmock.AnyArg()).
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "idx_activation_codes_code_unique"})
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO activation_codes").
+		WithArgs(sqlmock.AnyArg(), "d1", &second, 1, false, nil, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := insertActivationCode(context.Background(), tx, "d1", 1, defaultCodeFormat(), nil)
+
+	if err != nil {
+		t.Fatalf("insertActivationCode error = %v, want the retry to succeed", err)
+	}
+	if code != second.value || code == first.value {
+		t.Errorf("code = %q, want the second attempt %v rather than the colliding %v", code, second.value, first.value)
+	}
+	if len(code) != defaultActivationCodeLength {
+		t.Errorf("code length = %d, want %d", len(code), defaultActivationCodeLength)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestRegenerateCodesReplacesOnlyUnusedCodes(t *testing.T) {
+	mock := mockDB(t)
+	start := time.Now().UTC().Truncate(24 * time.Hour)
+	mock.ExpectQuery("SELECT id, emi_start_date FROM devices").WithArgs("TV100001", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "emi_start_date"}).AddRow("d1", start))
+	mock.ExpectBegin()
+	// Term 1 has been used, so only terms 2 and 3 are selected for rotation
+	mock.ExpectQuery("WHERE ac.device_id = \\$1 AND ac.is_used = false").WithArgs("d1").
+		WillReturnRows(sqlmock.NewRows([]string{"term_number", "lock_date", "paid"}).
+			AddRow(2, start.AddDate(0, 0, 60), false).
+			AddRow(3, start.AddDate(0, 0, 90), false))
+	mock.ExpectExec("DELETE FROM activation_codes WHERE device_id = \\$1 AND is_used = false").WithArgs("d1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery("SELECT dl.code_alphabet, dl.code_length").
+		WillReturnRows(sqlmock.NewRows([]string{"code_alphabet", "code_length"}).AddRow(nil, nil))
+	codes := make([]captured, 2)
+	for i := range codes {
+		mock.ExpectExec("SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO activation_codes").
+			WithArgs(sqlmock.AnyArg(), "d1", &codes[i], i+2, false, nil, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("RELEASE SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec("INSERT INTO audit_logs").WithArgs(sqlmock.AnyArg(), "d1", "regenerate_codes", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/regenerate-codes", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body RegenerateCodesResponse
+	decodeResponse(t, rec, &body)
+	if len(body.Terms) != 2 {
+		t.Fatalf("terms = %+v, want terms 2 and 3", body.Terms)
+	}
+	for i, term := range body.Terms {
+		if term.Term != i+2 || term.ActivationCode != codes[i].value {
+			t.Errorf("term %d = %+v, want term %d with the stored code %v", i, term, i+2, codes[i].value)
+		}
+	}
+	assertExpectations(t, mock)
+}
+
+func TestRegenerateCodesWithEveryCodeUsed(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectQuery("SELECT id, emi_start_date FROM devices").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "emi_start_date"}).AddRow("d1", time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectQuery("AND ac.is_used = false").WillReturnRows(sqlmock.NewRows([]string{"term_number", "lock_date", "paid"}))
+	mock.ExpectRollback()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/regenerate-codes", ""))
+
+	assertStatus(t, rec, http.StatusConflict)
+	assertExpectations(t, mock)
+}
+
+func TestActivationCodeExpiry(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		setting string
+		want    string
+	}{
+		{"", ""},
+		{"0", ""},
+		{"invalid", ""},
+		{"-5", ""},
+		{"90", "2026-04-01"},
+	} {
+		t.Run(tt.setting, func(t *testing.T) {
+			t.Setenv("ACTIVATION_CODE_EXPIRY_DAYS", tt.setting)
+
+			got := activationCodeExpiry(start)
+
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("expiry = %s, want codes that never expire", got)
+				}
+				return
+			}
+			if got == nil || got.Format("2006-01-02") != tt.want {
+				t.Errorf("expiry = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeviceCodesReportUsage(t *testing.T) {
+	mock := mockDB(t)
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	usedAt := created.AddDate(0, 1, 0)
+	expectDeviceLookup(mock, "d1")
+	mock.ExpectQuery("FROM activation_codes\\s+WHERE device_id = \\$1\\s+ORDER BY term_number").WithArgs("d1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "device_id", "code", "term_number", "is_used", "used_at", "expires_at", "created_at"}).
+			AddRow("c1", "d1", "CODEONE234", 1, true, usedAt, nil, created).
+			AddRow("c2", "d1", "CODETWO234", 2, false, nil, nil, created).
+			AddRow("c3", "d1", "CODETHR234", 3, false, nil, nil, created))
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/device/TV100001/codes", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body DeviceCodesResponse
+	decodeResponse(t, rec, &body)
+	if len(body.Codes) != 3 {
+		t.Fatalf("codes = %+v, want three", body.Codes)
+	}
+	for i, code := range body.Codes {
+		if code.TermNumber != i+1 {
+			t.Errorf("code %d is term %d, want codes ordered by term", i, code.TermNumber)
+		}
+		if code.IsUsed != (code.UsedAt != nil) {
+			t.Errorf("term %d: is_used %v with used_at %v, want used_at only on used codes", code.TermNumber, code.IsUsed, code.UsedAt)
+		}
+	}
+	if !body.Codes[0].UsedAt.Equal(usedAt) {
+		t.Errorf("term 1 used_at = %v, want %v", body.Codes[0].UsedAt, usedAt)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestDeviceCodesRequireAPIKey(t *testing.T) {
+	mock := mockDB(t)
+	r := apiRequest(t, http.MethodGet, "/api/device/TV100001/codes", "")
+	r.Header.Del("X-API-Key")
+
+	rec := serve(r)
+
+	assertStatus(t, rec, http.StatusUnauthorized)
+	assertExpectations(t, mock)
+}
+
+func TestRegistrationAvoidsPreSeededCode(t *testing.T) {
+	mock := mockDB(t)
+	var seeded, issued captured
+	mock.ExpectBegin()
+	expectDeviceInsert(mock)
+	// The first code drawn is already held by another device
+	mock.ExpectExec("SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO activation_codes").WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), &seeded, 1, false, nil, sqlmock.AnyArg()).
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "activation_codes_code_key"})
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO activation_codes").WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), &issued, 1, false, nil, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO lock_dates").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO remote_locks").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", registrationBody("TV100001", 1)))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body struct {
+		Terms []TermWithLockDateAndCode `json:"terms"`
+	}
+	decodeResponse(t, rec, &body)
+	if len(body.Terms) != 1 || body.Terms[0].ActivationCode != issued.value || body.Terms[0].ActivationCode == seeded.value {
+		t.Errorf("terms = %+v, want the retried code %v rather than the seeded %v", body.Terms, issued.value, seeded.value)
+	}
+	assertExpectations(t, mock)
+}
+
+// Uniqueness across devices rests on the database constraint, so it is
+// checked against the migrated schema
+func TestActivationCodesAreUniqueAcrossDevices(t *testing.T) {
+	conn := integrationDB(t)
+	code := "IT" + strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:10])
+	var deviceIDs []string
+	for i := 0; i < 2; i++ {
+		var deviceID string
+		err := conn.QueryRow(`
+			INSERT INTO devices (serial_number, customer_name, phone_number, emi_term, emi_start_date, term_duration)
+			VALUES ($1, 'Code Customer', '+15551234567', 1, CURRENT_DATE, 30) RETURNING id
+		`, fmt.Sprintf("%s%d", code, i)).Scan(&deviceID)
+		if err != nil {
+			t.Fatalf("seeding device: %v", err)
+		}
+		deviceIDs = append(deviceIDs, deviceID)
+	}
+	t.Cleanup(func() {
+		conn.Exec("DELETE FROM devices WHERE id = ANY($1)", pq.Array(deviceIDs))
+	})
+
+	insert := "INSERT INTO activation_codes (device_id, code, term_number) VALUES ($1, $2, 1)"
+	if _, err := conn.Exec(insert, deviceIDs[0], code); err != nil {
+		t.Fatalf("seeding code: %v", err)
+	}
+	_, err := conn.Exec(insert, deviceIDs[1], code)
+	if !isActivationCodeCollision(err) {
+		t.Errorf("reusing a code for another device: error = %v, want a code collision", err)
+	}
+}
+
+func TestAddTermRejectsDuplicateTerm(t *testing.T) {
+	mock := mockDB(t)
+	expectAddTermStart(mock, 6, 6, time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC))
+	mock.ExpectQuery("SELECT dl.code_alphabet, dl.code_length").
+		WillReturnRows(sqlmock.NewRows([]string{"code_alphabet", "code_length"}).AddRow(nil, nil))
+	mock.ExpectExec("SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO activation_codes").
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "activation_codes_device_id_term_number_key"})
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/add-term", ""))
+
+	assertStatus(t, rec, http.StatusConflict)
+	var body ErrorResponse
+	decodeResponse(t, rec, &body)
+	if body.Error.Code != "duplicate_term" || !strings.Contains(body.Error.Message, "Term 7") {
+		t.Errorf("error = %+v, want duplicate_term naming term 7", body.Error)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestDuplicateTermIsNotACodeCollision(t *testing.T) {
+	for _, constraint := range []string{"activation_codes_device_id_term_number_key", "idx_activation_codes_device_term"} {
+		err := &pq.Error{Code: "23505", Constraint: constraint}
+		if !isDuplicateTermCode(err) || isActivationCodeCollision(err) {
+			t.Errorf("%s: duplicate term = %v, collision = %v; want only a duplicate term", constraint, isDuplicateTermCode(err), isActivationCodeCollision(err))
+		}
+	}
+}
+
+func TestSecondCodeForTermIsRejected(t *testing.T) {
+	conn := integrationDB(t)
+	serialNumber := "IT" + strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+	var deviceID string
+	err := conn.QueryRow(`
+		INSERT INTO devices (serial_number, customer_name, phone_number, emi_term, emi_start_date, term_duration)
+		VALUES ($1, 'Code Customer', '+15551234567', 1, CURRENT_DATE, 30) RETURNING id
+	`, serialNumber).Scan(&deviceID)
+	if err != nil {
+		t.Fatalf("seeding device: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Exec("DELETE FROM devices WHERE id = $1", deviceID)
+	})
+
+	insert := "INSERT INTO activation_codes (device_id, code, term_number) VALUES ($1, $2, 1)"
+	if _, err = conn.Exec(insert, deviceID, serialNumber+"A"); err != nil {
+		t.Fatalf("seeding code: %v", err)
+	}
+	_, err = conn.Exec(insert, deviceID, serialNumber+"B")
+	if !isDuplicateTermCode(err) {
+		t.Errorf("second code for term 1: error = %v, want a duplicate term", err)
+	}
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// CORS preflight answers. Keep these in sync with the methods routed in
+// serveAPI and the request headers the handlers read.
+const (
+	corsAllowedMethods = "GET, POST, PATCH, DELETE, OPTIONS"
+	corsAllowedHeaders = "Content-Type, X-API-Key, Authorization, Idempotency-Key, X-Request-ID, If-None-Match"
+	corsExposedHeaders = "X-Request-ID, ETag"
+)
+
+// allowedOrigins reads the ALLOWED_ORIGINS comma-separated allowlist. An
+// empty list allows no cross-origin browser access.
+func allowedOrigins() []string {
+	origins := make([]string, 0)
+	for _, origin := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for a request
+// origin, or "" when the origin is not on the allowlist
+func allowOrigin(origin string, allowed []string) string {
+	for _, candidate := range allowed {
+		if candidate == "*" {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(candidate, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// corsMiddleware answers preflight requests and sets CORS headers. The
+// request Origin is only echoed back when it is listed in ALLOWED_ORIGINS
+// (or the list contains "*"); other origins get no Access-Control-Allow-Origin
+// header, so browsers refuse the response.
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		if origin := allowOrigin(r.Header.Get("Origin"), allowedOrigins()); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+		w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCORSAllowlist(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed string
+		origin  string
+		want    string
+	}{
+		{"listed origin is echoed", "https://admin.example.com, https://ops.example.com", "https://ops.example.com", "https://ops.example.com"},
+		{"unlisted origin", "https://admin.example.com", "https://evil.example.com", ""},
+		{"empty allowlist", "", "https://admin.example.com", ""},
+		{"wildcard", "*", "https://anywhere.example.com", "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := mockDB(t)
+			t.Setenv("ALLOWED_ORIGINS", tt.allowed)
+			r := apiRequest(t, http.MethodOptions, "/api/devices", "")
+			r.Header.Set("Origin", tt.origin)
+
+			rec := serve(r)
+
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.want)
+			}
+			if _, ok := rec.Header()["Access-Control-Allow-Origin"]; tt.want == "" && ok {
+				t.Error("Access-Control-Allow-Origin is set for a denied origin")
+			}
+			assertExpectations(t, mock)
+		})
+	}
+}
+
+func TestCORSPreflightAllowsAPIHeaders(t *testing.T) {
+	mock := mockDB(t)
+	t.Setenv("ALLOWED_ORIGINS", "https://admin.example.com")
+	r := apiRequest(t, http.MethodOptions, "/api/device/TV100001", "")
+	r.Header.Set("Origin", "https://admin.example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+
+	rec := serve(r)
+
+	assertStatus(t, rec, http.StatusOK)
+	methods := strings.Split(rec.Header().Get("Access-Control-Allow-Methods"), ", ")
+	for _, want := range []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"} {
+		if !slices.Contains(methods, want) {
+			t.Errorf("Access-Control-Allow-Methods = %v, missing %s", methods, want)
+		}
+	}
+	headers := strings.Split(rec.Header().Get("Access-Control-Allow-Headers"), ", ")
+	for _, want := range []string{"Content-Type", "X-API-Key", "Authorization", "Idempotency-Key", "X-Request-ID", "If-None-Match"} {
+		if !slices.Contains(headers, want) {
+			t.Errorf("Access-Control-Allow-Headers = %v, missing %s", headers, want)
+		}
+	}
+	if exposed := strings.Split(rec.Header().Get("Access-Control-Expose-Headers"), ", "); !slices.Contains(exposed, "ETag") {
+		t.Errorf("Access-Control-Expose-Headers = %v, missing ETag", exposed)
+	}
+	assertExpectations(t, mock)
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+type EnforceLocksResponse struct {
+	Success     bool     `json:"success"`
+	LockedCount int      `json:"locked_count"`
+	DeviceIDs   []string `json:"device_ids"`
+}
+
+// cronMiddleware only lets through requests carrying the CRON_SECRET as a
+// bearer token, which is how Vercel Cron authenticates its invocations
+func cronMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := os.Getenv("CRON_SECRET")
+		if secret == "" {
+			warnf(r.Context(), "CRON_SECRET is not set, rejecting request to %s", r.URL.Path)
+			writeError(w, http.StatusServiceUnavailable, "cron_not_configured", "Cron authentication is not configured")
+			return
+		}
+
+		expected := "Bearer " + secret
+		provided := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) != 1 {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "Missing or invalid cron secret")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// enforceLocks relocks every device whose temporary unlock has ended and
+// locks every device that has an overdue lock date not yet enforced, so TVs
+// that never poll are still locked on schedule
+func enforceLocks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	lockedIDs := make([]string, 0)
+
+	relockRows, err := db.QueryContext(ctx,
+		"SELECT id FROM devices WHERE relock_at <= $1 AND deleted_at IS NULL AND retired_at IS NULL", time.Now())
+	if err != nil {
+		errorf(ctx, "Error fetching expired temporary unlocks: %v", err)
+		writeDBError(w, r, err, "enforce_locks_failed", "Failed to enforce locks")
+		return
+	}
+
+	relockIDs := make([]string, 0)
+	for relockRows.Next() {
+		var deviceID string
+		if err := relockRows.Scan(&deviceID); err != nil {
+			errorf(ctx, "Error scanning device id: %v", err)
+			continue
+		}
+		relockIDs = append(relockIDs, deviceID)
+	}
+	relockRows.Close()
+
+	for _, deviceID := range relockIDs {
+		relocked, err := relockIfDue(ctx, deviceID)
+		if err != nil {
+			errorf(ctx, "Error relocking device %s: %v", deviceID, err)
+			continue
+		}
+		if relocked {
+			lockedIDs = append(lockedIDs, deviceID)
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT DISTINCT ld.device_id
+		FROM lock_dates ld
+		JOIN devices d ON d.id = ld.device_id
+		WHERE ld.is_locked = false AND ld.paid_at IS NULL AND d.deleted_at IS NULL AND d.retired_at IS NULL
+		  AND ld.lock_date + d.grace_days <= $1
+		  AND (d.snooze_until IS NULL OR d.snooze_until <= NOW())
+		  AND (d.relock_at IS NULL OR d.relock_at <= NOW())
+	`, time.Now())
+	if err != nil {
+		errorf(ctx, "Error fetching overdue lock dates: %v", err)
+		writeDBError(w, r, err, "enforce_locks_failed", "Failed to enforce locks")
+		return
+	}
+
+	candidates := make([]string, 0)
+	for rows.Next() {
+		var deviceID string
+		if err := rows.Scan(&deviceID); err != nil {
+			errorf(ctx, "Error scanning device id: %v", err)
+			continue
+		}
+		candidates = append(candidates, deviceID)
+	}
+	rows.Close()
+
+	for _, deviceID := range candidates {
+		locked, err := evaluateLocks(ctx, deviceID)
+		if err != nil {
+			errorf(ctx, "Error evaluating locks for device %s: %v", deviceID, err)
+			continue
+		}
+		if locked {
+			lockedIDs = append(lockedIDs, deviceID)
+		}
+	}
+
+	logEvent(ctx, slog.LevelInfo, "cron_enforce_locks", fmt.Sprintf("Cron enforce-locks: locked %d device(s)", len(lockedIDs)),
+		slog.Int("locked_count", len(lockedIDs)))
+
+	response := EnforceLocksResponse{
+		Success:     true,
+		LockedCount: len(lockedIDs),
+		DeviceIDs:   lockedIDs,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
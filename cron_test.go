@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+// cronRequest builds a cron invocation carrying CRON_SECRET
+func cronRequest(t *testing.T, target string) *http.Request {
+	t.Helper()
+	t.Setenv("CRON_SECRET", "test-cron-secret")
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.Header.Set("Authorization", "Bearer test-cron-secret")
+	return r
+}
+
+func TestEnforceLocksLocksOnlyOverdueDevices(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectQuery("SELECT id FROM devices WHERE relock_at <= \\$1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	// Of the three overdue devices and one due in the future, the lock date
+	// comparison only selects the overdue ones
+	mock.ExpectQuery("ld.lock_date \\+ d.grace_days <= \\$1").
+		WillReturnRows(sqlmock.NewRows([]string{"device_id"}).AddRow("d1").AddRow("d2").AddRow("d3"))
+	for _, id := range []string{"d1", "d2", "d3"} {
+		expectAutoLock(mock, id, 1)
+	}
+
+	rec := serve(cronRequest(t, "/api/cron/enforce-locks"))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body EnforceLocksResponse
+	decodeResponse(t, rec, &body)
+	if body.LockedCount != 3 || len(body.DeviceIDs) != 3 {
+		t.Fatalf("response = %+v, want three devices locked", body)
+	}
+	for i, id := range []string{"d1", "d2", "d3"} {
+		if body.DeviceIDs[i] != id {
+			t.Errorf("device_ids[%d] = %q, want %q", i, body.DeviceIDs[i], id)
+		}
+	}
+	assertExpectations(t, mock)
+}
+
+func TestEnforceLocksRelocksAfterRelockAt(t *testing.T) {
+	mock := mockDB(t)
+	relockAt := time.Now().Add(-time.Hour)
+	var now captured
+	mock.ExpectQuery("SELECT id FROM devices WHERE relock_at <= \\$1").WithArgs(&now).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("d1"))
+	mock.ExpectBegin()
+	// The device's terms are all paid; only the ended temporary unlock locks it
+	mock.ExpectQuery("UPDATE devices d SET is_locked = true, relock_at = NULL").WithArgs("d1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"serial_number", "relock_at"}).AddRow("TV100001", relockAt))
+	mock.ExpectExec("INSERT INTO remote_locks").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO lock_events").WithArgs(sqlmock.AnyArg(), "d1", true, lockSourceRelock, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_logs").WithArgs(sqlmock.AnyArg(), "d1", "relock", systemActor, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("ld.lock_date \\+ d.grace_days <= \\$1").WillReturnRows(sqlmock.NewRows([]string{"device_id"}))
+
+	before := time.Now()
+	rec := serve(cronRequest(t, "/api/cron/enforce-locks"))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body EnforceLocksResponse
+	decodeResponse(t, rec, &body)
+	if body.LockedCount != 1 || len(body.DeviceIDs) != 1 || body.DeviceIDs[0] != "d1" {
+		t.Errorf("response = %+v, want d1 relocked", body)
+	}
+	if at, ok := now.value.(time.Time); !ok || at.Before(before) {
+		t.Errorf("relock_at compared against %v, want the current time", now.value)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestEnforceLocksSkipsRelockNotYetDue(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectQuery("SELECT id FROM devices WHERE relock_at <= \\$1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("d1"))
+	// relock_at moved into the future after the device was selected
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE devices d SET is_locked = true, relock_at = NULL").WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+	mock.ExpectQuery("ld.lock_date \\+ d.grace_days <= \\$1").WillReturnRows(sqlmock.NewRows([]string{"device_id"}))
+
+	rec := serve(cronRequest(t, "/api/cron/enforce-locks"))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body EnforceLocksResponse
+	decodeResponse(t, rec, &body)
+	if body.LockedCount != 0 {
+		t.Errorf("response = %+v, want nothing locked", body)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestEnforceLocksSkipsRetiredDevices(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectQuery("SELECT id FROM devices WHERE relock_at <= \\$1 .*AND retired_at IS NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("d.deleted_at IS NULL AND d.retired_at IS NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"device_id"}))
+
+	rec := serve(cronRequest(t, "/api/cron/enforce-locks"))
+
+	assertStatus(t, rec, http.StatusOK)
+	assertExpectations(t, mock)
+}
+
+func TestEnforceLocksRequiresCronSecret(t *testing.T) {
+	mockDB(t)
+	t.Setenv("CRON_SECRET", "test-cron-secret")
+
+	rec := serve(httptest.NewRequest(http.MethodGet, "/api/cron/enforce-locks", nil))
+
+	assertStatus(t, rec, http.StatusUnauthorized)
+}
+
+func TestCronEndpointsGetLongerTimeout(t *testing.T) {
+	if got := requestTimeoutFor("/api/cron/enforce-locks"); got != cronRequestTimeout {
+		t.Errorf("cron timeout = %s, want %s", got, cronRequestTimeout)
+	}
+	if got := requestTimeoutFor("/api/devices"); got != requestTimeout {
+		t.Errorf("default timeout = %s, want %s", got, requestTimeout)
+	}
+}
+
+// A temporary unlock left pending on a settled device would be relocked by
+// the enforcer regardless of its terms
+func TestSettledDeviceIsNotRelockedAfterTemporaryUnlock(t *testing.T) {
+	conn := integrationDB(t)
+	serialNumber := "IT" + strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+	t.Cleanup(func() {
+		conn.Exec("DELETE FROM devices WHERE serial_number = $1", serialNumber)
+	})
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", registrationBody(serialNumber, 1)))
+	assertStatus(t, rec, http.StatusOK)
+	relockAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	rec = serve(apiRequest(t, http.MethodPost, "/api/device/"+serialNumber+"/temporary-unlock", `{"relock_at":"`+relockAt+`"}`))
+	assertStatus(t, rec, http.StatusOK)
+	rec = serve(apiRequest(t, http.MethodPost, "/api/device/"+serialNumber+"/settle", ""))
+	assertStatus(t, rec, http.StatusOK)
+
+	// Let the hour pass for any relock that survived the settlement
+	var deviceID string
+	err := conn.QueryRow(
+		"UPDATE devices SET relock_at = CASE WHEN relock_at IS NOT NULL THEN NOW() - INTERVAL '1 minute' END WHERE serial_number = $1 RETURNING id",
+		serialNumber,
+	).Scan(&deviceID)
+	if err != nil {
+		t.Fatalf("expiring relock: %v", err)
+	}
+
+	rec = serve(cronRequest(t, "/api/cron/enforce-locks"))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body EnforceLocksResponse
+	decodeResponse(t, rec, &body)
+	if slices.Contains(body.DeviceIDs, deviceID) {
+		t.Errorf("enforce-locks relocked the settled device %s", deviceID)
+	}
+	var isLocked, remoteLocked bool
+	err = conn.QueryRow(`
+		SELECT d.is_locked, rl.is_locked
+		FROM devices d JOIN remote_locks rl ON rl.device_id = d.id
+		WHERE d.id = $1
+	`, deviceID).Scan(&isLocked, &remoteLocked)
+	if err != nil {
+		t.Fatalf("reading device: %v", err)
+	}
+	if isLocked || remoteLocked {
+		t.Errorf("is_locked = %v, remote lock = %v; want the settled device unlocked", isLocked, remoteLocked)
+	}
+}
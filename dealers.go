@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type Dealer struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	CodeLength   *int      `json:"code_length"`   // nil uses ACTIVATION_CODE_LENGTH
+	CodeAlphabet *string   `json:"code_alphabet"` // nil uses ACTIVATION_CODE_ALPHABET
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// DealerCodeSettings is the activation code format of a dealer. A zero
+// code_length or empty code_alphabet falls back to the server-wide default.
+// expected_devices is only used to check that the format leaves enough
+// distinct codes.
+type DealerCodeSettings struct {
+	CodeLength      *int    `json:"code_length,omitempty"`
+	CodeAlphabet    *string `json:"code_alphabet,omitempty"`
+	ExpectedDevices *int    `json:"expected_devices,omitempty"`
+}
+
+type CreateDealerRequest struct {
+	Name string `json:"name"`
+	DealerCodeSettings
+}
+
+type DealerResponse struct {
+	Success bool   `json:"success"`
+	Dealer  Dealer `json:"dealer"`
+}
+
+type CreateDealerResponse struct {
+	Success bool   `json:"success"`
+	Dealer  Dealer `json:"dealer"`
+	APIKey  string `json:"api_key"`
+}
+
+// validateDealerCodeSettings checks a dealer's code format and returns the
+// values to store, nil meaning the server-wide default. The effective format,
+// defaults included, must have room for expected_devices devices.
+func validateDealerCodeSettings(settings DealerCodeSettings) (*int, *string, *apiError) {
+	var length *int
+	var alphabet *string
+	format := defaultCodeFormat()
+
+	if settings.CodeLength != nil && *settings.CodeLength != 0 {
+		if *settings.CodeLength < minActivationCodeLength || *settings.CodeLength > maxActivationCodeLength {
+			return nil, nil, &apiError{http.StatusBadRequest, "invalid_code_length", fmt.Sprintf("code_length must be between %d and %d", minActivationCodeLength, maxActivationCodeLength)}
+		}
+		length = settings.CodeLength
+		format.length = *length
+	}
+	if settings.CodeAlphabet != nil && *settings.CodeAlphabet != "" {
+		if err := validateCodeAlphabet(*settings.CodeAlphabet); err != nil {
+			return nil, nil, &apiError{http.StatusBadRequest, "invalid_code_alphabet", err.Error()}
+		}
+		alphabet = settings.CodeAlphabet
+		format.alphabet = *alphabet
+	}
+
+	expectedDevices := defaultExpectedDevices
+	if settings.ExpectedDevices != nil {
+		if *settings.ExpectedDevices < 1 || *settings.ExpectedDevices > 10000000 {
+			return nil, nil, &apiError{http.StatusBadRequest, "invalid_expected_devices", "expected_devices must be between 1 and 10000000"}
+		}
+		expectedDevices = *settings.ExpectedDevices
+	}
+	if ok, space, required := codeSpaceSufficient(format, expectedDevices); !ok {
+		message := fmt.Sprintf("%d characters of a %d-character alphabet give %s codes; %d devices need at least %s",
+			format.length, len(format.alphabet), space, expectedDevices, required)
+		return nil, nil, &apiError{http.StatusBadRequest, "insufficient_code_space", message}
+	}
+	return length, alphabet, nil
+}
+
+// requireOperator rejects dealer-scoped keys from operator-only routes. It
+// must run inside authMiddleware.
+func requireOperator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if dealerFromRequest(r) != "" {
+			writeError(w, http.StatusForbidden, "forbidden", "This endpoint requires the operator API key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// generateDealerAPIKey returns a random 32-byte key, hex encoded
+func generateDealerAPIKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// createDealer adds a dealer and returns its API key. Only the key's hash is
+// stored, so the key cannot be shown again.
+func createDealer(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req CreateDealerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 255 {
+		writeError(w, http.StatusBadRequest, "invalid_dealer_name", "Dealer name must be 1-255 characters")
+		return
+	}
+	codeLength, codeAlphabet, apiErr := validateDealerCodeSettings(req.DealerCodeSettings)
+	if apiErr != nil {
+		writeError(w, apiErr.status, apiErr.code, apiErr.message)
+		return
+	}
+
+	apiKey, err := generateDealerAPIKey()
+	if err != nil {
+		errorf(ctx, "Error generating dealer API key: %v", err)
+		writeError(w, http.StatusInternalServerError, "dealer_creation_failed", "Failed to create dealer")
+		return
+	}
+
+	dealer := Dealer{ID: uuid.New().String(), Name: name, CodeLength: codeLength, CodeAlphabet: codeAlphabet, CreatedAt: time.Now()}
+	_, err = db.ExecContext(ctx,
+		"INSERT INTO dealers (id, name, api_key_hash, code_length, code_alphabet, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		dealer.ID, dealer.Name, hashAPIKey(apiKey), dealer.CodeLength, dealer.CodeAlphabet, dealer.CreatedAt,
+	)
+	if err != nil {
+		errorf(ctx, "Error inserting dealer: %v", err)
+		writeDBError(w, r, err, "dealer_creation_failed", "Failed to create dealer")
+		return
+	}
+
+	response := CreateDealerResponse{
+		Success: true,
+		Dealer:  dealer,
+		APIKey:  apiKey,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// updateDealerCodeSettings replaces the activation code format of a dealer.
+// Only codes generated afterwards use it; existing codes are kept.
+func updateDealerCodeSettings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	dealerID := mux.Vars(r)["id"]
+	if _, err := uuid.Parse(dealerID); err != nil {
+		writeError(w, http.StatusNotFound, "dealer_not_found", "Dealer not found")
+		return
+	}
+
+	var req DealerCodeSettings
+	if bodyErr := decodeJSONBody(r.Body, &req); bodyErr != nil {
+		writeBodyError(w, bodyErr)
+		return
+	}
+	codeLength, codeAlphabet, apiErr := validateDealerCodeSettings(req)
+	if apiErr != nil {
+		writeError(w, apiErr.status, apiErr.code, apiErr.message)
+		return
+	}
+
+	var dealer Dealer
+	var storedLength sql.NullInt64
+	var storedAlphabet sql.NullString
+	err := db.QueryRowContext(ctx,
+		"UPDATE dealers SET code_length = $1, code_alphabet = $2 WHERE id = $3 RETURNING id, name, code_length, code_alphabet, created_at",
+		codeLength, codeAlphabet, dealerID,
+	).Scan(&dealer.ID, &dealer.Name, &storedLength, &storedAlphabet, &dealer.CreatedAt)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "dealer_not_found", "Dealer not found")
+		return
+	}
+	if err != nil {
+		errorf(ctx, "Error updating dealer %s: %v", dealerID, err)
+		writeDBError(w, r, err, "dealer_update_failed", "Failed to update dealer")
+		return
+	}
+	if storedLength.Valid {
+		length := int(storedLength.Int64)
+		dealer.CodeLength = &length
+	}
+	if storedAlphabet.Valid {
+		dealer.CodeAlphabet = &storedAlphabet.String
+	}
+
+	response := DealerResponse{
+		Success: true,
+		Dealer:  dealer,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const (
+	dealerA = "0a6f3c52-1f0e-4c55-9d6b-8f6b2d1c0a01"
+	dealerB = "7d2e9b14-5c3a-4e8f-a1d0-3b9c6e4f2a02"
+)
+
+// dealerRequest builds a request authenticated with a dealer's API key and
+// expects the key lookup that resolves it to dealerID
+func dealerRequest(t *testing.T, mock sqlmock.Sqlmock, dealerID, method, target, body string) *http.Request {
+	t.Helper()
+	r := apiRequest(t, method, target, body)
+	r.Header.Set("X-API-Key", "key-of-"+dealerID)
+	mock.ExpectQuery("SELECT id FROM dealers WHERE api_key_hash = \\$1").WithArgs(hashAPIKey("key-of-" + dealerID)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(dealerID))
+	return r
+}
+
+func TestRegistrationStampsDealer(t *testing.T) {
+	mock := mockDB(t)
+	r := dealerRequest(t, mock, dealerA, http.MethodPost, "/api/register", registrationBody("TV100001", 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM devices WHERE serial_number").WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("INSERT INTO devices").
+		WithArgs(sqlmock.AnyArg(), "TV100001", sqlmock.AnyArg(), sqlmock.AnyArg(), 1, sqlmock.AnyArg(), 30, 0, false, false, dealerA, "dealer:"+dealerA, sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT dl.code_alphabet, dl.code_length").
+		WillReturnRows(sqlmock.NewRows([]string{"code_alphabet", "code_length"}).AddRow(nil, nil))
+	expectTermInserts(mock, 1)
+	mock.ExpectExec("INSERT INTO remote_locks").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := serve(r)
+
+	assertStatus(t, rec, http.StatusOK)
+	assertExpectations(t, mock)
+}
+
+func TestCrossDealerAccessIsNotFound(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{"read", http.MethodGet, "/api/device/TV100001", ""},
+		{"remote lock", http.MethodPost, "/api/remote-lock", `{"serial_number":"TV100001","is_locked":true}`},
+		{"path lock", http.MethodPost, "/api/device/TV100001/lock", ""},
+		{"codes", http.MethodGet, "/api/device/TV100001/codes", ""},
+		{"audit", http.MethodGet, "/api/device/TV100001/audit", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := mockDB(t)
+			r := dealerRequest(t, mock, dealerB, tt.method, tt.target, tt.body)
+			// TV100001 belongs to dealer A, so scoping the lookup to dealer B
+			// finds nothing
+			mock.ExpectQuery("serial_number = \\$1 AND deleted_at IS NULL AND \\(\\$2::uuid IS NULL OR dealer_id = \\$2\\)").
+				WithArgs("TV100001", dealerB).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+			rec := serve(r)
+
+			assertStatus(t, rec, http.StatusNotFound)
+			var body ErrorResponse
+			decodeResponse(t, rec, &body)
+			if body.Error.Code != "device_not_found" {
+				t.Errorf("error code = %q, want device_not_found", body.Error.Code)
+			}
+			assertExpectations(t, mock)
+		})
+	}
+}
+
+// registerForDealer registers a one-term device for dealerID, whose dealer
+// row has the given code format, and returns the code it was issued
+func registerForDealer(t *testing.T, dealerID, serialNumber, alphabet string, length int) string {
+	t.Helper()
+	mock := mockDB(t)
+	r := dealerRequest(t, mock, dealerID, http.MethodPost, "/api/register", registrationBody(serialNumber, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM devices WHERE serial_number").WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("INSERT INTO devices").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT dl.code_alphabet, dl.code_length").
+		WillReturnRows(sqlmock.NewRows([]string{"code_alphabet", "code_length"}).AddRow(alphabet, length))
+	expectTermInserts(mock, 1)
+	mock.ExpectExec("INSERT INTO remote_locks").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := serve(r)
+
+	assertStatus(t, rec, http.StatusOK)
+	var body struct {
+		Terms []TermWithLockDateAndCode `json:"terms"`
+	}
+	decodeResponse(t, rec, &body)
+	assertExpectations(t, mock)
+	if len(body.Terms) != 1 {
+		t.Fatalf("terms = %+v, want one", body.Terms)
+	}
+	return body.Terms[0].ActivationCode
+}
+
+func TestDealerCodeFormatsDiffer(t *testing.T) {
+	numeric := registerForDealer(t, dealerA, "TV100001", "0123456789", 8)
+	letters := registerForDealer(t, dealerB, "TV100002", "ABCDEFGHJK", 12)
+
+	if !regexp.MustCompile(`^[0-9]{8}$`).MatchString(numeric) {
+		t.Errorf("dealer A code = %q, want 8 digits", numeric)
+	}
+	if !regexp.MustCompile(`^[A-HJK]{12}$`).MatchString(letters) {
+		t.Errorf("dealer B code = %q, want 12 letters of ABCDEFGHJK", letters)
+	}
+}
+
+func TestValidateDealerCodeSettings(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	strPtr := func(v string) *string { return &v }
+	tests := []struct {
+		name     string
+		settings DealerCodeSettings
+		wantCode string
+	}{
+		{"defaults", DealerCodeSettings{}, ""},
+		{"short numeric for a small dealer", DealerCodeSettings{CodeLength: intPtr(8), CodeAlphabet: strPtr("0123456789"), ExpectedDevices: intPtr(1000)}, ""},
+		{"short numeric for a large dealer", DealerCodeSettings{CodeLength: intPtr(6), CodeAlphabet: strPtr("0123456789"), ExpectedDevices: intPtr(100000)}, "insufficient_code_space"},
+		{"lowercase alphabet", DealerCodeSettings{CodeAlphabet: strPtr("abc123")}, "invalid_code_alphabet"},
+		{"repeated characters", DealerCodeSettings{CodeAlphabet: strPtr("AAB")}, "invalid_code_alphabet"},
+		{"too long", DealerCodeSettings{CodeLength: intPtr(maxActivationCodeLength + 1)}, "invalid_code_length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, apiErr := validateDealerCodeSettings(tt.settings)
+			got := ""
+			if apiErr != nil {
+				got = apiErr.code
+			}
+			if got != tt.wantCode {
+				t.Errorf("error = %+v, want %q", apiErr, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Reasons reported in error.details.reason when a request body is rejected
+const (
+	bodyReasonEmpty        = "empty"
+	bodyReasonMalformed    = "malformed"
+	bodyReasonUnknownField = "unknown_field"
+	bodyReasonWrongType    = "wrong_type"
+	bodyReasonMissing      = "missing"
+	bodyReasonTooLarge     = "too_large"
+)
+
+// requestBodyError explains why a request body was rejected, naming the
+// offending field when there is one
+type requestBodyError struct {
+	Field   string `json:"field,omitempty"`
+	Reason  string `json:"reason"`
+	message string
+	limit   int64 // Body size limit, set when Reason is bodyReasonTooLarge
+}
+
+func (e *requestBodyError) Error() string {
+	return e.message
+}
+
+// missingFieldError reports a required field that was absent or empty
+func missingFieldError(field string) *requestBodyError {
+	return &requestBodyError{Field: field, Reason: bodyReasonMissing, message: field + " is required"}
+}
+
+// decodeJSONBody strictly decodes a JSON object into dst: unknown fields,
+// type mismatches, malformed JSON, and trailing data are all rejected with a
+// requestBodyError that says which field was wrong and why
+func decodeJSONBody(body io.Reader, dst interface{}) *requestBodyError {
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil {
+		if dec.More() {
+			return &requestBodyError{Reason: bodyReasonMalformed, message: "Request body must contain a single JSON object"}
+		}
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxErr):
+		return &requestBodyError{Reason: bodyReasonTooLarge, message: fmt.Sprintf("Request body must be at most %d bytes", maxErr.Limit), limit: maxErr.Limit}
+	case errors.Is(err, io.EOF):
+		return &requestBodyError{Reason: bodyReasonEmpty, message: "Request body is empty"}
+	case errors.As(err, &syntaxErr):
+		return &requestBodyError{Reason: bodyReasonMalformed, message: fmt.Sprintf("Request body is not valid JSON (at byte %d)", syntaxErr.Offset)}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &requestBodyError{Reason: bodyReasonMalformed, message: "Request body is not valid JSON"}
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			expected := "object"
+			if typeErr.Type.Kind() == reflect.Slice {
+				expected = "array"
+			}
+			return &requestBodyError{Reason: bodyReasonWrongType, message: fmt.Sprintf("Request body must be a JSON %s, not %s", expected, typeErr.Value)}
+		}
+		return &requestBodyError{
+			Field:   typeErr.Field,
+			Reason:  bodyReasonWrongType,
+			message: fmt.Sprintf("%s must be of type %s, not %s", typeErr.Field, typeErr.Type, typeErr.Value),
+		}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for unknown fields
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return &requestBodyError{Field: field, Reason: bodyReasonUnknownField, message: fmt.Sprintf("Unknown field %q", field)}
+	default:
+		return &requestBodyError{Reason: bodyReasonMalformed, message: "Invalid request body"}
+	}
+}
+
+// decodeJSONBytes is decodeJSONBody for a body that has already been read
+func decodeJSONBytes(body []byte, dst interface{}) *requestBodyError {
+	return decodeJSONBody(bytes.NewReader(body), dst)
+}
+
+// writeBodyError writes a 400 invalid_request_body with the field and reason
+// under error.details, or a 413 when the body was over the size limit
+func writeBodyError(w http.ResponseWriter, e *requestBodyError) {
+	if e.Reason == bodyReasonTooLarge {
+		writeBodyTooLarge(w, e.limit)
+		return
+	}
+	writeErrorWithDetails(w, http.StatusBadRequest, "invalid_request_body", e.message, e)
+}
+
+// writeDecodeError reports a body that a lenient json.Decoder could not
+// decode: 413 when it was over the size limit, otherwise 400
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		writeBodyTooLarge(w, maxErr.Limit)
+		return
+	}
+	writeError(w, http.StatusBadRequest, "invalid_request_body", "Invalid request body")
+}
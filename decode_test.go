@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeJSONBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantField  string
+		wantReason string
+	}{
+		{"valid", `{"serial_number":"TV100001","is_locked":true}`, "", ""},
+		{"unknown field", `{"serial_number":"TV100001","is_lockd":true}`, "is_lockd", bodyReasonUnknownField},
+		{"wrong type", `{"serial_number":"TV100001","is_locked":"yes"}`, "is_locked", bodyReasonWrongType},
+		{"not an object", `["TV100001"]`, "", bodyReasonWrongType},
+		{"malformed", `{"serial_number":`, "", bodyReasonMalformed},
+		{"trailing data", `{"serial_number":"TV100001"} {}`, "", bodyReasonMalformed},
+		{"empty", ``, "", bodyReasonEmpty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req RemoteLockRequest
+			err := decodeJSONBody(strings.NewReader(tt.body), &req)
+
+			if tt.wantReason == "" {
+				if err != nil {
+					t.Fatalf("error = %v, want none", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("no error, want %s", tt.wantReason)
+			}
+			if err.Field != tt.wantField || err.Reason != tt.wantReason || err.Error() == "" {
+				t.Errorf("error = %+v (%q), want field %q, reason %s", err, err.Error(), tt.wantField, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestHandlersNameTheOffendingField(t *testing.T) {
+	tests := []struct {
+		target     string
+		body       string
+		wantField  string
+		wantReason string
+	}{
+		{"/api/register", `{"serial_number":"TV100001","emi_term":"six"}`, "emi_term", bodyReasonWrongType},
+		{"/api/register", `{"serial_number":"TV100001","emi_terms":6}`, "emi_terms", bodyReasonUnknownField},
+		{"/api/activate", `{"activation_code":12345}`, "activation_code", bodyReasonWrongType},
+		{"/api/activate", `{"code":"ABC"}`, "code", bodyReasonUnknownField},
+		{"/api/remote-lock", `{"serial_number":"TV100001","is_locked":"true"}`, "is_locked", bodyReasonWrongType},
+		{"/api/remote-lock", `{"serial":"TV100001","is_locked":true}`, "serial", bodyReasonUnknownField},
+		{"/api/unlock", `{"serial_number":["TV100001"]}`, "serial_number", bodyReasonWrongType},
+		{"/api/unlock", `{"serial_number":"TV100001","force":true}`, "force", bodyReasonUnknownField},
+		{"/api/unlock", `{}`, "serial_number", bodyReasonMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target+" "+tt.wantField+" "+tt.wantReason, func(t *testing.T) {
+			mock := mockDB(t)
+			previous := activationLimiter
+			activationLimiter = newTokenBucketLimiter(10, time.Minute)
+			t.Cleanup(func() { activationLimiter = previous })
+
+			rec := serve(apiRequest(t, http.MethodPost, tt.target, tt.body))
+
+			assertStatus(t, rec, http.StatusBadRequest)
+			var body struct {
+				Error struct {
+					Code    string           `json:"code"`
+					Details requestBodyError `json:"details"`
+				} `json:"error"`
+			}
+			decodeResponse(t, rec, &body)
+			if body.Error.Code != "invalid_request_body" || body.Error.Details.Field != tt.wantField || body.Error.Details.Reason != tt.wantReason {
+				t.Errorf("error = %+v, want invalid_request_body naming %s (%s)", body.Error, tt.wantField, tt.wantReason)
+			}
+			assertExpectations(t, mock)
+		})
+	}
+}
@@ -0,0 +1,549 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultDeviceListLimit = 50
+	maxDeviceListLimit     = 200
+)
+
+type DeviceListResponse struct {
+	Success    bool     `json:"success"`
+	Total      int      `json:"total"`
+	Limit      int      `json:"limit"`
+	Offset     int      `json:"offset"`
+	NextCursor *string  `json:"next_cursor"` // Pass back as cursor for the next page; nil on the last page
+	Devices    []Device `json:"devices"`
+}
+
+// deviceCursor is the position of the last device on a page. Pages are
+// ordered by (created_at, id) descending, so the next page starts strictly
+// after it and concurrent inserts cannot shift rows between pages.
+type deviceCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// encodeDeviceCursor turns a cursor into the opaque token returned as next_cursor
+func encodeDeviceCursor(c deviceCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt.Format(time.RFC3339Nano) + "|" + c.ID))
+}
+
+// decodeDeviceCursor parses a token produced by encodeDeviceCursor
+func decodeDeviceCursor(token string) (deviceCursor, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return deviceCursor{}, false
+	}
+	createdAt, id, found := strings.Cut(string(raw), "|")
+	if !found || id == "" {
+		return deviceCursor{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return deviceCursor{}, false
+	}
+	return deviceCursor{CreatedAt: t, ID: id}, true
+}
+
+// UpdateDeviceRequest holds the customer contact fields that can be changed
+// after registration; nil fields are left untouched. Version must match the
+// device's current version, so an edit based on stale data is rejected.
+type UpdateDeviceRequest struct {
+	CustomerName *string `json:"customer_name"`
+	PhoneNumber  *string `json:"phone_number"`
+	Version      *int    `json:"version"`
+}
+
+type DeviceDetailResponse struct {
+	Success         bool             `json:"success"`
+	Device          Device           `json:"device"`
+	ActivationCodes []ActivationCode `json:"activation_codes"`
+	LockDates       []LockDate       `json:"lock_dates"`
+	RemoteLocked    bool             `json:"remote_locked"`
+	DeviceToken     string           `json:"device_token,omitempty"`
+	// Unpaid terms times the installment amount, nil without an amount
+	OutstandingBalance *float64 `json:"outstanding_balance"`
+}
+
+// deviceColumns lists the devices columns in the order scanDevice reads them
+const deviceColumns = `id, serial_number, customer_name, phone_number,
+	emi_term, emi_start_date, term_duration, grace_days,
+	is_active, is_locked, dealer_id, created_by, created_at, version, snooze_until, relock_at, retired_at, force_locked_at, last_seen_at, installment_amount`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanDevice reads a row selected with deviceColumns into device and derives
+// its offline flag
+func scanDevice(row rowScanner, device *Device) error {
+	err := row.Scan(
+		&device.ID, &device.SerialNumber, &device.CustomerName, &device.PhoneNumber,
+		&device.EMITerm, &device.EMIStartDate, &device.TermDuration, &device.GraceDays,
+		&device.IsActive, &device.IsLocked, &device.DealerID, &device.CreatedBy, &device.CreatedAt, &device.Version, &device.SnoozeUntil, &device.RelockAt, &device.RetiredAt, &device.ForceLockedAt, &device.LastSeenAt, &device.InstallmentAmount,
+	)
+	if err == nil {
+		device.Offline = isOffline(device.LastSeenAt, device.CreatedAt, time.Now())
+	}
+	return err
+}
+
+// parseNonNegativeInt reads an optional integer query parameter, returning
+// def when the parameter is absent
+func parseNonNegativeInt(r *http.Request, name string, def int) (int, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, true
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		return 0, false
+	}
+	return value, true
+}
+
+// likeEscaper escapes the LIKE wildcards, and the backslash that escapes
+// them, so user input only ever matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func escapeLikePattern(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// parseOptionalBool reads an optional boolean query parameter, returning nil
+// when the parameter is absent
+func parseOptionalBool(r *http.Request, name string) (*bool, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, true
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, false
+	}
+	return &value, true
+}
+
+func listDevices(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	limit, ok := parseNonNegativeInt(r, "limit", defaultDeviceListLimit)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_limit", "limit must be a non-negative integer")
+		return
+	}
+	if limit > maxDeviceListLimit {
+		limit = maxDeviceListLimit
+	}
+
+	offset, ok := parseNonNegativeInt(r, "offset", 0)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_offset", "offset must be a non-negative integer")
+		return
+	}
+
+	// A cursor replaces offset: the page starts after the cursor's device
+	var cursor *deviceCursor
+	if token := r.URL.Query().Get("cursor"); token != "" {
+		if r.URL.Query().Get("offset") != "" {
+			writeError(w, http.StatusBadRequest, "invalid_offset", "offset cannot be combined with cursor")
+			return
+		}
+		decoded, ok := decodeDeviceCursor(token)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid_cursor", "cursor is not a valid next_cursor token")
+			return
+		}
+		cursor = &decoded
+	}
+
+	// Optional filters, ANDed together with the soft-delete and dealer filters
+	conditions := []string{"deleted_at IS NULL"}
+	args := make([]interface{}, 0)
+	if dealerID := dealerFromRequest(r); dealerID != "" {
+		args = append(args, dealerID)
+		conditions = append(conditions, fmt.Sprintf("dealer_id = $%d", len(args)))
+	}
+	for _, column := range []string{"is_locked", "is_active"} {
+		value, ok := parseOptionalBool(r, column)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid_filter", fmt.Sprintf("%s must be true or false", column))
+			return
+		}
+		if value != nil {
+			args = append(args, *value)
+			conditions = append(conditions, fmt.Sprintf("%s = $%d", column, len(args)))
+		}
+	}
+	// A customer may own several devices, so a phone lookup returns all of them
+	if raw := r.URL.Query().Get("phone"); raw != "" {
+		phoneNumber, err := validatePhone(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_phone_number", err.Error())
+			return
+		}
+		args = append(args, phoneNumber)
+		conditions = append(conditions, fmt.Sprintf("phone_number = $%d", len(args)))
+	}
+	// A single search box matches part of the customer name or the serial
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		args = append(args, "%"+escapeLikePattern(q)+"%")
+		conditions = append(conditions, fmt.Sprintf("(customer_name ILIKE $%d OR serial_number ILIKE $%d)", len(args), len(args)))
+	}
+	whereClause := "WHERE " + strings.Join(conditions, " AND ")
+
+	var total int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM devices "+whereClause, args...).Scan(&total); err != nil {
+		errorf(ctx, "Error counting devices: %v", err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch devices")
+		return
+	}
+
+	// total counts every page, so the cursor only narrows the page query
+	if cursor != nil {
+		args = append(args, cursor.CreatedAt, cursor.ID)
+		whereClause += fmt.Sprintf(" AND (created_at, id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM devices
+		%s
+		ORDER BY created_at DESC, id DESC
+		LIMIT $%d OFFSET $%d
+	`, deviceColumns, whereClause, len(args)+1, len(args)+2)
+	rows, err := db.QueryContext(ctx, query, append(args, limit+1, offset)...)
+	if err != nil {
+		errorf(ctx, "Error fetching devices: %v", err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch devices")
+		return
+	}
+	defer rows.Close()
+
+	devices := make([]Device, 0)
+	for rows.Next() {
+		var device Device
+		if err := scanDevice(rows, &device); err != nil {
+			errorf(ctx, "Error scanning device: %v", err)
+			continue
+		}
+		devices = append(devices, device)
+	}
+
+	var nextCursor *string
+	if len(devices) > limit {
+		devices = devices[:limit]
+		if limit > 0 {
+			last := devices[limit-1]
+			token := encodeDeviceCursor(deviceCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+			nextCursor = &token
+		}
+	}
+
+	response := DeviceListResponse{
+		Success:    true,
+		Total:      total,
+		Limit:      limit,
+		Offset:     offset,
+		NextCursor: nextCursor,
+		Devices:    devices,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func getDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	var device Device
+	row := db.QueryRowContext(ctx, "SELECT "+deviceColumns+" FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2)", serialNumber, dealerArg(r))
+	if err := scanDevice(row, &device); err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	// Get activation codes
+	activationCodes, err := fetchActivationCodes(ctx, device.ID)
+	if err != nil {
+		errorf(ctx, "Error fetching activation codes for device %s: %v", device.ID, err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch device")
+		return
+	}
+
+	// Get lock dates
+	lockDates := make([]LockDate, 0)
+	lockRows, err := db.QueryContext(ctx, `
+		SELECT id, device_id, term_number, lock_date, is_locked, paid_at, extension_days, created_at
+		FROM lock_dates
+		WHERE device_id = $1
+		ORDER BY term_number
+	`, device.ID)
+	if err != nil {
+		errorf(ctx, "Error fetching lock dates for device %s: %v", device.ID, err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch device")
+		return
+	}
+	defer lockRows.Close()
+	for lockRows.Next() {
+		var lockDate LockDate
+		if err := lockRows.Scan(&lockDate.ID, &lockDate.DeviceID, &lockDate.TermNumber, &lockDate.LockDate, &lockDate.IsLocked, &lockDate.PaidAt, &lockDate.ExtensionDays, &lockDate.CreatedAt); err != nil {
+			errorf(ctx, "Error scanning lock date: %v", err)
+			continue
+		}
+		lockDates = append(lockDates, lockDate)
+	}
+	unpaidTerms := 0
+	for _, lockDate := range lockDates {
+		if lockDate.PaidAt == nil {
+			unpaidTerms++
+		}
+	}
+
+	// Get remote lock state (a missing row means not remotely locked)
+	var remoteLocked bool
+	err = db.QueryRowContext(ctx, "SELECT is_locked FROM remote_locks WHERE device_id = $1", device.ID).Scan(&remoteLocked)
+	if err != nil && err != sql.ErrNoRows {
+		errorf(ctx, "Error fetching remote lock for device %s: %v", device.ID, err)
+	}
+
+	response := DeviceDetailResponse{
+		Success:            true,
+		Device:             device,
+		ActivationCodes:    activationCodes,
+		LockDates:          lockDates,
+		RemoteLocked:       remoteLocked,
+		DeviceToken:        deviceToken(device.ID),
+		OutstandingBalance: outstandingBalance(device.InstallmentAmount, unpaidTerms),
+	}
+
+	writeJSONWithETag(w, r, response)
+}
+
+// updateDevice changes a device's customer name and/or phone number, applying
+// the same validation as registration
+func updateDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	var req UpdateDeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.CustomerName == nil && req.PhoneNumber == nil {
+		writeError(w, http.StatusBadRequest, "no_fields_to_update", "customer_name or phone_number is required")
+		return
+	}
+	if req.Version == nil {
+		writeBodyError(w, missingFieldError("version"))
+		return
+	}
+
+	assignments := make([]string, 0)
+	args := make([]interface{}, 0)
+	changed := make([]string, 0)
+	if req.CustomerName != nil {
+		customerName, err := validateCustomerName(*req.CustomerName)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_customer_name", err.Error())
+			return
+		}
+		args = append(args, customerName)
+		assignments = append(assignments, fmt.Sprintf("customer_name = $%d", len(args)))
+		changed = append(changed, "customer_name")
+	}
+	if req.PhoneNumber != nil {
+		phoneNumber, err := validatePhone(*req.PhoneNumber)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_phone_number", err.Error())
+			return
+		}
+		args = append(args, phoneNumber)
+		assignments = append(assignments, fmt.Sprintf("phone_number = $%d", len(args)))
+		changed = append(changed, "phone_number")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "update_failed", "Failed to update device")
+		return
+	}
+	defer tx.Rollback()
+
+	assignments = append(assignments, "version = version + 1")
+	query := fmt.Sprintf(
+		"UPDATE devices SET %s WHERE serial_number = $%d AND deleted_at IS NULL AND ($%d::uuid IS NULL OR dealer_id = $%d) AND version = $%d RETURNING %s",
+		strings.Join(assignments, ", "), len(args)+1, len(args)+2, len(args)+2, len(args)+3, deviceColumns,
+	)
+	var device Device
+	err = scanDevice(tx.QueryRowContext(ctx, query, append(args, serialNumber, dealerArg(r), *req.Version)...), &device)
+	if err == sql.ErrNoRows {
+		// Nothing matched: either the device does not exist or someone else
+		// updated it since the client read it
+		var currentVersion int
+		lookupErr := tx.QueryRowContext(ctx,
+			"SELECT version FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2)",
+			serialNumber, dealerArg(r),
+		).Scan(&currentVersion)
+		if lookupErr != nil {
+			writeDeviceLookupError(w, r, lookupErr)
+			return
+		}
+		writeErrorWithDetails(w, http.StatusConflict, "version_conflict", "Device was changed by another request; reload it and retry",
+			map[string]interface{}{"current_version": currentVersion})
+		return
+	}
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	details := "Updated " + strings.Join(changed, ", ")
+	if err = appendAudit(ctx, tx, device.ID, "update", actorFromRequest(r), details); err != nil {
+		errorf(ctx, "Error writing audit log: %v", err)
+		writeDBError(w, r, err, "update_failed", "Failed to update device")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing device update: %v", err)
+		writeDBError(w, r, err, "update_failed", "Failed to update device")
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Device updated successfully",
+		"device":  device,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// deleteDevice soft-deletes a device by setting deleted_at. Its codes, lock
+// dates and audit history are kept, and the serial number becomes free to
+// register again.
+func deleteDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "delete_failed", "Failed to delete device")
+		return
+	}
+	defer tx.Rollback()
+
+	var deviceID string
+	err = tx.QueryRowContext(ctx,
+		"UPDATE devices SET deleted_at = NOW() WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2) RETURNING id",
+		serialNumber, dealerArg(r),
+	).Scan(&deviceID)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	if err = appendAudit(ctx, tx, deviceID, "delete", actorFromRequest(r), "Device deleted"); err != nil {
+		errorf(ctx, "Error writing audit log: %v", err)
+		writeDBError(w, r, err, "delete_failed", "Failed to delete device")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing device deletion: %v", err)
+		writeDBError(w, r, err, "delete_failed", "Failed to delete device")
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Device deleted successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// restoreDevice undoes the most recent soft-delete of a serial number. It
+// fails with 409 when the serial has since been registered to another device.
+func restoreDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "restore_failed", "Failed to restore device")
+		return
+	}
+	defer tx.Rollback()
+
+	var device Device
+	row := tx.QueryRowContext(ctx, `
+		UPDATE devices SET deleted_at = NULL
+		WHERE id = (
+			SELECT id FROM devices
+			WHERE serial_number = $1 AND deleted_at IS NOT NULL
+			  AND ($2::uuid IS NULL OR dealer_id = $2)
+			ORDER BY deleted_at DESC
+			LIMIT 1
+		)
+		RETURNING `+deviceColumns, serialNumber, dealerArg(r))
+	if err = scanDevice(row, &device); err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "deleted_device_not_found", "No deleted device with this serial number")
+			return
+		}
+		if isUniqueViolation(err) {
+			writeError(w, http.StatusConflict, "duplicate_serial", "Device with this serial number already exists")
+			return
+		}
+		errorf(ctx, "Error restoring device %s: %v", serialNumber, err)
+		writeDBError(w, r, err, "restore_failed", "Failed to restore device")
+		return
+	}
+
+	if err = appendAudit(ctx, tx, device.ID, "restore", actorFromRequest(r), "Device restored"); err != nil {
+		errorf(ctx, "Error writing audit log: %v", err)
+		writeDBError(w, r, err, "restore_failed", "Failed to restore device")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing device restore: %v", err)
+		writeDBError(w, r, err, "restore_failed", "Failed to restore device")
+		return
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Device restored successfully",
+		"device":  device,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,372 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestListDevicesFilters(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		where string
+		args  []driver.Value
+	}{
+		{"no filter", "", "WHERE deleted_at IS NULL", nil},
+		{"locked and active", "?is_locked=true&is_active=true", "WHERE deleted_at IS NULL AND is_locked = $1 AND is_active = $2", []driver.Value{true, true}},
+		{"locked and inactive", "?is_locked=true&is_active=false", "WHERE deleted_at IS NULL AND is_locked = $1 AND is_active = $2", []driver.Value{true, false}},
+		{"unlocked and active", "?is_locked=false&is_active=true", "WHERE deleted_at IS NULL AND is_locked = $1 AND is_active = $2", []driver.Value{false, true}},
+		{"unlocked and inactive", "?is_locked=false&is_active=false", "WHERE deleted_at IS NULL AND is_locked = $1 AND is_active = $2", []driver.Value{false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := mockDB(t)
+			device := testDevice("d1", "TV100001")
+
+			count := mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM devices "+tt.where) + "$")
+			page := mock.ExpectQuery(regexp.QuoteMeta(tt.where))
+			if tt.args != nil {
+				count.WithArgs(tt.args...)
+				page.WithArgs(append(tt.args, defaultDeviceListLimit+1, 0)...)
+			}
+			count.WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			page.WillReturnRows(deviceRows(device))
+
+			rec := serve(apiRequest(t, http.MethodGet, "/api/devices"+tt.query, ""))
+
+			assertStatus(t, rec, http.StatusOK)
+			var body DeviceListResponse
+			decodeResponse(t, rec, &body)
+			if body.Total != 1 || len(body.Devices) != 1 || body.Devices[0].SerialNumber != device.SerialNumber {
+				t.Errorf("response = %+v, want the one matching device", body)
+			}
+			assertExpectations(t, mock)
+		})
+	}
+}
+
+func TestListDevicesRejectsInvalidBoolean(t *testing.T) {
+	for _, query := range []string{"?is_locked=yes", "?is_active=maybe"} {
+		t.Run(query, func(t *testing.T) {
+			mock := mockDB(t)
+
+			rec := serve(apiRequest(t, http.MethodGet, "/api/devices"+query, ""))
+
+			assertStatus(t, rec, http.StatusBadRequest)
+			var body ErrorResponse
+			decodeResponse(t, rec, &body)
+			if body.Error.Code != "invalid_filter" || body.Error.Message == "" {
+				t.Errorf("error = %+v, want invalid_filter with a message", body.Error)
+			}
+			assertExpectations(t, mock)
+		})
+	}
+}
+
+// expectDeviceDetail expects GET /api/device/{serial} to find device, with
+// paid marking which of its terms have been paid
+func expectDeviceDetail(mock sqlmock.Sqlmock, device Device, paid ...bool) {
+	mock.ExpectQuery("SELECT id, serial_number, customer_name").
+		WillReturnRows(deviceRows(device))
+	mock.ExpectQuery("FROM activation_codes").WithArgs(device.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "device_id", "code", "term_number", "is_used", "used_at", "expires_at", "created_at"}))
+	lockDates := sqlmock.NewRows([]string{"id", "device_id", "term_number", "lock_date", "is_locked", "paid_at", "extension_days", "created_at"})
+	for i, isPaid := range paid {
+		var paidAt *time.Time
+		if isPaid {
+			now := time.Now()
+			paidAt = &now
+		}
+		lockDates.AddRow(fmt.Sprintf("ld%d", i+1), device.ID, i+1, device.EMIStartDate.AddDate(0, i+1, 0), false, nullable(paidAt), 0, device.CreatedAt)
+	}
+	mock.ExpectQuery("FROM lock_dates").WithArgs(device.ID).WillReturnRows(lockDates)
+	mock.ExpectQuery("SELECT is_locked FROM remote_locks").WithArgs(device.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"is_locked"}).AddRow(false))
+}
+
+func TestDeletedDeviceCanBeRestored(t *testing.T) {
+	mock := mockDB(t)
+	device := testDevice("d1", "TV100001")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE devices SET deleted_at = NOW\\(\\)").WithArgs("TV100001", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("d1"))
+	mock.ExpectExec("INSERT INTO audit_logs").WithArgs(sqlmock.AnyArg(), "d1", "delete", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	rec := serve(apiRequest(t, http.MethodDelete, "/api/device/TV100001", ""))
+	assertStatus(t, rec, http.StatusOK)
+
+	// Lookups skip deleted devices
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE serial_number = $1 AND deleted_at IS NULL")).
+		WillReturnRows(deviceRows())
+	rec = serve(apiRequest(t, http.MethodGet, "/api/device/TV100001", ""))
+	assertStatus(t, rec, http.StatusNotFound)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE devices SET deleted_at = NULL").WithArgs("TV100001", nil).
+		WillReturnRows(deviceRows(device))
+	mock.ExpectExec("INSERT INTO audit_logs").WithArgs(sqlmock.AnyArg(), "d1", "restore", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	rec = serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/restore", ""))
+	assertStatus(t, rec, http.StatusOK)
+
+	expectDeviceDetail(mock, device)
+	rec = serve(apiRequest(t, http.MethodGet, "/api/device/TV100001", ""))
+	assertStatus(t, rec, http.StatusOK)
+	assertExpectations(t, mock)
+}
+
+func TestRestoreWithoutDeletedDevice(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE devices SET deleted_at = NULL").WillReturnRows(deviceRows())
+	mock.ExpectRollback()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/restore", ""))
+
+	assertStatus(t, rec, http.StatusNotFound)
+	assertExpectations(t, mock)
+}
+
+func TestUpdateDevicePartial(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		assignment string
+		args       []driver.Value
+		wantField  string
+	}{
+		{"name only", `{"customer_name":" New Name ","version":1}`, "SET customer_name = $1, version = version + 1 WHERE", []driver.Value{"New Name", "TV100001", nil, 1}, "customer_name"},
+		{"phone only", `{"phone_number":"+1 555 987 6543","version":1}`, "SET phone_number = $1, version = version + 1 WHERE", []driver.Value{"+15559876543", "TV100001", nil, 1}, "phone_number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := mockDB(t)
+			updated := testDevice("d1", "TV100001")
+			updated.Version = 2
+			mock.ExpectBegin()
+			mock.ExpectQuery(regexp.QuoteMeta(tt.assignment)).WithArgs(tt.args...).WillReturnRows(deviceRows(updated))
+			mock.ExpectExec("INSERT INTO audit_logs").WithArgs(sqlmock.AnyArg(), "d1", "update", sqlmock.AnyArg(), "Updated "+tt.wantField, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			rec := serve(apiRequest(t, http.MethodPatch, "/api/device/TV100001", tt.body))
+
+			assertStatus(t, rec, http.StatusOK)
+			assertExpectations(t, mock)
+		})
+	}
+}
+
+func TestUpdateDeviceRejectsEmptyUpdate(t *testing.T) {
+	mock := mockDB(t)
+
+	rec := serve(apiRequest(t, http.MethodPatch, "/api/device/TV100001", `{"version":1}`))
+
+	assertStatus(t, rec, http.StatusBadRequest)
+	assertExpectations(t, mock)
+}
+
+func TestUpdateDeviceUnknownSerial(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE devices SET customer_name").WillReturnRows(deviceRows())
+	mock.ExpectQuery("SELECT version FROM devices").WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	mock.ExpectRollback()
+
+	rec := serve(apiRequest(t, http.MethodPatch, "/api/device/TV404", `{"customer_name":"New Name","version":1}`))
+
+	assertStatus(t, rec, http.StatusNotFound)
+	assertExpectations(t, mock)
+}
+
+func TestUpdateDeviceStaleVersionConflicts(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE devices SET customer_name").WithArgs("New Name", "TV100001", nil, 1).WillReturnRows(deviceRows())
+	mock.ExpectQuery("SELECT version FROM devices").WithArgs("TV100001", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+	mock.ExpectRollback()
+
+	rec := serve(apiRequest(t, http.MethodPatch, "/api/device/TV100001", `{"customer_name":"New Name","version":1}`))
+
+	assertStatus(t, rec, http.StatusConflict)
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Details struct {
+				CurrentVersion int `json:"current_version"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	decodeResponse(t, rec, &body)
+	if body.Error.Code != "version_conflict" || body.Error.Details.CurrentVersion != 3 {
+		t.Errorf("error = %+v, want version_conflict at version 3", body.Error)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestListDevicesByPhone(t *testing.T) {
+	const where = "WHERE deleted_at IS NULL AND phone_number = $1"
+	t.Run("two devices share a phone", func(t *testing.T) {
+		mock := mockDB(t)
+		first, second := testDevice("d1", "TV100001"), testDevice("d2", "TV100002")
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM devices "+where) + "$").WithArgs("+15551234567").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery(regexp.QuoteMeta(where)).WithArgs("+15551234567", defaultDeviceListLimit+1, 0).
+			WillReturnRows(deviceRows(second, first))
+
+		rec := serve(apiRequest(t, http.MethodGet, "/api/devices?phone="+url.QueryEscape("+1 (555) 123-4567"), ""))
+
+		assertStatus(t, rec, http.StatusOK)
+		var body DeviceListResponse
+		decodeResponse(t, rec, &body)
+		if body.Total != 2 || len(body.Devices) != 2 {
+			t.Errorf("response = %+v, want both devices", body)
+		}
+		assertExpectations(t, mock)
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		mock := mockDB(t)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*)")).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(regexp.QuoteMeta(where)).WillReturnRows(deviceRows())
+
+		rec := serve(apiRequest(t, http.MethodGet, "/api/devices?phone=%2B15559999999", ""))
+
+		assertStatus(t, rec, http.StatusOK)
+		if !strings.Contains(rec.Body.String(), `"devices":[]`) {
+			t.Errorf("body = %s, want an empty devices array", rec.Body.String())
+		}
+		assertExpectations(t, mock)
+	})
+
+	t.Run("invalid phone", func(t *testing.T) {
+		mock := mockDB(t)
+
+		rec := serve(apiRequest(t, http.MethodGet, "/api/devices?phone=call-me", ""))
+
+		assertStatus(t, rec, http.StatusBadRequest)
+		assertExpectations(t, mock)
+	})
+}
+
+// sameTime matches a time argument equal to the expected instant, whatever
+// its location
+type sameTime time.Time
+
+func (s sameTime) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && t.Equal(time.Time(s))
+}
+
+func TestListDevicesSearch(t *testing.T) {
+	const where = "WHERE deleted_at IS NULL AND (customer_name ILIKE $1 OR serial_number ILIKE $1)"
+	tests := []struct {
+		name    string
+		q       string
+		pattern string
+	}{
+		{"partial name", "ohn", "%ohn%"},
+		{"partial serial", "tv1000", "%tv1000%"},
+		{"wildcards match literally", `50%_off\`, `%50\%\_off\\%`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := mockDB(t)
+			match := testDevice("d1", "TV100001")
+			match.CustomerName = "John Doe"
+
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM devices "+where) + "$").WithArgs(tt.pattern).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			mock.ExpectQuery(regexp.QuoteMeta(where)).WithArgs(tt.pattern, defaultDeviceListLimit+1, 0).
+				WillReturnRows(deviceRows(match))
+
+			rec := serve(apiRequest(t, http.MethodGet, "/api/devices?q="+url.QueryEscape(tt.q), ""))
+
+			assertStatus(t, rec, http.StatusOK)
+			var body DeviceListResponse
+			decodeResponse(t, rec, &body)
+			if body.Total != 1 || len(body.Devices) != 1 || body.Devices[0].ID != "d1" {
+				t.Errorf("response = %+v, want the matching device", body)
+			}
+			assertExpectations(t, mock)
+		})
+	}
+}
+
+func TestListDevicesCursorPaging(t *testing.T) {
+	const total, limit = 150, 40
+	mock := mockDB(t)
+
+	// Newest first; devices are created in pairs so the id breaks ties
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	devices := make([]Device, total)
+	for i := range devices {
+		devices[i] = testDevice(fmt.Sprintf("d%03d", total-i), fmt.Sprintf("TV%06d", total-i))
+		devices[i].CreatedAt = base.Add(time.Duration((total-i+1)/2) * time.Minute)
+	}
+
+	seen := make(map[string]bool)
+	var order []string
+	cursor := ""
+	for page := 0; ; page++ {
+		start := page * limit
+		end := min(start+limit+1, total)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM devices WHERE deleted_at IS NULL") + "$").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(total))
+		if cursor == "" {
+			mock.ExpectQuery("ORDER BY created_at DESC, id DESC").WithArgs(limit+1, 0).
+				WillReturnRows(deviceRows(devices[start:end]...))
+		} else {
+			last := devices[start-1]
+			mock.ExpectQuery(regexp.QuoteMeta("AND (created_at, id) < ($1, $2)")).WithArgs(sameTime(last.CreatedAt), last.ID, limit+1, 0).
+				WillReturnRows(deviceRows(devices[start:end]...))
+		}
+
+		target := fmt.Sprintf("/api/devices?limit=%d", limit)
+		if cursor != "" {
+			target += "&cursor=" + cursor
+		}
+		rec := serve(apiRequest(t, http.MethodGet, target, ""))
+		assertStatus(t, rec, http.StatusOK)
+		var body DeviceListResponse
+		decodeResponse(t, rec, &body)
+
+		for _, device := range body.Devices {
+			if seen[device.ID] {
+				t.Fatalf("page %d repeats device %s", page, device.ID)
+			}
+			seen[device.ID] = true
+			order = append(order, device.ID)
+		}
+		if body.NextCursor == nil {
+			break
+		}
+		if page > total/limit {
+			t.Fatal("paging did not end")
+		}
+		cursor = *body.NextCursor
+	}
+
+	if len(order) != total {
+		t.Fatalf("paged through %d devices, want %d", len(order), total)
+	}
+	for i, id := range order {
+		if id != devices[i].ID {
+			t.Fatalf("device %d = %s, want %s; a page skipped or reordered devices", i, id, devices[i].ID)
+		}
+	}
+	assertExpectations(t, mock)
+}
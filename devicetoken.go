@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// deviceToken returns the token a TV presents when it polls: an HMAC-SHA256
+// of the device id under DEVICE_TOKEN_SECRET. Tokens are derived rather than
+// stored, so rotating the secret revokes every token at once. It returns ""
+// while no secret is configured.
+func deviceToken(deviceID string) string {
+	secret := os.Getenv("DEVICE_TOKEN_SECRET")
+	if secret == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(deviceID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// deviceTokenGrace reports whether polls without a token are still accepted.
+// It is meant for the rollout, while TVs registered before tokens existed are
+// being updated; a token that is sent must be valid either way.
+func deviceTokenGrace() bool {
+	raw := os.Getenv("DEVICE_TOKEN_GRACE")
+	if raw == "" {
+		return false
+	}
+	grace, err := strconv.ParseBool(raw)
+	if err != nil {
+		warnf(context.Background(), "Invalid DEVICE_TOKEN_GRACE %q, tokens are required", raw)
+		return false
+	}
+	return grace
+}
+
+// authorizeDevice checks the bearer token of a TV poll against the device it
+// asks about, writing 401 and returning false when the poll may not proceed.
+// Every poll is allowed while DEVICE_TOKEN_SECRET is unset.
+func authorizeDevice(w http.ResponseWriter, r *http.Request, deviceID string) bool {
+	expected := deviceToken(deviceID)
+	if expected == "" {
+		return true
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		if deviceTokenGrace() {
+			warnf(r.Context(), "Device %s polled without a device token", deviceID)
+			return true
+		}
+		writeError(w, http.StatusUnauthorized, "missing_device_token", "Authorization: Bearer <device_token> is required")
+		return false
+	}
+
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || !hmac.Equal([]byte(strings.TrimSpace(token)), []byte(expected)) {
+		writeError(w, http.StatusUnauthorized, "invalid_device_token", "Invalid device token")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// pollWithToken polls /api/check for TV100001 with an optional bearer token
+func pollWithToken(token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/api/check?serial_number=TV100001", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return serve(r)
+}
+
+// expectDeviceFound expects only the device lookup of a poll that is
+// rejected before anything else is read
+func expectDeviceFound(mock sqlmock.Sqlmock, deviceID string) {
+	mock.ExpectQuery("SELECT id, is_active, retired_at FROM devices").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "is_active", "retired_at"}).AddRow(deviceID, true, nil))
+}
+
+func TestDeviceTokenAuthorizesPolls(t *testing.T) {
+	t.Setenv("DEVICE_TOKEN_SECRET", "test-device-secret")
+	t.Setenv("DEVICE_TOKEN_GRACE", "")
+	mock := mockDB(t)
+
+	expectCheckActivation(mock, "d1", true, nil)
+	assertStatus(t, pollWithToken(deviceToken("d1")), http.StatusOK)
+
+	forged := map[string]string{
+		"another device's token": deviceToken("d2"),
+		"made-up token":          "bm90LWEtcmVhbC10b2tlbg",
+	}
+	for name, token := range forged {
+		expectDeviceFound(mock, "d1")
+		rec := pollWithToken(token)
+		assertStatus(t, rec, http.StatusUnauthorized)
+		var body ErrorResponse
+		decodeResponse(t, rec, &body)
+		if body.Error.Code != "invalid_device_token" {
+			t.Errorf("%s: error code = %q, want invalid_device_token", name, body.Error.Code)
+		}
+	}
+
+	expectDeviceFound(mock, "d1")
+	assertStatus(t, pollWithToken(""), http.StatusUnauthorized)
+	assertExpectations(t, mock)
+}
+
+func TestDeviceTokenGrace(t *testing.T) {
+	t.Setenv("DEVICE_TOKEN_SECRET", "test-device-secret")
+	t.Setenv("DEVICE_TOKEN_GRACE", "true")
+	mock := mockDB(t)
+
+	expectCheckActivation(mock, "d1", true, nil)
+	assertStatus(t, pollWithToken(""), http.StatusOK)
+
+	// A wrong token is rejected even during the rollout
+	expectDeviceFound(mock, "d1")
+	assertStatus(t, pollWithToken(deviceToken("d2")), http.StatusUnauthorized)
+	assertExpectations(t, mock)
+}
+
+func TestDeviceTermsRequireDeviceToken(t *testing.T) {
+	t.Setenv("DEVICE_TOKEN_SECRET", "test-device-secret")
+	t.Setenv("DEVICE_TOKEN_GRACE", "")
+	mock := mockDB(t)
+	expectDeviceLookup(mock, "d1")
+
+	r := httptest.NewRequest(http.MethodGet, "/api/device/TV100001/terms", nil)
+	r.Header.Set("Authorization", "Bearer "+deviceToken("d2"))
+	rec := serve(r)
+
+	assertStatus(t, rec, http.StatusUnauthorized)
+	assertExpectations(t, mock)
+}
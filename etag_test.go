@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDeviceDetailETagRoundTrip(t *testing.T) {
+	mock := mockDB(t)
+	device := testDevice("d1", "TV100001")
+
+	expectDeviceDetail(mock, device)
+	rec := serve(apiRequest(t, http.MethodGet, "/api/device/TV100001", ""))
+	assertStatus(t, rec, http.StatusOK)
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("response has no ETag")
+	}
+
+	expectDeviceDetail(mock, device)
+	r := apiRequest(t, http.MethodGet, "/api/device/TV100001", "")
+	r.Header.Set("If-None-Match", etag)
+	rec = serve(r)
+	assertStatus(t, rec, http.StatusNotModified)
+	if rec.Body.Len() != 0 {
+		t.Errorf("304 body = %q, want none", rec.Body.String())
+	}
+
+	// A payment changes the payload without bumping the device version
+	expectDeviceDetail(mock, device, true)
+	r = apiRequest(t, http.MethodGet, "/api/device/TV100001", "")
+	r.Header.Set("If-None-Match", etag)
+	rec = serve(r)
+	assertStatus(t, rec, http.StatusOK)
+	if rec.Header().Get("ETag") == etag {
+		t.Error("ETag did not change with the payment")
+	}
+	assertExpectations(t, mock)
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportFlushRows is how many CSV rows are written between flushes
+const exportFlushRows = 100
+
+// exportTruncatedMarker starts the row appended when an export stops early.
+// The status line is already sent by then, so this row is the only way to
+// tell the client its file is incomplete.
+const exportTruncatedMarker = "#export_truncated"
+
+var deviceExportHeader = []string{
+	"serial_number", "customer_name", "phone_number", "emi_term", "emi_start_date",
+	"term_duration", "is_active", "is_locked", "created_at",
+}
+
+// csvSafe keeps spreadsheet apps from running a cell as a formula
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// exportDevices streams every device the caller can see as CSV, newest
+// first. Rows are written as they are read instead of buffering the table.
+func exportDevices(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT serial_number, customer_name, phone_number, emi_term, emi_start_date,
+		       term_duration, is_active, is_locked, created_at
+		FROM devices
+		WHERE deleted_at IS NULL AND ($1::uuid IS NULL OR dealer_id = $1)
+		ORDER BY created_at DESC, id DESC
+	`, dealerArg(r))
+	if err != nil {
+		errorf(ctx, "Error fetching devices for export: %v", err)
+		writeDBError(w, r, err, "export_failed", "Failed to export devices")
+		return
+	}
+	defer rows.Close()
+
+	filename := fmt.Sprintf("devices-%s.csv", time.Now().UTC().Format("2006-01-02"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	controller := http.NewResponseController(w)
+	writer := csv.NewWriter(w)
+	writer.Write(deviceExportHeader)
+
+	written := 0
+	truncated := false
+	for rows.Next() {
+		var serialNumber, customerName, phoneNumber string
+		var emiTerm, termDuration int
+		var isActive, isLocked bool
+		var emiStartDate, createdAt time.Time
+		if err := rows.Scan(&serialNumber, &customerName, &phoneNumber, &emiTerm, &emiStartDate,
+			&termDuration, &isActive, &isLocked, &createdAt); err != nil {
+			errorf(ctx, "Error scanning device for export: %v", err)
+			truncated = true
+			break
+		}
+		writer.Write([]string{
+			serialNumber,
+			csvSafe(customerName),
+			phoneNumber,
+			strconv.Itoa(emiTerm),
+			emiStartDate.Format("2006-01-02"),
+			strconv.Itoa(termDuration),
+			strconv.FormatBool(isActive),
+			strconv.FormatBool(isLocked),
+			createdAt.UTC().Format(time.RFC3339),
+		})
+
+		written++
+		if written%exportFlushRows == 0 {
+			writer.Flush()
+			controller.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		errorf(ctx, "Error reading devices for export: %v", err)
+		truncated = true
+	}
+	if truncated {
+		writer.Write([]string{exportTruncatedMarker, fmt.Sprintf("export stopped after %d rows", written)})
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		errorf(ctx, "Error writing device export: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// exportRows returns devices as rows of the export query
+func exportRows(devices ...Device) *sqlmock.Rows {
+	rows := sqlmock.NewRows(deviceExportHeader)
+	for _, d := range devices {
+		rows.AddRow(d.SerialNumber, d.CustomerName, d.PhoneNumber, d.EMITerm, d.EMIStartDate,
+			d.TermDuration, d.IsActive, d.IsLocked, d.CreatedAt)
+	}
+	return rows
+}
+
+func TestExportDevicesAsCSV(t *testing.T) {
+	mock := mockDB(t)
+	first, second := testDevice("d1", "TV100001"), testDevice("d2", "TV100002")
+	second.CustomerName = "=HYPERLINK(\"x\")"
+	second.IsLocked = true
+	mock.ExpectQuery("FROM devices").WithArgs(nil).WillReturnRows(exportRows(second, first))
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/devices/export", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/csv") {
+		t.Errorf("Content-Type = %q, want text/csv", got)
+	}
+	if got := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(got, "attachment; filename=") {
+		t.Errorf("Content-Disposition = %q, want an attachment", got)
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parsing CSV: %v", err)
+	}
+	if len(records) != 3 || !slices.Equal(records[0], deviceExportHeader) {
+		t.Fatalf("records = %q, want a header and two devices", records)
+	}
+	want := []string{"TV100002", "'=HYPERLINK(\"x\")", "+15551234567", "6", second.EMIStartDate.Format("2006-01-02"),
+		"30", "true", "true", second.CreatedAt.UTC().Format(time.RFC3339)}
+	if !slices.Equal(records[1], want) {
+		t.Errorf("first row = %q, want %q", records[1], want)
+	}
+	if records[2][0] != "TV100001" {
+		t.Errorf("second row = %q, want TV100001", records[2])
+	}
+	assertExpectations(t, mock)
+}
+
+func TestExportMarksTruncatedFile(t *testing.T) {
+	mock := mockDB(t)
+	rows := exportRows(testDevice("d1", "TV100001"), testDevice("d2", "TV100002")).
+		RowError(1, errors.New("connection reset"))
+	mock.ExpectQuery("FROM devices").WillReturnRows(rows)
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/devices/export", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	reader := csv.NewReader(rec.Body)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("parsing CSV: %v", err)
+	}
+	last := records[len(records)-1]
+	if len(records) != 3 || last[0] != exportTruncatedMarker || last[1] != "export stopped after 1 rows" {
+		t.Errorf("records = %q, want one device and a truncation row", records)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestExportGetsLongerTimeout(t *testing.T) {
+	if got := requestTimeoutFor("/api/devices/export"); got != exportRequestTimeout {
+		t.Errorf("export timeout = %s, want %s", got, exportRequestTimeout)
+	}
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// maxForceLockReasonLength caps the reason recorded with a force lock, in
+// characters
+const maxForceLockReasonLength = 500
+
+type ForceLockRequest struct {
+	Reason string `json:"reason"`
+}
+
+type ForceLockResponse struct {
+	Success       bool      `json:"success"`
+	Message       string    `json:"message"`
+	ForceLockedAt time.Time `json:"force_locked_at"`
+}
+
+// writeForceLockedError rejects a change that would soften a force lock
+func writeForceLockedError(w http.ResponseWriter, forceLockedAt time.Time) {
+	writeErrorWithDetails(w, http.StatusConflict, "device_force_locked",
+		"Device is force locked; unlock it explicitly first",
+		map[string]interface{}{"force_locked_at": forceLockedAt})
+}
+
+// forceLockDevice locks a device immediately, for example on confirmed
+// fraud. It clears any snooze and pending relock, and until an explicit
+// unlock (remote unlock, /api/unlock, settle, or reissue) payments leave the
+// device locked and snoozes and temporary unlocks are refused.
+func forceLockDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	var req ForceLockRequest
+	if bodyErr := decodeJSONBody(r.Body, &req); bodyErr != nil {
+		writeBodyError(w, bodyErr)
+		return
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		writeBodyError(w, missingFieldError("reason"))
+		return
+	}
+	if utf8.RuneCountInString(reason) > maxForceLockReasonLength {
+		writeError(w, http.StatusBadRequest, "invalid_reason", fmt.Sprintf("reason must be at most %d characters", maxForceLockReasonLength))
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "force_lock_failed", "Failed to force lock device")
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var deviceID string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE devices SET is_locked = true, snooze_until = NULL, relock_at = NULL, force_locked_at = $1
+		WHERE serial_number = $2 AND deleted_at IS NULL AND ($3::uuid IS NULL OR dealer_id = $3)
+		RETURNING id
+	`, now, serialNumber, dealerArg(r)).Scan(&deviceID)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO remote_locks (id, device_id, is_locked, created_at, updated_at) VALUES ($1, $2, true, $3, $3)
+		ON CONFLICT (device_id) DO UPDATE SET is_locked = true, updated_at = EXCLUDED.updated_at
+	`, uuid.New().String(), deviceID, now)
+	if err != nil {
+		errorf(ctx, "Error updating remote lock: %v", err)
+		writeDBError(w, r, err, "force_lock_failed", "Failed to force lock device")
+		return
+	}
+
+	if err = appendLockEvent(ctx, tx, deviceID, true, lockSourceForce); err != nil {
+		errorf(ctx, "Error writing lock event: %v", err)
+		writeDBError(w, r, err, "force_lock_failed", "Failed to force lock device")
+		return
+	}
+
+	if err = appendAudit(ctx, tx, deviceID, "force_lock", actorFromRequest(r), "Force locked: "+reason); err != nil {
+		errorf(ctx, "Error writing audit log: %v", err)
+		writeDBError(w, r, err, "force_lock_failed", "Failed to force lock device")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing force lock: %v", err)
+		writeDBError(w, r, err, "force_lock_failed", "Failed to force lock device")
+		return
+	}
+
+	locksTotal.Add(1)
+	notifyDeviceLocked(deviceID)
+	dispatchWebhook(webhookEventLocked, deviceID, serialNumber, map[string]interface{}{"source": "force_lock"})
+
+	response := ForceLockResponse{
+		Success:       true,
+		Message:       "Device force locked",
+		ForceLockedAt: now,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestForceLockClearsSnoozeAndRelock(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE devices SET is_locked = true, snooze_until = NULL, relock_at = NULL, force_locked_at = \\$1").
+		WithArgs(sqlmock.AnyArg(), "TV100001", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("d1"))
+	mock.ExpectExec("INSERT INTO remote_locks").WithArgs(sqlmock.AnyArg(), "d1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO lock_events").WithArgs(sqlmock.AnyArg(), "d1", true, lockSourceForce, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_logs").WithArgs(sqlmock.AnyArg(), "d1", "force_lock", sqlmock.AnyArg(), "Force locked: confirmed fraud", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/tv100001/force-lock", `{"reason":" confirmed fraud "}`))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body ForceLockResponse
+	decodeResponse(t, rec, &body)
+	if !body.Success || body.ForceLockedAt.IsZero() {
+		t.Errorf("response = %+v, want success with force_locked_at", body)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestForceLockNeedsReason(t *testing.T) {
+	mock := mockDB(t)
+
+	for _, body := range []string{`{}`, `{"reason":"   "}`, `{"reason":"` + strings.Repeat("x", maxForceLockReasonLength+1) + `"}`} {
+		rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/force-lock", body))
+
+		assertStatus(t, rec, http.StatusBadRequest)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestForceLockedDeviceRefusesSofteners(t *testing.T) {
+	relockAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	tests := []struct {
+		name   string
+		target string
+		body   string
+	}{
+		{"snooze", "/api/device/TV100001/snooze", `{"hours":24}`},
+		{"temporary unlock", "/api/device/TV100001/temporary-unlock", `{"relock_at":"` + relockAt + `"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := mockDB(t)
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id, force_locked_at FROM devices").WithArgs("TV100001", nil).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "force_locked_at"}).AddRow("d1", time.Now()))
+			mock.ExpectRollback()
+
+			rec := serve(apiRequest(t, http.MethodPost, tt.target, tt.body))
+
+			assertStatus(t, rec, http.StatusConflict)
+			var body ErrorResponse
+			decodeResponse(t, rec, &body)
+			if body.Error.Code != "device_force_locked" {
+				t.Errorf("error = %+v, want device_force_locked", body.Error)
+			}
+			assertExpectations(t, mock)
+		})
+	}
+}
+
+func TestSnoozedDeviceStillLocksUnderForceLock(t *testing.T) {
+	conn := integrationDB(t)
+	serialNumber := "IT" + strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+	t.Cleanup(func() {
+		conn.Exec("DELETE FROM devices WHERE serial_number = $1", serialNumber)
+	})
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", registrationBody(serialNumber, 1)))
+	assertStatus(t, rec, http.StatusOK)
+	rec = serve(apiRequest(t, http.MethodPost, "/api/device/"+serialNumber+"/snooze", `{"hours":24}`))
+	assertStatus(t, rec, http.StatusOK)
+
+	rec = serve(apiRequest(t, http.MethodPost, "/api/device/"+serialNumber+"/force-lock", `{"reason":"confirmed fraud"}`))
+	assertStatus(t, rec, http.StatusOK)
+
+	var isLocked, snoozed, remoteLocked bool
+	err := conn.QueryRow(`
+		SELECT d.is_locked, d.snooze_until IS NOT NULL, rl.is_locked
+		FROM devices d JOIN remote_locks rl ON rl.device_id = d.id
+		WHERE d.serial_number = $1
+	`, serialNumber).Scan(&isLocked, &snoozed, &remoteLocked)
+	if err != nil {
+		t.Fatalf("reading device: %v", err)
+	}
+	if !isLocked || snoozed || !remoteLocked {
+		t.Errorf("is_locked = %v, snoozed = %v, remote lock = %v; want locked with the snooze cleared", isLocked, snoozed, remoteLocked)
+	}
+
+	rec = serve(apiRequest(t, http.MethodPost, "/api/device/"+serialNumber+"/snooze", `{"hours":24}`))
+	assertStatus(t, rec, http.StatusConflict)
+}
@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipMinBytes is the smallest response worth compressing; below it the
+// gzip framing and CPU cost outweigh the saving
+const gzipMinBytes = 1400
+
+// gzipPaths are the list and export routes whose responses can grow large
+// enough to be worth compressing
+var gzipPaths = map[string]bool{
+	"/api/devices":                true,
+	"/api/devices/export":         true,
+	"/api/devices/upcoming-locks": true,
+	"/api/devices/offline":        true,
+	"/api/admin/devices":          true,
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, either
+// by name or through "*", with a non-zero quality
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !found {
+			return true
+		}
+		if quality, err := strconv.ParseFloat(q, 64); err == nil && quality > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipMiddleware compresses the responses of gzipPaths for clients that
+// accept gzip. A response is buffered until it reaches gzipMinBytes, so
+// short ones (errors, small pages) go out uncompressed; a handler that
+// flushes before then, like the streaming CSV export, is compressed from
+// that point on.
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !gzipPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Not deferred: after a panic, nothing buffered is sent, leaving the
+		// response to the recovery middleware
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(gw, r)
+		if err := gw.Close(); err != nil {
+			errorf(r.Context(), "Error finishing compressed response: %v", err)
+		}
+	})
+}
+
+// gzipResponseWriter holds back the status and the start of the body until
+// it knows whether the response is large enough to compress
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     []byte
+	started bool
+	gz      *gzip.Writer
+}
+
+func (gw *gzipResponseWriter) WriteHeader(status int) {
+	if gw.status == 0 && !gw.started {
+		gw.status = status
+	}
+}
+
+func (gw *gzipResponseWriter) Write(b []byte) (int, error) {
+	if gw.started {
+		if gw.gz != nil {
+			return gw.gz.Write(b)
+		}
+		return gw.ResponseWriter.Write(b)
+	}
+	gw.buf = append(gw.buf, b...)
+	if len(gw.buf) >= gzipMinBytes {
+		if err := gw.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// start sends the status and headers, then the buffered body, compressed
+// when compress is set and the response can carry a body that is not
+// already encoded
+func (gw *gzipResponseWriter) start(compress bool) error {
+	gw.started = true
+	status := gw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := gw.ResponseWriter.Header()
+	if compress && status != http.StatusNoContent && status != http.StatusNotModified && header.Get("Content-Encoding") == "" {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		gw.gz = gzip.NewWriter(gw.ResponseWriter)
+	}
+	gw.ResponseWriter.WriteHeader(status)
+
+	buf := gw.buf
+	gw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if gw.gz != nil {
+		_, err = gw.gz.Write(buf)
+	} else {
+		_, err = gw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush starts compressing a response that is still being buffered, since
+// a handler that flushes is streaming, and pushes out what has been written
+func (gw *gzipResponseWriter) Flush() {
+	if !gw.started {
+		if err := gw.start(true); err != nil {
+			return
+		}
+	}
+	if gw.gz != nil {
+		if err := gw.gz.Flush(); err != nil {
+			return
+		}
+	}
+	http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+// Close sends a response that stayed under gzipMinBytes as is, or finishes
+// the gzip stream
+func (gw *gzipResponseWriter) Close() error {
+	if !gw.started {
+		return gw.start(false)
+	}
+	if gw.gz != nil {
+		return gw.gz.Close()
+	}
+	return nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (gw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectDeviceList expects GET /api/devices to return n devices
+func expectDeviceList(mock sqlmock.Sqlmock, n int) {
+	devices := make([]Device, n)
+	for i := range devices {
+		devices[i] = testDevice(fmt.Sprintf("d%d", i+1), fmt.Sprintf("TV%06d", i+1))
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*)")).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(n))
+	mock.ExpectQuery("FROM devices").WillReturnRows(deviceRows(devices...))
+}
+
+// requestDeviceList requests the device list with the given Accept-Encoding
+func requestDeviceList(t *testing.T, acceptEncoding string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := apiRequest(t, http.MethodGet, "/api/devices", "")
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	return serve(r)
+}
+
+func TestLargeDeviceListIsGzipped(t *testing.T) {
+	mock := mockDB(t)
+	expectDeviceList(mock, 50)
+
+	rec := requestDeviceList(t, "gzip, deflate")
+
+	assertStatus(t, rec, http.StatusOK)
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	if vary := rec.Header().Values("Vary"); !slices.Contains(vary, "Accept-Encoding") {
+		t.Errorf("Vary = %q, want it to include Accept-Encoding", vary)
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	var body DeviceListResponse
+	if err = json.NewDecoder(gz).Decode(&body); err != nil {
+		t.Fatalf("decoding decompressed body: %v", err)
+	}
+	if len(body.Devices) != 50 {
+		t.Errorf("devices = %d, want 50", len(body.Devices))
+	}
+	assertExpectations(t, mock)
+}
+
+func TestDeviceListIsPlainWhenSmallOrNotAccepted(t *testing.T) {
+	tests := []struct {
+		name           string
+		devices        int
+		acceptEncoding string
+	}{
+		{"small list", 1, "gzip"},
+		{"no Accept-Encoding", 50, ""},
+		{"gzip refused", 50, "gzip;q=0, br"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := mockDB(t)
+			expectDeviceList(mock, tt.devices)
+
+			rec := requestDeviceList(t, tt.acceptEncoding)
+
+			assertStatus(t, rec, http.StatusOK)
+			if got := rec.Header().Get("Content-Encoding"); got != "" {
+				t.Errorf("Content-Encoding = %q, want none", got)
+			}
+			var body DeviceListResponse
+			decodeResponse(t, rec, &body)
+			if len(body.Devices) != tt.devices {
+				t.Errorf("devices = %d, want %d", len(body.Devices), tt.devices)
+			}
+			assertExpectations(t, mock)
+		})
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip;q=0.5", true},
+		{"*", true},
+		{"gzip;q=0", false},
+		{"br, deflate", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
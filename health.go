@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// readyPingTimeout bounds the database ping of a readiness check
+const readyPingTimeout = 2 * time.Second
+
+// liveCheck reports that the process is up. It never touches the database,
+// so an orchestrator does not restart an instance just because the database
+// is unreachable.
+func liveCheck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// isReadinessProbe reports whether r is a readiness check, which answers 503
+// rather than the generic error when the database cannot be reached
+func isReadinessProbe(r *http.Request) bool {
+	return r.Method == http.MethodGet && (r.URL.Path == "/api/ready" || r.URL.Path == "/api/health")
+}
+
+// writeNotReady answers a readiness check that failed before a connection
+// pool existed
+func writeNotReady(w http.ResponseWriter, checks map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(HealthResponse{Status: "degraded", DB: "down", Checks: checks})
+}
+
+// readyCheck reports whether the instance can serve traffic: the database
+// must answer a ping and the connection pool must have a free connection.
+// /api/health is kept as an alias.
+func readyCheck(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyPingTimeout)
+	defer cancel()
+
+	stats := db.Stats()
+	response := HealthResponse{
+		Status:          "ok",
+		DB:              "up",
+		OpenConnections: stats.OpenConnections,
+		IdleConnections: stats.Idle,
+		Checks:          map[string]string{"database": "ok", "pool": "ok"},
+	}
+	status := http.StatusOK
+
+	if stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections {
+		warnf(ctx, "Readiness check: connection pool exhausted (%d/%d in use)", stats.InUse, stats.MaxOpenConnections)
+		response.Status = "degraded"
+		response.Checks["pool"] = "exhausted"
+		status = http.StatusServiceUnavailable
+	} else if err := db.PingContext(ctx); err != nil {
+		errorf(ctx, "Readiness check database ping failed: %v", err)
+		response.Status = "degraded"
+		response.DB = "down"
+		response.Checks["database"] = "down"
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
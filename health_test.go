@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// noDB leaves the package without a database and without a URL to connect
+// to, so initDB fails
+func noDB(t *testing.T) {
+	t.Helper()
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("POSTGRES_URL", "")
+	previous := db
+	db = nil
+	t.Cleanup(func() { db = previous })
+}
+
+// closedDB swaps in a database handle that has already been closed, so every
+// ping fails
+func closedDB(t *testing.T) {
+	t.Helper()
+	conn, err := sql.Open("postgres", "postgres://localhost/unused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	previous := db
+	db = conn
+	t.Cleanup(func() { db = previous })
+}
+
+func TestHealthReportsClosedDatabase(t *testing.T) {
+	closedDB(t)
+
+	rec := serve(httptest.NewRequest(http.MethodGet, "/api/health", nil))
+
+	assertStatus(t, rec, http.StatusServiceUnavailable)
+	var body HealthResponse
+	decodeResponse(t, rec, &body)
+	if body.Status != "degraded" || body.DB != "down" {
+		t.Errorf("response = %+v, want status degraded and db down", body)
+	}
+}
+
+func TestHealthReportsReachableDatabase(t *testing.T) {
+	mockDB(t)
+
+	for _, path := range []string{"/api/ready", "/api/health"} {
+		rec := serve(httptest.NewRequest(http.MethodGet, path, nil))
+
+		assertStatus(t, rec, http.StatusOK)
+		var body HealthResponse
+		decodeResponse(t, rec, &body)
+		if body.Status != "ok" || body.DB != "up" {
+			t.Errorf("%s: response = %+v, want status ok and db up", path, body)
+		}
+	}
+}
+
+func TestLiveNeedsNoDatabase(t *testing.T) {
+	noDB(t)
+
+	rec := serve(httptest.NewRequest(http.MethodGet, "/api/live", nil))
+
+	assertStatus(t, rec, http.StatusOK)
+}
+
+func TestReadyWithoutDatabase(t *testing.T) {
+	noDB(t)
+
+	rec := serve(httptest.NewRequest(http.MethodGet, "/api/ready", nil))
+
+	assertStatus(t, rec, http.StatusServiceUnavailable)
+	var body HealthResponse
+	decodeResponse(t, rec, &body)
+	if body.Checks["database"] != "down" {
+		t.Errorf("checks = %v, want database down", body.Checks)
+	}
+}
+
+func TestReadyReportsExhaustedPool(t *testing.T) {
+	mockDB(t)
+	db.SetMaxOpenConns(1)
+	held, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+
+	rec := serve(httptest.NewRequest(http.MethodGet, "/api/ready", nil))
+
+	assertStatus(t, rec, http.StatusServiceUnavailable)
+	var body HealthResponse
+	decodeResponse(t, rec, &body)
+	if body.Checks["pool"] != "exhausted" || body.Checks["database"] != "ok" {
+		t.Errorf("checks = %v, want pool exhausted and database ok", body.Checks)
+	}
+}
@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+// testAPIKey is the operator key requests built by apiRequest carry
+const testAPIKey = "test-operator-key"
+
+func TestMain(m *testing.M) {
+	// Keep test output readable; tests that assert on logs swap in their own
+	appLoggerOnce.Do(func() {
+		appLogger = newLogger(io.Discard, slog.LevelDebug)
+	})
+	os.Exit(m.Run())
+}
+
+// mockDB replaces the package database with a sqlmock connection for the
+// length of the test. Expectations are matched in order.
+func mockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	previous := db
+	db = conn
+	t.Cleanup(func() {
+		db = previous
+		conn.Close()
+	})
+	return mock
+}
+
+// assertExpectations fails the test when the handler skipped an expected
+// query, which would otherwise pass silently
+func assertExpectations(t *testing.T, mock sqlmock.Sqlmock) {
+	t.Helper()
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet database expectations: %v", err)
+	}
+}
+
+// apiRequest builds a request authenticated with the operator key; it sets
+// API_KEY for the rest of the test
+func apiRequest(t *testing.T, method, target, body string) *http.Request {
+	t.Helper()
+	t.Setenv("API_KEY", testAPIKey)
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("X-API-Key", testAPIKey)
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	return r
+}
+
+// serve runs a request through the full handler, middleware included
+func serve(r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	Handler(rec, r)
+	return rec
+}
+
+// decodeResponse unmarshals a JSON response body into v
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+	}
+}
+
+// assertStatus fails the test when a response has an unexpected status
+func assertStatus(t *testing.T, rec *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, want, rec.Body.String())
+	}
+}
+
+// testDevice is an active, unlocked device with a six-term monthly schedule
+// that started today
+func testDevice(id, serialNumber string) Device {
+	today, _ := time.Parse("2006-01-02", time.Now().UTC().Format("2006-01-02"))
+	return Device{
+		ID:           id,
+		SerialNumber: serialNumber,
+		CustomerName: "Test Customer",
+		PhoneNumber:  "+15551234567",
+		EMITerm:      6,
+		EMIStartDate: today,
+		TermDuration: 30,
+		IsActive:     true,
+		CreatedBy:    "unknown",
+		CreatedAt:    time.Now(),
+		Version:      1,
+	}
+}
+
+// nullable is the driver value of an optional column
+func nullable[T any](value *T) driver.Value {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
+
+// deviceRows returns devices as rows selected with deviceColumns
+func deviceRows(devices ...Device) *sqlmock.Rows {
+	columns := strings.Split(deviceColumns, ",")
+	for i := range columns {
+		columns[i] = strings.TrimSpace(columns[i])
+	}
+	rows := sqlmock.NewRows(columns)
+	for _, d := range devices {
+		rows.AddRow(
+			d.ID, d.SerialNumber, d.CustomerName, d.PhoneNumber,
+			d.EMITerm, d.EMIStartDate, d.TermDuration, d.GraceDays,
+			d.IsActive, d.IsLocked, nullable(d.DealerID), d.CreatedBy, d.CreatedAt, d.Version,
+			nullable(d.SnoozeUntil), nullable(d.RelockAt), nullable(d.RetiredAt), nullable(d.ForceLockedAt),
+			nullable(d.LastSeenAt), nullable(d.InstallmentAmount),
+		)
+	}
+	return rows
+}
+
+// expectDeviceLookup expects the lookup of a device by serial number that
+// starts most handlers, finding deviceID
+func expectDeviceLookup(mock sqlmock.Sqlmock, deviceID string) {
+	mock.ExpectQuery("SELECT id FROM devices WHERE serial_number").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(deviceID))
+}
+
+// captured is a sqlmock argument matcher that accepts any value and keeps it
+// so a test can inspect what a statement was executed with
+type captured struct {
+	value driver.Value
+}
+
+func (c *captured) Match(v driver.Value) bool {
+	c.value = v
+	return true
+}
+
+// integrationDB connects the package to the migrated Postgres database at
+// TEST_DATABASE_URL for tests that depend on real locking or DDL. Those
+// tests are skipped when it is unset.
+func integrationDB(t *testing.T) *sql.DB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	previous := db
+	db = nil
+	t.Setenv("DATABASE_URL", url)
+	if err := initDB(); err != nil {
+		db = previous
+		t.Fatalf("initDB: %v", err)
+	}
+	conn := db
+	t.Cleanup(func() {
+		db = previous
+		conn.Close()
+	})
+	return conn
+}
+
+// integrationDealer creates a dealer in the integration database and returns
+// its id and API key. Requests made with the key only see the dealer's own
+// devices, which keeps rows left by other runs out of counts and lists.
+// Devices of the dealer are deleted with it when the test ends.
+func integrationDealer(t *testing.T, conn *sql.DB) (string, string) {
+	t.Helper()
+	dealerID := uuid.New().String()
+	apiKey := "key-of-" + dealerID
+	if _, err := conn.Exec("INSERT INTO dealers (id, name, api_key_hash) VALUES ($1, 'Test Dealer', $2)", dealerID, hashAPIKey(apiKey)); err != nil {
+		t.Fatalf("seeding dealer: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Exec("DELETE FROM devices WHERE dealer_id = $1", dealerID)
+		conn.Exec("DELETE FROM dealers WHERE id = $1", dealerID)
+	})
+	return dealerID, apiKey
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+)
+
+// idempotencyWindow is how long a stored response is replayed for a repeated
+// Idempotency-Key before the key may be used again
+const idempotencyWindow = 24 * time.Hour
+
+type idempotentResponse struct {
+	requestHash string
+	status      int
+	body        []byte
+}
+
+// hashRequestBody fingerprints a request body so a reused key can be checked
+// against the request it was first used with
+func hashRequestBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// lookupIdempotentResponse returns the stored response for a key that was used
+// within the idempotency window, or nil if there is none
+func lookupIdempotentResponse(ctx context.Context, key string) (*idempotentResponse, error) {
+	var stored idempotentResponse
+	err := db.QueryRowContext(ctx,
+		"SELECT request_hash, response_status, response_body FROM idempotency_keys WHERE key = $1 AND created_at > $2",
+		key, time.Now().Add(-idempotencyWindow),
+	).Scan(&stored.requestHash, &stored.status, &stored.body)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// saveIdempotentResponse stores a response under its key as part of the
+// caller's transaction, replacing any entry that has aged out of the window
+func saveIdempotentResponse(ctx context.Context, tx *sql.Tx, key, requestHash, deviceID string, status int, body []byte) error {
+	_, err := tx.ExecContext(ctx,
+		"DELETE FROM idempotency_keys WHERE key = $1 AND created_at <= $2",
+		key, time.Now().Add(-idempotencyWindow),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO idempotency_keys (key, request_hash, device_id, response_status, response_body, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		key, requestHash, deviceID, status, body, time.Now(),
+	)
+	return err
+}
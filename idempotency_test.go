@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func idempotentRegistration(t *testing.T, body, key string) *http.Request {
+	r := apiRequest(t, http.MethodPost, "/api/register", body)
+	r.Header.Set("Idempotency-Key", key)
+	return r
+}
+
+func TestRegisterDeviceIdempotencyKey(t *testing.T) {
+	body := registrationBody("TV100001", 2)
+
+	t.Run("first use", func(t *testing.T) {
+		mock := mockDB(t)
+		var storedBody captured
+		mock.ExpectQuery("SELECT request_hash, response_status, response_body FROM idempotency_keys").
+			WithArgs("key-1", sqlmock.AnyArg()).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectBegin()
+		expectDeviceInsert(mock)
+		expectTermInserts(mock, 2)
+		mock.ExpectExec("INSERT INTO remote_locks").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("DELETE FROM idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO idempotency_keys").
+			WithArgs("key-1", hashRequestBody([]byte(body)), sqlmock.AnyArg(), http.StatusOK, &storedBody, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		rec := serve(idempotentRegistration(t, body, "key-1"))
+
+		assertStatus(t, rec, http.StatusOK)
+		stored, _ := storedBody.value.([]byte)
+		if !bytes.Equal(stored, rec.Body.Bytes()) {
+			t.Errorf("stored response %q differs from the one sent %q", stored, rec.Body.Bytes())
+		}
+		assertExpectations(t, mock)
+	})
+
+	t.Run("replay with the same body", func(t *testing.T) {
+		mock := mockDB(t)
+		original := []byte(`{"success":true,"device_id":"d1"}`)
+		mock.ExpectQuery("SELECT request_hash, response_status, response_body FROM idempotency_keys").
+			WillReturnRows(sqlmock.NewRows([]string{"request_hash", "response_status", "response_body"}).
+				AddRow(hashRequestBody([]byte(body)), http.StatusOK, original))
+
+		rec := serve(idempotentRegistration(t, body, "key-1"))
+
+		assertStatus(t, rec, http.StatusOK)
+		if !bytes.Equal(rec.Body.Bytes(), original) {
+			t.Errorf("body = %q, want the original response %q", rec.Body.Bytes(), original)
+		}
+		// No transaction was expected, so nothing was registered again
+		assertExpectations(t, mock)
+	})
+
+	t.Run("replay with a different body", func(t *testing.T) {
+		mock := mockDB(t)
+		mock.ExpectQuery("SELECT request_hash, response_status, response_body FROM idempotency_keys").
+			WillReturnRows(sqlmock.NewRows([]string{"request_hash", "response_status", "response_body"}).
+				AddRow(hashRequestBody([]byte(registrationBody("TV999999", 2))), http.StatusOK, []byte(`{}`)))
+
+		rec := serve(idempotentRegistration(t, body, "key-1"))
+
+		assertStatus(t, rec, http.StatusConflict)
+		var errBody ErrorResponse
+		decodeResponse(t, rec, &errBody)
+		if errBody.Error.Code != "idempotency_key_reused" {
+			t.Errorf("error code = %q, want idempotency_key_reused", errBody.Error.Code)
+		}
+		assertExpectations(t, mock)
+	})
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultOfflineAfter is how long a TV may go without polling before it is
+// reported offline
+const defaultOfflineAfter = 72 * time.Hour
+
+var (
+	offlineAfter     time.Duration
+	offlineAfterOnce sync.Once
+)
+
+// deviceOfflineAfter returns DEVICE_OFFLINE_AFTER, read once per instance
+func deviceOfflineAfter() time.Duration {
+	offlineAfterOnce.Do(func() {
+		offlineAfter = envNonNegativeDuration("DEVICE_OFFLINE_AFTER", defaultOfflineAfter)
+	})
+	return offlineAfter
+}
+
+// isOffline reports whether a device has not polled within
+// DEVICE_OFFLINE_AFTER. A device that has never polled counts as offline
+// once it has been registered that long, so a TV that was never switched on
+// is noticed too. A zero threshold turns the flag off.
+func isOffline(lastSeenAt *time.Time, createdAt, now time.Time) bool {
+	threshold := deviceOfflineAfter()
+	if threshold == 0 {
+		return false
+	}
+	if lastSeenAt == nil {
+		return now.Sub(createdAt) > threshold
+	}
+	return now.Sub(*lastSeenAt) > threshold
+}
+
+// touchLastSeen records that a TV has just polled. A failure is only logged
+// so it never fails the poll itself.
+func touchLastSeen(ctx context.Context, deviceID string) {
+	if _, err := db.ExecContext(ctx, "UPDATE devices SET last_seen_at = NOW() WHERE id = $1", deviceID); err != nil {
+		errorf(ctx, "Error updating last seen for device %s: %v", deviceID, err)
+	}
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// useOfflineAfter sets DEVICE_OFFLINE_AFTER for the rest of the test and
+// drops the parsed threshold so the next use reads it
+func useOfflineAfter(t *testing.T, raw string) {
+	t.Helper()
+	t.Setenv("DEVICE_OFFLINE_AFTER", raw)
+	offlineAfterOnce = sync.Once{}
+	t.Cleanup(func() {
+		offlineAfterOnce = sync.Once{}
+	})
+}
+
+func TestIsOffline(t *testing.T) {
+	useOfflineAfter(t, "48h")
+	now := time.Now()
+	ago := func(d time.Duration) *time.Time {
+		at := now.Add(-d)
+		return &at
+	}
+	tests := []struct {
+		name       string
+		lastSeenAt *time.Time
+		createdAt  time.Time
+		want       bool
+	}{
+		{"polled recently", ago(time.Hour), now.Add(-30 * 24 * time.Hour), false},
+		{"silent past the threshold", ago(49 * time.Hour), now.Add(-30 * 24 * time.Hour), true},
+		{"never polled, newly registered", nil, now.Add(-time.Hour), false},
+		{"never polled, registered long ago", nil, now.Add(-72 * time.Hour), true},
+	}
+	for _, tt := range tests {
+		if got := isOffline(tt.lastSeenAt, tt.createdAt, now); got != tt.want {
+			t.Errorf("%s: isOffline = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	useOfflineAfter(t, "0")
+	if isOffline(ago(365*24*time.Hour), now, now) {
+		t.Error("isOffline with a zero threshold = true, want the flag off")
+	}
+}
+
+func TestDeviceDetailReportsOffline(t *testing.T) {
+	useOfflineAfter(t, "72h")
+	mock := mockDB(t)
+	device := testDevice("d1", "TV100001")
+	lastSeenAt := time.Now().Add(-5 * 24 * time.Hour)
+	device.LastSeenAt = &lastSeenAt
+	expectDeviceDetail(mock, device)
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/device/TV100001", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body DeviceDetailResponse
+	decodeResponse(t, rec, &body)
+	if body.Device.LastSeenAt == nil || !body.Device.LastSeenAt.Equal(lastSeenAt) || !body.Device.Offline {
+		t.Errorf("device last_seen_at = %v, offline = %v; want %v and offline", body.Device.LastSeenAt, body.Device.Offline, lastSeenAt)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestPollAdvancesLastSeen(t *testing.T) {
+	conn := integrationDB(t)
+	serialNumber := "IT" + strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+	t.Cleanup(func() {
+		conn.Exec("DELETE FROM devices WHERE serial_number = $1", serialNumber)
+	})
+	lastSeen := func() time.Time {
+		t.Helper()
+		var at time.Time
+		if err := conn.QueryRow("SELECT COALESCE(last_seen_at, 'epoch') FROM devices WHERE serial_number = $1", serialNumber).Scan(&at); err != nil {
+			t.Fatalf("reading last_seen_at: %v", err)
+		}
+		return at
+	}
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", registrationBody(serialNumber, 1)))
+	assertStatus(t, rec, http.StatusOK)
+	if _, err := conn.Exec("UPDATE devices SET last_seen_at = NOW() - INTERVAL '1 day' WHERE serial_number = $1", serialNumber); err != nil {
+		t.Fatalf("backdating last_seen_at: %v", err)
+	}
+	before := lastSeen()
+
+	checkLock(t, serialNumber)
+
+	if after := lastSeen(); !after.After(before.Add(23 * time.Hour)) {
+		t.Errorf("last_seen_at went from %v to %v, want it advanced to the poll", before, after)
+	}
+}
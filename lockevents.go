@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Sources of a lock state transition, recorded on each lock event
+const (
+	lockSourceRemote  = "remote"
+	lockSourceAuto    = "auto"
+	lockSourceUnlock  = "unlock"
+	lockSourcePayment = "payment"
+	lockSourceSettle  = "settle"
+	lockSourceTemp    = "temporary_unlock"
+	lockSourceRelock  = "relock"
+	lockSourceReissue = "reissue"
+	lockSourceForce   = "force_lock"
+)
+
+type LockEvent struct {
+	IsLocked  bool      `json:"is_locked"`
+	Timestamp time.Time `json:"timestamp"`
+	Source    string    `json:"source"`
+}
+
+type LockHistoryResponse struct {
+	Success      bool        `json:"success"`
+	SerialNumber string      `json:"serial_number"`
+	Events       []LockEvent `json:"events"`
+}
+
+// appendLockEvent records a lock state transition as part of the caller's
+// transaction. lock_events is append-only, unlike the single mutable
+// remote_locks row.
+func appendLockEvent(ctx context.Context, tx *sql.Tx, deviceID string, isLocked bool, source string) error {
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO lock_events (id, device_id, is_locked, source, created_at) VALUES ($1, $2, $3, $4, $5)",
+		uuid.New().String(), deviceID, isLocked, source, time.Now(),
+	)
+	return err
+}
+
+func getLockHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	// Find device
+	var deviceID string
+	err := db.QueryRowContext(ctx,
+		"SELECT id FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2)",
+		serialNumber, dealerArg(r),
+	).Scan(&deviceID)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT is_locked, created_at, source
+		FROM lock_events
+		WHERE device_id = $1
+		ORDER BY created_at, id
+	`, deviceID)
+	if err != nil {
+		errorf(ctx, "Error fetching lock events for device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch lock history")
+		return
+	}
+	defer rows.Close()
+
+	events := make([]LockEvent, 0)
+	for rows.Next() {
+		var event LockEvent
+		if err := rows.Scan(&event.IsLocked, &event.Timestamp, &event.Source); err != nil {
+			errorf(ctx, "Error scanning lock event: %v", err)
+			continue
+		}
+		events = append(events, event)
+	}
+
+	response := LockHistoryResponse{
+		Success:      true,
+		SerialNumber: serialNumber,
+		Events:       events,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLockHistoryOrdersTransitions(t *testing.T) {
+	mock := mockDB(t)
+	steps := []struct {
+		target   string
+		isLocked bool
+	}{
+		{"/api/device/TV100001/lock", true},
+		{"/api/device/TV100001/unlock", false},
+		{"/api/device/TV100001/lock", true},
+	}
+	sources := make([]captured, len(steps))
+	times := make([]captured, len(steps))
+	for i, step := range steps {
+		expectDeviceLookup(mock, "d1")
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO remote_locks").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE devices SET is_locked").WithArgs(step.isLocked, "d1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO lock_events").WithArgs(sqlmock.AnyArg(), "d1", step.isLocked, &sources[i], &times[i]).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		rec := serve(apiRequest(t, http.MethodPost, step.target, ""))
+		assertStatus(t, rec, http.StatusOK)
+	}
+
+	// Serve the recorded events back as stored
+	expectDeviceLookup(mock, "d1")
+	rows := sqlmock.NewRows([]string{"is_locked", "created_at", "source"})
+	for i, step := range steps {
+		rows.AddRow(step.isLocked, times[i].value, sources[i].value)
+	}
+	mock.ExpectQuery("FROM lock_events\\s+WHERE device_id = \\$1\\s+ORDER BY created_at, id").WithArgs("d1").WillReturnRows(rows)
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/device/TV100001/lock-history", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body LockHistoryResponse
+	decodeResponse(t, rec, &body)
+	if len(body.Events) != len(steps) {
+		t.Fatalf("got %d events, want %d", len(body.Events), len(steps))
+	}
+	for i, event := range body.Events {
+		if event.IsLocked != steps[i].isLocked || event.Source != lockSourceRemote {
+			t.Errorf("event %d = %+v, want is_locked %v from %s", i, event, steps[i].isLocked, lockSourceRemote)
+		}
+		if i > 0 && event.Timestamp.Before(body.Events[i-1].Timestamp) {
+			t.Errorf("event %d at %s precedes event %d", i, event.Timestamp, i-1)
+		}
+	}
+	assertExpectations(t, mock)
+}
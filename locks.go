@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// systemActor is recorded in the audit log for changes made automatically
+// rather than by an API caller
+const systemActor = "system"
+
+// evaluateLocks enforces any unpaid lock dates of a device that have come due
+// (after the device's grace period) but are not yet marked locked, locking the device
+// and its remote lock. A snoozed or temporarily unlocked device is left alone
+// until its snooze ends or it is relocked, and a retired device until it is
+// reactivated.
+// It reports whether a new lock was applied.
+func evaluateLocks(ctx context.Context, deviceID string) (bool, error) {
+	now := time.Now()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE lock_dates ld SET is_locked = true
+		FROM devices d
+		WHERE d.id = ld.device_id AND ld.device_id = $1 AND d.deleted_at IS NULL AND d.retired_at IS NULL
+		  AND ld.is_locked = false AND ld.paid_at IS NULL
+		  AND ld.lock_date + d.grace_days <= $2
+		  AND (d.snooze_until IS NULL OR d.snooze_until <= NOW())
+		  AND (d.relock_at IS NULL OR d.relock_at <= NOW())
+	`, deviceID, now)
+	if err != nil {
+		return false, err
+	}
+	overdue, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if overdue == 0 {
+		return false, nil
+	}
+
+	var serialNumber string
+	err = tx.QueryRowContext(ctx, "UPDATE devices SET is_locked = true WHERE id = $1 RETURNING serial_number", deviceID).Scan(&serialNumber)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO remote_locks (id, device_id, is_locked, created_at, updated_at) VALUES ($1, $2, true, $3, $3)
+		ON CONFLICT (device_id) DO UPDATE SET is_locked = true, updated_at = EXCLUDED.updated_at
+	`, uuid.New().String(), deviceID, now)
+	if err != nil {
+		return false, err
+	}
+
+	if err = appendLockEvent(ctx, tx, deviceID, true, lockSourceAuto); err != nil {
+		return false, err
+	}
+
+	details := fmt.Sprintf("Locked automatically: %d overdue term(s)", overdue)
+	if err = appendAudit(ctx, tx, deviceID, "auto_lock", systemActor, details); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	logEvent(ctx, slog.LevelInfo, "auto_lock", fmt.Sprintf("Device locked automatically (%d overdue term(s))", overdue),
+		slog.String("device_id", deviceID), slog.Int64("overdue_terms", overdue))
+	locksTotal.Add(1)
+	notifyDeviceLocked(deviceID)
+	dispatchWebhook(webhookEventLocked, deviceID, serialNumber, map[string]interface{}{
+		"source":        "auto",
+		"overdue_terms": overdue,
+	})
+	return true, nil
+}
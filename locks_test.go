@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectAutoLock expects evaluateLocks to find overdue unpaid terms and lock
+// the device. A zero overdue expects it to find none and change nothing.
+func expectAutoLock(mock sqlmock.Sqlmock, deviceID string, overdue int64) {
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE lock_dates ld SET is_locked = true").WithArgs(deviceID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, overdue))
+	if overdue == 0 {
+		mock.ExpectRollback()
+		return
+	}
+	mock.ExpectQuery("UPDATE devices SET is_locked = true WHERE id = \\$1 RETURNING serial_number").WithArgs(deviceID).
+		WillReturnRows(sqlmock.NewRows([]string{"serial_number"}).AddRow("TV100001"))
+	mock.ExpectExec("INSERT INTO remote_locks").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO lock_events").WithArgs(sqlmock.AnyArg(), deviceID, true, lockSourceAuto, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_logs").WithArgs(sqlmock.AnyArg(), deviceID, "auto_lock", systemActor, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestCheckLockEnforcesLockDateThatPassedYesterday(t *testing.T) {
+	mock := mockDB(t)
+	yesterday := time.Now().UTC().AddDate(0, 0, -1)
+
+	expectPollStart(mock, "d1")
+	expectAutoLock(mock, "d1", 1)
+	expectPollResult(mock, true, &yesterday, 1)
+
+	body := checkLock(t, "TV100001")
+
+	if !body.IsLocked {
+		t.Error("is_locked = false, want true once a lock date has passed")
+	}
+	if body.DaysUntilLock == nil || *body.DaysUntilLock >= 0 {
+		t.Errorf("days_until_lock = %v, want negative", body.DaysUntilLock)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestEvaluateLocksComparesAgainstNow(t *testing.T) {
+	mock := mockDB(t)
+	var now captured
+	mock.ExpectBegin()
+	mock.ExpectExec("ld.lock_date \\+ d.grace_days <= \\$2").WithArgs("d1", &now).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	before := time.Now()
+	locked, err := evaluateLocks(context.Background(), "d1")
+	if err != nil || locked {
+		t.Fatalf("evaluateLocks = %v, %v, want false, nil", locked, err)
+	}
+	if at, ok := now.value.(time.Time); !ok || at.Before(before) {
+		t.Errorf("lock dates compared against %v, want the current time", now.value)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestGraceDaysDelayLock(t *testing.T) {
+	lockDate := time.Date(2026, 5, 10, 0, 0, 0, 0, time.UTC)
+	earliestUnpaid := sql.NullTime{Time: lockDate, Valid: true}
+
+	// A term locks once days_until_lock reaches 0, the same lock_date +
+	// grace_days <= today rule evaluateLocks applies
+	tests := []struct {
+		name      string
+		now       time.Time
+		wantDays  int
+		wantLocks bool
+	}{
+		{"on the lock date", lockDate.Add(12 * time.Hour), 3, false},
+		{"two days later", lockDate.AddDate(0, 0, 2), 1, false},
+		{"three days later", lockDate.AddDate(0, 0, 3), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			days := daysUntilLock(earliestUnpaid, 3, tt.now)
+			if days == nil || *days != tt.wantDays {
+				t.Fatalf("days_until_lock = %v, want %d", days, tt.wantDays)
+			}
+			if locks := *days <= 0; locks != tt.wantLocks {
+				t.Errorf("locks = %v, want %v", locks, tt.wantLocks)
+			}
+		})
+	}
+}
+
+func TestGraceDaysValidation(t *testing.T) {
+	for _, graceDays := range []int{-1, 0, 15, 16} {
+		req := validRegistration()
+		req.GraceDays = graceDays
+		fields := fieldErrorsFor(req)
+		wantValid := graceDays >= 0 && graceDays <= 15
+		if valid := len(fields) == 0; valid != wantValid {
+			t.Errorf("grace_days %d: rejected fields %v, want valid = %v", graceDays, fields, wantValid)
+		}
+	}
+}
+
+func TestEvaluateLocksSkipsRetiredDevice(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectBegin()
+	// A retired device matches no lock dates, so nothing else is written
+	mock.ExpectExec("AND d.retired_at IS NULL").WithArgs("d1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if locked, err := evaluateLocks(context.Background(), "d1"); err != nil || locked {
+		t.Fatalf("evaluateLocks = %v, %v, want false, nil", locked, err)
+	}
+	assertExpectations(t, mock)
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const requestIDContextKey contextKey = "request_id"
+
+// requestIDFromContext returns the correlation ID assigned by
+// requestLogMiddleware, or "" outside of a request
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+var (
+	appLogger     *slog.Logger
+	appLoggerOnce sync.Once
+)
+
+// logger returns the process logger, which writes one JSON object per line
+// to stderr at LOG_LEVEL (debug, info, warn, or error; default info). It also
+// becomes the slog default, so anything logged through the log package ends
+// up as JSON too.
+func logger() *slog.Logger {
+	appLoggerOnce.Do(func() {
+		level := slog.LevelInfo
+		raw := os.Getenv("LOG_LEVEL")
+		invalid := raw != "" && level.UnmarshalText([]byte(raw)) != nil
+		if invalid {
+			level = slog.LevelInfo
+		}
+		appLogger = newLogger(os.Stderr, level)
+		slog.SetDefault(appLogger)
+		if invalid {
+			appLogger.Warn(fmt.Sprintf("Invalid LOG_LEVEL %q, using info", raw))
+		}
+	})
+	return appLogger
+}
+
+// newLogger builds a JSON logger writing to w at level
+func newLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// logAt logs a formatted message at level, tagged with the request ID
+// carried by ctx so every line written while serving a request can be
+// correlated
+func logAt(ctx context.Context, level slog.Level, format string, args ...interface{}) {
+	l := logger()
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	var attrs []slog.Attr
+	if id := requestIDFromContext(ctx); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+	l.LogAttrs(ctx, level, fmt.Sprintf(format, args...), attrs...)
+}
+
+// logEvent logs a notable event with its name under "event" and any further
+// fields, such as device_id, alongside the request ID carried by ctx
+func logEvent(ctx context.Context, level slog.Level, event, msg string, attrs ...slog.Attr) {
+	l := logger()
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	fields := make([]slog.Attr, 0, len(attrs)+2)
+	if id := requestIDFromContext(ctx); id != "" {
+		fields = append(fields, slog.String("request_id", id))
+	}
+	fields = append(fields, slog.String("event", event))
+	l.LogAttrs(ctx, level, msg, append(fields, attrs...)...)
+}
+
+// logf logs at info level
+func logf(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelInfo, format, args...)
+}
+
+// debugf logs at debug level, for detail only wanted while troubleshooting
+func debugf(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelDebug, format, args...)
+}
+
+// warnf logs at warn level, for problems the service works around
+func warnf(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelWarn, format, args...)
+}
+
+// errorf logs at error level, for failed operations
+func errorf(ctx context.Context, format string, args ...interface{}) {
+	logAt(ctx, slog.LevelError, format, args...)
+}
+
+// statusRecorder captures the status code written by the wrapped handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so
+// streaming handlers can flush through the recorder
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// requestLogMiddleware assigns each request a UUID, echoes it in the
+// X-Request-ID response header, and logs one "request" event when the
+// request completes
+func requestLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := uuid.New().String()
+		w.Header().Set("X-Request-ID", requestID)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		logEvent(ctx, slog.LevelInfo, "request", fmt.Sprintf("%s %s %d", r.Method, r.URL.Path, status),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Int64("duration_ms", time.Since(start).Milliseconds()))
+	})
+}
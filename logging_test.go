@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestResponsesCarryRequestID(t *testing.T) {
+	mock := mockDB(t)
+
+	first := serve(apiRequest(t, http.MethodGet, "/api/nowhere", ""))
+	second := serve(apiRequest(t, http.MethodGet, "/api/nowhere", ""))
+
+	firstID := first.Header().Get("X-Request-ID")
+	if _, err := uuid.Parse(firstID); err != nil {
+		t.Fatalf("X-Request-ID = %q, want a UUID", firstID)
+	}
+	if secondID := second.Header().Get("X-Request-ID"); secondID == firstID {
+		t.Errorf("two requests share request ID %q", firstID)
+	}
+	assertExpectations(t, mock)
+}
+
+// captureLogs swaps in a logger writing JSON at level to a buffer for the
+// rest of the test
+func captureLogs(t *testing.T, level slog.Level) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := appLogger
+	appLogger = newLogger(&buf, level)
+	t.Cleanup(func() {
+		appLogger = previous
+	})
+	return &buf
+}
+
+// logLines decodes each captured line, failing the test on one that is not
+// a JSON object
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestRequestLogIsStructuredJSON(t *testing.T) {
+	buf := captureLogs(t, slog.LevelInfo)
+	mock := mockDB(t)
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/nowhere", ""))
+
+	var request map[string]interface{}
+	for _, fields := range logLines(t, buf) {
+		if fields["event"] == "request" {
+			request = fields
+		}
+	}
+	if request == nil {
+		t.Fatalf("no request event in logs: %s", buf.String())
+	}
+	for _, key := range []string{"time", "level", "msg", "method", "path", "status", "duration_ms"} {
+		if _, ok := request[key]; !ok {
+			t.Errorf("request event %v lacks %q", request, key)
+		}
+	}
+	if request["request_id"] != rec.Header().Get("X-Request-ID") || request["path"] != "/api/nowhere" || request["status"] != float64(http.StatusNotFound) {
+		t.Errorf("request event = %v, want request %s to /api/nowhere with status 404", request, rec.Header().Get("X-Request-ID"))
+	}
+	assertExpectations(t, mock)
+}
+
+func TestLogEventFieldsAndLevel(t *testing.T) {
+	buf := captureLogs(t, slog.LevelInfo)
+	ctx := context.WithValue(context.Background(), requestIDContextKey, "req-1")
+
+	debugf(ctx, "not shown at info")
+	logEvent(ctx, slog.LevelWarn, "lock", "Device locked", slog.String("device_id", "d1"))
+
+	lines := logLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("logged %d lines, want only the warning: %s", len(lines), buf.String())
+	}
+	want := map[string]interface{}{"level": "WARN", "msg": "Device locked", "event": "lock", "device_id": "d1", "request_id": "req-1"}
+	for key, value := range want {
+		if lines[0][key] != value {
+			t.Errorf("%s = %v, want %v", key, lines[0][key], value)
+		}
+	}
+}
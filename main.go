@@ -1,31 +1,51 @@
 package handler
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type Device struct {
-	ID           string    `json:"id"`
-	SerialNumber string    `json:"serial_number"`
-	CustomerName string    `json:"customer_name"`
-	PhoneNumber  string    `json:"phone_number"`
-	EMITerm      int       `json:"emi_term"`
-	EMIStartDate time.Time `json:"emi_start_date"`
-	TermDuration int       `json:"term_duration"` // 7, 15, or 30 days
-	IsActive     bool      `json:"is_active"`
-	IsLocked     bool      `json:"is_locked"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           string     `json:"id"`
+	SerialNumber string     `json:"serial_number"`
+	CustomerName string     `json:"customer_name"`
+	PhoneNumber  string     `json:"phone_number"`
+	EMITerm      int        `json:"emi_term"`
+	EMIStartDate time.Time  `json:"emi_start_date"`
+	TermDuration int        `json:"term_duration"` // One of ALLOWED_TERM_DURATIONS, in days
+	GraceDays    int        `json:"grace_days"`    // Days after a lock date before it is enforced
+	IsActive     bool       `json:"is_active"`
+	IsLocked     bool       `json:"is_locked"`
+	DealerID     *string    `json:"dealer_id"`  // Dealer that registered the device, nil for the operator
+	CreatedBy    string     `json:"created_by"` // Actor that registered the device
+	CreatedAt    time.Time  `json:"created_at"`
+	Version      int        `json:"version"`      // Incremented by every PATCH, for optimistic concurrency
+	SnoozeUntil  *time.Time `json:"snooze_until"` // Automatic locking is held off until then
+	RelockAt     *time.Time `json:"relock_at"`    // End of a temporary unlock
+	RetiredAt    *time.Time `json:"retired_at"`   // Set by /api/unlock; cleared by /api/reactivate
+	// Set by a force lock, which holds until an explicit unlock
+	ForceLockedAt *time.Time `json:"force_locked_at"`
+	// Last /api/check or /api/check-lock poll, nil if the TV never polled
+	LastSeenAt *time.Time `json:"last_seen_at"`
+	// Derived: no poll within DEVICE_OFFLINE_AFTER
+	Offline bool `json:"offline"`
+	// Amount due per term, nil when the dealer did not give one
+	InstallmentAmount *float64 `json:"installment_amount"`
 }
 
 type ActivationCode struct {
@@ -35,15 +55,19 @@ type ActivationCode struct {
 	TermNumber int        `json:"term_number"`
 	IsUsed     bool       `json:"is_used"`
 	UsedAt     *time.Time `json:"used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at"` // nil when codes do not expire
 	CreatedAt  time.Time  `json:"created_at"`
 }
 
 type LockDate struct {
-	ID        string    `json:"id"`
-	DeviceID  string    `json:"device_id"`
-	LockDate  time.Time `json:"lock_date"`
-	IsLocked  bool      `json:"is_locked"`
-	CreatedAt time.Time `json:"created_at"`
+	ID            string     `json:"id"`
+	DeviceID      string     `json:"device_id"`
+	TermNumber    int        `json:"term_number"`
+	LockDate      time.Time  `json:"lock_date"`
+	IsLocked      bool       `json:"is_locked"`
+	PaidAt        *time.Time `json:"paid_at,omitempty"`
+	ExtensionDays int        `json:"extension_days"` // Days added by partial payments
+	CreatedAt     time.Time  `json:"created_at"`
 }
 
 type RemoteLock struct {
@@ -59,8 +83,15 @@ type RegisterDeviceRequest struct {
 	CustomerName string `json:"customer_name"`
 	PhoneNumber  string `json:"phone_number"`
 	EMITerm      int    `json:"emi_term"`
-	EMIStartDate string `json:"emi_start_date"` // Format: "2006-01-02"
-	TermDuration int    `json:"term_duration"`  // 7, 15, or 30
+	EMIStartDate string `json:"emi_start_date"` // "2006-01-02" or RFC 3339
+	TermDuration int    `json:"term_duration"`  // One of ALLOWED_TERM_DURATIONS; DEFAULT_TERM_DURATION when omitted
+	GraceDays    int    `json:"grace_days"`     // 0-15, optional
+	// TermDurations optionally gives each term its own length in days,
+	// overriding TermDuration when computing lock dates
+	TermDurations []int `json:"term_durations,omitempty"`
+	// InstallmentAmount is the optional amount due per term, used for the
+	// outstanding balance
+	InstallmentAmount *float64 `json:"installment_amount,omitempty"`
 }
 
 type ActivateRequest struct {
@@ -78,13 +109,27 @@ type TermWithLockDateAndCode struct {
 	ActivationCode string  `json:"activation_code"`
 	IsExpired      bool    `json:"is_expired"`
 	IsUsed         bool    `json:"is_used"`
+	IsPaid         bool    `json:"is_paid"`
 	UsedAt         *string `json:"used_at,omitempty"`
 }
 
+// DeviceTerm is a term as the TV sees it. It carries no activation code:
+// the customer gets each code from the dealer on payment, so anyone who can
+// poll a serial number must not be able to read them.
+type DeviceTerm struct {
+	Term      int     `json:"term"`
+	LockDate  string  `json:"lock_date"`
+	IsExpired bool    `json:"is_expired"`
+	IsUsed    bool    `json:"is_used"`
+	IsPaid    bool    `json:"is_paid"`
+	UsedAt    *string `json:"used_at,omitempty"`
+}
+
 type ActivationResponse struct {
-	Success bool                      `json:"success"`
-	Message string                    `json:"message"`
-	Terms   []TermWithLockDateAndCode `json:"terms,omitempty"`
+	Success  bool         `json:"success"`
+	Message  string       `json:"message"`
+	IsActive bool         `json:"is_active"`
+	Terms    []DeviceTerm `json:"terms,omitempty"`
 }
 
 type RemoteLockRequest struct {
@@ -93,13 +138,25 @@ type RemoteLockRequest struct {
 }
 
 type CheckLockResponse struct {
-	IsLocked bool `json:"is_locked"`
+	IsLocked       bool    `json:"is_locked"`
+	NextLockDate   *string `json:"next_lock_date"`
+	RemainingTerms *int    `json:"remaining_terms"`
+	DaysUntilLock  *int    `json:"days_until_lock"` // Negative once overdue, nil when fully paid
 }
 
 type UnlockRequest struct {
 	SerialNumber string `json:"serial_number"`
 }
 
+// PathUnlockRequest is the optional body of /api/device/{serial}/unlock
+type PathUnlockRequest struct {
+	Deactivate bool `json:"deactivate"` // Also deactivate the device, for uninstall
+}
+
+type ReactivateRequest struct {
+	SerialNumber string `json:"serial_number"`
+}
+
 type AdminDeviceResponse struct {
 	ID                       string                    `json:"id"`
 	SerialNumber             string                    `json:"serial_number"`
@@ -124,62 +181,207 @@ type AdminDevicesResponse struct {
 	Devices []AdminDeviceResponse `json:"devices"`
 }
 
+type HealthResponse struct {
+	Status          string            `json:"status"`
+	DB              string            `json:"db"`
+	OpenConnections int               `json:"open_connections"`
+	IdleConnections int               `json:"idle_connections"`
+	Checks          map[string]string `json:"checks,omitempty"` // Result of each readiness sub-check
+}
+
+type ErrorDetail struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"` // Optional machine-readable context
+}
+
+type ErrorResponse struct {
+	Success bool        `json:"success"`
+	Error   ErrorDetail `json:"error"`
+}
+
+// requestTimeout bounds each request's database work. The cron jobs go
+// through every device, so they get cronRequestTimeout instead, and the
+// CSV export streams the whole table, so it gets exportRequestTimeout.
+const (
+	requestTimeout       = 5 * time.Second
+	cronRequestTimeout   = 60 * time.Second
+	exportRequestTimeout = 5 * time.Minute
+)
+
+// requestTimeoutFor returns the deadline for a request to path
+func requestTimeoutFor(path string) time.Duration {
+	if strings.HasPrefix(path, "/api/cron/") {
+		return cronRequestTimeout
+	}
+	if path == "/api/devices/export" {
+		return exportRequestTimeout
+	}
+	return requestTimeout
+}
+
 var db *sql.DB
-var dbOnce sync.Once
-var dbInitError error
 
-func initDB() error {
-	dbOnce.Do(func() {
-		// Try DATABASE_URL first, then POSTGRES_URL as fallback
-		connStr := os.Getenv("DATABASE_URL")
-		if connStr == "" {
-			connStr = os.Getenv("POSTGRES_URL")
-		}
-		if connStr == "" {
-			dbInitError = fmt.Errorf("DATABASE_URL or POSTGRES_URL environment variable is not set")
-			log.Println("ERROR: DATABASE_URL or POSTGRES_URL environment variable is not set")
-			log.Println("Available env vars:", os.Environ())
-			return
-		}
+// dbMu guards db while initDB connects. Only a successful connection is
+// kept; a failed attempt is discarded so the next request starts fresh.
+var dbMu sync.Mutex
 
-		log.Printf("Connecting to database... (connection string length: %d)", len(connStr))
+// Attempts and initial backoff for the startup ping. The backoff doubles
+// after each failed attempt.
+const (
+	dbPingAttempts = 3
+	dbPingBackoff  = 200 * time.Millisecond
+)
 
-		var err error
-		db, err = sql.Open("postgres", connStr)
-		if err != nil {
-			dbInitError = fmt.Errorf("Failed to open database connection: %v", err)
-			log.Printf("ERROR: Failed to open database: %v", err)
-			return
-		}
+// Connection pool defaults, sized for serverless instances that each serve
+// few concurrent requests. Long-lived servers can raise them with
+// DB_MAX_OPEN, DB_MAX_IDLE, and DB_CONN_LIFETIME.
+const (
+	defaultDBMaxOpen      = 5
+	defaultDBMaxIdle      = 2
+	defaultDBConnLifetime = 5 * time.Minute
+)
 
-		// Set connection pool settings for serverless
-		db.SetMaxOpenConns(5)
-		db.SetMaxIdleConns(2)
-		db.SetConnMaxLifetime(5 * time.Minute)
+// envNonNegativeInt reads an integer environment variable, falling back to
+// def when it is unset, not a number, or negative
+func envNonNegativeInt(name string, def int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		warnf(context.Background(), "Invalid %s %q, using %d", name, raw, def)
+		return def
+	}
+	return value
+}
 
-		log.Println("Pinging database...")
-		if err = db.Ping(); err != nil {
-			dbInitError = fmt.Errorf("Failed to ping database: %v", err)
-			log.Printf("ERROR: Database ping failed: %v", err)
-			log.Printf("Connection string format: postgresql://[user]:[password]@[host]:[port]/[database]")
-			return
+// envNonNegativeDuration reads a duration environment variable such as
+// "10m", falling back to def when it is unset, malformed, or negative
+func envNonNegativeDuration(name string, def time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil || value < 0 {
+		warnf(context.Background(), "Invalid %s %q, using %s", name, raw, def)
+		return def
+	}
+	return value
+}
+
+// configurePool sizes the connection pool from DB_MAX_OPEN, DB_MAX_IDLE, and
+// DB_CONN_LIFETIME, falling back to the serverless defaults
+func configurePool(conn *sql.DB) {
+	maxOpen := envNonNegativeInt("DB_MAX_OPEN", defaultDBMaxOpen)
+	maxIdle := envNonNegativeInt("DB_MAX_IDLE", defaultDBMaxIdle)
+	lifetime := envNonNegativeDuration("DB_CONN_LIFETIME", defaultDBConnLifetime)
+	conn.SetMaxOpenConns(maxOpen)
+	conn.SetMaxIdleConns(maxIdle)
+	conn.SetConnMaxLifetime(lifetime)
+	logf(context.Background(), "Connection pool: max open %d, max idle %d, max lifetime %s", maxOpen, maxIdle, lifetime)
+}
+
+// pinger is the part of *sql.DB that pingWithRetry needs
+type pinger interface {
+	Ping() error
+}
+
+// pingWithRetry pings p up to attempts times with exponential backoff,
+// returning the last error if every attempt fails
+func pingWithRetry(p pinger, attempts int, backoff time.Duration) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = p.Ping(); err == nil {
+			return nil
 		}
+		warnf(context.Background(), "Database ping attempt %d/%d failed: %v", attempt, attempts, err)
+		if attempt < attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return err
+}
 
-		log.Println("✓ Database connection established successfully")
-	})
-	return dbInitError
+// envNames lists the names of the environment variables, without their
+// values, to help spot a missing or misspelled one
+func envNames() []string {
+	names := make([]string, 0)
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
-func generateActivationCode() string {
-	return uuid.New().String()[:8]
+func initDB() error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	if db != nil {
+		return nil
+	}
+
+	// Try DATABASE_URL first, then POSTGRES_URL as fallback
+	connStr := os.Getenv("DATABASE_URL")
+	if connStr == "" {
+		connStr = os.Getenv("POSTGRES_URL")
+	}
+	if connStr == "" {
+		errorf(context.Background(), "DATABASE_URL or POSTGRES_URL environment variable is not set")
+		debugf(context.Background(), "Available env vars: %s", strings.Join(envNames(), ", "))
+		return fmt.Errorf("DATABASE_URL or POSTGRES_URL environment variable is not set")
+	}
+
+	logf(context.Background(), "Connecting to database... (connection string length: %d)", len(connStr))
+
+	conn, err := sql.Open("postgres", connStr)
+	if err != nil {
+		errorf(context.Background(), "Failed to open database: %v", err)
+		return fmt.Errorf("Failed to open database connection: %v", err)
+	}
+
+	configurePool(conn)
+
+	debugf(context.Background(), "Pinging database...")
+	if err = pingWithRetry(conn, dbPingAttempts, dbPingBackoff); err != nil {
+		conn.Close()
+		errorf(context.Background(), "Database ping failed: %v", err)
+		errorf(context.Background(), "Connection string format: postgresql://[user]:[password]@[host]:[port]/[database]")
+		return fmt.Errorf("Failed to ping database: %v", err)
+	}
+
+	if err = runMigrations(conn); err != nil {
+		conn.Close()
+		errorf(context.Background(), "Database migration failed: %v", err)
+		return fmt.Errorf("Failed to migrate database: %v", err)
+	}
+
+	db = conn
+	logf(context.Background(), "Database connection established successfully")
+	return nil
 }
 
-func calculateLockDates(startDate time.Time, termDuration int, emiTerm int) []time.Time {
+// maxTermDurationDays bounds each entry of a custom term schedule
+const maxTermDurationDays = 365
+
+// calculateLockDates returns one lock date per term. Terms are termDuration
+// days apart unless termDurations is given, in which case term i lasts
+// termDurations[i] days.
+func calculateLockDates(startDate time.Time, termDuration int, emiTerm int, termDurations []int) []time.Time {
 	var lockDates []time.Time
 	currentDate := startDate
 
 	for i := 0; i < emiTerm; i++ {
-		lockDate := currentDate.AddDate(0, 0, termDuration)
+		duration := termDuration
+		if len(termDurations) > 0 {
+			duration = termDurations[i]
+		}
+		lockDate := currentDate.AddDate(0, 0, duration)
 		lockDates = append(lockDates, lockDate)
 		currentDate = lockDate
 	}
@@ -187,77 +389,392 @@ func calculateLockDates(startDate time.Time, termDuration int, emiTerm int) []ti
 	return lockDates
 }
 
-func registerDevice(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// isUniqueViolation reports whether err is a Postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
+// writeDBError reports a failed database operation: 504 when the request ran
+// out of time, otherwise 500 with the given code and message
+func writeDBError(w http.ResponseWriter, r *http.Request, err error, code, message string) {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		writeError(w, http.StatusGatewayTimeout, "timeout", "The request timed out")
 		return
 	}
+	writeError(w, http.StatusInternalServerError, code, message)
+}
 
-	var req RegisterDeviceRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+// writeAPIError reports err with its own status and code when it is an
+// *apiError, otherwise as a database error with the given code and message
+func writeAPIError(w http.ResponseWriter, r *http.Request, err error, code, message string) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		writeError(w, apiErr.status, apiErr.code, apiErr.message)
 		return
 	}
+	writeDBError(w, r, err, code, message)
+}
 
-	// Validate term duration
-	if req.TermDuration != 7 && req.TermDuration != 15 && req.TermDuration != 30 {
-		http.Error(w, "Term duration must be 7, 15, or 30 days", http.StatusBadRequest)
+// writeDeviceLookupError reports a failed device lookup: 404 when the device
+// does not exist, otherwise a database error
+func writeDeviceLookupError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "device_not_found", "Device not found")
 		return
 	}
+	writeDBError(w, r, err, "fetch_failed", "Failed to fetch device")
+}
+
+// writeError writes the standard JSON error envelope used by every handler
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Success: false,
+		Error: ErrorDetail{
+			Code:    code,
+			Message: message,
+		},
+	})
+}
+
+// writeErrorWithDetails is writeError with extra machine-readable context
+// under error.details
+func writeErrorWithDetails(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Success: false,
+		Error: ErrorDetail{
+			Code:    code,
+			Message: message,
+			Details: details,
+		},
+	})
+}
+
+// methodNotAllowedHandler answers a request whose path is routed but not for
+// its method with a JSON 405, listing the methods the path does accept in
+// the Allow header
+func methodNotAllowedHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		seen := make(map[string]bool)
+		router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+			var match mux.RouteMatch
+			if route.Match(r, &match) || match.MatchErr != mux.ErrMethodMismatch {
+				return nil
+			}
+			methods, err := route.GetMethods()
+			if err != nil {
+				return nil
+			}
+			for _, method := range methods {
+				if !seen[method] {
+					seen[method] = true
+					allowed = append(allowed, method)
+				}
+			}
+			return nil
+		})
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed",
+			fmt.Sprintf("Method %s not allowed for %s", r.Method, r.URL.Path))
+	})
+}
+
+// apiError is an error that carries the HTTP status and error code it should
+// be reported with
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// FieldError is one problem with one field of a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ValidationErrorResponse struct {
+	Success bool         `json:"success"`
+	Error   ErrorDetail  `json:"error"`
+	Errors  []FieldError `json:"errors"`
+}
+
+// validationErrorDetail summarizes field errors as a single ErrorDetail
+func validationErrorDetail(fieldErrs []FieldError) ErrorDetail {
+	message := fieldErrs[0].Message
+	if len(fieldErrs) > 1 {
+		message = fmt.Sprintf("%d fields are invalid", len(fieldErrs))
+	}
+	return ErrorDetail{Code: "validation_failed", Message: message}
+}
+
+// writeValidationErrors reports every field error of a request at once
+func writeValidationErrors(w http.ResponseWriter, fieldErrs []FieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(ValidationErrorResponse{
+		Success: false,
+		Error:   validationErrorDetail(fieldErrs),
+		Errors:  fieldErrs,
+	})
+}
+
+// defaultAllowedTermDurations is the ALLOWED_TERM_DURATIONS used when it is
+// unset or invalid
+var defaultAllowedTermDurations = []int{7, 15, 30}
+
+var allowedTermDurationsOnce sync.Once
+var allowedTermDurationsList []int
+
+// allowedTermDurations returns the term durations in days listed in
+// ALLOWED_TERM_DURATIONS, a comma-separated list such as "7,14,28,30",
+// parsed on first use. An unset or invalid list falls back to 7, 15, and 30.
+func allowedTermDurations() []int {
+	allowedTermDurationsOnce.Do(func() {
+		allowedTermDurationsList = defaultAllowedTermDurations
+		raw := os.Getenv("ALLOWED_TERM_DURATIONS")
+		if raw == "" {
+			return
+		}
+		durations := make([]int, 0)
+		for _, part := range strings.Split(raw, ",") {
+			days, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || days < 1 || days > maxTermDurationDays {
+				warnf(context.Background(), "Invalid ALLOWED_TERM_DURATIONS %q, using 7, 15, and 30", raw)
+				return
+			}
+			durations = append(durations, days)
+		}
+		sort.Ints(durations)
+		allowedTermDurationsList = durations
+	})
+	return allowedTermDurationsList
+}
+
+// validTermDuration reports whether days is one of the allowed term durations
+func validTermDuration(days int) bool {
+	for _, allowed := range allowedTermDurations() {
+		if days == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// termDurationsText lists the allowed term durations for error messages,
+// as in "7, 15, or 30"
+func termDurationsText() string {
+	durations := allowedTermDurations()
+	parts := make([]string, len(durations))
+	for i, days := range durations {
+		parts[i] = strconv.Itoa(days)
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	if len(parts) == 2 {
+		return parts[0] + " or " + parts[1]
+	}
+	return strings.Join(parts[:len(parts)-1], ", ") + ", or " + parts[len(parts)-1]
+}
+
+// defaultTermDuration returns DEFAULT_TERM_DURATION, used for registrations
+// that omit term_duration, or 0 when it is unset or not an allowed duration
+func defaultTermDuration() int {
+	raw := os.Getenv("DEFAULT_TERM_DURATION")
+	if raw == "" {
+		return 0
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || !validTermDuration(value) {
+		warnf(context.Background(), "Invalid DEFAULT_TERM_DURATION %q, term_duration stays required", raw)
+		return 0
+	}
+	return value
+}
+
+// validateRegistration validates and normalizes a registration request in
+// place and returns the parsed EMI start date. Every field is checked, so
+// all problems are reported together rather than one per attempt.
+func validateRegistration(req *RegisterDeviceRequest) (time.Time, []FieldError) {
+	fieldErrs := make([]FieldError, 0)
+	fail := func(field, code, message string) {
+		fieldErrs = append(fieldErrs, FieldError{Field: field, Code: code, Message: message})
+	}
+
+	// Validate serial number and customer name
+	if serialNumber, err := validateSerialNumber(req.SerialNumber); err != nil {
+		fail("serial_number", "invalid_serial_number", err.Error())
+	} else {
+		req.SerialNumber = serialNumber
+	}
+
+	if customerName, err := validateCustomerName(req.CustomerName); err != nil {
+		fail("customer_name", "invalid_customer_name", err.Error())
+	} else {
+		req.CustomerName = customerName
+	}
+
+	// Validate and normalize phone number
+	if phoneNumber, err := validatePhone(req.PhoneNumber); err != nil {
+		fail("phone_number", "invalid_phone_number", err.Error())
+	} else {
+		req.PhoneNumber = phoneNumber
+	}
+
+	// Validate the number of terms before anything is generated per term
+	validEMITerm := req.EMITerm >= 1 && req.EMITerm <= maxEMITerm
+	if !validEMITerm {
+		fail("emi_term", "invalid_emi_term", fmt.Sprintf("EMI term must be between 1 and %d", maxEMITerm))
+	}
+
+	// Validate term duration, falling back to the configured default when omitted
+	if req.TermDuration == 0 {
+		req.TermDuration = defaultTermDuration()
+	}
+	if req.TermDuration == 0 {
+		fail("term_duration", "invalid_term_duration", "term_duration is required")
+	} else if !validTermDuration(req.TermDuration) {
+		fail("term_duration", "invalid_term_duration", "Term duration must be "+termDurationsText()+" days")
+	}
+
+	// Validate the optional per-term schedule. Its length can only be checked
+	// against a valid emi_term.
+	if req.TermDurations != nil {
+		if validEMITerm && len(req.TermDurations) != req.EMITerm {
+			fail("term_durations", "invalid_term_durations", "term_durations must have one entry per EMI term")
+		} else {
+			for _, duration := range req.TermDurations {
+				if duration < 1 || duration > maxTermDurationDays {
+					fail("term_durations", "invalid_term_durations", fmt.Sprintf("Each term duration must be between 1 and %d days", maxTermDurationDays))
+					break
+				}
+			}
+		}
+	}
+
+	// Validate grace period
+	if req.GraceDays < 0 || req.GraceDays > 15 {
+		fail("grace_days", "invalid_grace_days", "Grace days must be between 0 and 15")
+	}
 
-	// Parse EMI start date
-	emiStartDate, err := time.Parse("2006-01-02", req.EMIStartDate)
+	if req.InstallmentAmount != nil {
+		if err := validateInstallmentAmount(*req.InstallmentAmount); err != nil {
+			fail("installment_amount", "invalid_installment_amount", err.Error())
+		}
+	}
+
+	// Parse EMI start date, storing it back as a plain date
+	emiStartDate, err := parseEMIStartDate(req.EMIStartDate)
 	if err != nil {
-		http.Error(w, "Invalid date format. Use YYYY-MM-DD", http.StatusBadRequest)
-		return
+		fail("emi_start_date", "invalid_date", err.Error())
+	} else {
+		req.EMIStartDate = emiStartDate.Format("2006-01-02")
+		// Catch typos such as "0202-01-01" that would build a nonsensical schedule
+		maxPastDays := envNonNegativeInt("EMI_START_MAX_PAST_DAYS", defaultEMIStartMaxPastDays)
+		maxFutureDays := envNonNegativeInt("EMI_START_MAX_FUTURE_DAYS", defaultEMIStartMaxFutureDays)
+		today, _ := time.Parse("2006-01-02", time.Now().UTC().Format("2006-01-02"))
+		if emiStartDate.Before(today.AddDate(0, 0, -maxPastDays)) {
+			fail("emi_start_date", "invalid_emi_start_date", fmt.Sprintf("emi_start_date must be at most %d days in the past", maxPastDays))
+		} else if emiStartDate.After(today.AddDate(0, 0, maxFutureDays)) {
+			fail("emi_start_date", "invalid_emi_start_date", fmt.Sprintf("emi_start_date must be at most %d days in the future", maxFutureDays))
+		}
+	}
+
+	if len(fieldErrs) > 0 {
+		return time.Time{}, fieldErrs
 	}
+	return emiStartDate, nil
+}
 
+// createDevice inserts a validated device with its activation codes, lock
+// dates, and remote lock inside tx. A duplicate serial number is reported as
+// an *apiError.
+func createDevice(ctx context.Context, tx *sql.Tx, req RegisterDeviceRequest, emiStartDate time.Time, dealerID, createdBy string) (string, []TermWithLockDateAndCode, error) {
 	// Check if device already exists
 	var existingID string
-	err = db.QueryRow("SELECT id FROM devices WHERE serial_number = $1", req.SerialNumber).Scan(&existingID)
+	err := tx.QueryRowContext(ctx, "SELECT id FROM devices WHERE serial_number = $1 AND deleted_at IS NULL", req.SerialNumber).Scan(&existingID)
 	if err == nil {
-		http.Error(w, "Device with this serial number already exists", http.StatusConflict)
-		return
+		return "", nil, &apiError{http.StatusConflict, "duplicate_serial", "Device with this serial number already exists"}
+	}
+	if err != sql.ErrNoRows {
+		return "", nil, err
 	}
 
 	// Insert device
 	deviceID := uuid.New().String()
-	_, err = db.Exec(
-		"INSERT INTO devices (id, serial_number, customer_name, phone_number, emi_term, emi_start_date, term_duration, is_active, is_locked, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
-		deviceID, req.SerialNumber, req.CustomerName, req.PhoneNumber, req.EMITerm, emiStartDate, req.TermDuration, false, false, time.Now(),
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO devices (id, serial_number, customer_name, phone_number, emi_term, emi_start_date, term_duration, grace_days, is_active, is_locked, dealer_id, created_by, created_at, installment_amount) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)",
+		deviceID, req.SerialNumber, req.CustomerName, req.PhoneNumber, req.EMITerm, emiStartDate, req.TermDuration, req.GraceDays, false, false, sql.NullString{String: dealerID, Valid: dealerID != ""}, createdBy, time.Now(), req.InstallmentAmount,
 	)
 	if err != nil {
-		log.Printf("Error inserting device: %v", err)
-		http.Error(w, "Failed to register device", http.StatusInternalServerError)
-		return
+		// A concurrent registration of the same serial can pass the check
+		// above; the unique index on the serial number still rejects it
+		if isUniqueViolation(err) {
+			return "", nil, &apiError{http.StatusConflict, "duplicate_serial", "Device with this serial number already exists"}
+		}
+		errorf(ctx, "Error inserting device: %v", err)
+		return "", nil, err
+	}
+
+	termsWithDates, err := createSchedule(ctx, tx, deviceID, req, emiStartDate)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Create initial remote lock entry
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO remote_locks (id, device_id, is_locked, created_at, updated_at) VALUES ($1, $2, $3, $4, $5)",
+		uuid.New().String(), deviceID, false, time.Now(), time.Now(),
+	)
+	if err != nil {
+		errorf(ctx, "Error inserting remote lock: %v", err)
+		return "", nil, err
 	}
 
-	// Generate activation codes and lock dates together
-	lockDates := calculateLockDates(emiStartDate, req.TermDuration, req.EMITerm)
+	return deviceID, termsWithDates, nil
+}
+
+// createSchedule generates the activation codes and lock dates of every term
+// of a device as part of the caller's transaction
+func createSchedule(ctx context.Context, tx *sql.Tx, deviceID string, req RegisterDeviceRequest, emiStartDate time.Time) ([]TermWithLockDateAndCode, error) {
+	lockDates := calculateLockDates(emiStartDate, req.TermDuration, req.EMITerm, req.TermDurations)
+	expiresAt := activationCodeExpiry(emiStartDate)
 	termsWithDates := make([]TermWithLockDateAndCode, 0)
 
+	format, err := deviceCodeFormat(ctx, tx, deviceID)
+	if err != nil {
+		errorf(ctx, "Error fetching code format for device %s: %v", deviceID, err)
+		return nil, err
+	}
+
 	for i := 1; i <= req.EMITerm; i++ {
-		code := generateActivationCode()
-		_, err = db.Exec(
-			"INSERT INTO activation_codes (id, device_id, code, term_number, is_used, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
-			uuid.New().String(), deviceID, code, i, false, time.Now(),
-		)
+		code, err := insertActivationCode(ctx, tx, deviceID, i, format, expiresAt)
 		if err != nil {
-			log.Printf("Error inserting activation code: %v", err)
-			http.Error(w, "Failed to generate activation codes", http.StatusInternalServerError)
-			return
+			errorf(ctx, "Error inserting activation code: %v", err)
+			return nil, err
 		}
 
 		// Insert lock date
 		lockDate := lockDates[i-1]
-		_, err = db.Exec(
-			"INSERT INTO lock_dates (id, device_id, lock_date, is_locked, created_at) VALUES ($1, $2, $3, $4, $5)",
-			uuid.New().String(), deviceID, lockDate, false, time.Now(),
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO lock_dates (id, device_id, term_number, lock_date, is_locked, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+			uuid.New().String(), deviceID, i, lockDate, false, time.Now(),
 		)
 		if err != nil {
-			log.Printf("Error inserting lock date: %v", err)
-			http.Error(w, "Failed to generate lock dates", http.StatusInternalServerError)
-			return
+			errorf(ctx, "Error inserting lock date: %v", err)
+			return nil, err
 		}
 
 		// Add to terms array with activation code (new codes are not expired)
@@ -269,14 +786,122 @@ func registerDevice(w http.ResponseWriter, r *http.Request) {
 			IsUsed:         false,
 		})
 	}
+	return termsWithDates, nil
+}
 
-	// Create initial remote lock entry
-	_, err = db.Exec(
-		"INSERT INTO remote_locks (id, device_id, is_locked, created_at, updated_at) VALUES ($1, $2, $3, $4, $5)",
-		uuid.New().String(), deviceID, false, time.Now(), time.Now(),
-	)
+// previewRegistration validates a registration and returns its lock-date
+// schedule without generating codes or writing anything
+func previewRegistration(w http.ResponseWriter, r *http.Request, req RegisterDeviceRequest) {
+	ctx := r.Context()
+
+	emiStartDate, fieldErrs := validateRegistration(&req)
+	if fieldErrs != nil {
+		writeValidationErrors(w, fieldErrs)
+		return
+	}
+
+	// Report a duplicate serial now rather than on the real registration
+	var existingID string
+	err := db.QueryRowContext(ctx, "SELECT id FROM devices WHERE serial_number = $1 AND deleted_at IS NULL", req.SerialNumber).Scan(&existingID)
+	if err == nil {
+		writeError(w, http.StatusConflict, "duplicate_serial", "Device with this serial number already exists")
+		return
+	}
+	if err != sql.ErrNoRows {
+		errorf(ctx, "Error checking serial number: %v", err)
+		writeDBError(w, r, err, "registration_failed", "Failed to preview registration")
+		return
+	}
+
+	lockDates := calculateLockDates(emiStartDate, req.TermDuration, req.EMITerm, req.TermDurations)
+	terms := make([]TermWithLockDate, 0, len(lockDates))
+	for i, lockDate := range lockDates {
+		terms = append(terms, TermWithLockDate{Term: i + 1, LockDate: lockDate.Format("2006-01-02")})
+	}
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Registration preview, nothing was saved",
+		"dry_run": true,
+		"terms":   terms,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func registerDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Error inserting remote lock: %v", err)
+		writeDecodeError(w, err)
+		return
+	}
+
+	var req RegisterDeviceRequest
+	if bodyErr := decodeJSONBytes(body, &req); bodyErr != nil {
+		writeBodyError(w, bodyErr)
+		return
+	}
+
+	dryRun, ok := parseOptionalBool(r, "dry_run")
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_dry_run", "dry_run must be true or false")
+		return
+	}
+	if dryRun != nil && *dryRun {
+		previewRegistration(w, r, req)
+		return
+	}
+
+	// Replay the stored response when a client retries with the same
+	// Idempotency-Key, so a retried registration never creates a second device
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	requestHash := hashRequestBody(body)
+	if idempotencyKey != "" {
+		// Keys are per dealer so one dealer cannot replay another's response
+		if dealerID := dealerFromRequest(r); dealerID != "" {
+			idempotencyKey = dealerID + ":" + idempotencyKey
+		}
+		stored, err := lookupIdempotentResponse(ctx, idempotencyKey)
+		if err != nil {
+			errorf(ctx, "Error looking up idempotency key: %v", err)
+			writeDBError(w, r, err, "registration_failed", "Failed to register device")
+			return
+		}
+		if stored != nil {
+			if stored.requestHash != requestHash {
+				writeError(w, http.StatusConflict, "idempotency_key_reused", "Idempotency-Key was already used with a different request body")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(stored.status)
+			w.Write(stored.body)
+			return
+		}
+	}
+
+	emiStartDate, fieldErrs := validateRegistration(&req)
+	if fieldErrs != nil {
+		writeValidationErrors(w, fieldErrs)
+		return
+	}
+
+	// Run the whole registration in one transaction so a failure midway
+	// leaves no half-registered device behind
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "registration_failed", "Failed to register device")
+		return
+	}
+	defer tx.Rollback()
+
+	deviceID, termsWithDates, err := createDevice(ctx, tx, req, emiStartDate, dealerFromRequest(r), actorFromRequest(r))
+	if err != nil {
+		writeAPIError(w, r, err, "registration_failed", "Failed to register device")
+		return
 	}
 
 	response := map[string]interface{}{
@@ -285,139 +910,147 @@ func registerDevice(w http.ResponseWriter, r *http.Request) {
 		"device_id": deviceID,
 		"terms":     termsWithDates,
 	}
+	if token := deviceToken(deviceID); token != "" {
+		response["device_token"] = token
+	}
+	responseBody, err := json.Marshal(response)
+	if err != nil {
+		errorf(ctx, "Error encoding registration response: %v", err)
+		writeDBError(w, r, err, "registration_failed", "Failed to register device")
+		return
+	}
+
+	if idempotencyKey != "" {
+		err = saveIdempotentResponse(ctx, tx, idempotencyKey, requestHash, deviceID, http.StatusOK, responseBody)
+		if err != nil {
+			if isUniqueViolation(err) {
+				writeError(w, http.StatusConflict, "idempotency_key_in_use", "A request with this Idempotency-Key is already in progress")
+				return
+			}
+			errorf(ctx, "Error saving idempotency key: %v", err)
+			writeDBError(w, r, err, "registration_failed", "Failed to register device")
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing registration: %v", err)
+		writeDBError(w, r, err, "registration_failed", "Failed to register device")
+		return
+	}
+	registrationsTotal.Add(1)
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	w.Write(responseBody)
 }
 
 func activateDevice(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	ctx := r.Context()
+
+	var req ActivateRequest
+	if bodyErr := decodeJSONBody(r.Body, &req); bodyErr != nil {
+		writeBodyError(w, bodyErr)
+		return
+	}
+	if req.ActivationCode == "" {
+		writeBodyError(w, missingFieldError("activation_code"))
 		return
 	}
 
-	var req ActivateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "activation_failed", "Failed to activate device")
 		return
 	}
+	defer tx.Rollback()
 
-	// Find device by activation code (activation codes are unique)
+	// Find device by activation code (activation codes are unique). The
+	// lookup ignores is_used so an unknown code and a used one can be told apart.
+	// FOR UPDATE holds the code row until commit, so a concurrent activation
+	// with the same code waits and then sees it used.
 	var deviceID string
 	var activationCodeID string
 	var termNumber int
 	var isUsed bool
-	err := db.QueryRow(
-		"SELECT ac.id, ac.device_id, ac.term_number, ac.is_used FROM activation_codes ac WHERE ac.code = $1",
+	var usedAt, expiresAt, retiredAt *time.Time
+	err = tx.QueryRowContext(ctx,
+		"SELECT ac.id, ac.device_id, ac.term_number, ac.is_used, ac.used_at, ac.expires_at, d.retired_at FROM activation_codes ac JOIN devices d ON d.id = ac.device_id WHERE ac.code = $1 AND d.deleted_at IS NULL FOR UPDATE OF ac",
 		req.ActivationCode,
-	).Scan(&activationCodeID, &deviceID, &termNumber, &isUsed)
+	).Scan(&activationCodeID, &deviceID, &termNumber, &isUsed, &usedAt, &expiresAt, &retiredAt)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusBadRequest, "code_not_found", "Activation code not found")
+		return
+	}
 	if err != nil {
-		http.Error(w, "Invalid activation code", http.StatusBadRequest)
+		writeDBError(w, r, err, "activation_failed", "Failed to activate device")
+		return
+	}
+
+	// A device retired by /api/unlock only returns to service through
+	// /api/reactivate, so a leftover code cannot undo the unlock
+	if retiredAt != nil {
+		writeErrorWithDetails(w, http.StatusConflict, "device_retired", "Device has been unlocked and retired; reactivate it first",
+			map[string]interface{}{"retired_at": retiredAt})
 		return
 	}
 
 	// Check if activation code is already used/expired
 	if isUsed {
-		http.Error(w, "Activation code has already been used and is now expired", http.StatusBadRequest)
+		writeErrorWithDetails(w, http.StatusBadRequest, "code_already_used", "Activation code has already been used and is now expired",
+			map[string]interface{}{"used_at": usedAt})
+		return
+	}
+	if expiresAt != nil && time.Now().After(*expiresAt) {
+		writeErrorWithDetails(w, http.StatusBadRequest, "code_expired", "Activation code has expired",
+			map[string]interface{}{"expires_at": expiresAt})
 		return
 	}
 
 	// Mark activation code as used
 	now := time.Now()
-	_, err = db.Exec(
+	_, err = tx.ExecContext(ctx,
 		"UPDATE activation_codes SET is_used = true, used_at = $1 WHERE id = $2",
 		now, activationCodeID,
 	)
 	if err != nil {
-		log.Printf("Error updating activation code: %v", err)
-		http.Error(w, "Failed to activate device", http.StatusInternalServerError)
+		errorf(ctx, "Error updating activation code: %v", err)
+		writeDBError(w, r, err, "activation_failed", "Failed to activate device")
 		return
 	}
 
 	// Activate device if not already active
-	_, err = db.Exec("UPDATE devices SET is_active = true WHERE id = $1", deviceID)
+	var serialNumber string
+	err = tx.QueryRowContext(ctx, "UPDATE devices SET is_active = true WHERE id = $1 RETURNING serial_number", deviceID).Scan(&serialNumber)
 	if err != nil {
-		log.Printf("Error activating device: %v", err)
+		errorf(ctx, "Error activating device: %v", err)
+		writeDBError(w, r, err, "activation_failed", "Failed to activate device")
+		return
 	}
 
-	// Get terms with their corresponding lock dates and activation codes
-	termsWithDates := make([]TermWithLockDateAndCode, 0)
-
-	// Get activation codes with term numbers ordered by term_number
-	codeRows, err := db.Query("SELECT term_number, code, is_used, used_at FROM activation_codes WHERE device_id = $1 ORDER BY term_number", deviceID)
-	if err == nil {
-		defer codeRows.Close()
-
-		// Get lock dates ordered by lock_date
-		lockRows, err := db.Query("SELECT lock_date FROM lock_dates WHERE device_id = $1 ORDER BY lock_date", deviceID)
-		if err == nil {
-			defer lockRows.Close()
-
-			// Store activation codes by term number with usage info
-			codesByTerm := make(map[int]struct {
-				code   string
-				isUsed bool
-				usedAt *time.Time
-			})
-			for codeRows.Next() {
-				var termNumber int
-				var code string
-				var isUsed bool
-				var usedAt *time.Time
-				if err := codeRows.Scan(&termNumber, &code, &isUsed, &usedAt); err == nil {
-					codesByTerm[termNumber] = struct {
-						code   string
-						isUsed bool
-						usedAt *time.Time
-					}{code: code, isUsed: isUsed, usedAt: usedAt}
-				}
-			}
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing activation: %v", err)
+		writeDBError(w, r, err, "activation_failed", "Failed to activate device")
+		return
+	}
 
-			// Match lock dates with terms and activation codes by index
-			termIndex := 0
-			termNumbers := make([]int, 0, len(codesByTerm))
-			for termNum := range codesByTerm {
-				termNumbers = append(termNumbers, termNum)
-			}
-			// Sort term numbers
-			for i := 0; i < len(termNumbers)-1; i++ {
-				for j := i + 1; j < len(termNumbers); j++ {
-					if termNumbers[i] > termNumbers[j] {
-						termNumbers[i], termNumbers[j] = termNumbers[j], termNumbers[i]
-					}
-				}
-			}
+	activationsTotal.Add(1)
+	dispatchWebhook(webhookEventActivated, deviceID, serialNumber, map[string]interface{}{
+		"term_number": termNumber,
+	})
 
-			for lockRows.Next() {
-				var lockDate time.Time
-				if err := lockRows.Scan(&lockDate); err == nil {
-					if termIndex < len(termNumbers) {
-						termNumber := termNumbers[termIndex]
-						codeInfo := codesByTerm[termNumber]
-						var usedAtStr *string
-						if codeInfo.usedAt != nil {
-							formatted := codeInfo.usedAt.Format("2006-01-02 15:04:05")
-							usedAtStr = &formatted
-						}
-						termsWithDates = append(termsWithDates, TermWithLockDateAndCode{
-							Term:           termNumber,
-							LockDate:       lockDate.Format("2006-01-02"),
-							ActivationCode: codeInfo.code,
-							IsExpired:      codeInfo.isUsed,
-							IsUsed:         codeInfo.isUsed,
-							UsedAt:         usedAtStr,
-						})
-						termIndex++
-					}
-				}
-			}
-		}
+	// Get terms with their lock dates and activation codes
+	termsWithDates, err := fetchTerms(ctx, deviceID)
+	if err != nil {
+		errorf(ctx, "Error fetching terms for device %s: %v", deviceID, err)
+		termsWithDates = make([]TermWithLockDateAndCode, 0)
 	}
 
 	response := ActivationResponse{
-		Success: true,
-		Message: "Device activated successfully",
-		Terms:   termsWithDates,
+		Success:  true,
+		Message:  "Device activated successfully",
+		IsActive: true,
+		Terms:    withoutCodes(termsWithDates),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -425,169 +1058,159 @@ func activateDevice(w http.ResponseWriter, r *http.Request) {
 }
 
 func checkActivation(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+	ctx := r.Context()
 
-	serialNumber := r.URL.Query().Get("serial_number")
+	serialNumber := normalizeSerialNumber(r.URL.Query().Get("serial_number"))
 	if serialNumber == "" {
-		http.Error(w, "serial_number parameter is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "missing_serial_number", "serial_number parameter is required")
 		return
 	}
 
 	// Find device
 	var deviceID string
 	var isActive bool
-	err := db.QueryRow(
-		"SELECT id, is_active FROM devices WHERE serial_number = $1",
+	var retiredAt *time.Time
+	err := db.QueryRowContext(ctx,
+		"SELECT id, is_active, retired_at FROM devices WHERE serial_number = $1 AND deleted_at IS NULL",
 		serialNumber,
-	).Scan(&deviceID, &isActive)
+	).Scan(&deviceID, &isActive, &retiredAt)
 	if err != nil {
-		http.Error(w, "Device not found", http.StatusNotFound)
+		writeDeviceLookupError(w, r, err)
 		return
 	}
-
-	// Automatically activate the device when TV calls this endpoint
-	if !isActive {
-		_, err = db.Exec("UPDATE devices SET is_active = true WHERE id = $1", deviceID)
-		if err != nil {
-			log.Printf("Error activating device: %v", err)
-			http.Error(w, "Failed to activate device", http.StatusInternalServerError)
-			return
-		}
-		isActive = true
-		log.Printf("Device %s activated via /api/check", serialNumber)
+	if !authorizeDevice(w, r, deviceID) {
+		return
 	}
+	touchLastSeen(ctx, deviceID)
 
-	// Get terms with their corresponding lock dates and activation codes
-	termsWithDates := make([]TermWithLockDateAndCode, 0)
-
-	// Get activation codes with term numbers ordered by term_number
-	codeRows, err := db.Query("SELECT term_number, code, is_used, used_at FROM activation_codes WHERE device_id = $1 ORDER BY term_number", deviceID)
-	if err == nil {
-		defer codeRows.Close()
-
-		// Get lock dates ordered by lock_date
-		lockRows, err := db.Query("SELECT lock_date FROM lock_dates WHERE device_id = $1 ORDER BY lock_date", deviceID)
-		if err == nil {
-			defer lockRows.Close()
-
-			// Store activation codes by term number with usage info
-			codesByTerm := make(map[int]struct {
-				code   string
-				isUsed bool
-				usedAt *time.Time
-			})
-			for codeRows.Next() {
-				var termNumber int
-				var code string
-				var isUsed bool
-				var usedAt *time.Time
-				if err := codeRows.Scan(&termNumber, &code, &isUsed, &usedAt); err == nil {
-					codesByTerm[termNumber] = struct {
-						code   string
-						isUsed bool
-						usedAt *time.Time
-					}{code: code, isUsed: isUsed, usedAt: usedAt}
-				}
-			}
-
-			// Match lock dates with terms and activation codes by index
-			termIndex := 0
-			termNumbers := make([]int, 0, len(codesByTerm))
-			for termNum := range codesByTerm {
-				termNumbers = append(termNumbers, termNum)
-			}
-			// Sort term numbers
-			for i := 0; i < len(termNumbers)-1; i++ {
-				for j := i + 1; j < len(termNumbers); j++ {
-					if termNumbers[i] > termNumbers[j] {
-						termNumbers[i], termNumbers[j] = termNumbers[j], termNumbers[i]
-					}
-				}
-			}
+	// Get terms with their lock dates and activation codes
+	termsWithDates, err := fetchTerms(ctx, deviceID)
+	if err != nil {
+		errorf(ctx, "Error fetching terms for device %s: %v", deviceID, err)
+		termsWithDates = make([]TermWithLockDateAndCode, 0)
+	}
 
-			for lockRows.Next() {
-				var lockDate time.Time
-				if err := lockRows.Scan(&lockDate); err == nil {
-					if termIndex < len(termNumbers) {
-						termNumber := termNumbers[termIndex]
-						codeInfo := codesByTerm[termNumber]
-						var usedAtStr *string
-						if codeInfo.usedAt != nil {
-							formatted := codeInfo.usedAt.Format("2006-01-02 15:04:05")
-							usedAtStr = &formatted
-						}
-						termsWithDates = append(termsWithDates, TermWithLockDateAndCode{
-							Term:           termNumber,
-							LockDate:       lockDate.Format("2006-01-02"),
-							ActivationCode: codeInfo.code,
-							IsExpired:      codeInfo.isUsed,
-							IsUsed:         codeInfo.isUsed,
-							UsedAt:         usedAtStr,
-						})
-						termIndex++
-					}
-				}
-			}
-		}
+	// This is a pure read: polling never changes activation state. Activation
+	// happens only through POST /api/activate or /api/reactivate, and a
+	// retired device only through /api/reactivate.
+	message := "Device is active"
+	if retiredAt != nil {
+		message = "Device is retired"
+	} else if !isActive {
+		message = "Device is not active"
 	}
 
 	response := ActivationResponse{
-		Success: true,
-		Message: "Device activated successfully",
-		Terms:   termsWithDates,
+		Success:  true,
+		Message:  message,
+		IsActive: isActive,
+		Terms:    withoutCodes(termsWithDates),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// setRemoteLock is the deprecated body-based form of
+// /api/device/{serial}/lock and /unlock
 func setRemoteLock(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	var req RemoteLockRequest
+	if bodyErr := decodeJSONBody(r.Body, &req); bodyErr != nil {
+		writeBodyError(w, bodyErr)
 		return
 	}
-
-	var req RemoteLockRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	req.SerialNumber = normalizeSerialNumber(req.SerialNumber)
+	if req.SerialNumber == "" {
+		writeBodyError(w, missingFieldError("serial_number"))
 		return
 	}
 
+	w.Header().Set("Deprecation", "true")
+	applyRemoteLock(w, r, req.SerialNumber, req.IsLocked)
+}
+
+// writeRemoteLock sets the remote lock and lock status of a device as part of
+// the caller's transaction, cancelling any pending relock, and records the
+// change in the lock events and audit log. An unlock also ends a force lock.
+func writeRemoteLock(ctx context.Context, tx *sql.Tx, deviceID string, isLocked bool, actor string) error {
+	// Update remote lock, creating the row if registration left none
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO remote_locks (id, device_id, is_locked, created_at, updated_at) VALUES ($1, $2, $3, $4, $4)
+		ON CONFLICT (device_id) DO UPDATE SET is_locked = EXCLUDED.is_locked, updated_at = EXCLUDED.updated_at
+	`, uuid.New().String(), deviceID, isLocked, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// Also update device lock status
+	_, err = tx.ExecContext(ctx,
+		"UPDATE devices SET is_locked = $1, relock_at = NULL, force_locked_at = CASE WHEN $1 THEN force_locked_at END WHERE id = $2",
+		isLocked, deviceID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err = appendLockEvent(ctx, tx, deviceID, isLocked, lockSourceRemote); err != nil {
+		return err
+	}
+
+	action := "unlock"
+	if isLocked {
+		action = "lock"
+	}
+	return appendAudit(ctx, tx, deviceID, action, actor, fmt.Sprintf("Remote lock set to %v", isLocked))
+}
+
+// applyRemoteLock sets a device's remote lock, shared by /api/remote-lock and
+// the /api/device/{serial}/lock and /unlock routes
+func applyRemoteLock(w http.ResponseWriter, r *http.Request, serialNumber string, isLocked bool) {
+	ctx := r.Context()
+
 	// Find device
 	var deviceID string
-	err := db.QueryRow(
-		"SELECT id FROM devices WHERE serial_number = $1",
-		req.SerialNumber,
+	err := db.QueryRowContext(ctx,
+		"SELECT id FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2)",
+		serialNumber, dealerArg(r),
 	).Scan(&deviceID)
 	if err != nil {
-		http.Error(w, "Device not found", http.StatusNotFound)
+		writeDeviceLookupError(w, r, err)
 		return
 	}
 
-	// Update remote lock
-	_, err = db.Exec(
-		"UPDATE remote_locks SET is_locked = $1, updated_at = $2 WHERE device_id = $3",
-		req.IsLocked, time.Now(), deviceID,
-	)
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("Error updating remote lock: %v", err)
-		http.Error(w, "Failed to update remote lock", http.StatusInternalServerError)
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "remote_lock_failed", "Failed to update remote lock")
 		return
 	}
+	defer tx.Rollback()
 
-	// Also update device lock status
-	_, err = db.Exec("UPDATE devices SET is_locked = $1 WHERE id = $2", req.IsLocked, deviceID)
-	if err != nil {
-		log.Printf("Error updating device lock: %v", err)
+	if err = writeRemoteLock(ctx, tx, deviceID, isLocked, actorFromRequest(r)); err != nil {
+		errorf(ctx, "Error updating remote lock: %v", err)
+		writeDBError(w, r, err, "remote_lock_failed", "Failed to update remote lock")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing remote lock: %v", err)
+		writeDBError(w, r, err, "remote_lock_failed", "Failed to update remote lock")
+		return
+	}
+
+	if isLocked {
+		locksTotal.Add(1)
+		notifyDeviceLocked(deviceID)
+		dispatchWebhook(webhookEventLocked, deviceID, serialNumber, map[string]interface{}{"source": "remote"})
+	} else {
+		unlocksTotal.Add(1)
+		dispatchWebhook(webhookEventUnlocked, deviceID, serialNumber, map[string]interface{}{"source": "remote"})
 	}
 
 	response := map[string]interface{}{
 		"success":   true,
-		"message":   fmt.Sprintf("Remote lock set to %v", req.IsLocked),
-		"is_locked": req.IsLocked,
+		"message":   fmt.Sprintf("Remote lock set to %v", isLocked),
+		"is_locked": isLocked,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -595,87 +1218,175 @@ func setRemoteLock(w http.ResponseWriter, r *http.Request) {
 }
 
 func checkRemoteLock(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+	ctx := r.Context()
 
-	serialNumber := r.URL.Query().Get("serial_number")
+	serialNumber := normalizeSerialNumber(r.URL.Query().Get("serial_number"))
 	if serialNumber == "" {
-		http.Error(w, "serial_number parameter is required", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "missing_serial_number", "serial_number parameter is required")
 		return
 	}
 
 	// Find device
 	var deviceID string
-	err := db.QueryRow(
-		"SELECT id FROM devices WHERE serial_number = $1",
+	err := db.QueryRowContext(ctx,
+		"SELECT id FROM devices WHERE serial_number = $1 AND deleted_at IS NULL",
 		serialNumber,
 	).Scan(&deviceID)
 	if err != nil {
-		http.Error(w, "Device not found", http.StatusNotFound)
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+	if !authorizeDevice(w, r, deviceID) {
 		return
 	}
+	touchLastSeen(ctx, deviceID)
+
+	// Relock after an expired temporary unlock and enforce any lock dates
+	// that have come due since the last poll
+	if _, err = relockIfDue(ctx, deviceID); err != nil {
+		errorf(ctx, "Error relocking device %s: %v", deviceID, err)
+	}
+	if _, err = evaluateLocks(ctx, deviceID); err != nil {
+		errorf(ctx, "Error evaluating locks for device %s: %v", deviceID, err)
+	}
 
 	// Get remote lock status
 	var isLocked bool
-	err = db.QueryRow(
+	err = db.QueryRowContext(ctx,
 		"SELECT is_locked FROM remote_locks WHERE device_id = $1",
 		deviceID,
 	).Scan(&isLocked)
+	if err == sql.ErrNoRows {
+		// A device without a remote lock row was never remotely locked; create
+		// the default unlocked row so later lock changes have one to update
+		warnf(ctx, "Remote lock missing for device %s, creating default", deviceID)
+		_, err = db.ExecContext(ctx, `
+			INSERT INTO remote_locks (id, device_id, is_locked, created_at, updated_at) VALUES ($1, $2, false, $3, $3)
+			ON CONFLICT (device_id) DO NOTHING
+		`, uuid.New().String(), deviceID, time.Now())
+		if err != nil {
+			errorf(ctx, "Error creating default remote lock for device %s: %v", deviceID, err)
+		}
+		isLocked = false
+	} else if err != nil {
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch remote lock")
+		return
+	}
+
+	// Get the payment schedule so the TV can show when the next EMI is due.
+	// Both fields stay null once every term has been paid.
+	var nextLockDate, earliestUnpaid sql.NullTime
+	var unpaidTerms, unusedCodes, graceDays int
+	err = db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT MIN(lock_date) FROM lock_dates
+			 WHERE device_id = $1 AND paid_at IS NULL AND lock_date >= CURRENT_DATE),
+			(SELECT MIN(lock_date) FROM lock_dates WHERE device_id = $1 AND paid_at IS NULL),
+			(SELECT COUNT(*) FROM lock_dates WHERE device_id = $1 AND paid_at IS NULL),
+			(SELECT COUNT(*) FROM activation_codes WHERE device_id = $1 AND is_used = false),
+			(SELECT grace_days FROM devices WHERE id = $1)
+	`, deviceID).Scan(&nextLockDate, &earliestUnpaid, &unpaidTerms, &unusedCodes, &graceDays)
 	if err != nil {
-		http.Error(w, "Remote lock not found", http.StatusNotFound)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch payment schedule")
 		return
 	}
 
 	response := CheckLockResponse{
-		IsLocked: isLocked,
+		IsLocked:      isLocked,
+		DaysUntilLock: daysUntilLock(earliestUnpaid, graceDays, time.Now()),
+	}
+	if unpaidTerms > 0 {
+		if nextLockDate.Valid {
+			formatted := nextLockDate.Time.Format("2006-01-02")
+			response.NextLockDate = &formatted
+		}
+		response.RemainingTerms = &unusedCodes
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+// unlockDevice is the deprecated body-based form of
+// /api/device/{serial}/unlock with deactivate set
 func unlockDevice(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	var req UnlockRequest
+	if bodyErr := decodeJSONBody(r.Body, &req); bodyErr != nil {
+		writeBodyError(w, bodyErr)
 		return
 	}
-
-	var req UnlockRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	req.SerialNumber = normalizeSerialNumber(req.SerialNumber)
+	if req.SerialNumber == "" {
+		writeBodyError(w, missingFieldError("serial_number"))
 		return
 	}
 
+	w.Header().Set("Deprecation", "true")
+	applyUnlock(w, r, req.SerialNumber)
+}
+
+// applyUnlock unlocks and deactivates a device for uninstall, shared by
+// /api/unlock and /api/device/{serial}/unlock with deactivate set
+func applyUnlock(w http.ResponseWriter, r *http.Request, serialNumber string) {
+	ctx := r.Context()
+
 	// Find device
 	var deviceID string
-	err := db.QueryRow(
-		"SELECT id FROM devices WHERE serial_number = $1",
-		req.SerialNumber,
+	err := db.QueryRowContext(ctx,
+		"SELECT id FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2)",
+		serialNumber, dealerArg(r),
 	).Scan(&deviceID)
 	if err != nil {
-		http.Error(w, "Device not found", http.StatusNotFound)
+		writeDeviceLookupError(w, r, err)
 		return
 	}
 
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "unlock_failed", "Failed to unlock device")
+		return
+	}
+	defer tx.Rollback()
+
 	// Unlock device
-	_, err = db.Exec("UPDATE devices SET is_locked = false, is_active = false WHERE id = $1", deviceID)
+	_, err = tx.ExecContext(ctx, "UPDATE devices SET is_locked = false, is_active = false, relock_at = NULL, force_locked_at = NULL, retired_at = NOW() WHERE id = $1", deviceID)
 	if err != nil {
-		log.Printf("Error unlocking device: %v", err)
-		http.Error(w, "Failed to unlock device", http.StatusInternalServerError)
+		errorf(ctx, "Error unlocking device: %v", err)
+		writeDBError(w, r, err, "unlock_failed", "Failed to unlock device")
 		return
 	}
 
 	// Update remote lock
-	_, err = db.Exec(
+	_, err = tx.ExecContext(ctx,
 		"UPDATE remote_locks SET is_locked = false, updated_at = $1 WHERE device_id = $2",
 		time.Now(), deviceID,
 	)
 	if err != nil {
-		log.Printf("Error updating remote lock: %v", err)
+		errorf(ctx, "Error updating remote lock: %v", err)
 	}
 
+	if err = appendLockEvent(ctx, tx, deviceID, false, lockSourceUnlock); err != nil {
+		errorf(ctx, "Error writing lock event: %v", err)
+		writeDBError(w, r, err, "unlock_failed", "Failed to unlock device")
+		return
+	}
+
+	if err = appendAudit(ctx, tx, deviceID, "unlock", actorFromRequest(r), "Device unlocked and retired"); err != nil {
+		errorf(ctx, "Error writing audit log: %v", err)
+		writeDBError(w, r, err, "unlock_failed", "Failed to unlock device")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing unlock: %v", err)
+		writeDBError(w, r, err, "unlock_failed", "Failed to unlock device")
+		return
+	}
+
+	unlocksTotal.Add(1)
+	dispatchWebhook(webhookEventUnlocked, deviceID, serialNumber, map[string]interface{}{"source": "unlock"})
+
 	response := map[string]interface{}{
 		"success": true,
 		"message": "Device unlocked successfully",
@@ -685,25 +1396,115 @@ func unlockDevice(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func getAllDevices(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// lockDeviceByPath remotely locks the device named in the path
+func lockDeviceByPath(w http.ResponseWriter, r *http.Request) {
+	applyRemoteLock(w, r, normalizeSerialNumber(mux.Vars(r)["serial"]), true)
+}
+
+// unlockDeviceByPath remotely unlocks the device named in the path. With
+// deactivate set it instead unlocks and deactivates it, like /api/unlock.
+func unlockDeviceByPath(w http.ResponseWriter, r *http.Request) {
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	// The body is optional; an empty one is a plain remote unlock
+	var req PathUnlockRequest
+	if bodyErr := decodeJSONBody(r.Body, &req); bodyErr != nil && bodyErr.Reason != bodyReasonEmpty {
+		writeBodyError(w, bodyErr)
+		return
+	}
+
+	if req.Deactivate {
+		applyUnlock(w, r, serialNumber)
+		return
+	}
+	applyRemoteLock(w, r, serialNumber, false)
+}
+
+func reactivateDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req ReactivateRequest
+	if bodyErr := decodeJSONBody(r.Body, &req); bodyErr != nil {
+		writeBodyError(w, bodyErr)
+		return
+	}
+	req.SerialNumber = normalizeSerialNumber(req.SerialNumber)
+	if req.SerialNumber == "" {
+		writeBodyError(w, missingFieldError("serial_number"))
+		return
+	}
+
+	// Find device
+	var deviceID string
+	var isActive bool
+	err := db.QueryRowContext(ctx,
+		"SELECT id, is_active FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2)",
+		req.SerialNumber, dealerArg(r),
+	).Scan(&deviceID, &isActive)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+	if isActive {
+		writeError(w, http.StatusConflict, "device_already_active", "Device is already active")
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "reactivation_failed", "Failed to reactivate device")
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "UPDATE devices SET is_active = true, retired_at = NULL WHERE id = $1", deviceID); err != nil {
+		errorf(ctx, "Error reactivating device: %v", err)
+		writeDBError(w, r, err, "reactivation_failed", "Failed to reactivate device")
+		return
+	}
+
+	if err = appendAudit(ctx, tx, deviceID, "reactivate", actorFromRequest(r), "Device returned to service"); err != nil {
+		errorf(ctx, "Error writing audit log: %v", err)
+		writeDBError(w, r, err, "reactivation_failed", "Failed to reactivate device")
 		return
 	}
 
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing reactivation: %v", err)
+		writeDBError(w, r, err, "reactivation_failed", "Failed to reactivate device")
+		return
+	}
+
+	activationsTotal.Add(1)
+	dispatchWebhook(webhookEventActivated, deviceID, req.SerialNumber, map[string]interface{}{"source": "reactivate"})
+
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Device reactivated successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func getAllDevices(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
 	// Get all devices
-	rows, err := db.Query(`
+	rows, err := db.QueryContext(ctx, `
 		SELECT d.id, d.serial_number, d.customer_name, d.phone_number, 
 		       d.emi_term, d.emi_start_date, d.term_duration, 
 		       d.is_active, d.is_locked, d.created_at,
 		       COALESCE(rl.is_locked, false) as remote_locked
 		FROM devices d
 		LEFT JOIN remote_locks rl ON d.id = rl.device_id
+		WHERE d.deleted_at IS NULL AND ($1::uuid IS NULL OR d.dealer_id = $1)
 		ORDER BY d.created_at DESC
-	`)
+	`, dealerArg(r))
 	if err != nil {
-		log.Printf("Error fetching devices: %v", err)
-		http.Error(w, "Failed to fetch devices", http.StatusInternalServerError)
+		errorf(ctx, "Error fetching devices: %v", err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch devices")
 		return
 	}
 	defer rows.Close()
@@ -722,7 +1523,7 @@ func getAllDevices(w http.ResponseWriter, r *http.Request) {
 			&device.RemoteLocked,
 		)
 		if err != nil {
-			log.Printf("Error scanning device: %v", err)
+			errorf(ctx, "Error scanning device: %v", err)
 			continue
 		}
 
@@ -730,100 +1531,17 @@ func getAllDevices(w http.ResponseWriter, r *http.Request) {
 		device.CreatedAt = createdAt.Format("2006-01-02 15:04:05")
 
 		// Get terms with lock dates and activation codes
-		termsWithDates := make([]TermWithLockDateAndCode, 0)
-		usedCount := 0
-		totalCodes := 0
-
-		codeRows, err := db.Query(`
-			SELECT ac.term_number, ac.code, ac.is_used, ac.used_at 
-			FROM activation_codes ac 
-			WHERE ac.device_id = $1 
-			ORDER BY ac.term_number
-		`, device.ID)
+		termsWithDates, err := fetchTerms(ctx, device.ID)
 		if err != nil {
-			log.Printf("Error fetching activation codes for device %s: %v", device.ID, err)
-		} else {
-			defer codeRows.Close()
-
-			codesByTerm := make(map[int]struct {
-				code   string
-				isUsed bool
-				usedAt *time.Time
-			})
-
-			for codeRows.Next() {
-				var termNumber int
-				var code string
-				var isUsed bool
-				var usedAt *time.Time
-				if err := codeRows.Scan(&termNumber, &code, &isUsed, &usedAt); err == nil {
-					codesByTerm[termNumber] = struct {
-						code   string
-						isUsed bool
-						usedAt *time.Time
-					}{code: code, isUsed: isUsed, usedAt: usedAt}
-					totalCodes++
-					if isUsed {
-						usedCount++
-					}
-				}
-			}
-
-			// Get lock dates
-			lockRows, err := db.Query(`
-				SELECT lock_date 
-				FROM lock_dates 
-				WHERE device_id = $1 
-				ORDER BY lock_date
-			`, device.ID)
-			if err != nil {
-				log.Printf("Error fetching lock dates for device %s: %v", device.ID, err)
-			} else {
-				defer lockRows.Close()
-
-				termNumbers := make([]int, 0, len(codesByTerm))
-				for termNum := range codesByTerm {
-					termNumbers = append(termNumbers, termNum)
-				}
-				// Sort term numbers
-				for i := 0; i < len(termNumbers)-1; i++ {
-					for j := i + 1; j < len(termNumbers); j++ {
-						if termNumbers[i] > termNumbers[j] {
-							termNumbers[i], termNumbers[j] = termNumbers[j], termNumbers[i]
-						}
-					}
-				}
-
-				termIndex := 0
-				for lockRows.Next() {
-					var lockDate time.Time
-					if err := lockRows.Scan(&lockDate); err == nil {
-						if termIndex < len(termNumbers) {
-							termNumber := termNumbers[termIndex]
-							codeInfo := codesByTerm[termNumber]
-							var usedAtStr *string
-							if codeInfo.usedAt != nil {
-								formatted := codeInfo.usedAt.Format("2006-01-02 15:04:05")
-								usedAtStr = &formatted
-							}
-							termsWithDates = append(termsWithDates, TermWithLockDateAndCode{
-								Term:           termNumber,
-								LockDate:       lockDate.Format("2006-01-02"),
-								ActivationCode: codeInfo.code,
-								IsExpired:      codeInfo.isUsed,
-								IsUsed:         codeInfo.isUsed,
-								UsedAt:         usedAtStr,
-							})
-							termIndex++
-						}
-					}
-				}
+			errorf(ctx, "Error fetching terms for device %s: %v", device.ID, err)
+			termsWithDates = make([]TermWithLockDateAndCode, 0)
+		}
+		for _, term := range termsWithDates {
+			if term.IsUsed {
+				device.UsedActivationCodes++
 			}
-
-			// Set counts even if there are no lock dates
-			device.UsedActivationCodes = usedCount
-			device.RemainingActivationCodes = totalCodes - usedCount
 		}
+		device.RemainingActivationCodes = len(termsWithDates) - device.UsedActivationCodes
 
 		device.Terms = termsWithDates
 		device.TotalTerms = len(termsWithDates)
@@ -841,86 +1559,136 @@ func getAllDevices(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func healthCheck(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+// newRouter registers every API route with its middleware
+func newRouter() *mux.Router {
+	router := mux.NewRouter()
+
+	// API routes
+	router.HandleFunc("/api/health", readyCheck).Methods("GET")
+	router.HandleFunc("/api/live", liveCheck).Methods("GET")
+	router.HandleFunc("/api/ready", readyCheck).Methods("GET")
+	router.HandleFunc("/api/openapi.json", getOpenAPISpec).Methods("GET")
+	router.Handle("/api/register", authMiddleware(http.HandlerFunc(registerDevice))).Methods("POST")
+	router.Handle("/api/register/bulk", authMiddleware(http.HandlerFunc(registerDevicesBulk))).Methods("POST")
+	router.Handle("/api/activate", rateLimitMiddleware(activationLimiter, http.HandlerFunc(activateDevice))).Methods("POST")
+	router.HandleFunc("/api/check", checkActivation).Methods("GET")
+	router.Handle("/api/remote-lock", authMiddleware(http.HandlerFunc(setRemoteLock))).Methods("POST")
+	router.Handle("/api/remote-lock/batch", authMiddleware(http.HandlerFunc(setRemoteLockBulk))).Methods("POST")
+	router.HandleFunc("/api/check-lock", checkRemoteLock).Methods("GET")
+	router.Handle("/api/check-lock/batch", authMiddleware(http.HandlerFunc(checkRemoteLockBatch))).Methods("POST")
+	router.Handle("/api/unlock", authMiddleware(http.HandlerFunc(unlockDevice))).Methods("POST")
+	router.Handle("/api/reactivate", authMiddleware(http.HandlerFunc(reactivateDevice))).Methods("POST")
+	router.Handle("/api/admin/devices", authMiddleware(http.HandlerFunc(getAllDevices))).Methods("GET")
+	router.Handle("/api/admin/device/{serial}/terms", authMiddleware(http.HandlerFunc(getAdminDeviceTerms))).Methods("GET")
+	router.Handle("/api/payment", authMiddleware(http.HandlerFunc(recordPayment))).Methods("POST")
+	router.Handle("/api/devices", authMiddleware(http.HandlerFunc(listDevices))).Methods("GET")
+	router.Handle("/api/devices/export", authMiddleware(http.HandlerFunc(exportDevices))).Methods("GET")
+	router.Handle("/api/devices/upcoming-locks", authMiddleware(http.HandlerFunc(getUpcomingLocks))).Methods("GET")
+	router.Handle("/api/devices/offline", authMiddleware(http.HandlerFunc(getOfflineDevices))).Methods("GET")
+	router.Handle("/api/stats", authMiddleware(http.HandlerFunc(getStats))).Methods("GET")
+	router.Handle("/api/serial/{serial}/history", authMiddleware(http.HandlerFunc(getSerialHistory))).Methods("GET")
+	router.Handle("/api/device/{serial}", authMiddleware(http.HandlerFunc(getDevice))).Methods("GET")
+	router.Handle("/api/device/{serial}", authMiddleware(http.HandlerFunc(updateDevice))).Methods("PATCH")
+	router.Handle("/api/device/{serial}", authMiddleware(http.HandlerFunc(deleteDevice))).Methods("DELETE")
+	router.Handle("/api/device/{serial}/lock", authMiddleware(http.HandlerFunc(lockDeviceByPath))).Methods("POST")
+	router.Handle("/api/device/{serial}/unlock", authMiddleware(http.HandlerFunc(unlockDeviceByPath))).Methods("POST")
+	router.Handle("/api/device/{serial}/force-lock", authMiddleware(http.HandlerFunc(forceLockDevice))).Methods("POST")
+	router.Handle("/api/device/{serial}/add-term", authMiddleware(http.HandlerFunc(addTerm))).Methods("POST")
+	router.Handle("/api/device/{serial}/snooze", authMiddleware(http.HandlerFunc(snoozeDevice))).Methods("POST")
+	router.Handle("/api/device/{serial}/temporary-unlock", authMiddleware(http.HandlerFunc(temporaryUnlockDevice))).Methods("POST")
+	router.Handle("/api/device/{serial}/reissue", authMiddleware(http.HandlerFunc(reissueDevice))).Methods("POST")
+	router.Handle("/api/device/{serial}/notes", authMiddleware(http.HandlerFunc(addDeviceNote))).Methods("POST")
+	router.Handle("/api/device/{serial}/notes", authMiddleware(http.HandlerFunc(getDeviceNotes))).Methods("GET")
+	router.Handle("/api/device/{serial}/restore", authMiddleware(http.HandlerFunc(restoreDevice))).Methods("POST")
+	router.Handle("/api/device/{serial}/partial-payment", authMiddleware(http.HandlerFunc(recordPartialPayment))).Methods("POST")
+	router.Handle("/api/device/{serial}/rewind", authMiddleware(http.HandlerFunc(rewindDevice))).Methods("POST")
+	router.Handle("/api/device/{serial}/settle", authMiddleware(http.HandlerFunc(settleDevice))).Methods("POST")
+	router.Handle("/api/device/{serial}/codes", authMiddleware(http.HandlerFunc(getDeviceCodes))).Methods("GET")
+	router.Handle("/api/device/{serial}/regenerate-codes", authMiddleware(http.HandlerFunc(regenerateCodes))).Methods("POST")
+	router.Handle("/api/device/{serial}/audit", authMiddleware(http.HandlerFunc(getDeviceAudit))).Methods("GET")
+	router.Handle("/api/device/{serial}/lock-history", authMiddleware(http.HandlerFunc(getLockHistory))).Methods("GET")
+	router.Handle("/api/device/{serial}/validate", authMiddleware(http.HandlerFunc(validateSchedule))).Methods("GET")
+	router.Handle("/api/device/{serial}/repair", authMiddleware(http.HandlerFunc(repairSchedule))).Methods("POST")
+	router.HandleFunc("/api/device/{serial}/terms", getDeviceTerms).Methods("GET")
+	router.HandleFunc("/api/device/{serial}/status", getDeviceStatus).Methods("GET")
+	router.Handle("/api/cron/enforce-locks", cronMiddleware(http.HandlerFunc(enforceLocks))).Methods("GET")
+	router.Handle("/api/cron/lock-reminders", cronMiddleware(http.HandlerFunc(sendLockReminders))).Methods("GET")
+	router.Handle("/metrics", authMiddleware(requireOperator(http.HandlerFunc(getMetrics)))).Methods("GET")
+	router.Handle("/api/admin/api-keys", authMiddleware(requireOperator(http.HandlerFunc(getAPIKeys)))).Methods("GET")
+	router.Handle("/api/dealers", authMiddleware(requireOperator(http.HandlerFunc(createDealer)))).Methods("POST")
+	router.Handle("/api/dealers/{id}", authMiddleware(requireOperator(http.HandlerFunc(updateDealerCodeSettings)))).Methods("PATCH")
+
+	// Demo data generation exists only in development deployments
+	if isDevelopment() {
+		router.Handle("/api/dev/seed", authMiddleware(http.HandlerFunc(seedDevices))).Methods("POST")
+	}
+	router.MethodNotAllowedHandler = methodNotAllowedHandler(router)
+	router.Use(metricsMiddleware)
+	router.Use(bodyLimitMiddleware)
+	router.Use(gzipMiddleware)
+	return router
 }
 
 // Handler is the entry point for Vercel serverless functions
 func Handler(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Request received: %s %s", r.Method, r.URL.Path)
+	requestLogMiddleware(http.HandlerFunc(serveAPI)).ServeHTTP(w, r)
+}
+
+// serveAPI routes a request once it has been assigned a request ID
+func serveAPI(w http.ResponseWriter, r *http.Request) {
+	debugf(r.Context(), "Request received: %s %s", r.Method, r.URL.Path)
+
+	// Liveness never depends on the database
+	if r.URL.Path == "/api/live" && r.Method == http.MethodGet {
+		liveCheck(w, r)
+		return
+	}
 
 	// Initialize database connection (only once)
 	if err := initDB(); err != nil {
-		log.Printf("Database initialization error: %v", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error":   "Database connection failed",
-			"message": err.Error(),
-			"hint":    "Check Vercel environment variables: DATABASE_URL or POSTGRES_URL must be set",
+		errorf(r.Context(), "Database initialization error: %v", err)
+		if isReadinessProbe(r) {
+			writeNotReady(w, map[string]string{"database": "down"})
+			return
+		}
+		writeErrorWithDetails(w, http.StatusInternalServerError, "database_unavailable", "Database connection failed", map[string]string{
+			"reason": err.Error(),
+			"hint":   "Check Vercel environment variables: DATABASE_URL or POSTGRES_URL must be set",
 		})
 		return
 	}
 
 	// Check if database is nil (shouldn't happen, but safety check)
 	if db == nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"error":   "Database not initialized",
-			"message": "Database connection is not available",
-		})
+		writeError(w, http.StatusInternalServerError, "database_unavailable", "Database connection is not available")
 		return
 	}
 
-	// Create router
-	router := mux.NewRouter()
+	router := newRouter()
 
-	// API routes
-	router.HandleFunc("/api/health", healthCheck).Methods("GET")
-	router.HandleFunc("/api/register", registerDevice).Methods("POST")
-	router.HandleFunc("/api/activate", activateDevice).Methods("POST")
-	router.HandleFunc("/api/check", checkActivation).Methods("GET")
-	router.HandleFunc("/api/remote-lock", setRemoteLock).Methods("POST")
-	router.HandleFunc("/api/check-lock", checkRemoteLock).Methods("GET")
-	router.HandleFunc("/api/unlock", unlockDevice).Methods("POST")
-	router.HandleFunc("/api/admin/devices", getAllDevices).Methods("GET")
+	// Bound every request, and so every database call made with its
+	// context, so a stalled connection cannot hang the function
+	timeoutMiddleware := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), requestTimeoutFor(r.URL.Path))
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 
 	// Recovery middleware to catch panics
 	recoveryMiddleware := func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			defer func() {
 				if err := recover(); err != nil {
-					log.Printf("Panic recovered: %v", err)
-					w.Header().Set("Content-Type", "application/json")
-					w.WriteHeader(http.StatusInternalServerError)
-					json.NewEncoder(w).Encode(map[string]interface{}{
-						"error":   "Internal server error",
-						"message": "An unexpected error occurred",
-					})
+					errorf(r.Context(), "Panic recovered: %v", err)
+					writeError(w, http.StatusInternalServerError, "internal_error", "An unexpected error occurred")
 				}
 			}()
 			next.ServeHTTP(w, r)
 		})
 	}
 
-	// CORS middleware
-	corsMiddleware := func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-			if r.Method == "OPTIONS" {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-
-			next.ServeHTTP(w, r)
-		})
-	}
-
-	handler := recoveryMiddleware(corsMiddleware(router))
+	handler := recoveryMiddleware(corsMiddleware(timeoutMiddleware(router)))
 	handler.ServeHTTP(w, r)
 }
@@ -0,0 +1,1120 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// registrationBody is a valid registration starting today
+func registrationBody(serialNumber string, emiTerm int) string {
+	return fmt.Sprintf(`{"serial_number":%q,"customer_name":"Test Customer","phone_number":"+15551234567","emi_term":%d,"emi_start_date":%q,"term_duration":30}`,
+		serialNumber, emiTerm, time.Now().UTC().Format("2006-01-02"))
+}
+
+// expectDeviceInsert expects the duplicate-serial check, the device insert,
+// and the code format lookup that start every registration
+func expectDeviceInsert(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT id FROM devices WHERE serial_number").WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("INSERT INTO devices").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT dl.code_alphabet, dl.code_length").
+		WillReturnRows(sqlmock.NewRows([]string{"code_alphabet", "code_length"}).AddRow(nil, nil))
+}
+
+// expectTermInserts expects the activation code and lock date inserts of
+// terms successfully generated terms
+func expectTermInserts(mock sqlmock.Sqlmock, terms int) {
+	for i := 0; i < terms; i++ {
+		mock.ExpectExec("SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO activation_codes").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("RELEASE SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO lock_dates").WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+}
+
+// expectRegistration expects every statement of a successful registration
+func expectRegistration(mock sqlmock.Sqlmock, emiTerm int) {
+	mock.ExpectBegin()
+	expectDeviceInsert(mock)
+	expectTermInserts(mock, emiTerm)
+	mock.ExpectExec("INSERT INTO remote_locks").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestRegisterDeviceRollsBackWhenActivationCodeInsertFails(t *testing.T) {
+	const emiTerm, failingTerm = 6, 3
+	mock := mockDB(t)
+
+	mock.ExpectBegin()
+	expectDeviceInsert(mock)
+	expectTermInserts(mock, failingTerm-1)
+	mock.ExpectExec("SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO activation_codes").WillReturnError(errors.New("connection reset by peer"))
+	// Rolling back, and never committing, is what leaves the devices table empty
+	mock.ExpectRollback()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", registrationBody("TV100001", emiTerm)))
+
+	assertStatus(t, rec, http.StatusInternalServerError)
+	var body ErrorResponse
+	decodeResponse(t, rec, &body)
+	if body.Error.Code != "registration_failed" {
+		t.Errorf("error code = %q, want registration_failed", body.Error.Code)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestRegisterDeviceDuplicateSerial(t *testing.T) {
+	t.Run("existing device", func(t *testing.T) {
+		mock := mockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM devices WHERE serial_number").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("d1"))
+		mock.ExpectRollback()
+
+		rec := serve(apiRequest(t, http.MethodPost, "/api/register", registrationBody("TV100001", 3)))
+
+		assertDuplicateSerial(t, rec)
+		assertExpectations(t, mock)
+	})
+
+	// A registration racing another for the same serial passes the existence
+	// check and is stopped by the unique index instead
+	t.Run("concurrent registration", func(t *testing.T) {
+		mock := mockDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM devices WHERE serial_number").WillReturnError(sql.ErrNoRows)
+		mock.ExpectExec("INSERT INTO devices").
+			WillReturnError(&pq.Error{Code: "23505", Constraint: "idx_devices_serial_number_normalized"})
+		mock.ExpectRollback()
+
+		rec := serve(apiRequest(t, http.MethodPost, "/api/register", registrationBody("TV100001", 3)))
+
+		assertDuplicateSerial(t, rec)
+		assertExpectations(t, mock)
+	})
+}
+
+func assertDuplicateSerial(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	assertStatus(t, rec, http.StatusConflict)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var body ErrorResponse
+	decodeResponse(t, rec, &body)
+	if body.Error.Code != "duplicate_serial" {
+		t.Errorf("error code = %q, want duplicate_serial", body.Error.Code)
+	}
+}
+
+func TestInvalidBodyUsesErrorEnvelope(t *testing.T) {
+	mockDB(t)
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", `{"serial_number":`))
+
+	assertStatus(t, rec, http.StatusBadRequest)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var body map[string]interface{}
+	decodeResponse(t, rec, &body)
+	if body["success"] != false {
+		t.Errorf("success = %v, want false", body["success"])
+	}
+	detail, ok := body["error"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("error = %v, want an object", body["error"])
+	}
+	if detail["code"] != "invalid_request_body" {
+		t.Errorf("error.code = %v, want invalid_request_body", detail["code"])
+	}
+	if message, _ := detail["message"].(string); message == "" {
+		t.Error("error.message is empty")
+	}
+}
+
+func TestDatabaseUnavailableUsesErrorEnvelope(t *testing.T) {
+	previous := db
+	db = nil
+	t.Cleanup(func() { db = previous })
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("POSTGRES_URL", "")
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/devices", ""))
+
+	assertStatus(t, rec, http.StatusInternalServerError)
+	var body ErrorResponse
+	decodeResponse(t, rec, &body)
+	if body.Success || body.Error.Code != "database_unavailable" {
+		t.Errorf("response = %+v, want database_unavailable", body)
+	}
+	details, _ := body.Error.Details.(map[string]interface{})
+	if details["hint"] == nil || details["reason"] == nil {
+		t.Errorf("details = %v, want a reason and a hint", body.Error.Details)
+	}
+}
+
+// expectRemoteLockWrite expects writeRemoteLock for deviceID, capturing the
+// audit action and timestamp
+func expectRemoteLockWrite(mock sqlmock.Sqlmock, deviceID string, isLocked bool, action, createdAt *captured) {
+	mock.ExpectExec("INSERT INTO remote_locks").WithArgs(sqlmock.AnyArg(), deviceID, isLocked, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE devices SET is_locked").WithArgs(isLocked, deviceID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO lock_events").WithArgs(sqlmock.AnyArg(), deviceID, isLocked, lockSourceRemote, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_logs").WithArgs(sqlmock.AnyArg(), deviceID, action, sqlmock.AnyArg(), sqlmock.AnyArg(), createdAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// expectPollStart expects what /api/check-lock does before evaluating locks:
+// the device lookup, the last-seen stamp, and a relock check that finds no
+// temporary unlock to end
+func expectPollStart(mock sqlmock.Sqlmock, deviceID string) {
+	expectDeviceLookup(mock, deviceID)
+	mock.ExpectExec("UPDATE devices SET last_seen_at = NOW\\(\\)").WithArgs(deviceID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE devices d SET is_locked = true, relock_at = NULL").WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+}
+
+// expectPollResult expects the remote lock and payment schedule reads that
+// end /api/check-lock. earliestUnpaid is nil for a fully paid device.
+func expectPollResult(mock sqlmock.Sqlmock, isLocked bool, earliestUnpaid *time.Time, unpaidTerms int) {
+	mock.ExpectQuery("SELECT is_locked FROM remote_locks").
+		WillReturnRows(sqlmock.NewRows([]string{"is_locked"}).AddRow(isLocked))
+	mock.ExpectQuery("SELECT MIN\\(lock_date\\) FROM lock_dates").
+		WillReturnRows(sqlmock.NewRows([]string{"next", "earliest", "unpaid", "unused", "grace_days"}).
+			AddRow(nullable(earliestUnpaid), nullable(earliestUnpaid), unpaidTerms, unpaidTerms, 0))
+}
+
+// checkLock polls /api/check-lock for a serial number
+func checkLock(t *testing.T, serialNumber string) CheckLockResponse {
+	t.Helper()
+	rec := serve(httptest.NewRequest(http.MethodGet, "/api/check-lock?serial_number="+serialNumber, nil))
+	assertStatus(t, rec, http.StatusOK)
+	var body CheckLockResponse
+	decodeResponse(t, rec, &body)
+	return body
+}
+
+// validRegistration is a registration request that passes validation
+func validRegistration() RegisterDeviceRequest {
+	return RegisterDeviceRequest{
+		SerialNumber: "TV100001",
+		CustomerName: "Test Customer",
+		PhoneNumber:  "+15551234567",
+		EMITerm:      6,
+		EMIStartDate: time.Now().UTC().Format("2006-01-02"),
+		TermDuration: 30,
+	}
+}
+
+// fieldErrorsFor returns the fields validateRegistration rejects req for
+func fieldErrorsFor(req RegisterDeviceRequest) []string {
+	_, fieldErrs := validateRegistration(&req)
+	fields := make([]string, 0, len(fieldErrs))
+	for _, fieldErr := range fieldErrs {
+		fields = append(fields, fieldErr.Field)
+	}
+	return fields
+}
+
+func TestReactivateDevice(t *testing.T) {
+	t.Run("retired device", func(t *testing.T) {
+		mock := mockDB(t)
+		mock.ExpectQuery("SELECT id, is_active FROM devices").WithArgs("TV100001", nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "is_active"}).AddRow("d1", false))
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE devices SET is_active = true, retired_at = NULL").WithArgs("d1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO audit_logs").WithArgs(sqlmock.AnyArg(), "d1", "reactivate", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		rec := serve(apiRequest(t, http.MethodPost, "/api/reactivate", `{"serial_number":"tv100001"}`))
+
+		assertStatus(t, rec, http.StatusOK)
+		assertExpectations(t, mock)
+	})
+
+	t.Run("active device", func(t *testing.T) {
+		mock := mockDB(t)
+		mock.ExpectQuery("SELECT id, is_active FROM devices").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "is_active"}).AddRow("d1", true))
+
+		rec := serve(apiRequest(t, http.MethodPost, "/api/reactivate", `{"serial_number":"TV100001"}`))
+
+		assertStatus(t, rec, http.StatusConflict)
+		assertExpectations(t, mock)
+	})
+
+	for name, body := range map[string]string{
+		"missing serial": `{}`,
+		"unknown field":  `{"serial_number":"TV100001","force":true}`,
+		"empty body":     ``,
+	} {
+		t.Run(name, func(t *testing.T) {
+			mock := mockDB(t)
+
+			rec := serve(apiRequest(t, http.MethodPost, "/api/reactivate", body))
+
+			assertStatus(t, rec, http.StatusBadRequest)
+			var errBody ErrorResponse
+			decodeResponse(t, rec, &errBody)
+			if errBody.Error.Code != "invalid_request_body" {
+				t.Errorf("error code = %q, want invalid_request_body", errBody.Error.Code)
+			}
+			assertExpectations(t, mock)
+		})
+	}
+}
+
+// expectCheckActivation expects /api/check for a device in the given state.
+// Only last_seen_at is written; the activation state is never touched.
+func expectCheckActivation(mock sqlmock.Sqlmock, deviceID string, isActive bool, retiredAt *time.Time) {
+	mock.ExpectQuery("SELECT id, is_active, retired_at FROM devices").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "is_active", "retired_at"}).AddRow(deviceID, isActive, nullable(retiredAt)))
+	mock.ExpectExec("UPDATE devices SET last_seen_at = NOW\\(\\)").WithArgs(deviceID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM activation_codes ac").WithArgs(deviceID).WillReturnRows(termRows())
+}
+
+// checkActivationStatus polls /api/check for a serial number
+func checkActivationStatus(t *testing.T, serialNumber string) ActivationResponse {
+	t.Helper()
+	rec := serve(httptest.NewRequest(http.MethodGet, "/api/check?serial_number="+serialNumber, nil))
+	assertStatus(t, rec, http.StatusOK)
+	var body ActivationResponse
+	decodeResponse(t, rec, &body)
+	return body
+}
+
+func TestCheckActivationDoesNotReactivateRetiredDevice(t *testing.T) {
+	mock := mockDB(t)
+	retiredAt := time.Now().Add(-time.Hour)
+	expectCheckActivation(mock, "d1", false, &retiredAt)
+
+	body := checkActivationStatus(t, "TV100001")
+
+	if body.IsActive {
+		t.Error("is_active = true, want a retired device to stay inactive")
+	}
+	if body.Message != "Device is retired" {
+		t.Errorf("message = %q, want %q", body.Message, "Device is retired")
+	}
+	assertExpectations(t, mock)
+}
+
+func TestUnlockedDeviceStaysInactiveOnPoll(t *testing.T) {
+	mock := mockDB(t)
+	expectDeviceLookup(mock, "d1")
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE devices SET is_locked = false, is_active = false, .*retired_at = NOW\\(\\)").WithArgs("d1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE remote_locks SET is_locked = false").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO lock_events").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/unlock", `{"serial_number":"TV100001"}`))
+	assertStatus(t, rec, http.StatusOK)
+
+	// The poll only reads the device; reactivating is left to /api/reactivate
+	retiredAt := time.Now()
+	for i := 1; i <= 2; i++ {
+		expectCheckActivation(mock, "d1", false, &retiredAt)
+		if body := checkActivationStatus(t, "TV100001"); body.IsActive {
+			t.Errorf("poll %d: is_active = true, want the unlocked device to stay inactive", i)
+		}
+	}
+	assertExpectations(t, mock)
+}
+
+func TestCheckActivationIsReadOnly(t *testing.T) {
+	mock := mockDB(t)
+	expectCheckActivation(mock, "d1", false, nil)
+	expectCheckActivation(mock, "d1", false, nil)
+
+	for i := 1; i <= 2; i++ {
+		body := checkActivationStatus(t, "TV100001")
+		if body.IsActive || body.Message != "Device is not active" {
+			t.Errorf("poll %d: is_active = %v, message = %q, want an inactive device", i, body.IsActive, body.Message)
+		}
+	}
+	assertExpectations(t, mock)
+}
+
+// registerInvalid posts a registration that must fail validation before any
+// database work and returns the reported field errors
+func registerInvalid(t *testing.T, body string) []FieldError {
+	t.Helper()
+	mock := mockDB(t)
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", body))
+	assertStatus(t, rec, http.StatusUnprocessableEntity)
+	var errBody ValidationErrorResponse
+	decodeResponse(t, rec, &errBody)
+	assertExpectations(t, mock)
+	return errBody.Errors
+}
+
+func TestRegisterDeviceReportsEveryInvalidField(t *testing.T) {
+	body := `{"serial_number":"TV100001","customer_name":"  ","phone_number":"not a phone","emi_term":6,"emi_start_date":"01/02/2026","term_duration":-5}`
+
+	errs := registerInvalid(t, body)
+
+	fields := make([]string, 0, len(errs))
+	for _, fieldErr := range errs {
+		if fieldErr.Code == "" || fieldErr.Message == "" {
+			t.Errorf("error %+v lacks a code or message", fieldErr)
+		}
+		fields = append(fields, fieldErr.Field)
+	}
+	want := []string{"customer_name", "phone_number", "emi_start_date", "term_duration"}
+	slices.Sort(fields)
+	slices.Sort(want)
+	if !slices.Equal(fields, want) {
+		t.Errorf("fields = %v, want %v", fields, want)
+	}
+}
+
+func TestExpiredContextReturnsGatewayTimeout(t *testing.T) {
+	mock := mockDB(t)
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/devices", "").WithContext(ctx))
+
+	assertStatus(t, rec, http.StatusGatewayTimeout)
+	var body ErrorResponse
+	decodeResponse(t, rec, &body)
+	if body.Error.Code != "timeout" {
+		t.Errorf("error code = %q, want timeout", body.Error.Code)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestCheckLockReportsRemainingSchedule(t *testing.T) {
+	t.Run("partially paid", func(t *testing.T) {
+		mock := mockDB(t)
+		nextLock := time.Now().UTC().AddDate(0, 0, 10)
+
+		expectPollStart(mock, "d1")
+		expectAutoLock(mock, "d1", 0)
+		mock.ExpectQuery("SELECT is_locked FROM remote_locks").
+			WillReturnRows(sqlmock.NewRows([]string{"is_locked"}).AddRow(false))
+		mock.ExpectQuery("SELECT MIN\\(lock_date\\) FROM lock_dates").
+			WillReturnRows(sqlmock.NewRows([]string{"next", "earliest", "unpaid", "unused", "grace_days"}).
+				AddRow(nextLock, nextLock, 4, 4, 0))
+
+		body := checkLock(t, "TV100001")
+
+		if body.IsLocked {
+			t.Error("is_locked = true, want false")
+		}
+		if body.NextLockDate == nil || *body.NextLockDate != nextLock.Format("2006-01-02") {
+			t.Errorf("next_lock_date = %v, want %s", body.NextLockDate, nextLock.Format("2006-01-02"))
+		}
+		if body.RemainingTerms == nil || *body.RemainingTerms != 4 {
+			t.Errorf("remaining_terms = %v, want 4", body.RemainingTerms)
+		}
+		assertExpectations(t, mock)
+	})
+
+	t.Run("fully paid", func(t *testing.T) {
+		mock := mockDB(t)
+
+		expectPollStart(mock, "d1")
+		expectAutoLock(mock, "d1", 0)
+		expectPollResult(mock, false, nil, 0)
+
+		body := checkLock(t, "TV100001")
+
+		if body.NextLockDate != nil || body.RemainingTerms != nil || body.DaysUntilLock != nil {
+			t.Errorf("response = %+v, want null schedule fields", body)
+		}
+		assertExpectations(t, mock)
+	})
+}
+
+func TestCalculateLockDates(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		termDurations []int
+		want          []string
+	}{
+		{"uniform", nil, []string{"2024-01-31", "2024-03-01", "2024-03-31"}},
+		{"longer first term", []int{60, 30, 30}, []string{"2024-03-01", "2024-03-31", "2024-04-30"}},
+		{"final balloon", []int{30, 30, 90}, []string{"2024-01-31", "2024-03-01", "2024-05-30"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := calculateLockDates(start, 30, 3, tt.termDurations)
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d lock dates, want %d", len(got), len(tt.want))
+			}
+			for i, lockDate := range got {
+				if lockDate.Format("2006-01-02") != tt.want[i] {
+					t.Errorf("term %d locks on %s, want %s", i+1, lockDate.Format("2006-01-02"), tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateTermDurations(t *testing.T) {
+	tests := []struct {
+		name          string
+		termDurations []int
+		wantInvalid   bool
+	}{
+		{"absent", nil, false},
+		{"one per term", []int{60, 30, 30, 30, 30, 90}, false},
+		{"too few", []int{60, 30}, true},
+		{"too many", []int{30, 30, 30, 30, 30, 30, 30}, true},
+		{"zero days", []int{0, 30, 30, 30, 30, 30}, true},
+		{"over a year", []int{maxTermDurationDays + 1, 30, 30, 30, 30, 30}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := validRegistration()
+			req.TermDurations = tt.termDurations
+
+			fields := fieldErrorsFor(req)
+
+			want := []string{}
+			if tt.wantInvalid {
+				want = []string{"term_durations"}
+			}
+			if fmt.Sprint(fields) != fmt.Sprint(want) {
+				t.Errorf("field errors = %v, want %v", fields, want)
+			}
+		})
+	}
+}
+
+func TestValidateEMITermBounds(t *testing.T) {
+	for _, tt := range []struct {
+		emiTerm int
+		valid   bool
+	}{
+		{-1, false},
+		{0, false},
+		{1, true},
+		{maxEMITerm, true},
+		{maxEMITerm + 1, false},
+		{100000, false},
+	} {
+		t.Run(fmt.Sprint(tt.emiTerm), func(t *testing.T) {
+			req := validRegistration()
+			req.EMITerm = tt.emiTerm
+
+			fields := fieldErrorsFor(req)
+
+			if valid := len(fields) == 0; valid != tt.valid {
+				t.Errorf("emi_term %d: field errors = %v, want valid: %v", tt.emiTerm, fields, tt.valid)
+			}
+		})
+	}
+}
+
+func TestRegisterDeviceRejectsZeroTermsBeforeInserting(t *testing.T) {
+	errs := registerInvalid(t, registrationBody("TV100001", 0))
+
+	if len(errs) != 1 || errs[0].Field != "emi_term" {
+		t.Errorf("errors = %+v, want emi_term rejected", errs)
+	}
+}
+
+// activate posts an activation code with a fresh rate limit allowance
+func activate(t *testing.T, code string) *httptest.ResponseRecorder {
+	t.Helper()
+	previous := activationLimiter
+	activationLimiter = newTokenBucketLimiter(10, time.Minute)
+	t.Cleanup(func() { activationLimiter = previous })
+	return serve(apiRequest(t, http.MethodPost, "/api/activate", fmt.Sprintf(`{"activation_code":%q}`, code)))
+}
+
+// expectCodeLookup expects activateDevice's lookup of code, finding a code of
+// an active device; usedAt and expiresAt may be nil
+func expectCodeLookup(mock sqlmock.Sqlmock, code string, usedAt, expiresAt *time.Time) {
+	mock.ExpectQuery("SELECT ac.id, ac.device_id, ac.term_number, ac.is_used").WithArgs(code).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "device_id", "term_number", "is_used", "used_at", "expires_at", "retired_at"}).
+			AddRow("c1", "d1", 1, usedAt != nil, nullable(usedAt), nullable(expiresAt), nil))
+}
+
+// activationError returns the error envelope of a rejected activation
+func activationError(t *testing.T, rec *httptest.ResponseRecorder) ErrorDetail {
+	t.Helper()
+	assertStatus(t, rec, http.StatusBadRequest)
+	var body ErrorResponse
+	decodeResponse(t, rec, &body)
+	return body.Error
+}
+
+func TestActivateRejectsUnknownCode(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT ac.id, ac.device_id").WithArgs("NOSUCHCODE").WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	got := activationError(t, activate(t, "NOSUCHCODE"))
+
+	if got.Code != "code_not_found" {
+		t.Errorf("error code = %q, want code_not_found", got.Code)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestActivateRejectsUsedCode(t *testing.T) {
+	mock := mockDB(t)
+	usedAt := time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	expectCodeLookup(mock, "USEDCODE22", &usedAt, nil)
+	mock.ExpectRollback()
+
+	got := activationError(t, activate(t, "USEDCODE22"))
+
+	if got.Code != "code_already_used" {
+		t.Errorf("error code = %q, want code_already_used", got.Code)
+	}
+	details, _ := got.Details.(map[string]interface{})
+	if details["used_at"] != usedAt.Format(time.RFC3339) {
+		t.Errorf("details = %v, want used_at %s", got.Details, usedAt.Format(time.RFC3339))
+	}
+	assertExpectations(t, mock)
+}
+
+func TestActivateRejectsExpiredCode(t *testing.T) {
+	mock := mockDB(t)
+	expiresAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	mock.ExpectBegin()
+	expectCodeLookup(mock, "OLDCODE234", nil, &expiresAt)
+	mock.ExpectRollback()
+
+	got := activationError(t, activate(t, "OLDCODE234"))
+
+	if got.Code != "code_expired" {
+		t.Errorf("error code = %q, want code_expired", got.Code)
+	}
+	details, _ := got.Details.(map[string]interface{})
+	if details["expires_at"] != expiresAt.Format(time.RFC3339) {
+		t.Errorf("details = %v, want expires_at %s", got.Details, expiresAt.Format(time.RFC3339))
+	}
+	assertExpectations(t, mock)
+}
+
+func TestCheckLockCreatesMissingRemoteLock(t *testing.T) {
+	mock := mockDB(t)
+	nextLock := time.Now().UTC().AddDate(0, 0, 10)
+
+	expectPollStart(mock, "d1")
+	expectAutoLock(mock, "d1", 0)
+	mock.ExpectQuery("SELECT is_locked FROM remote_locks").WithArgs("d1").WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("INSERT INTO remote_locks .* ON CONFLICT \\(device_id\\) DO NOTHING").WithArgs(sqlmock.AnyArg(), "d1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT MIN\\(lock_date\\) FROM lock_dates").
+		WillReturnRows(sqlmock.NewRows([]string{"next", "earliest", "unpaid", "unused", "grace_days"}).
+			AddRow(nextLock, nextLock, 6, 6, 0))
+
+	body := checkLock(t, "TV100001")
+
+	if body.IsLocked {
+		t.Error("is_locked = true, want the default false")
+	}
+	assertExpectations(t, mock)
+}
+
+func TestRegisterDeviceFailsWithoutRemoteLock(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectBegin()
+	expectDeviceInsert(mock)
+	expectTermInserts(mock, 2)
+	mock.ExpectExec("INSERT INTO remote_locks").WillReturnError(errors.New("connection reset by peer"))
+	mock.ExpectRollback()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", registrationBody("TV100001", 2)))
+
+	assertStatus(t, rec, http.StatusInternalServerError)
+	assertExpectations(t, mock)
+}
+
+func TestRegistrationStampsActorFromAPIKey(t *testing.T) {
+	mock := mockDB(t)
+	var createdBy captured
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM devices WHERE serial_number").WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("INSERT INTO devices").
+		WithArgs(sqlmock.AnyArg(), "TV100001", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			false, false, nil, &createdBy, sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT dl.code_alphabet, dl.code_length").
+		WillReturnRows(sqlmock.NewRows([]string{"code_alphabet", "code_length"}).AddRow(nil, nil))
+	expectTermInserts(mock, 1)
+	mock.ExpectExec("INSERT INTO remote_locks").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", registrationBody("TV100001", 1)))
+	assertStatus(t, rec, http.StatusOK)
+
+	want := apiKeyIdentifier(testAPIKey)
+	if createdBy.value != want {
+		t.Fatalf("created_by = %v, want %s", createdBy.value, want)
+	}
+
+	// The detail endpoint reports who registered the device
+	device := testDevice("d1", "TV100001")
+	device.CreatedBy = want
+	expectDeviceDetail(mock, device)
+	rec = serve(apiRequest(t, http.MethodGet, "/api/device/TV100001", ""))
+	assertStatus(t, rec, http.StatusOK)
+	var body DeviceDetailResponse
+	decodeResponse(t, rec, &body)
+	if body.Device.CreatedBy != want {
+		t.Errorf("device created_by = %q, want %s", body.Device.CreatedBy, want)
+	}
+	assertExpectations(t, mock)
+}
+
+// flakyPinger fails its first failures pings, then succeeds
+type flakyPinger struct {
+	failures int
+	calls    int
+}
+
+func (p *flakyPinger) Ping() error {
+	p.calls++
+	if p.calls <= p.failures {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestPingWithRetryRecoversFromTransientFailures(t *testing.T) {
+	p := &flakyPinger{failures: 2}
+
+	err := pingWithRetry(p, dbPingAttempts, time.Millisecond)
+
+	if err != nil {
+		t.Errorf("pingWithRetry error = %v, want success on the third attempt", err)
+	}
+	if p.calls != 3 {
+		t.Errorf("pinged %d times, want 3", p.calls)
+	}
+}
+
+func TestPingWithRetryGivesUp(t *testing.T) {
+	p := &flakyPinger{failures: 10}
+
+	err := pingWithRetry(p, dbPingAttempts, time.Millisecond)
+
+	if err == nil {
+		t.Error("pingWithRetry succeeded, want the last ping error")
+	}
+	if p.calls != dbPingAttempts {
+		t.Errorf("pinged %d times, want %d", p.calls, dbPingAttempts)
+	}
+}
+
+func TestConfigurePoolFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxOpen string
+		want    int
+	}{
+		{"configured", "25", 25},
+		{"unset", "", defaultDBMaxOpen},
+		{"invalid", "lots", defaultDBMaxOpen},
+		{"negative", "-1", defaultDBMaxOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DB_MAX_OPEN", tt.maxOpen)
+			t.Setenv("DB_MAX_IDLE", "4")
+			t.Setenv("DB_CONN_LIFETIME", "10m")
+			conn, _, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("sqlmock.New: %v", err)
+			}
+			defer conn.Close()
+
+			configurePool(conn)
+
+			if got := conn.Stats().MaxOpenConnections; got != tt.want {
+				t.Errorf("MaxOpenConnections = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConcurrentActivationsUseCodeOnce(t *testing.T) {
+	conn := integrationDB(t)
+	serialNumber := "IT" + strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+	t.Cleanup(func() {
+		conn.Exec("DELETE FROM devices WHERE serial_number = $1", serialNumber)
+	})
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", registrationBody(serialNumber, 1)))
+	assertStatus(t, rec, http.StatusOK)
+	var registered struct {
+		Terms []TermWithLockDateAndCode `json:"terms"`
+	}
+	decodeResponse(t, rec, &registered)
+	if len(registered.Terms) != 1 {
+		t.Fatalf("terms = %+v, want one", registered.Terms)
+	}
+
+	previous := activationLimiter
+	activationLimiter = newTokenBucketLimiter(10, time.Minute)
+	t.Cleanup(func() { activationLimiter = previous })
+	body := fmt.Sprintf(`{"activation_code":%q}`, registered.Terms[0].ActivationCode)
+	requests := []*http.Request{
+		apiRequest(t, http.MethodPost, "/api/activate", body),
+		apiRequest(t, http.MethodPost, "/api/activate", body),
+	}
+
+	results := make([]*httptest.ResponseRecorder, len(requests))
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, r := range requests {
+		wg.Add(1)
+		go func(i int, r *http.Request) {
+			defer wg.Done()
+			<-start
+			results[i] = serve(r)
+		}(i, r)
+	}
+	close(start)
+	wg.Wait()
+
+	succeeded, alreadyUsed := 0, 0
+	for _, rec := range results {
+		switch rec.Code {
+		case http.StatusOK:
+			succeeded++
+		case http.StatusBadRequest:
+			var errBody ErrorResponse
+			decodeResponse(t, rec, &errBody)
+			if errBody.Error.Code == "code_already_used" {
+				alreadyUsed++
+			}
+		}
+	}
+	if succeeded != 1 || alreadyUsed != 1 {
+		t.Errorf("responses = %d, %d, want one success and one code_already_used", results[0].Code, results[1].Code)
+	}
+}
+
+func TestRegisterDryRunCreatesNothing(t *testing.T) {
+	mock := mockDB(t)
+	// The serial check is the only statement; no transaction, codes, or inserts
+	mock.ExpectQuery("SELECT id FROM devices WHERE serial_number").WithArgs("TV100001").WillReturnError(sql.ErrNoRows)
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register?dry_run=true", registrationBody("TV100001", 3)))
+
+	assertStatus(t, rec, http.StatusOK)
+	if strings.Contains(rec.Body.String(), "activation_code") {
+		t.Errorf("preview generated activation codes: %s", rec.Body.String())
+	}
+	var body struct {
+		DryRun bool               `json:"dry_run"`
+		Terms  []TermWithLockDate `json:"terms"`
+	}
+	decodeResponse(t, rec, &body)
+	if !body.DryRun || len(body.Terms) != 3 {
+		t.Fatalf("response = %+v, want a dry run with three terms", body)
+	}
+	start := time.Now().UTC()
+	for i, term := range body.Terms {
+		want := start.AddDate(0, 0, 30*(i+1)).Format("2006-01-02")
+		if term.Term != i+1 || term.LockDate != want {
+			t.Errorf("term %d = %+v, want lock date %s", i+1, term, want)
+		}
+	}
+	assertExpectations(t, mock)
+}
+
+func TestRegisterDryRunStillValidates(t *testing.T) {
+	mock := mockDB(t)
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register?dry_run=true", registrationBody("TV100001", 0)))
+
+	assertStatus(t, rec, http.StatusUnprocessableEntity)
+	assertExpectations(t, mock)
+}
+
+func TestSerialNumbersAreCaseInsensitive(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM devices WHERE serial_number").WithArgs("ABC123").WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("INSERT INTO devices").WithArgs(sqlmock.AnyArg(), "ABC123", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+		sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT dl.code_alphabet, dl.code_length").
+		WillReturnRows(sqlmock.NewRows([]string{"code_alphabet", "code_length"}).AddRow(nil, nil))
+	expectTermInserts(mock, 1)
+	mock.ExpectExec("INSERT INTO remote_locks").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", registrationBody(" abc123 ", 1)))
+	assertStatus(t, rec, http.StatusOK)
+
+	for _, serialNumber := range []string{"abc123", "ABC123", "AbC123"} {
+		mock.ExpectQuery("SELECT id, is_active, retired_at FROM devices").WithArgs("ABC123").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "is_active", "retired_at"}).AddRow("d1", true, nil))
+		mock.ExpectExec("UPDATE devices SET last_seen_at = NOW\\(\\)").WithArgs("d1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("FROM activation_codes ac").WithArgs("d1").WillReturnRows(termRows())
+
+		if body := checkActivationStatus(t, serialNumber); !body.IsActive {
+			t.Errorf("%s: is_active = false, want the registered device", serialNumber)
+		}
+	}
+	assertExpectations(t, mock)
+}
+
+func TestValidateEMIStartDateBounds(t *testing.T) {
+	today := time.Now().UTC()
+	tests := []struct {
+		name  string
+		date  string
+		valid bool
+	}{
+		{"today", today.Format("2006-01-02"), true},
+		{"oldest allowed", today.AddDate(0, 0, -defaultEMIStartMaxPastDays).Format("2006-01-02"), true},
+		{"too old", today.AddDate(0, 0, -defaultEMIStartMaxPastDays-1).Format("2006-01-02"), false},
+		{"latest allowed", today.AddDate(0, 0, defaultEMIStartMaxFutureDays).Format("2006-01-02"), true},
+		{"too far ahead", today.AddDate(0, 0, defaultEMIStartMaxFutureDays+1).Format("2006-01-02"), false},
+		{"typo", "0202-01-01", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := validRegistration()
+			req.EMIStartDate = tt.date
+
+			_, fieldErrs := validateRegistration(&req)
+
+			if tt.valid {
+				if len(fieldErrs) != 0 {
+					t.Errorf("%s: errors = %+v, want valid", tt.date, fieldErrs)
+				}
+				return
+			}
+			if len(fieldErrs) != 1 || fieldErrs[0].Code != "invalid_emi_start_date" {
+				t.Errorf("%s: errors = %+v, want invalid_emi_start_date", tt.date, fieldErrs)
+			}
+		})
+	}
+}
+
+func TestEMIStartDateBoundsFromEnv(t *testing.T) {
+	t.Setenv("EMI_START_MAX_PAST_DAYS", "30")
+	t.Setenv("EMI_START_MAX_FUTURE_DAYS", "10")
+	today := time.Now().UTC()
+
+	for _, tt := range []struct {
+		offset int
+		valid  bool
+	}{{-30, true}, {-31, false}, {10, true}, {11, false}} {
+		req := validRegistration()
+		req.EMIStartDate = today.AddDate(0, 0, tt.offset).Format("2006-01-02")
+
+		if _, fieldErrs := validateRegistration(&req); (len(fieldErrs) == 0) != tt.valid {
+			t.Errorf("%d days from today: errors = %+v, want valid: %v", tt.offset, fieldErrs, tt.valid)
+		}
+	}
+}
+
+func TestPathLockAndUnlock(t *testing.T) {
+	for _, tt := range []struct {
+		target   string
+		isLocked bool
+	}{
+		{"/api/device/tv100001/lock", true},
+		{"/api/device/tv100001/unlock", false},
+	} {
+		mock := mockDB(t)
+		mock.ExpectQuery("SELECT id FROM devices WHERE serial_number").WithArgs("TV100001", nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("d1"))
+		mock.ExpectBegin()
+		expectRemoteLockWrite(mock, "d1", tt.isLocked, &captured{}, &captured{})
+		mock.ExpectCommit()
+
+		rec := serve(apiRequest(t, http.MethodPost, tt.target, ""))
+
+		assertStatus(t, rec, http.StatusOK)
+		var body struct {
+			IsLocked bool `json:"is_locked"`
+		}
+		decodeResponse(t, rec, &body)
+		if body.IsLocked != tt.isLocked {
+			t.Errorf("%s: is_locked = %v, want %v", tt.target, body.IsLocked, tt.isLocked)
+		}
+		if rec.Header().Get("Deprecation") != "" {
+			t.Errorf("%s: carries a Deprecation header", tt.target)
+		}
+		assertExpectations(t, mock)
+	}
+}
+
+func TestPathUnlockWithDeactivateRetiresDevice(t *testing.T) {
+	mock := mockDB(t)
+	expectDeviceLookup(mock, "d1")
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE devices SET is_locked = false, is_active = false, .*retired_at = NOW\\(\\)").WithArgs("d1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE remote_locks SET is_locked = false").WithArgs(sqlmock.AnyArg(), "d1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO lock_events").WithArgs(sqlmock.AnyArg(), "d1", false, lockSourceUnlock, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_logs").WithArgs(sqlmock.AnyArg(), "d1", "unlock", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/unlock", `{"deactivate":true}`))
+
+	assertStatus(t, rec, http.StatusOK)
+	assertExpectations(t, mock)
+}
+
+func TestPathLockUnknownSerial(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectQuery("SELECT id FROM devices WHERE serial_number").WillReturnError(sql.ErrNoRows)
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV999999/lock", ""))
+
+	assertStatus(t, rec, http.StatusNotFound)
+	assertExpectations(t, mock)
+}
+
+func TestPathUnlockRejectsMalformedBody(t *testing.T) {
+	mock := mockDB(t)
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/unlock", `{"deactivate":"yes"}`))
+
+	assertStatus(t, rec, http.StatusBadRequest)
+	assertExpectations(t, mock)
+}
+
+func TestDefaultTermDuration(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          string
+		termDuration int
+		want         int
+		wantValid    bool
+	}{
+		{"omitted uses the default", "30", 0, 30, true},
+		{"omitted without a default", "", 0, 0, false},
+		{"omitted with an invalid default", "10", 0, 0, false},
+		{"explicit value wins", "30", 15, 15, true},
+		{"explicit invalid value", "30", 10, 10, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEFAULT_TERM_DURATION", tt.env)
+			req := validRegistration()
+			req.TermDuration = tt.termDuration
+
+			_, fieldErrs := validateRegistration(&req)
+
+			if req.TermDuration != tt.want {
+				t.Errorf("term_duration = %d, want %d", req.TermDuration, tt.want)
+			}
+			if valid := len(fieldErrs) == 0; valid != tt.wantValid {
+				t.Errorf("errors = %+v, want valid = %v", fieldErrs, tt.wantValid)
+			}
+			for _, fieldErr := range fieldErrs {
+				if fieldErr.Field != "term_duration" {
+					t.Errorf("unexpected error %+v", fieldErr)
+				}
+			}
+		})
+	}
+}
+
+// useAllowedTermDurations sets ALLOWED_TERM_DURATIONS for the rest of the
+// test and drops the parsed list so the next use reads it
+func useAllowedTermDurations(t *testing.T, raw string) {
+	t.Helper()
+	t.Setenv("ALLOWED_TERM_DURATIONS", raw)
+	allowedTermDurationsOnce = sync.Once{}
+	t.Cleanup(func() {
+		allowedTermDurationsOnce = sync.Once{}
+	})
+}
+
+func TestCustomTermDurations(t *testing.T) {
+	req := validRegistration()
+	req.TermDuration = 28
+	if fields := fieldErrorsFor(req); !slices.Equal(fields, []string{"term_duration"}) {
+		t.Fatalf("default list rejected fields %v, want term_duration", fields)
+	}
+
+	useAllowedTermDurations(t, "30, 7,28,14")
+
+	if fields := fieldErrorsFor(req); len(fields) != 0 {
+		t.Errorf("28 days with a custom list rejected fields %v, want none", fields)
+	}
+	req.TermDuration = 15
+	_, fieldErrs := validateRegistration(&req)
+	if len(fieldErrs) != 1 || !strings.Contains(fieldErrs[0].Message, "7, 14, 28, or 30") {
+		t.Errorf("15 days with a custom list = %+v, want an error listing 7, 14, 28, or 30", fieldErrs)
+	}
+}
+
+func TestInvalidTermDurationListFallsBack(t *testing.T) {
+	for _, raw := range []string{"7,x,30", "0,30", "7,,30"} {
+		useAllowedTermDurations(t, raw)
+
+		if got := allowedTermDurations(); !slices.Equal(got, defaultAllowedTermDurations) {
+			t.Errorf("ALLOWED_TERM_DURATIONS=%q gives %v, want the default %v", raw, got, defaultAllowedTermDurations)
+		}
+	}
+}
+
+func TestWrongMethodGetsJSON405(t *testing.T) {
+	tests := []struct {
+		method, target, allow string
+	}{
+		{http.MethodDelete, "/api/register", "POST"},
+		{http.MethodPut, "/api/device/TV100001", "DELETE, GET, PATCH"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			mock := mockDB(t)
+
+			rec := serve(apiRequest(t, tt.method, tt.target, ""))
+
+			assertStatus(t, rec, http.StatusMethodNotAllowed)
+			if got := rec.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			var body ErrorResponse
+			decodeResponse(t, rec, &body)
+			if body.Error.Code != "method_not_allowed" {
+				t.Errorf("error = %+v, want method_not_allowed", body.Error)
+			}
+			assertExpectations(t, mock)
+		})
+	}
+}
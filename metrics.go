@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+)
+
+// Event counters. Like the rate limiter these live in process memory, so on
+// serverless deployments each instance reports its own totals since it started.
+var (
+	registrationsTotal atomic.Uint64
+	activationsTotal   atomic.Uint64
+	locksTotal         atomic.Uint64
+	unlocksTotal       atomic.Uint64
+)
+
+type endpointKey struct {
+	method string
+	path   string
+}
+
+// endpointCounters counts requests per route template (not the raw path, so
+// serial numbers do not create new series)
+type endpointCounters struct {
+	mu       sync.Mutex
+	requests map[endpointKey]uint64
+	errors   map[endpointKey]uint64
+}
+
+var endpointMetrics = &endpointCounters{
+	requests: make(map[endpointKey]uint64),
+	errors:   make(map[endpointKey]uint64),
+}
+
+func (c *endpointCounters) record(key endpointKey, status int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests[key]++
+	if status >= http.StatusBadRequest {
+		c.errors[key]++
+	}
+}
+
+// snapshot copies the counters so they can be written without holding the lock
+func (c *endpointCounters) snapshot() (map[endpointKey]uint64, map[endpointKey]uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	requests := make(map[endpointKey]uint64, len(c.requests))
+	for key, value := range c.requests {
+		requests[key] = value
+	}
+	errors := make(map[endpointKey]uint64, len(c.errors))
+	for key, value := range c.errors {
+		errors[key] = value
+	}
+	return requests, errors
+}
+
+// metricsMiddleware counts each routed request and whether it failed
+// (status 400 or above). It must be installed with router.Use so the matched
+// route is known.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		path := r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				path = template
+			}
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		endpointMetrics.record(endpointKey{method: r.Method, path: path}, status)
+	})
+}
+
+func writeCounter(b *strings.Builder, name, help string, value uint64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+}
+
+func writeEndpointCounter(b *strings.Builder, name, help string, values map[endpointKey]uint64) {
+	keys := make([]endpointKey, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].path != keys[j].path {
+			return keys[i].path < keys[j].path
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, key := range keys {
+		fmt.Fprintf(b, "%s{method=%q,path=%q} %d\n", name, key.method, key.path, values[key])
+	}
+}
+
+// getMetrics serves the counters in the Prometheus text exposition format
+func getMetrics(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var lockedDevices int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM devices WHERE is_locked = true AND deleted_at IS NULL").Scan(&lockedDevices)
+	if err != nil {
+		errorf(ctx, "Error counting locked devices: %v", err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to collect metrics")
+		return
+	}
+
+	requests, errors := endpointMetrics.snapshot()
+
+	var b strings.Builder
+	writeCounter(&b, "tv_locker_registrations_total", "Devices registered.", registrationsTotal.Load())
+	writeCounter(&b, "tv_locker_activations_total", "Devices activated or reactivated.", activationsTotal.Load())
+	writeCounter(&b, "tv_locker_locks_total", "Devices locked, remotely or automatically.", locksTotal.Load())
+	writeCounter(&b, "tv_locker_unlocks_total", "Devices unlocked, remotely, by unlock, by payment, or by settling.", unlocksTotal.Load())
+	writeEndpointCounter(&b, "tv_locker_http_requests_total", "HTTP requests by route.", requests)
+	writeEndpointCounter(&b, "tv_locker_http_request_errors_total", "HTTP requests by route that returned status 400 or above.", errors)
+	fmt.Fprintf(&b, "# HELP tv_locker_locked_devices Devices currently locked.\n# TYPE tv_locker_locked_devices gauge\ntv_locker_locked_devices %d\n", lockedDevices)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"bufio"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// scrapeMetrics fetches /metrics and returns each sample by its name and labels
+func scrapeMetrics(t *testing.T, mock sqlmock.Sqlmock) map[string]uint64 {
+	t.Helper()
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM devices WHERE is_locked = true").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	rec := serve(apiRequest(t, http.MethodGet, "/metrics", ""))
+	assertStatus(t, rec, http.StatusOK)
+
+	samples := make(map[string]uint64)
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		value, err := strconv.ParseUint(line[i+1:], 10, 64)
+		if err != nil {
+			t.Fatalf("malformed sample %q: %v", line, err)
+		}
+		samples[line[:i]] = value
+	}
+	return samples
+}
+
+func TestMetricsCountRegistrations(t *testing.T) {
+	mock := mockDB(t)
+	const registerRequests = `tv_locker_http_requests_total{method="POST",path="/api/register"}`
+
+	before := scrapeMetrics(t, mock)
+	expectRegistration(mock, 3)
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", registrationBody("TV100001", 3)))
+	assertStatus(t, rec, http.StatusOK)
+	after := scrapeMetrics(t, mock)
+
+	if got := after["tv_locker_registrations_total"] - before["tv_locker_registrations_total"]; got != 1 {
+		t.Errorf("tv_locker_registrations_total grew by %d, want 1", got)
+	}
+	if got := after[registerRequests] - before[registerRequests]; got != 1 {
+		t.Errorf("%s grew by %d, want 1", registerRequests, got)
+	}
+	if _, ok := after["tv_locker_locked_devices"]; !ok {
+		t.Error("tv_locker_locked_devices gauge missing")
+	}
+	assertExpectations(t, mock)
+}
+
+func TestMetricsRequireAPIKey(t *testing.T) {
+	mock := mockDB(t)
+	r := apiRequest(t, http.MethodGet, "/metrics", "")
+	r.Header.Del("X-API-Key")
+
+	rec := serve(r)
+
+	assertStatus(t, rec, http.StatusUnauthorized)
+	assertExpectations(t, mock)
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+)
+
+// migrationFiles holds the schema, one numbered SQL file per change. Files
+// run in name order and each runs once, so never edit one that has shipped;
+// add the next number instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the advisory lock key that keeps cold starts racing on
+// a fresh database from applying the same migration twice
+const migrationLockID = 7426001
+
+// runMigrations applies every embedded migration that schema_migrations does
+// not list yet. Each migration runs in its own transaction together with its
+// schema_migrations row, so a failed one leaves nothing half applied and is
+// retried on the next connection.
+func runMigrations(conn *sql.DB) error {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		script, err := migrationFiles.ReadFile(path.Join("migrations", name))
+		if err != nil {
+			return err
+		}
+		version := strings.TrimSuffix(name, ".sql")
+		if err = applyMigration(conn, version, string(script)); err != nil {
+			return fmt.Errorf("migration %s: %v", version, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs one migration unless it has already been applied
+func applyMigration(conn *sql.DB, version, script string) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLockID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version VARCHAR(255) PRIMARY KEY,
+			applied_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	var applied bool
+	if err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", version).Scan(&applied); err != nil {
+		return err
+	}
+	if applied {
+		return tx.Commit()
+	}
+
+	if _, err = tx.Exec(script); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	logEvent(context.Background(), slog.LevelInfo, "migration", "Applied migration "+version, slog.String("version", version))
+	return nil
+}
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"database/sql"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+// migrationVersions lists the embedded migrations in the order they run,
+// with their scripts
+func migrationVersions(t *testing.T) ([]string, map[string]string) {
+	t.Helper()
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		t.Fatalf("reading migrations: %v", err)
+	}
+	var versions []string
+	scripts := make(map[string]string)
+	for _, entry := range entries {
+		script, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			t.Fatalf("reading %s: %v", entry.Name(), err)
+		}
+		version := strings.TrimSuffix(entry.Name(), ".sql")
+		versions = append(versions, version)
+		scripts[version] = string(script)
+	}
+	sort.Strings(versions)
+	return versions, scripts
+}
+
+// expectMigrationCheck expects the start of applyMigration, reporting whether
+// version has already been applied
+func expectMigrationCheck(mock sqlmock.Sqlmock, version string, applied bool) {
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM schema_migrations").WithArgs(version).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(applied))
+}
+
+func TestRunMigrationsAppliesEachVersionOnce(t *testing.T) {
+	versions, scripts := migrationVersions(t)
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer conn.Close()
+
+	// An empty database gets every migration, in name order
+	for _, version := range versions {
+		expectMigrationCheck(mock, version, false)
+		mock.ExpectExec(regexp.QuoteMeta(scripts[version])).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(version).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	if err = runMigrations(conn); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+
+	// A migrated one gets none
+	for _, version := range versions {
+		expectMigrationCheck(mock, version, true)
+		mock.ExpectCommit()
+	}
+	if err = runMigrations(conn); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestFailedMigrationIsNotRecorded(t *testing.T) {
+	versions, scripts := migrationVersions(t)
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	defer conn.Close()
+
+	expectMigrationCheck(mock, versions[0], false)
+	mock.ExpectExec(regexp.QuoteMeta(scripts[versions[0]])).WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	err = runMigrations(conn)
+
+	if err == nil || !strings.Contains(err.Error(), versions[0]) {
+		t.Errorf("error = %v, want one naming migration %s", err, versions[0])
+	}
+	assertExpectations(t, mock)
+}
+
+func TestMigrationsCreateSchemaInEmptyDatabase(t *testing.T) {
+	conn := integrationDB(t)
+
+	// A schema of its own stands in for an empty database
+	schema := "migrate_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	if _, err := conn.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Exec("DROP SCHEMA " + schema + " CASCADE")
+	})
+	url := os.Getenv("TEST_DATABASE_URL")
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+	empty, err := sql.Open("postgres", url+separator+"search_path="+schema)
+	if err != nil {
+		t.Fatalf("opening schema: %v", err)
+	}
+	defer empty.Close()
+
+	for run := 1; run <= 2; run++ {
+		if err = runMigrations(empty); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+	}
+
+	for _, table := range []string{"schema_migrations", "dealers", "devices", "activation_codes", "lock_dates", "remote_locks", "audit_logs"} {
+		var exists bool
+		err = empty.QueryRow("SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = $1 AND table_name = $2)", schema, table).Scan(&exists)
+		if err != nil {
+			t.Fatalf("checking %s: %v", table, err)
+		}
+		if !exists {
+			t.Errorf("table %s was not created", table)
+		}
+	}
+	versions, _ := migrationVersions(t)
+	var applied int
+	if err = empty.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil {
+		t.Fatalf("counting migrations: %v", err)
+	}
+	if applied != len(versions) {
+		t.Errorf("schema_migrations has %d rows, want %d", applied, len(versions))
+	}
+}
@@ -0,0 +1,198 @@
+
+CREATE TABLE IF NOT EXISTS dealers (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(255) NOT NULL,
+    api_key_hash VARCHAR(64) UNIQUE NOT NULL,
+    code_length INTEGER CHECK (code_length BETWEEN 6 AND 32),
+    code_alphabet VARCHAR(36),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+
+CREATE TABLE IF NOT EXISTS devices (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    serial_number VARCHAR(255) NOT NULL,
+    customer_name VARCHAR(255) NOT NULL,
+    phone_number VARCHAR(50) NOT NULL,
+    emi_term INTEGER NOT NULL,
+    emi_start_date DATE NOT NULL,
+    term_duration INTEGER NOT NULL CHECK (term_duration IN (7, 15, 30)),
+    grace_days INTEGER NOT NULL DEFAULT 0 CHECK (grace_days BETWEEN 0 AND 15),
+    is_active BOOLEAN DEFAULT false,
+    is_locked BOOLEAN DEFAULT false,
+    dealer_id UUID REFERENCES dealers(id),
+    created_by VARCHAR(255) NOT NULL DEFAULT 'unknown',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    version INTEGER NOT NULL DEFAULT 1,
+    snooze_until TIMESTAMP WITH TIME ZONE,
+    relock_at TIMESTAMP WITH TIME ZONE,
+    retired_at TIMESTAMP WITH TIME ZONE,
+    deleted_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Per-dealer activation code format; NULL uses the server-wide default
+ALTER TABLE dealers ADD COLUMN IF NOT EXISTS code_length INTEGER CHECK (code_length BETWEEN 6 AND 32);
+ALTER TABLE dealers ADD COLUMN IF NOT EXISTS code_alphabet VARCHAR(36);
+
+
+-- Upgrade existing devices tables
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS grace_days INTEGER NOT NULL DEFAULT 0 CHECK (grace_days BETWEEN 0 AND 15);
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS dealer_id UUID REFERENCES dealers(id);
+-- Devices registered before created_by existed are backfilled as 'unknown'
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS created_by VARCHAR(255) NOT NULL DEFAULT 'unknown';
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS snooze_until TIMESTAMP WITH TIME ZONE;
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS relock_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS retired_at TIMESTAMP WITH TIME ZONE;
+-- Serial numbers only need to be unique among devices that are not deleted
+-- (see idx_devices_serial_number_normalized below)
+ALTER TABLE devices DROP CONSTRAINT IF EXISTS devices_serial_number_key;
+-- Serial numbers are stored trimmed and uppercase. If two undeleted devices
+-- differ only in case this fails; delete or rename one of them first.
+UPDATE devices SET serial_number = UPPER(TRIM(serial_number)) WHERE serial_number <> UPPER(TRIM(serial_number));
+DROP INDEX IF EXISTS idx_devices_serial_number_active;
+
+
+CREATE TABLE IF NOT EXISTS activation_codes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    code VARCHAR(50) UNIQUE NOT NULL,
+    term_number INTEGER NOT NULL,
+    is_used BOOLEAN DEFAULT false,
+    used_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(device_id, term_number)
+);
+
+
+-- Upgrade existing activation_codes tables
+ALTER TABLE activation_codes ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
+
+
+CREATE TABLE IF NOT EXISTS lock_dates (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    term_number INTEGER NOT NULL,
+    lock_date DATE NOT NULL,
+    is_locked BOOLEAN DEFAULT false,
+    paid_at TIMESTAMP WITH TIME ZONE,
+    extension_days INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(device_id, term_number)
+);
+
+
+-- Upgrade existing lock_dates tables: add term_number and backfill it from
+-- the lock date order of each device
+ALTER TABLE lock_dates ADD COLUMN IF NOT EXISTS term_number INTEGER;
+
+UPDATE lock_dates ld
+SET term_number = numbered.term_number
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY device_id ORDER BY lock_date, created_at) AS term_number
+    FROM lock_dates
+) numbered
+WHERE ld.id = numbered.id AND ld.term_number IS NULL;
+
+ALTER TABLE lock_dates ALTER COLUMN term_number SET NOT NULL;
+
+ALTER TABLE lock_dates ADD COLUMN IF NOT EXISTS paid_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE lock_dates ADD COLUMN IF NOT EXISTS extension_days INTEGER NOT NULL DEFAULT 0;
+
+
+CREATE TABLE IF NOT EXISTS remote_locks (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    device_id UUID NOT NULL UNIQUE REFERENCES devices(id) ON DELETE CASCADE,
+    is_locked BOOLEAN DEFAULT false,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    action VARCHAR(50) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    details TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+
+CREATE TABLE IF NOT EXISTS lock_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    is_locked BOOLEAN NOT NULL,
+    source VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+
+CREATE TABLE IF NOT EXISTS device_notes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    author VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL CHECK (char_length(body) <= 2000),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+
+-- Previous customers of devices that were reissued to someone else
+CREATE TABLE IF NOT EXISTS device_archives (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+    customer_name VARCHAR(255) NOT NULL,
+    phone_number VARCHAR(50) NOT NULL,
+    emi_term INTEGER NOT NULL,
+    emi_start_date DATE NOT NULL,
+    term_duration INTEGER NOT NULL,
+    grace_days INTEGER NOT NULL,
+    paid_terms INTEGER NOT NULL,
+    registered_at TIMESTAMP WITH TIME ZONE,
+    archived_by VARCHAR(255) NOT NULL,
+    archived_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key VARCHAR(255) PRIMARY KEY,
+    request_hash VARCHAR(64) NOT NULL,
+    device_id UUID REFERENCES devices(id) ON DELETE CASCADE,
+    response_status INTEGER NOT NULL,
+    response_body BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+
+CREATE INDEX IF NOT EXISTS idx_devices_serial_number ON devices(serial_number);
+CREATE INDEX IF NOT EXISTS idx_devices_dealer_id ON devices(dealer_id);
+CREATE INDEX IF NOT EXISTS idx_devices_phone_number ON devices(phone_number);
+CREATE INDEX IF NOT EXISTS idx_devices_created_at_id ON devices(created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_devices_relock_at ON devices(relock_at) WHERE relock_at IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_devices_serial_number_normalized ON devices(UPPER(TRIM(serial_number))) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_activation_codes_device_id ON activation_codes(device_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_activation_codes_code_unique ON activation_codes(code);
+CREATE INDEX IF NOT EXISTS idx_lock_dates_device_id ON lock_dates(device_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_lock_dates_device_term ON lock_dates(device_id, term_number);
+CREATE INDEX IF NOT EXISTS idx_remote_locks_device_id ON remote_locks(device_id);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_device_id_created_at ON audit_logs(device_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_lock_events_device_id_created_at ON lock_events(device_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_device_archives_device_id ON device_archives(device_id);
+CREATE INDEX IF NOT EXISTS idx_device_notes_device_id_created_at ON device_notes(device_id, created_at DESC);
+
+
+CREATE OR REPLACE FUNCTION update_updated_at_column()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = NOW();
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+
+DROP TRIGGER IF EXISTS update_remote_locks_updated_at ON remote_locks;
+CREATE TRIGGER update_remote_locks_updated_at BEFORE UPDATE ON remote_locks
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
@@ -0,0 +1,2 @@
+-- Set by POST /api/device/{serial}/force-lock and cleared by an explicit unlock
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS force_locked_at TIMESTAMP WITH TIME ZONE;
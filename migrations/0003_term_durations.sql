@@ -0,0 +1,4 @@
+-- term_duration is validated against ALLOWED_TERM_DURATIONS by the API, so
+-- the column only keeps it within the bounds of a custom term schedule
+ALTER TABLE devices DROP CONSTRAINT IF EXISTS devices_term_duration_check;
+ALTER TABLE devices ADD CONSTRAINT devices_term_duration_check CHECK (term_duration BETWEEN 1 AND 365);
@@ -0,0 +1,3 @@
+-- Set whenever the TV polls /api/check or /api/check-lock
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_devices_last_seen_at ON devices(last_seen_at);
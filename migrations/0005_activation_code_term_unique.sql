@@ -0,0 +1,14 @@
+-- One activation code per device and term. Tables created before the
+-- UNIQUE(device_id, term_number) table constraint may hold duplicates; keep
+-- the used code of a term if there is one, otherwise the newest.
+DELETE FROM activation_codes WHERE id IN (
+    SELECT id FROM (
+        SELECT id, ROW_NUMBER() OVER (
+            PARTITION BY device_id, term_number
+            ORDER BY is_used DESC NULLS LAST, created_at DESC NULLS LAST, id
+        ) AS position
+        FROM activation_codes
+    ) ranked
+    WHERE position > 1
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_activation_codes_device_term ON activation_codes(device_id, term_number);
@@ -0,0 +1,2 @@
+-- Amount due per term, optional; the outstanding balance is derived from it
+ALTER TABLE devices ADD COLUMN IF NOT EXISTS installment_amount NUMERIC(12, 2) CHECK (installment_amount > 0);
@@ -0,0 +1,3 @@
+-- Set when the device.lock_reminder webhook for a term is sent, so each term
+-- is reminded once
+ALTER TABLE lock_dates ADD COLUMN IF NOT EXISTS reminder_sent_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// maxNoteLength caps a support note, in characters
+const maxNoteLength = 2000
+
+type DeviceNote struct {
+	ID        string    `json:"id"`
+	Author    string    `json:"author"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AddNoteRequest struct {
+	Body string `json:"body"`
+}
+
+type DeviceNoteResponse struct {
+	Success bool       `json:"success"`
+	Note    DeviceNote `json:"note"`
+}
+
+type DeviceNotesResponse struct {
+	Success bool         `json:"success"`
+	Total   int          `json:"total"`
+	Notes   []DeviceNote `json:"notes"`
+}
+
+// addDeviceNote records a free-text note from a support agent against a
+// device. The author is the API key that sent it.
+func addDeviceNote(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	var req AddNoteRequest
+	if bodyErr := decodeJSONBody(r.Body, &req); bodyErr != nil {
+		writeBodyError(w, bodyErr)
+		return
+	}
+	body := strings.TrimSpace(req.Body)
+	if body == "" {
+		writeBodyError(w, missingFieldError("body"))
+		return
+	}
+	if utf8.RuneCountInString(body) > maxNoteLength {
+		writeError(w, http.StatusBadRequest, "invalid_note", fmt.Sprintf("body must be at most %d characters", maxNoteLength))
+		return
+	}
+
+	var deviceID string
+	err := db.QueryRowContext(ctx,
+		"SELECT id FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2)",
+		serialNumber, dealerArg(r),
+	).Scan(&deviceID)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	note := DeviceNote{
+		ID:        uuid.New().String(),
+		Author:    actorFromRequest(r),
+		Body:      body,
+		CreatedAt: time.Now(),
+	}
+	_, err = db.ExecContext(ctx,
+		"INSERT INTO device_notes (id, device_id, author, body, created_at) VALUES ($1, $2, $3, $4, $5)",
+		note.ID, deviceID, note.Author, note.Body, note.CreatedAt,
+	)
+	if err != nil {
+		errorf(ctx, "Error inserting note for device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "note_failed", "Failed to add note")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeviceNoteResponse{Success: true, Note: note})
+}
+
+// getDeviceNotes lists a device's support notes, newest first
+func getDeviceNotes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	var deviceID string
+	err := db.QueryRowContext(ctx,
+		"SELECT id FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2)",
+		serialNumber, dealerArg(r),
+	).Scan(&deviceID)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	rows, err := db.QueryContext(ctx,
+		"SELECT id, author, body, created_at FROM device_notes WHERE device_id = $1 ORDER BY created_at DESC, id DESC",
+		deviceID,
+	)
+	if err != nil {
+		errorf(ctx, "Error fetching notes for device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch notes")
+		return
+	}
+	defer rows.Close()
+
+	notes := make([]DeviceNote, 0)
+	for rows.Next() {
+		var note DeviceNote
+		if err := rows.Scan(&note.ID, &note.Author, &note.Body, &note.CreatedAt); err != nil {
+			errorf(ctx, "Error scanning note: %v", err)
+			continue
+		}
+		notes = append(notes, note)
+	}
+	if err = rows.Err(); err != nil {
+		errorf(ctx, "Error iterating notes: %v", err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch notes")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeviceNotesResponse{Success: true, Total: len(notes), Notes: notes})
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAddAndListNotesNewestFirst(t *testing.T) {
+	mock := mockDB(t)
+	type stored struct{ id, author, body, createdAt captured }
+	notes := make([]*stored, 2)
+	for i, text := range []string{"Customer disputes term 3", "Promised to pay Friday"} {
+		note := &stored{}
+		notes[i] = note
+		expectDeviceLookup(mock, "d1")
+		mock.ExpectExec("INSERT INTO device_notes").WithArgs(&note.id, "d1", &note.author, &note.body, &note.createdAt).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/notes", fmt.Sprintf(`{"body":"  %s  "}`, text)))
+
+		assertStatus(t, rec, http.StatusOK)
+		if note.body.value != text || note.author.value != apiKeyIdentifier(testAPIKey) {
+			t.Errorf("note stored as %v by %v, want %q by the API key", note.body.value, note.author.value, text)
+		}
+	}
+
+	// Serve the notes back as stored, newest first
+	expectDeviceLookup(mock, "d1")
+	rows := sqlmock.NewRows([]string{"id", "author", "body", "created_at"})
+	for i := len(notes) - 1; i >= 0; i-- {
+		rows.AddRow(notes[i].id.value, notes[i].author.value, notes[i].body.value, notes[i].createdAt.value)
+	}
+	mock.ExpectQuery("FROM device_notes WHERE device_id = \\$1 ORDER BY created_at DESC, id DESC").WithArgs("d1").WillReturnRows(rows)
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/device/TV100001/notes", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body DeviceNotesResponse
+	decodeResponse(t, rec, &body)
+	if body.Total != 2 || body.Notes[0].Body != "Promised to pay Friday" || body.Notes[1].Body != "Customer disputes term 3" {
+		t.Fatalf("notes = %+v, want the newest note first", body.Notes)
+	}
+	if body.Notes[0].CreatedAt.Before(body.Notes[1].CreatedAt) {
+		t.Errorf("newest note at %s is before %s", body.Notes[0].CreatedAt, body.Notes[1].CreatedAt)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestNoteLengthIsBounded(t *testing.T) {
+	mock := mockDB(t)
+	// Characters are counted, not bytes
+	longest := strings.Repeat("é", maxNoteLength)
+	expectDeviceLookup(mock, "d1")
+	mock.ExpectExec("INSERT INTO device_notes").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/notes", fmt.Sprintf(`{"body":%q}`, longest)))
+	assertStatus(t, rec, http.StatusOK)
+
+	rec = serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/notes", fmt.Sprintf(`{"body":%q}`, longest+"é")))
+	assertStatus(t, rec, http.StatusBadRequest)
+
+	rec = serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/notes", `{"body":"   "}`))
+	assertStatus(t, rec, http.StatusBadRequest)
+	assertExpectations(t, mock)
+}
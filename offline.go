@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// defaultOfflineDays is the silence, in days, after which an active device is
+// listed as offline
+const defaultOfflineDays = 14
+
+type OfflineDevice struct {
+	SerialNumber string     `json:"serial_number"`
+	CustomerName string     `json:"customer_name"`
+	PhoneNumber  string     `json:"phone_number"`
+	IsLocked     bool       `json:"is_locked"`
+	LastSeenAt   *time.Time `json:"last_seen_at"` // nil when the TV never polled
+}
+
+type OfflineDevicesResponse struct {
+	Success bool            `json:"success"`
+	Days    int             `json:"days"`
+	Total   int             `json:"total"`
+	Devices []OfflineDevice `json:"devices"`
+}
+
+// getOfflineDevices lists active devices that have not polled for more than
+// days, or never have, most stale first, so operators can look into TVs kept
+// unplugged or offline to dodge a lock
+func getOfflineDevices(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	days, ok := parseNonNegativeInt(r, "days", defaultOfflineDays)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_days", "days must be a non-negative integer")
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT serial_number, customer_name, phone_number, is_locked, last_seen_at
+		FROM devices
+		WHERE deleted_at IS NULL AND is_active = true AND ($1::uuid IS NULL OR dealer_id = $1)
+		  AND (last_seen_at IS NULL OR last_seen_at < $2)
+		ORDER BY last_seen_at NULLS FIRST, serial_number
+	`, dealerArg(r), time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		errorf(ctx, "Error fetching offline devices: %v", err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch offline devices")
+		return
+	}
+	defer rows.Close()
+
+	devices := make([]OfflineDevice, 0)
+	for rows.Next() {
+		var device OfflineDevice
+		if err := rows.Scan(&device.SerialNumber, &device.CustomerName, &device.PhoneNumber, &device.IsLocked, &device.LastSeenAt); err != nil {
+			errorf(ctx, "Error scanning offline device: %v", err)
+			continue
+		}
+		devices = append(devices, device)
+	}
+	if err = rows.Err(); err != nil {
+		errorf(ctx, "Error iterating offline devices: %v", err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch offline devices")
+		return
+	}
+
+	response := OfflineDevicesResponse{
+		Success: true,
+		Days:    days,
+		Total:   len(devices),
+		Devices: devices,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestOfflineDevicesCutoff(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		days  int
+	}{
+		{"default", "", defaultOfflineDays},
+		{"explicit", "?days=30", 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := mockDB(t)
+			cutoff := &captured{}
+			lastSeenAt := time.Now().AddDate(0, 0, -tt.days-1)
+			mock.ExpectQuery("ORDER BY last_seen_at NULLS FIRST").WithArgs(nil, cutoff).
+				WillReturnRows(sqlmock.NewRows([]string{"serial_number", "customer_name", "phone_number", "is_locked", "last_seen_at"}).
+					AddRow("TV100001", "Never Seen", "+15551234567", false, nil).
+					AddRow("TV100002", "Long Gone", "+15551234568", true, lastSeenAt))
+			at := time.Now()
+
+			rec := serve(apiRequest(t, http.MethodGet, "/api/devices/offline"+tt.query, ""))
+
+			assertStatus(t, rec, http.StatusOK)
+			var body OfflineDevicesResponse
+			decodeResponse(t, rec, &body)
+			if body.Days != tt.days || body.Total != 2 || body.Devices[0].LastSeenAt != nil || body.Devices[1].LastSeenAt == nil {
+				t.Errorf("response = %+v, want both devices over %d days", body, tt.days)
+			}
+			got, ok := cutoff.value.(time.Time)
+			if want := at.AddDate(0, 0, -tt.days); !ok || got.Sub(want).Abs() > time.Minute {
+				t.Errorf("cutoff = %v, want about %v", cutoff.value, want)
+			}
+			assertExpectations(t, mock)
+		})
+	}
+}
+
+func TestOfflineDevicesRejectsInvalidDays(t *testing.T) {
+	mock := mockDB(t)
+
+	for _, query := range []string{"?days=-1", "?days=two"} {
+		rec := serve(apiRequest(t, http.MethodGet, "/api/devices/offline"+query, ""))
+
+		assertStatus(t, rec, http.StatusBadRequest)
+	}
+	assertExpectations(t, mock)
+}
+
+// The threshold and ordering are applied in SQL, so they are checked against
+// seeded rows in a real database, under a dealer of its own
+func TestOfflineDevicesFilterSeededDevices(t *testing.T) {
+	conn := integrationDB(t)
+	dealerID, apiKey := integrationDealer(t, conn)
+	days := func(v int) *int { return &v }
+
+	seeds := []struct {
+		suffix       string
+		isActive     bool
+		lastSeenDays *int // days since the last poll, nil for never
+	}{
+		{"A", true, nil},
+		{"B", true, days(20)},
+		{"C", true, days(30)},
+		{"D", true, days(5)},
+		{"E", false, days(30)},
+	}
+	for _, seed := range seeds {
+		_, err := conn.Exec(`
+			INSERT INTO devices (serial_number, customer_name, phone_number, emi_term, emi_start_date, term_duration,
+			                     is_active, dealer_id, last_seen_at)
+			VALUES ($1, 'Offline Customer', '+15551234567', 1, CURRENT_DATE, 30, $2, $3,
+			        NOW() - make_interval(days => $4::integer))
+		`, "OF"+dealerID[:8]+seed.suffix, seed.isActive, dealerID, seed.lastSeenDays)
+		if err != nil {
+			t.Fatalf("seeding device %s: %v", seed.suffix, err)
+		}
+	}
+
+	r := apiRequest(t, http.MethodGet, "/api/devices/offline?days=14", "")
+	r.Header.Set("X-API-Key", apiKey)
+	rec := serve(r)
+
+	assertStatus(t, rec, http.StatusOK)
+	var body OfflineDevicesResponse
+	decodeResponse(t, rec, &body)
+	var serials []string
+	for _, device := range body.Devices {
+		serials = append(serials, device.SerialNumber)
+	}
+	prefix := "OF" + dealerID[:8]
+	want := []string{prefix + "A", prefix + "C", prefix + "B"}
+	if !slices.Equal(serials, want) {
+		t.Errorf("offline devices = %v, want never seen, then 30 and 20 days silent: %v", serials, want)
+	}
+}
@@ -0,0 +1,19 @@
+package handler
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec is the OpenAPI 3.0 description of every route registered in
+// serveAPI. It is maintained by hand, so add a route's path here whenever
+// one is added to the router.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// getOpenAPISpec serves the embedded OpenAPI document
+func getOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
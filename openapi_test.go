@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestOpenAPISpecCoversEveryRoute(t *testing.T) {
+	// Include the development-only routes
+	t.Setenv("ENV", "development")
+	t.Setenv("VERCEL_ENV", "")
+
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("openapi = %q, want a 3.x document", spec.OpenAPI)
+	}
+
+	err := newRouter().Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		operations, ok := spec.Paths[path]
+		if !ok {
+			t.Errorf("route %s is missing from openapi.json paths", path)
+			return nil
+		}
+		for _, method := range methods {
+			if _, ok := operations[strings.ToLower(method)]; !ok && method != http.MethodOptions {
+				t.Errorf("openapi.json has no %s operation for %s", method, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walking routes: %v", err)
+	}
+}
@@ -0,0 +1,576 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type PaymentRequest struct {
+	SerialNumber string `json:"serial_number"`
+	TermNumber   int    `json:"term_number"`
+}
+
+type PaymentResponse struct {
+	Success        bool               `json:"success"`
+	Message        string             `json:"message"`
+	Unlocked       bool               `json:"unlocked"`
+	RemainingTerms []TermWithLockDate `json:"remaining_terms"`
+}
+
+type PartialPaymentRequest struct {
+	TermNumber    int `json:"term_number"`
+	DaysExtension int `json:"days_extension"`
+}
+
+type PartialPaymentResponse struct {
+	Success            bool   `json:"success"`
+	Message            string `json:"message"`
+	TermNumber         int    `json:"term_number"`
+	LockDate           string `json:"lock_date"`
+	TotalExtensionDays int    `json:"total_extension_days"`
+}
+
+const defaultMaxTermExtensionDays = 30
+
+// maxTermExtensionDays reads MAX_TERM_EXTENSION_DAYS, the cap on the days
+// partial payments may add to a single term's lock date
+func maxTermExtensionDays() int {
+	raw := os.Getenv("MAX_TERM_EXTENSION_DAYS")
+	if raw == "" {
+		return defaultMaxTermExtensionDays
+	}
+	days, err := strconv.Atoi(raw)
+	if err != nil || days < 0 {
+		warnf(context.Background(), "Invalid MAX_TERM_EXTENSION_DAYS %q, using %d", raw, defaultMaxTermExtensionDays)
+		return defaultMaxTermExtensionDays
+	}
+	return days
+}
+
+type RewindRequest struct {
+	TermNumber int `json:"term_number"`
+}
+
+type RewindResponse struct {
+	Success bool                      `json:"success"`
+	Message string                    `json:"message"`
+	Terms   []TermWithLockDateAndCode `json:"terms"`
+}
+
+type SettleResponse struct {
+	Success      bool   `json:"success"`
+	Message      string `json:"message"`
+	TermsCleared int    `json:"terms_cleared"`
+	Unlocked     bool   `json:"unlocked"`
+}
+
+// fetchUnpaidTerms returns the terms of a device that have not been paid yet
+func fetchUnpaidTerms(ctx context.Context, deviceID string) ([]TermWithLockDate, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT term_number, lock_date FROM lock_dates WHERE device_id = $1 AND paid_at IS NULL ORDER BY term_number",
+		deviceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	terms := make([]TermWithLockDate, 0)
+	for rows.Next() {
+		var termNumber int
+		var lockDate time.Time
+		if err := rows.Scan(&termNumber, &lockDate); err != nil {
+			return nil, err
+		}
+		terms = append(terms, TermWithLockDate{
+			Term:     termNumber,
+			LockDate: lockDate.Format("2006-01-02"),
+		})
+	}
+	return terms, rows.Err()
+}
+
+func recordPayment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req PaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	req.SerialNumber = normalizeSerialNumber(req.SerialNumber)
+
+	// Find device
+	var deviceID string
+	err := db.QueryRowContext(ctx,
+		"SELECT id FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2)",
+		req.SerialNumber, dealerArg(r),
+	).Scan(&deviceID)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "payment_failed", "Failed to record payment")
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the term row so concurrent payments for the same term serialize
+	var termLocked bool
+	var paidAt *time.Time
+	err = tx.QueryRowContext(ctx,
+		"SELECT is_locked, paid_at FROM lock_dates WHERE device_id = $1 AND term_number = $2 FOR UPDATE",
+		deviceID, req.TermNumber,
+	).Scan(&termLocked, &paidAt)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "term_not_found", "Term not found for this device")
+		return
+	}
+	if err != nil {
+		errorf(ctx, "Error fetching term: %v", err)
+		writeDBError(w, r, err, "payment_failed", "Failed to record payment")
+		return
+	}
+	if paidAt != nil {
+		writeError(w, http.StatusConflict, "term_already_paid", "This term has already been paid")
+		return
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx,
+		"UPDATE lock_dates SET paid_at = $1 WHERE device_id = $2 AND term_number = $3",
+		now, deviceID, req.TermNumber,
+	)
+	if err != nil {
+		errorf(ctx, "Error marking term paid: %v", err)
+		writeDBError(w, r, err, "payment_failed", "Failed to record payment")
+		return
+	}
+
+	// Unlock the device if this term was the only enforced, unpaid lock.
+	// Manual remote locks (no enforced term) and force locks are left in
+	// place.
+	unlocked := false
+	if termLocked {
+		var outstanding int
+		var forceLocked bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT
+				(SELECT COUNT(*) FROM lock_dates WHERE device_id = $1 AND is_locked = true AND paid_at IS NULL),
+				(SELECT force_locked_at IS NOT NULL FROM devices WHERE id = $1)
+		`, deviceID).Scan(&outstanding, &forceLocked)
+		if err != nil {
+			errorf(ctx, "Error counting outstanding locks: %v", err)
+			writeDBError(w, r, err, "payment_failed", "Failed to record payment")
+			return
+		}
+		if outstanding == 0 && !forceLocked {
+			if _, err = tx.ExecContext(ctx, "UPDATE devices SET is_locked = false WHERE id = $1", deviceID); err != nil {
+				errorf(ctx, "Error unlocking device: %v", err)
+				writeDBError(w, r, err, "payment_failed", "Failed to record payment")
+				return
+			}
+			_, err = tx.ExecContext(ctx,
+				"UPDATE remote_locks SET is_locked = false, updated_at = $1 WHERE device_id = $2",
+				now, deviceID,
+			)
+			if err != nil {
+				errorf(ctx, "Error updating remote lock: %v", err)
+				writeDBError(w, r, err, "payment_failed", "Failed to record payment")
+				return
+			}
+			unlocked = true
+		}
+	}
+
+	details := fmt.Sprintf("Term %d paid", req.TermNumber)
+	if unlocked {
+		details += ", device unlocked"
+		if err = appendLockEvent(ctx, tx, deviceID, false, lockSourcePayment); err != nil {
+			errorf(ctx, "Error writing lock event: %v", err)
+			writeDBError(w, r, err, "payment_failed", "Failed to record payment")
+			return
+		}
+	}
+	if err = appendAudit(ctx, tx, deviceID, "payment", actorFromRequest(r), details); err != nil {
+		errorf(ctx, "Error writing audit log: %v", err)
+		writeDBError(w, r, err, "payment_failed", "Failed to record payment")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing payment: %v", err)
+		writeDBError(w, r, err, "payment_failed", "Failed to record payment")
+		return
+	}
+
+	if unlocked {
+		unlocksTotal.Add(1)
+		dispatchWebhook(webhookEventUnlocked, deviceID, req.SerialNumber, map[string]interface{}{
+			"source":      "payment",
+			"term_number": req.TermNumber,
+		})
+	}
+
+	remainingTerms, err := fetchUnpaidTerms(ctx, deviceID)
+	if err != nil {
+		errorf(ctx, "Error fetching unpaid terms for device %s: %v", deviceID, err)
+		remainingTerms = make([]TermWithLockDate, 0)
+	}
+
+	response := PaymentResponse{
+		Success:        true,
+		Message:        fmt.Sprintf("Payment recorded for term %d", req.TermNumber),
+		Unlocked:       unlocked,
+		RemainingTerms: remainingTerms,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// settleDevice closes out a fully repaid loan: every unpaid term is marked
+// paid and the device is unlocked and deactivated in one transaction. A
+// pending snooze or relock is cleared with it so nothing locks it again.
+func settleDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "settle_failed", "Failed to settle device")
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the device row so a concurrent payment or lock waits for the settlement
+	var deviceID string
+	var wasLocked bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, is_locked FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2) FOR UPDATE",
+		serialNumber, dealerArg(r),
+	).Scan(&deviceID, &wasLocked)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	result, err := tx.ExecContext(ctx,
+		"UPDATE lock_dates SET paid_at = $1 WHERE device_id = $2 AND paid_at IS NULL",
+		now, deviceID,
+	)
+	if err != nil {
+		errorf(ctx, "Error marking terms paid: %v", err)
+		writeDBError(w, r, err, "settle_failed", "Failed to settle device")
+		return
+	}
+	cleared, err := result.RowsAffected()
+	if err != nil {
+		errorf(ctx, "Error counting settled terms: %v", err)
+		writeDBError(w, r, err, "settle_failed", "Failed to settle device")
+		return
+	}
+	if cleared == 0 {
+		writeError(w, http.StatusConflict, "already_settled", "Every term of this device is already paid")
+		return
+	}
+
+	if _, err = tx.ExecContext(ctx, "UPDATE devices SET is_locked = false, is_active = false, snooze_until = NULL, relock_at = NULL, force_locked_at = NULL WHERE id = $1", deviceID); err != nil {
+		errorf(ctx, "Error unlocking device: %v", err)
+		writeDBError(w, r, err, "settle_failed", "Failed to settle device")
+		return
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE remote_locks SET is_locked = false, updated_at = $1 WHERE device_id = $2",
+		now, deviceID,
+	)
+	if err != nil {
+		errorf(ctx, "Error updating remote lock: %v", err)
+		writeDBError(w, r, err, "settle_failed", "Failed to settle device")
+		return
+	}
+
+	if wasLocked {
+		if err = appendLockEvent(ctx, tx, deviceID, false, lockSourceSettle); err != nil {
+			errorf(ctx, "Error writing lock event: %v", err)
+			writeDBError(w, r, err, "settle_failed", "Failed to settle device")
+			return
+		}
+	}
+
+	details := fmt.Sprintf("Settled %d unpaid term(s), device unlocked and deactivated", cleared)
+	if err = appendAudit(ctx, tx, deviceID, "settled", actorFromRequest(r), details); err != nil {
+		errorf(ctx, "Error writing audit log: %v", err)
+		writeDBError(w, r, err, "settle_failed", "Failed to settle device")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing settlement: %v", err)
+		writeDBError(w, r, err, "settle_failed", "Failed to settle device")
+		return
+	}
+
+	if wasLocked {
+		unlocksTotal.Add(1)
+		dispatchWebhook(webhookEventUnlocked, deviceID, serialNumber, map[string]interface{}{"source": "settle"})
+	}
+
+	response := SettleResponse{
+		Success:      true,
+		Message:      "Device settled successfully",
+		TermsCleared: int(cleared),
+		Unlocked:     wasLocked,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// recordPartialPayment pushes one unpaid term's lock date forward in
+// proportion to a partial payment, up to maxTermExtensionDays in total. The
+// term gets a fresh lock reminder ahead of its new date.
+func recordPartialPayment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	var req PartialPaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.DaysExtension < 1 {
+		writeError(w, http.StatusBadRequest, "invalid_days_extension", "days_extension must be a positive number of days")
+		return
+	}
+
+	// Find device
+	var deviceID string
+	err := db.QueryRowContext(ctx,
+		"SELECT id FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2)",
+		serialNumber, dealerArg(r),
+	).Scan(&deviceID)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "partial_payment_failed", "Failed to record partial payment")
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the term row so concurrent extensions cannot both pass the cap
+	var termLocked bool
+	var paidAt *time.Time
+	var extensionDays int
+	err = tx.QueryRowContext(ctx,
+		"SELECT is_locked, paid_at, extension_days FROM lock_dates WHERE device_id = $1 AND term_number = $2 FOR UPDATE",
+		deviceID, req.TermNumber,
+	).Scan(&termLocked, &paidAt, &extensionDays)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "term_not_found", "Term not found for this device")
+		return
+	}
+	if err != nil {
+		errorf(ctx, "Error fetching term: %v", err)
+		writeDBError(w, r, err, "partial_payment_failed", "Failed to record partial payment")
+		return
+	}
+	if paidAt != nil {
+		writeError(w, http.StatusConflict, "term_already_paid", "This term has already been paid")
+		return
+	}
+	if termLocked {
+		writeError(w, http.StatusConflict, "term_already_locked", "This term has already locked the device; record a full payment instead")
+		return
+	}
+	maxDays := maxTermExtensionDays()
+	if extensionDays+req.DaysExtension > maxDays {
+		writeErrorWithDetails(w, http.StatusConflict, "extension_limit_exceeded",
+			fmt.Sprintf("A term's lock date can be extended by at most %d days in total", maxDays),
+			map[string]interface{}{"max_extension_days": maxDays, "current_extension_days": extensionDays})
+		return
+	}
+
+	var lockDate time.Time
+	err = tx.QueryRowContext(ctx, `
+		UPDATE lock_dates
+		SET lock_date = lock_date + $1::integer, extension_days = extension_days + $1::integer, reminder_sent_at = NULL
+		WHERE device_id = $2 AND term_number = $3
+		RETURNING lock_date, extension_days
+	`, req.DaysExtension, deviceID, req.TermNumber).Scan(&lockDate, &extensionDays)
+	if err != nil {
+		errorf(ctx, "Error extending lock date: %v", err)
+		writeDBError(w, r, err, "partial_payment_failed", "Failed to record partial payment")
+		return
+	}
+
+	details := fmt.Sprintf("Term %d lock date extended by %d day(s) to %s", req.TermNumber, req.DaysExtension, lockDate.Format("2006-01-02"))
+	if err = appendAudit(ctx, tx, deviceID, "partial_payment", actorFromRequest(r), details); err != nil {
+		errorf(ctx, "Error writing audit log: %v", err)
+		writeDBError(w, r, err, "partial_payment_failed", "Failed to record partial payment")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing partial payment: %v", err)
+		writeDBError(w, r, err, "partial_payment_failed", "Failed to record partial payment")
+		return
+	}
+
+	response := PartialPaymentResponse{
+		Success:            true,
+		Message:            fmt.Sprintf("Lock date for term %d extended by %d day(s)", req.TermNumber, req.DaysExtension),
+		TermNumber:         req.TermNumber,
+		LockDate:           lockDate.Format("2006-01-02"),
+		TotalExtensionDays: extensionDays,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// rewindDevice undoes everything recorded from a term onward, for when a
+// payment was misapplied: those terms become unpaid, their activation codes
+// are replaced with fresh unused ones, and partial-payment extensions are
+// removed so each lock date returns to the original schedule
+func rewindDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	var req RewindRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if req.TermNumber < 1 {
+		writeError(w, http.StatusBadRequest, "invalid_term_number", "term_number must be 1 or greater")
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "rewind_failed", "Failed to rewind device")
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the device row so payments and activations wait for the rewind
+	var deviceID string
+	var emiTerm int
+	var emiStartDate time.Time
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, emi_term, emi_start_date FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2) FOR UPDATE",
+		serialNumber, dealerArg(r),
+	).Scan(&deviceID, &emiTerm, &emiStartDate)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+	if req.TermNumber > emiTerm {
+		writeError(w, http.StatusNotFound, "term_not_found", "Term not found for this device")
+		return
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE lock_dates
+		SET paid_at = NULL, is_locked = false, lock_date = lock_date - extension_days, extension_days = 0, reminder_sent_at = NULL
+		WHERE device_id = $1 AND term_number >= $2
+		RETURNING term_number, lock_date
+	`, deviceID, req.TermNumber)
+	if err != nil {
+		errorf(ctx, "Error resetting lock dates: %v", err)
+		writeDBError(w, r, err, "rewind_failed", "Failed to rewind device")
+		return
+	}
+	terms := make([]TermWithLockDateAndCode, 0)
+	for rows.Next() {
+		var termNumber int
+		var lockDate time.Time
+		if err := rows.Scan(&termNumber, &lockDate); err != nil {
+			rows.Close()
+			errorf(ctx, "Error scanning lock date: %v", err)
+			writeDBError(w, r, err, "rewind_failed", "Failed to rewind device")
+			return
+		}
+		terms = append(terms, TermWithLockDateAndCode{
+			Term:     termNumber,
+			LockDate: lockDate.Format("2006-01-02"),
+		})
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		errorf(ctx, "Error resetting lock dates: %v", err)
+		writeDBError(w, r, err, "rewind_failed", "Failed to rewind device")
+		return
+	}
+	sort.Slice(terms, func(i, j int) bool { return terms[i].Term < terms[j].Term })
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM activation_codes WHERE device_id = $1 AND term_number >= $2", deviceID, req.TermNumber)
+	if err != nil {
+		errorf(ctx, "Error deleting activation codes: %v", err)
+		writeDBError(w, r, err, "rewind_failed", "Failed to rewind device")
+		return
+	}
+	format, err := deviceCodeFormat(ctx, tx, deviceID)
+	if err != nil {
+		errorf(ctx, "Error fetching code format for device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "rewind_failed", "Failed to rewind device")
+		return
+	}
+	expiresAt := activationCodeExpiry(emiStartDate)
+	for i := range terms {
+		code, err := insertActivationCode(ctx, tx, deviceID, terms[i].Term, format, expiresAt)
+		if err != nil {
+			errorf(ctx, "Error inserting activation code: %v", err)
+			writeAPIError(w, r, err, "rewind_failed", "Failed to rewind device")
+			return
+		}
+		terms[i].ActivationCode = code
+	}
+
+	details := fmt.Sprintf("Rewound to term %d: %d term(s) reset", req.TermNumber, len(terms))
+	if err = appendAudit(ctx, tx, deviceID, "rewind", actorFromRequest(r), details); err != nil {
+		errorf(ctx, "Error writing audit log: %v", err)
+		writeDBError(w, r, err, "rewind_failed", "Failed to rewind device")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing rewind: %v", err)
+		writeDBError(w, r, err, "rewind_failed", "Failed to rewind device")
+		return
+	}
+
+	response := RewindResponse{
+		Success: true,
+		Message: fmt.Sprintf("Device rewound to term %d", req.TermNumber),
+		Terms:   terms,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPayTermUnlocksDevice(t *testing.T) {
+	mock := mockDB(t)
+	lockDate := time.Now().UTC().AddDate(0, 1, 0)
+
+	expectDeviceLookup(mock, "d1")
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT is_locked, paid_at FROM lock_dates").WithArgs("d1", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"is_locked", "paid_at"}).AddRow(true, nil))
+	mock.ExpectExec("UPDATE lock_dates SET paid_at").WithArgs(sqlmock.AnyArg(), "d1", 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Term 2 was the only enforced, unpaid term
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM lock_dates WHERE device_id = \\$1 AND is_locked = true").
+		WillReturnRows(sqlmock.NewRows([]string{"outstanding", "force_locked"}).AddRow(0, false))
+	mock.ExpectExec("UPDATE devices SET is_locked = false").WithArgs("d1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE remote_locks SET is_locked = false").WithArgs(sqlmock.AnyArg(), "d1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO lock_events").WithArgs(sqlmock.AnyArg(), "d1", false, lockSourcePayment, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_logs").WithArgs(sqlmock.AnyArg(), "d1", "payment", sqlmock.AnyArg(), "Term 2 paid, device unlocked", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT term_number, lock_date FROM lock_dates WHERE device_id = \\$1 AND paid_at IS NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"term_number", "lock_date"}).AddRow(3, lockDate))
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/payment", `{"serial_number":"TV100001","term_number":2}`))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body PaymentResponse
+	decodeResponse(t, rec, &body)
+	if !body.Unlocked {
+		t.Error("unlocked = false, want true after paying the overdue term")
+	}
+	if len(body.RemainingTerms) != 1 || body.RemainingTerms[0].Term != 3 {
+		t.Errorf("remaining_terms = %+v, want term 3", body.RemainingTerms)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestPayTermAlreadyPaid(t *testing.T) {
+	mock := mockDB(t)
+
+	expectDeviceLookup(mock, "d1")
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT is_locked, paid_at FROM lock_dates").
+		WillReturnRows(sqlmock.NewRows([]string{"is_locked", "paid_at"}).AddRow(false, time.Now()))
+	mock.ExpectRollback()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/payment", `{"serial_number":"TV100001","term_number":2}`))
+
+	assertStatus(t, rec, http.StatusConflict)
+	assertExpectations(t, mock)
+}
+
+func TestSettleHalfPaidDevice(t *testing.T) {
+	mock := mockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, is_locked FROM devices").WithArgs("TV100001", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "is_locked"}).AddRow("d1", true))
+	// Three of the six terms were already paid
+	mock.ExpectExec("UPDATE lock_dates SET paid_at = \\$1 WHERE device_id = \\$2 AND paid_at IS NULL").WithArgs(sqlmock.AnyArg(), "d1").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("UPDATE devices SET is_locked = false, is_active = false, snooze_until = NULL, relock_at = NULL").WithArgs("d1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE remote_locks SET is_locked = false").WithArgs(sqlmock.AnyArg(), "d1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO lock_events").WithArgs(sqlmock.AnyArg(), "d1", false, lockSourceSettle, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_logs").WithArgs(sqlmock.AnyArg(), "d1", "settled", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/settle", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body SettleResponse
+	decodeResponse(t, rec, &body)
+	if body.TermsCleared != 3 || !body.Unlocked {
+		t.Errorf("response = %+v, want 3 terms cleared and the device unlocked", body)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestSettleAlreadySettledDevice(t *testing.T) {
+	mock := mockDB(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, is_locked FROM devices").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "is_locked"}).AddRow("d1", false))
+	mock.ExpectExec("UPDATE lock_dates SET paid_at").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/settle", ""))
+
+	assertStatus(t, rec, http.StatusConflict)
+	var body ErrorResponse
+	decodeResponse(t, rec, &body)
+	if body.Error.Code != "already_settled" {
+		t.Errorf("error code = %q, want already_settled", body.Error.Code)
+	}
+	assertExpectations(t, mock)
+}
+
+// expectExtensionCheck expects a partial payment to find term 2 of d1 unpaid
+// and unlocked, already extended by extensionDays
+func expectExtensionCheck(mock sqlmock.Sqlmock, extensionDays int) {
+	expectDeviceLookup(mock, "d1")
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT is_locked, paid_at, extension_days FROM lock_dates").WithArgs("d1", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"is_locked", "paid_at", "extension_days"}).AddRow(false, nil, extensionDays))
+}
+
+func TestPartialPaymentShiftsLockDate(t *testing.T) {
+	mock := mockDB(t)
+	lockDate := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	expectExtensionCheck(mock, 5)
+	mock.ExpectQuery("UPDATE lock_dates\\s+SET lock_date = lock_date \\+ \\$1::integer").WithArgs(10, "d1", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"lock_date", "extension_days"}).AddRow(lockDate.AddDate(0, 0, 10), 15))
+	mock.ExpectExec("INSERT INTO audit_logs").WithArgs(sqlmock.AnyArg(), "d1", "partial_payment", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/partial-payment", `{"term_number":2,"days_extension":10}`))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body PartialPaymentResponse
+	decodeResponse(t, rec, &body)
+	if body.LockDate != "2026-03-11" || body.TotalExtensionDays != 15 {
+		t.Errorf("response = %+v, want lock date 2026-03-11 after 15 days of extensions", body)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestPartialPaymentExtensionCap(t *testing.T) {
+	t.Setenv("MAX_TERM_EXTENSION_DAYS", "20")
+	mock := mockDB(t)
+	expectExtensionCheck(mock, 15)
+	mock.ExpectRollback()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/partial-payment", `{"term_number":2,"days_extension":6}`))
+
+	assertStatus(t, rec, http.StatusConflict)
+	var body ErrorResponse
+	decodeResponse(t, rec, &body)
+	if body.Error.Code != "extension_limit_exceeded" {
+		t.Errorf("error code = %q, want extension_limit_exceeded", body.Error.Code)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestRewindFromTermFourToTwo(t *testing.T) {
+	mock := mockDB(t)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, emi_term, emi_start_date FROM devices").WithArgs("TV100001", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "emi_term", "emi_start_date"}).AddRow("d1", 6, start))
+	// Terms 1-3 were paid; 2 onward go back to unpaid on their original dates
+	mock.ExpectQuery("SET paid_at = NULL, is_locked = false, lock_date = lock_date - extension_days").WithArgs("d1", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"term_number", "lock_date"}).
+			AddRow(4, start.AddDate(0, 0, 120)).
+			AddRow(2, start.AddDate(0, 0, 60)).
+			AddRow(3, start.AddDate(0, 0, 90)).
+			AddRow(5, start.AddDate(0, 0, 150)).
+			AddRow(6, start.AddDate(0, 0, 180)))
+	mock.ExpectExec("DELETE FROM activation_codes WHERE device_id = \\$1 AND term_number >= \\$2").WithArgs("d1", 2).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectQuery("SELECT dl.code_alphabet, dl.code_length").
+		WillReturnRows(sqlmock.NewRows([]string{"code_alphabet", "code_length"}).AddRow(nil, nil))
+	for term := 2; term <= 6; term++ {
+		mock.ExpectExec("SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO activation_codes").WithArgs(sqlmock.AnyArg(), "d1", sqlmock.AnyArg(), term, false, nil, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("RELEASE SAVEPOINT activation_code").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec("INSERT INTO audit_logs").WithArgs(sqlmock.AnyArg(), "d1", "rewind", sqlmock.AnyArg(), "Rewound to term 2: 5 term(s) reset", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/rewind", `{"term_number":2}`))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body RewindResponse
+	decodeResponse(t, rec, &body)
+	if len(body.Terms) != 5 {
+		t.Fatalf("terms = %+v, want terms 2-6", body.Terms)
+	}
+	for i, term := range body.Terms {
+		want := start.AddDate(0, 0, 30*(i+2)).Format("2006-01-02")
+		if term.Term != i+2 || term.LockDate != want || term.ActivationCode == "" || term.IsPaid {
+			t.Errorf("term %d = %+v, want unpaid term %d with a new code, locking %s", i, term, i+2, want)
+		}
+	}
+	assertExpectations(t, mock)
+}
+
+func TestRewindRejectsTermBeforeFirst(t *testing.T) {
+	mock := mockDB(t)
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/rewind", `{"term_number":0}`))
+
+	assertStatus(t, rec, http.StatusBadRequest)
+	assertExpectations(t, mock)
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter decides whether a request identified by key may proceed. When
+// it may not, it returns how long the caller should wait before retrying.
+type RateLimiter interface {
+	Allow(key string) (bool, time.Duration)
+}
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// tokenBucketLimiter is an in-memory RateLimiter that allows capacity
+// requests per window for each key, refilling continuously. State is per
+// function instance, so limits are approximate across serverless instances.
+type tokenBucketLimiter struct {
+	mu         sync.Mutex
+	capacity   float64
+	refillRate float64 // tokens per second
+	buckets    map[string]*tokenBucket
+	lastPrune  time.Time
+}
+
+func newTokenBucketLimiter(capacity int, window time.Duration) *tokenBucketLimiter {
+	return &tokenBucketLimiter{
+		capacity:   float64(capacity),
+		refillRate: float64(capacity) / window.Seconds(),
+		buckets:    make(map[string]*tokenBucket),
+		lastPrune:  time.Now(),
+	}
+}
+
+func (l *tokenBucketLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.capacity, lastSeen: now}
+		l.buckets[key] = bucket
+	}
+
+	elapsed := now.Sub(bucket.lastSeen).Seconds()
+	bucket.tokens = math.Min(l.capacity, bucket.tokens+elapsed*l.refillRate)
+	bucket.lastSeen = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / l.refillRate * float64(time.Second))
+		return false, wait
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// prune drops buckets that have refilled completely so the map does not
+// grow without bound
+func (l *tokenBucketLimiter) prune(now time.Time) {
+	fullAfter := time.Duration(l.capacity / l.refillRate * float64(time.Second))
+	if now.Sub(l.lastPrune) < fullAfter {
+		return
+	}
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) >= fullAfter {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
+
+// activationLimiter throttles activation attempts to slow down brute-forcing
+// of activation codes
+var activationLimiter RateLimiter = newTokenBucketLimiter(10, time.Minute)
+
+// clientIP returns the originating client address, preferring the first
+// X-Forwarded-For entry set by the platform proxy
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		if ip := strings.TrimSpace(strings.Split(forwarded, ",")[0]); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitMiddleware rejects requests with 429 once the client IP has
+// exhausted its allowance on limiter
+func rateLimitMiddleware(limiter RateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, retryAfter := limiter.Allow(clientIP(r))
+		if !allowed {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			if seconds < 1 {
+				seconds = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			writeError(w, http.StatusTooManyRequests, "rate_limited", "Too many requests, please try again later")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestActivationRateLimit(t *testing.T) {
+	mockDB(t)
+	previous := activationLimiter
+	activationLimiter = newTokenBucketLimiter(10, time.Minute)
+	t.Cleanup(func() { activationLimiter = previous })
+
+	for i := 1; i <= 11; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/api/activate", nil)
+		r.RemoteAddr = "203.0.113.7:5000"
+		rec := serve(r)
+
+		if i <= 10 {
+			if rec.Code == http.StatusTooManyRequests {
+				t.Fatalf("request %d was rate limited, want the first 10 allowed", i)
+			}
+			continue
+		}
+		assertStatus(t, rec, http.StatusTooManyRequests)
+		if rec.Header().Get("Retry-After") == "" {
+			t.Error("429 response has no Retry-After header")
+		}
+	}
+
+	// Another client still has its own allowance
+	r := httptest.NewRequest(http.MethodPost, "/api/activate", nil)
+	r.RemoteAddr = "198.51.100.9:5000"
+	if rec := serve(r); rec.Code == http.StatusTooManyRequests {
+		t.Error("a different client was rate limited")
+	}
+}
+
+// denyAll is a RateLimiter that rejects every request
+type denyAll struct{ keys []string }
+
+func (d *denyAll) Allow(key string) (bool, time.Duration) {
+	d.keys = append(d.keys, key)
+	return false, 1500 * time.Millisecond
+}
+
+func TestRateLimitMiddlewareUsesLimiter(t *testing.T) {
+	limiter := &denyAll{}
+	handler := rateLimitMiddleware(limiter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request passed a limiter that denies everything")
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/activate", nil)
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+
+	assertStatus(t, rec, http.StatusTooManyRequests)
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+	if len(limiter.keys) != 1 || limiter.keys[0] != "203.0.113.7" {
+		t.Errorf("limiter keys = %v, want the forwarded client IP", limiter.keys)
+	}
+}
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// ReissueDeviceRequest is a registration without the serial number, which
+// comes from the path
+type ReissueDeviceRequest struct {
+	CustomerName      string   `json:"customer_name"`
+	PhoneNumber       string   `json:"phone_number"`
+	EMITerm           int      `json:"emi_term"`
+	EMIStartDate      string   `json:"emi_start_date"`
+	TermDuration      int      `json:"term_duration"`
+	GraceDays         int      `json:"grace_days"`
+	TermDurations     []int    `json:"term_durations,omitempty"`
+	InstallmentAmount *float64 `json:"installment_amount,omitempty"`
+}
+
+type ReissueDeviceResponse struct {
+	Success bool                      `json:"success"`
+	Message string                    `json:"message"`
+	Device  Device                    `json:"device"`
+	Terms   []TermWithLockDateAndCode `json:"terms"`
+}
+
+// reissueDevice hands a repossessed device to a new customer. The previous
+// customer and plan are copied to device_archives, every activation code and
+// lock date is deleted, and a fresh schedule is generated on the same device
+// record, all in one transaction. The audit log and lock history are kept.
+func reissueDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	var body ReissueDeviceRequest
+	if bodyErr := decodeJSONBody(r.Body, &body); bodyErr != nil {
+		writeBodyError(w, bodyErr)
+		return
+	}
+	req := RegisterDeviceRequest{
+		SerialNumber:      serialNumber,
+		CustomerName:      body.CustomerName,
+		PhoneNumber:       body.PhoneNumber,
+		EMITerm:           body.EMITerm,
+		EMIStartDate:      body.EMIStartDate,
+		TermDuration:      body.TermDuration,
+		GraceDays:         body.GraceDays,
+		TermDurations:     body.TermDurations,
+		InstallmentAmount: body.InstallmentAmount,
+	}
+	emiStartDate, fieldErrs := validateRegistration(&req)
+	if fieldErrs != nil {
+		writeValidationErrors(w, fieldErrs)
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "reissue_failed", "Failed to reissue device")
+		return
+	}
+	defer tx.Rollback()
+
+	var deviceID string
+	var wasLocked bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, is_locked FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2) FOR UPDATE",
+		serialNumber, dealerArg(r),
+	).Scan(&deviceID, &wasLocked)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	// Keep the previous customer and plan, with how far they got
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO device_archives (id, device_id, customer_name, phone_number, emi_term, emi_start_date,
+			term_duration, grace_days, paid_terms, registered_at, archived_by, archived_at)
+		SELECT $1, d.id, d.customer_name, d.phone_number, d.emi_term, d.emi_start_date,
+			d.term_duration, d.grace_days,
+			(SELECT COUNT(*) FROM lock_dates ld WHERE ld.device_id = d.id AND ld.paid_at IS NOT NULL),
+			d.created_at, $2, $3
+		FROM devices d WHERE d.id = $4
+	`, uuid.New().String(), actorFromRequest(r), time.Now(), deviceID)
+	if err != nil {
+		errorf(ctx, "Error archiving device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "reissue_failed", "Failed to reissue device")
+		return
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM activation_codes WHERE device_id = $1", deviceID); err != nil {
+		errorf(ctx, "Error deleting activation codes: %v", err)
+		writeDBError(w, r, err, "reissue_failed", "Failed to reissue device")
+		return
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM lock_dates WHERE device_id = $1", deviceID); err != nil {
+		errorf(ctx, "Error deleting lock dates: %v", err)
+		writeDBError(w, r, err, "reissue_failed", "Failed to reissue device")
+		return
+	}
+
+	// Start over as a freshly registered, inactive and unlocked device
+	_, err = tx.ExecContext(ctx, `
+		UPDATE devices SET customer_name = $1, phone_number = $2, emi_term = $3, emi_start_date = $4,
+			term_duration = $5, grace_days = $6, installment_amount = $7, is_active = false, is_locked = false,
+			snooze_until = NULL, relock_at = NULL, retired_at = NULL, force_locked_at = NULL, version = version + 1
+		WHERE id = $8
+	`, req.CustomerName, req.PhoneNumber, req.EMITerm, emiStartDate, req.TermDuration, req.GraceDays, req.InstallmentAmount, deviceID)
+	if err != nil {
+		errorf(ctx, "Error updating device: %v", err)
+		writeDBError(w, r, err, "reissue_failed", "Failed to reissue device")
+		return
+	}
+
+	termsWithDates, err := createSchedule(ctx, tx, deviceID, req, emiStartDate)
+	if err != nil {
+		writeDBError(w, r, err, "reissue_failed", "Failed to reissue device")
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO remote_locks (id, device_id, is_locked, created_at, updated_at) VALUES ($1, $2, false, $3, $3)
+		ON CONFLICT (device_id) DO UPDATE SET is_locked = false, updated_at = EXCLUDED.updated_at
+	`, uuid.New().String(), deviceID, time.Now())
+	if err != nil {
+		errorf(ctx, "Error updating remote lock: %v", err)
+		writeDBError(w, r, err, "reissue_failed", "Failed to reissue device")
+		return
+	}
+	if wasLocked {
+		if err = appendLockEvent(ctx, tx, deviceID, false, lockSourceReissue); err != nil {
+			errorf(ctx, "Error writing lock event: %v", err)
+			writeDBError(w, r, err, "reissue_failed", "Failed to reissue device")
+			return
+		}
+	}
+
+	if err = appendAudit(ctx, tx, deviceID, "reissue", actorFromRequest(r), "Reissued to a new customer; previous customer archived"); err != nil {
+		errorf(ctx, "Error writing audit log: %v", err)
+		writeDBError(w, r, err, "reissue_failed", "Failed to reissue device")
+		return
+	}
+
+	var device Device
+	if err = scanDevice(tx.QueryRowContext(ctx, "SELECT "+deviceColumns+" FROM devices WHERE id = $1", deviceID), &device); err != nil {
+		errorf(ctx, "Error fetching reissued device: %v", err)
+		writeDBError(w, r, err, "reissue_failed", "Failed to reissue device")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing reissue: %v", err)
+		writeDBError(w, r, err, "reissue_failed", "Failed to reissue device")
+		return
+	}
+
+	if wasLocked {
+		unlocksTotal.Add(1)
+		dispatchWebhook(webhookEventUnlocked, deviceID, serialNumber, map[string]interface{}{"source": "reissue"})
+	}
+
+	response := ReissueDeviceResponse{
+		Success: true,
+		Message: "Device reissued successfully",
+		Device:  device,
+		Terms:   termsWithDates,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
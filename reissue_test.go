@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+// reissueBody is a reissue to a new customer with emiTerm monthly terms
+// starting today
+func reissueBody(emiTerm int) string {
+	return fmt.Sprintf(`{"customer_name":"New Owner","phone_number":"+15559876543","emi_term":%d,"emi_start_date":%q,"term_duration":30}`,
+		emiTerm, time.Now().UTC().Format("2006-01-02"))
+}
+
+func TestReissueReplacesCodesAndArchivesCustomer(t *testing.T) {
+	mock := mockDB(t)
+	reissued := testDevice("d1", "TV100001")
+	reissued.CustomerName = "New Owner"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, is_locked FROM devices").WithArgs("TV100001", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "is_locked"}).AddRow("d1", false))
+	mock.ExpectExec("INSERT INTO device_archives").WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "d1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// The old codes and lock dates go before the new schedule is created
+	mock.ExpectExec("DELETE FROM activation_codes WHERE device_id = \\$1").WithArgs("d1").WillReturnResult(sqlmock.NewResult(0, 6))
+	mock.ExpectExec("DELETE FROM lock_dates WHERE device_id = \\$1").WithArgs("d1").WillReturnResult(sqlmock.NewResult(0, 6))
+	mock.ExpectExec("UPDATE devices SET customer_name = \\$1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT dl.code_alphabet, dl.code_length").
+		WillReturnRows(sqlmock.NewRows([]string{"code_alphabet", "code_length"}).AddRow(nil, nil))
+	expectTermInserts(mock, 2)
+	mock.ExpectExec("INSERT INTO remote_locks").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_logs").WithArgs(sqlmock.AnyArg(), "d1", "reissue", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM devices WHERE id = \\$1").WithArgs("d1").WillReturnRows(deviceRows(reissued))
+	mock.ExpectCommit()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/reissue", reissueBody(2)))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body ReissueDeviceResponse
+	decodeResponse(t, rec, &body)
+	if len(body.Terms) != 2 || body.Terms[0].ActivationCode == "" || body.Device.CustomerName != "New Owner" {
+		t.Errorf("response = %+v, want two new codes for the new customer", body)
+	}
+	assertExpectations(t, mock)
+}
+
+// Checked against a real database so the codes read back are the stored ones
+func TestReissuedDeviceHasOnlyNewCodes(t *testing.T) {
+	conn := integrationDB(t)
+	serialNumber := "IT" + strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+	t.Cleanup(func() {
+		conn.Exec("DELETE FROM devices WHERE serial_number = $1", serialNumber)
+	})
+	storedCodes := func() []string {
+		rows, err := conn.Query(`
+			SELECT ac.code FROM activation_codes ac JOIN devices d ON d.id = ac.device_id
+			WHERE d.serial_number = $1 ORDER BY ac.term_number
+		`, serialNumber)
+		if err != nil {
+			t.Fatalf("reading codes: %v", err)
+		}
+		defer rows.Close()
+		codes := make([]string, 0)
+		for rows.Next() {
+			var code string
+			rows.Scan(&code)
+			codes = append(codes, code)
+		}
+		return codes
+	}
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", registrationBody(serialNumber, 3)))
+	assertStatus(t, rec, http.StatusOK)
+	oldCodes := storedCodes()
+
+	rec = serve(apiRequest(t, http.MethodPost, "/api/device/"+serialNumber+"/reissue", reissueBody(2)))
+	assertStatus(t, rec, http.StatusOK)
+	var body ReissueDeviceResponse
+	decodeResponse(t, rec, &body)
+
+	newCodes := storedCodes()
+	if len(oldCodes) != 3 || len(newCodes) != 2 {
+		t.Fatalf("codes = %v before and %v after, want three then two", oldCodes, newCodes)
+	}
+	for i, code := range newCodes {
+		if slices.Contains(oldCodes, code) {
+			t.Errorf("old code %s survived the reissue", code)
+		}
+		if body.Terms[i].ActivationCode != code {
+			t.Errorf("term %d code = %s, want the stored %s", i+1, body.Terms[i].ActivationCode, code)
+		}
+	}
+	var archived int
+	conn.QueryRow("SELECT COUNT(*) FROM device_archives WHERE device_id = $1 AND customer_name = 'Test Customer'", body.Device.ID).Scan(&archived)
+	if archived != 1 {
+		t.Errorf("archived rows = %d, want the previous customer archived once", archived)
+	}
+}
@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// defaultLockReminderDays is how many days before a lock date the
+// device.lock_reminder webhook is sent
+const defaultLockReminderDays = 3
+
+type LockRemindersResponse struct {
+	Success      bool     `json:"success"`
+	ReminderDays int      `json:"reminder_days"`
+	SentCount    int      `json:"sent_count"`
+	DeviceIDs    []string `json:"device_ids"`
+	// Reminders left undelivered because the receiver failed
+	PendingCount int `json:"pending_count"`
+}
+
+// lockReminder is an unpaid term due for a device.lock_reminder webhook
+type lockReminder struct {
+	lockDateID   string
+	deviceID     string
+	serialNumber string
+	termNumber   int
+	lockDate     time.Time
+	graceDays    int
+}
+
+// sendLockReminders fires a device.lock_reminder webhook for every unpaid
+// term whose lock date is between today and LOCK_REMINDER_DAYS away and that
+// has not been reminded yet, so integrators can nudge customers before the TV
+// locks. "Today" is taken in DEVICE_TIMEZONE. Each term is claimed in its own
+// transaction and marked with reminder_sent_at as soon as its webhook is
+// delivered, so overlapping or repeated runs remind a term only once and a
+// later failure cannot undo marks already made. If the receiver is down the
+// run stops at the first undelivered reminder; the rest stay unmarked and,
+// being inside the window until their lock date, are caught up by the next
+// daily run. Nothing is sent when WEBHOOK_URL is not set.
+func sendLockReminders(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	reminderDays := envNonNegativeInt("LOCK_REMINDER_DAYS", defaultLockReminderDays)
+	response := LockRemindersResponse{
+		Success:      true,
+		ReminderDays: reminderDays,
+		DeviceIDs:    make([]string, 0),
+	}
+	webhookURL := os.Getenv("WEBHOOK_URL")
+	if webhookURL == "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	y, m, d := time.Now().In(deviceTimezone()).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	dueDate := today.AddDate(0, 0, reminderDays)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT ld.id, ld.device_id, d.serial_number, ld.term_number, ld.lock_date, d.grace_days
+		FROM lock_dates ld
+		JOIN devices d ON d.id = ld.device_id
+		WHERE d.deleted_at IS NULL AND d.retired_at IS NULL
+		  AND ld.paid_at IS NULL AND ld.reminder_sent_at IS NULL
+		  AND ld.lock_date BETWEEN $1::date AND $2::date
+		ORDER BY ld.lock_date, d.serial_number, ld.term_number
+	`, today, dueDate)
+	if err != nil {
+		errorf(ctx, "Error fetching lock reminders: %v", err)
+		writeDBError(w, r, err, "lock_reminders_failed", "Failed to send lock reminders")
+		return
+	}
+	due := make([]lockReminder, 0)
+	for rows.Next() {
+		var reminder lockReminder
+		if err := rows.Scan(&reminder.lockDateID, &reminder.deviceID, &reminder.serialNumber, &reminder.termNumber, &reminder.lockDate, &reminder.graceDays); err != nil {
+			errorf(ctx, "Error scanning lock reminder: %v", err)
+			continue
+		}
+		due = append(due, reminder)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		errorf(ctx, "Error iterating lock reminders: %v", err)
+		writeDBError(w, r, err, "lock_reminders_failed", "Failed to send lock reminders")
+		return
+	}
+
+	secret := os.Getenv("WEBHOOK_SECRET")
+	for i, reminder := range due {
+		// Delivery does not watch the request deadline, so stop starting new
+		// reminders once it has passed and leave them to the next run
+		if ctx.Err() != nil {
+			response.PendingCount = len(due) - i
+			break
+		}
+		sent, err := sendLockReminder(ctx, webhookURL, secret, reminder)
+		if err != nil {
+			errorf(ctx, "Error sending lock reminder for device %s: %v", reminder.deviceID, err)
+			response.PendingCount = len(due) - i
+			break
+		}
+		if sent {
+			response.DeviceIDs = append(response.DeviceIDs, reminder.deviceID)
+		}
+	}
+	response.SentCount = len(response.DeviceIDs)
+
+	logEvent(ctx, slog.LevelInfo, "cron_lock_reminders", fmt.Sprintf("Cron lock-reminders: sent %d reminder(s), %d pending", response.SentCount, response.PendingCount),
+		slog.Int("sent_count", response.SentCount), slog.Int("pending_count", response.PendingCount))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// sendLockReminder claims one term, delivers its reminder and marks it sent
+// in a single short transaction. The row lock keeps an overlapping run from
+// delivering the same term; a term that run holds, or that has since been
+// reminded or paid, is skipped and reports false. The transaction outlives
+// the request deadline so a delivered reminder is always recorded.
+func sendLockReminder(ctx context.Context, webhookURL, secret string, reminder lockReminder) (bool, error) {
+	ctx = context.WithoutCancel(ctx)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var claimed string
+	err = tx.QueryRowContext(ctx,
+		"SELECT id FROM lock_dates WHERE id = $1 AND paid_at IS NULL AND reminder_sent_at IS NULL FOR UPDATE SKIP LOCKED",
+		reminder.lockDateID,
+	).Scan(&claimed)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	body, err := encodeWebhook(webhookEventLockReminder, reminder.deviceID, reminder.serialNumber, map[string]interface{}{
+		"term_number": reminder.termNumber,
+		"due_date":    reminder.lockDate.Format("2006-01-02"),
+		"locks_on":    reminder.lockDate.AddDate(0, 0, reminder.graceDays).Format("2006-01-02"),
+	})
+	if err != nil {
+		return false, err
+	}
+	if err = deliverWebhook(webhookURL, secret, webhookEventLockReminder, reminder.deviceID, body); err != nil {
+		return false, err
+	}
+
+	if _, err = tx.ExecContext(ctx, "UPDATE lock_dates SET reminder_sent_at = NOW() WHERE id = $1", reminder.lockDateID); err != nil {
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,303 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+// reminderDueDate is the lock date days from today, as a reminder run
+// counts it
+func reminderDueDate(days int) time.Time {
+	y, m, d := time.Now().In(deviceTimezone()).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).AddDate(0, 0, days)
+}
+
+// expectDueReminders expects a reminder run with days of notice to find the
+// given terms of d1, due on lockDate
+func expectDueReminders(mock sqlmock.Sqlmock, days int, lockDate time.Time, terms ...int) {
+	rows := sqlmock.NewRows([]string{"id", "device_id", "serial_number", "term_number", "lock_date", "grace_days"})
+	for _, term := range terms {
+		rows.AddRow(fmt.Sprintf("ld%d", term), "d1", "TV100001", term, lockDate, 2)
+	}
+	mock.ExpectQuery(`AND ld.lock_date BETWEEN \$1::date AND \$2::date`).WithArgs(reminderDueDate(0), reminderDueDate(days)).
+		WillReturnRows(rows)
+}
+
+// expectReminderClaim expects one term to be claimed for delivery in its own
+// transaction
+func expectReminderClaim(mock sqlmock.Sqlmock, lockDateID string) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM lock_dates WHERE id = \\$1 AND paid_at IS NULL AND reminder_sent_at IS NULL FOR UPDATE SKIP LOCKED").
+		WithArgs(lockDateID).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(lockDateID))
+}
+
+// expectReminderMarked expects a delivered reminder to be marked and committed
+func expectReminderMarked(mock sqlmock.Sqlmock, lockDateID string) {
+	mock.ExpectExec("UPDATE lock_dates SET reminder_sent_at = NOW\\(\\) WHERE id = \\$1").WithArgs(lockDateID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestLockReminderIsMarkedAfterDelivery(t *testing.T) {
+	t.Setenv("LOCK_REMINDER_DAYS", "5")
+	received := webhookReceiver(t)
+	mock := mockDB(t)
+	dueDate := reminderDueDate(5)
+	expectDueReminders(mock, 5, dueDate, 2)
+	expectReminderClaim(mock, "ld2")
+	expectReminderMarked(mock, "ld2")
+
+	rec := serve(cronRequest(t, "/api/cron/lock-reminders"))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body LockRemindersResponse
+	decodeResponse(t, rec, &body)
+	if body.ReminderDays != 5 || body.SentCount != 1 || body.PendingCount != 0 {
+		t.Errorf("response = %+v, want one reminder sent 5 days ahead", body)
+	}
+	var event WebhookEvent
+	if err := json.Unmarshal(nextWebhook(t, received).body, &event); err != nil {
+		t.Fatalf("decoding webhook: %v", err)
+	}
+	due := dueDate.Format("2006-01-02")
+	if event.Event != webhookEventLockReminder || event.Payload["due_date"] != due || event.Payload["locks_on"] != dueDate.AddDate(0, 0, 2).Format("2006-01-02") {
+		t.Errorf("event = %+v, want a lock reminder due %s", event, due)
+	}
+	select {
+	case extra := <-received:
+		t.Errorf("unexpected second webhook %s", extra.body)
+	default:
+	}
+	assertExpectations(t, mock)
+}
+
+func TestUndeliveredLockReminderIsNotMarked(t *testing.T) {
+	t.Setenv("LOCK_REMINDER_DAYS", "3")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("WEBHOOK_URL", server.URL)
+	mock := mockDB(t)
+	expectDueReminders(mock, 3, reminderDueDate(3), 1, 2)
+	expectReminderClaim(mock, "ld1")
+	mock.ExpectRollback()
+
+	rec := serve(cronRequest(t, "/api/cron/lock-reminders"))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body LockRemindersResponse
+	decodeResponse(t, rec, &body)
+	if body.SentCount != 0 || body.PendingCount != 2 {
+		t.Errorf("response = %+v, want both reminders pending", body)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestDeliveredRemindersStayMarkedWhenALaterOneFails(t *testing.T) {
+	t.Setenv("LOCK_REMINDER_DAYS", "3")
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) > 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("WEBHOOK_URL", server.URL)
+	mock := mockDB(t)
+	expectDueReminders(mock, 3, reminderDueDate(3), 1, 2)
+	// The first mark is committed before the second delivery is attempted
+	expectReminderClaim(mock, "ld1")
+	expectReminderMarked(mock, "ld1")
+	expectReminderClaim(mock, "ld2")
+	mock.ExpectRollback()
+
+	rec := serve(cronRequest(t, "/api/cron/lock-reminders"))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body LockRemindersResponse
+	decodeResponse(t, rec, &body)
+	if body.SentCount != 1 || body.PendingCount != 1 {
+		t.Errorf("response = %+v, want one sent and one pending", body)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestLockReminderClaimedElsewhereIsSkipped(t *testing.T) {
+	t.Setenv("LOCK_REMINDER_DAYS", "3")
+	received := webhookReceiver(t)
+	mock := mockDB(t)
+	expectDueReminders(mock, 3, reminderDueDate(3), 1)
+	mock.ExpectBegin()
+	mock.ExpectQuery("FOR UPDATE SKIP LOCKED").WithArgs("ld1").WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	rec := serve(cronRequest(t, "/api/cron/lock-reminders"))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body LockRemindersResponse
+	decodeResponse(t, rec, &body)
+	if body.SentCount != 0 || body.PendingCount != 0 {
+		t.Errorf("response = %+v, want nothing sent or pending", body)
+	}
+	select {
+	case extra := <-received:
+		t.Errorf("unexpected webhook %s", extra.body)
+	default:
+	}
+	assertExpectations(t, mock)
+}
+
+func TestLockRemindersNeedWebhookURL(t *testing.T) {
+	t.Setenv("WEBHOOK_URL", "")
+	mock := mockDB(t)
+
+	rec := serve(cronRequest(t, "/api/cron/lock-reminders"))
+
+	assertStatus(t, rec, http.StatusOK)
+	assertExpectations(t, mock)
+}
+
+func TestPartialPaymentResetsLockReminder(t *testing.T) {
+	mock := mockDB(t)
+	expectExtensionCheck(mock, 0)
+	mock.ExpectQuery(`SET lock_date = lock_date \+ \$1::integer, extension_days = extension_days \+ \$1::integer, reminder_sent_at = NULL`).
+		WithArgs(5, "d1", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"lock_date", "extension_days"}).AddRow(time.Date(2026, 3, 6, 0, 0, 0, 0, time.UTC), 5))
+	mock.ExpectExec("INSERT INTO audit_logs").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/partial-payment", `{"term_number":2,"days_extension":5}`))
+
+	assertStatus(t, rec, http.StatusOK)
+	assertExpectations(t, mock)
+}
+
+// Which terms are due, and that a sent one stays sent, is decided in SQL, so
+// it is checked against seeded rows in a real database
+func TestSeededDeviceGetsExactlyOneReminder(t *testing.T) {
+	conn := integrationDB(t)
+	const reminderDays = 4
+	t.Setenv("LOCK_REMINDER_DAYS", "4")
+	serialNumber := "IT" + strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+
+	// Other rows in the database may be due too; only this serial is counted
+	var mu sync.Mutex
+	var terms []float64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		var event WebhookEvent
+		if json.Unmarshal(raw, &event) == nil && event.SerialNumber == serialNumber {
+			mu.Lock()
+			terms = append(terms, event.Payload["term_number"].(float64))
+			mu.Unlock()
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("WEBHOOK_URL", server.URL)
+
+	var deviceID string
+	err := conn.QueryRow(`
+		INSERT INTO devices (serial_number, customer_name, phone_number, emi_term, emi_start_date, term_duration)
+		VALUES ($1, 'Reminder Customer', '+15551234567', 3, CURRENT_DATE, 30) RETURNING id
+	`, serialNumber).Scan(&deviceID)
+	if err != nil {
+		t.Fatalf("seeding device: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Exec("DELETE FROM devices WHERE id = $1", deviceID)
+	})
+	// Term 1 is already past due and term 3 is beyond the window; only term
+	// 2 is reminded
+	for term, days := range map[int]int{1: -1, 2: reminderDays, 3: reminderDays + 1} {
+		if _, err = conn.Exec("INSERT INTO lock_dates (device_id, term_number, lock_date) VALUES ($1, $2, $3)", deviceID, term, reminderDueDate(days)); err != nil {
+			t.Fatalf("seeding term %d: %v", term, err)
+		}
+	}
+
+	for run := 1; run <= 2; run++ {
+		rec := serve(cronRequest(t, "/api/cron/lock-reminders"))
+		assertStatus(t, rec, http.StatusOK)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(terms) != 1 || terms[0] != 2 {
+		t.Errorf("reminded terms = %v, want term 2 once", terms)
+	}
+}
+
+// A reminder the receiver missed on one day's run is sent by the next day's,
+// when its lock date is a day nearer
+func TestMissedReminderIsSentOnTheNextRun(t *testing.T) {
+	conn := integrationDB(t)
+	t.Setenv("LOCK_REMINDER_DAYS", "3")
+	serialNumber := "IT" + strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+
+	var receiverUp atomic.Bool
+	var mu sync.Mutex
+	var terms []float64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		var event WebhookEvent
+		if json.Unmarshal(raw, &event) != nil || event.SerialNumber != serialNumber {
+			return
+		}
+		if !receiverUp.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		mu.Lock()
+		terms = append(terms, event.Payload["term_number"].(float64))
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("WEBHOOK_URL", server.URL)
+
+	var deviceID string
+	err := conn.QueryRow(`
+		INSERT INTO devices (serial_number, customer_name, phone_number, emi_term, emi_start_date, term_duration)
+		VALUES ($1, 'Reminder Customer', '+15551234567', 1, CURRENT_DATE, 30) RETURNING id
+	`, serialNumber).Scan(&deviceID)
+	if err != nil {
+		t.Fatalf("seeding device: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Exec("DELETE FROM devices WHERE id = $1", deviceID)
+	})
+	if _, err = conn.Exec("INSERT INTO lock_dates (device_id, term_number, lock_date) VALUES ($1, 1, $2)", deviceID, reminderDueDate(3)); err != nil {
+		t.Fatalf("seeding term: %v", err)
+	}
+
+	// Day one: the receiver is down
+	rec := serve(cronRequest(t, "/api/cron/lock-reminders"))
+	assertStatus(t, rec, http.StatusOK)
+
+	// Day two: the lock date is a day nearer and the receiver is back
+	if _, err = conn.Exec("UPDATE lock_dates SET lock_date = lock_date - 1 WHERE device_id = $1", deviceID); err != nil {
+		t.Fatalf("advancing a day: %v", err)
+	}
+	receiverUp.Store(true)
+	for run := 1; run <= 2; run++ {
+		rec = serve(cronRequest(t, "/api/cron/lock-reminders"))
+		assertStatus(t, rec, http.StatusOK)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(terms) != 1 || terms[0] != 1 {
+		t.Errorf("reminded terms = %v, want term 1 once on day two", terms)
+	}
+}
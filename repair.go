@@ -0,0 +1,419 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// RepairScheduleRequest is the optional body of a repair. Devices do not
+// store a custom term schedule, so one registered with term_durations must
+// be repaired with the same list, or its lock dates are respaced evenly by
+// term_duration.
+type RepairScheduleRequest struct {
+	TermDurations []int `json:"term_durations,omitempty"`
+}
+
+// RepairTermState is one term as a repair found or left it. LockDate or
+// ActivationCode is nil when the term had none, and ActivationCode is also
+// nil for a code a dry run would generate.
+type RepairTermState struct {
+	LockDate       *string `json:"lock_date"`
+	ActivationCode *string `json:"activation_code"`
+	IsUsed         bool    `json:"is_used"`
+	IsPaid         bool    `json:"is_paid"`
+
+	codePending bool // The term's code is still to be generated
+}
+
+// RepairChange is a term whose lock date or activation code a repair
+// changed. After is nil for a term beyond emi_term that was removed.
+type RepairChange struct {
+	Term   int              `json:"term"`
+	Before RepairTermState  `json:"before"`
+	After  *RepairTermState `json:"after"`
+}
+
+type RepairScheduleResponse struct {
+	Success bool           `json:"success"`
+	Message string         `json:"message"`
+	DryRun  bool           `json:"dry_run"`
+	EMITerm int            `json:"emi_term"`
+	Changes []RepairChange `json:"changes"`
+}
+
+// repairLockDate is a lock_dates row as read and rewritten by a repair. ID
+// is empty for a row the repair adds.
+type repairLockDate struct {
+	ID             string
+	Term           int
+	LockDate       time.Time
+	ExtensionDays  int
+	IsLocked       bool
+	PaidAt         *time.Time
+	ReminderSentAt *time.Time
+	CreatedAt      time.Time
+}
+
+// repairCode is an activation code as read and reassigned by a repair. ID
+// and Code are empty for a code the repair has to generate, and Code is set
+// once it has been.
+type repairCode struct {
+	ID     string
+	Term   int
+	Code   string
+	IsUsed bool
+}
+
+// fetchRepairRows reads and locks every lock date and activation code of a
+// device
+func fetchRepairRows(ctx context.Context, tx *sql.Tx, deviceID string) ([]repairLockDate, []repairCode, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, term_number, lock_date, extension_days, COALESCE(is_locked, false), paid_at, reminder_sent_at, created_at
+		FROM lock_dates WHERE device_id = $1
+		ORDER BY term_number
+		FOR UPDATE
+	`, deviceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	dates := make([]repairLockDate, 0)
+	for rows.Next() {
+		var d repairLockDate
+		if err := rows.Scan(&d.ID, &d.Term, &d.LockDate, &d.ExtensionDays, &d.IsLocked, &d.PaidAt, &d.ReminderSentAt, &d.CreatedAt); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		dates = append(dates, d)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+		SELECT id, term_number, code, COALESCE(is_used, false)
+		FROM activation_codes WHERE device_id = $1
+		ORDER BY term_number, created_at
+		FOR UPDATE
+	`, deviceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	codes := make([]repairCode, 0)
+	for rows.Next() {
+		var c repairCode
+		if err := rows.Scan(&c.ID, &c.Term, &c.Code, &c.IsUsed); err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, c)
+	}
+	return dates, codes, rows.Err()
+}
+
+// planRepair works out the schedule a device should have. Lock date rows
+// are taken in order of their scheduled (unextended) date and given terms 1
+// to emi_term, so each keeps its paid status, lock state, and extension
+// while its date is recomputed from expected; rows beyond emi_term are
+// dropped and missing ones added. Each term keeps its own activation code;
+// a used code numbered outside 1 to emi_term moves to the first term without
+// one, other such codes are dropped, and terms still without a code get a
+// placeholder to generate.
+func planRepair(emiTerm int, expected []time.Time, dates []repairLockDate, codes []repairCode) ([]repairLockDate, []repairCode) {
+	ordered := append([]repairLockDate(nil), dates...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a := ordered[i].LockDate.AddDate(0, 0, -ordered[i].ExtensionDays)
+		b := ordered[j].LockDate.AddDate(0, 0, -ordered[j].ExtensionDays)
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return ordered[i].Term < ordered[j].Term
+	})
+
+	newDates := make([]repairLockDate, 0, emiTerm)
+	for i := 0; i < emiTerm; i++ {
+		if i >= len(ordered) {
+			newDates = append(newDates, repairLockDate{Term: i + 1, LockDate: expected[i]})
+			continue
+		}
+		d := ordered[i]
+		d.Term = i + 1
+		lockDate := expected[i].AddDate(0, 0, d.ExtensionDays)
+		if !lockDate.Equal(d.LockDate) {
+			d.LockDate = lockDate
+			d.ReminderSentAt = nil
+		}
+		newDates = append(newDates, d)
+	}
+
+	slots := make(map[int]repairCode)
+	var orphans []repairCode
+	for _, c := range codes {
+		if _, taken := slots[c.Term]; !taken && c.Term >= 1 && c.Term <= emiTerm {
+			slots[c.Term] = c
+		} else if c.IsUsed {
+			orphans = append(orphans, c)
+		}
+	}
+	newCodes := make([]repairCode, 0, emiTerm)
+	for term := 1; term <= emiTerm; term++ {
+		c, ok := slots[term]
+		if !ok && len(orphans) > 0 {
+			c, orphans = orphans[0], orphans[1:]
+			ok = true
+		}
+		if !ok {
+			c = repairCode{}
+		}
+		c.Term = term
+		newCodes = append(newCodes, c)
+	}
+	return newDates, newCodes
+}
+
+// repairTermStates describes each term of a schedule for the diff
+func repairTermStates(dates []repairLockDate, codes []repairCode) map[int]RepairTermState {
+	states := make(map[int]RepairTermState)
+	for _, d := range dates {
+		state := states[d.Term]
+		lockDate := d.LockDate.Format("2006-01-02")
+		state.LockDate = &lockDate
+		state.IsPaid = d.PaidAt != nil
+		states[d.Term] = state
+	}
+	for _, c := range codes {
+		state := states[c.Term]
+		if c.Code != "" {
+			code := c.Code
+			state.ActivationCode = &code
+		} else {
+			state.codePending = true
+		}
+		state.IsUsed = c.IsUsed
+		states[c.Term] = state
+	}
+	return states
+}
+
+func sameOptionalString(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// repairChanges lists, by term, every term whose state differs between the
+// schedule before and after a repair
+func repairChanges(before, after map[int]RepairTermState) []RepairChange {
+	terms := make([]int, 0, len(before)+len(after))
+	for term := range before {
+		terms = append(terms, term)
+	}
+	for term := range after {
+		if _, ok := before[term]; !ok {
+			terms = append(terms, term)
+		}
+	}
+	sort.Ints(terms)
+
+	changes := make([]RepairChange, 0)
+	for _, term := range terms {
+		b := before[term]
+		a, ok := after[term]
+		if !ok {
+			changes = append(changes, RepairChange{Term: term, Before: b})
+			continue
+		}
+		if sameOptionalString(a.LockDate, b.LockDate) && sameOptionalString(a.ActivationCode, b.ActivationCode) &&
+			a.IsUsed == b.IsUsed && a.IsPaid == b.IsPaid && !a.codePending {
+			continue
+		}
+		changes = append(changes, RepairChange{Term: term, Before: b, After: &a})
+	}
+	return changes
+}
+
+// writeRepair replaces a device's lock dates with the planned ones, keeping
+// the IDs of existing rows, and moves, drops, or generates activation codes
+// to match the plan. Generated codes are filled into codes.
+func writeRepair(ctx context.Context, tx *sql.Tx, deviceID string, emiStartDate time.Time, oldCodes []repairCode, dates []repairLockDate, codes []repairCode) error {
+	// Renumbered rows could collide on (device_id, term_number) one at a
+	// time, so the lock dates are rewritten as a whole
+	if _, err := tx.ExecContext(ctx, "DELETE FROM lock_dates WHERE device_id = $1", deviceID); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, d := range dates {
+		id, createdAt := d.ID, d.CreatedAt
+		if id == "" {
+			id, createdAt = uuid.New().String(), now
+		}
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO lock_dates (id, device_id, term_number, lock_date, is_locked, paid_at, extension_days, reminder_sent_at, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+			id, deviceID, d.Term, d.LockDate, d.IsLocked, d.PaidAt, d.ExtensionDays, d.ReminderSentAt, createdAt,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	kept := make(map[string]int)
+	for _, c := range codes {
+		if c.ID != "" {
+			kept[c.ID] = c.Term
+		}
+	}
+	// Drop the codes that lost their term first, so the moved ones land on
+	// free term numbers
+	for _, c := range oldCodes {
+		if _, ok := kept[c.ID]; !ok {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM activation_codes WHERE id = $1", c.ID); err != nil {
+				return err
+			}
+		}
+	}
+	for _, c := range oldCodes {
+		if term, ok := kept[c.ID]; ok && term != c.Term {
+			if _, err := tx.ExecContext(ctx, "UPDATE activation_codes SET term_number = $1 WHERE id = $2", term, c.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	format, err := deviceCodeFormat(ctx, tx, deviceID)
+	if err != nil {
+		return err
+	}
+	expiresAt := activationCodeExpiry(emiStartDate)
+	for i := range codes {
+		if codes[i].ID != "" {
+			continue
+		}
+		code, err := insertActivationCode(ctx, tx, deviceID, codes[i].Term, format, expiresAt)
+		if err != nil {
+			return err
+		}
+		codes[i].Code = code
+	}
+	return nil
+}
+
+// repairSchedule rebuilds a device's schedule from its stored
+// emi_start_date, term_duration, and emi_term, fixing the terms left
+// mismatched by the old index-based pairing of codes and lock dates (see
+// validateSchedule). Paid status, lock state, extensions, and used codes
+// are kept wherever a term survives. The result is a per-term before/after
+// diff; with ?dry_run=true nothing is written.
+func repairSchedule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	dryRun, ok := parseOptionalBool(r, "dry_run")
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_dry_run", "dry_run must be true or false")
+		return
+	}
+
+	// The body is optional; an empty one respaces terms by term_duration
+	var req RepairScheduleRequest
+	if bodyErr := decodeJSONBody(r.Body, &req); bodyErr != nil && bodyErr.Reason != bodyReasonEmpty {
+		writeBodyError(w, bodyErr)
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "repair_failed", "Failed to repair schedule")
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the device so payments and activations wait for the repair
+	var deviceID string
+	var emiTerm, termDuration int
+	var emiStartDate time.Time
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, emi_term, term_duration, emi_start_date FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2) FOR UPDATE",
+		serialNumber, dealerArg(r),
+	).Scan(&deviceID, &emiTerm, &termDuration, &emiStartDate)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	if req.TermDurations != nil {
+		if len(req.TermDurations) != emiTerm {
+			writeError(w, http.StatusBadRequest, "invalid_term_durations", "term_durations must have one entry per EMI term")
+			return
+		}
+		for _, duration := range req.TermDurations {
+			if duration < 1 || duration > maxTermDurationDays {
+				writeError(w, http.StatusBadRequest, "invalid_term_durations", fmt.Sprintf("Each term duration must be between 1 and %d days", maxTermDurationDays))
+				return
+			}
+		}
+	}
+
+	dates, codes, err := fetchRepairRows(ctx, tx, deviceID)
+	if err != nil {
+		errorf(ctx, "Error fetching schedule for device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "repair_failed", "Failed to repair schedule")
+		return
+	}
+
+	expected := calculateLockDates(emiStartDate, termDuration, emiTerm, req.TermDurations)
+	newDates, newCodes := planRepair(emiTerm, expected, dates, codes)
+	before := repairTermStates(dates, codes)
+	changes := repairChanges(before, repairTermStates(newDates, newCodes))
+
+	response := RepairScheduleResponse{
+		Success: true,
+		DryRun:  dryRun != nil && *dryRun,
+		EMITerm: emiTerm,
+		Changes: changes,
+	}
+
+	switch {
+	case len(changes) == 0:
+		response.Message = "Schedule is already consistent"
+	case response.DryRun:
+		response.Message = fmt.Sprintf("Repair would change %d term(s), nothing was saved", len(changes))
+	default:
+		if err = writeRepair(ctx, tx, deviceID, emiStartDate, codes, newDates, newCodes); err != nil {
+			errorf(ctx, "Error repairing schedule for device %s: %v", deviceID, err)
+			writeAPIError(w, r, err, "repair_failed", "Failed to repair schedule")
+			return
+		}
+
+		details := fmt.Sprintf("Repaired schedule: %d term(s) changed", len(changes))
+		if err = appendAudit(ctx, tx, deviceID, "repair_schedule", actorFromRequest(r), details); err != nil {
+			errorf(ctx, "Error writing audit log: %v", err)
+			writeDBError(w, r, err, "repair_failed", "Failed to repair schedule")
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			errorf(ctx, "Error committing schedule repair: %v", err)
+			writeDBError(w, r, err, "repair_failed", "Failed to repair schedule")
+			return
+		}
+		logf(ctx, "Repaired schedule of device %s: %d term(s) changed", deviceID, len(changes))
+
+		// Recomputed to show the activation codes just generated
+		response.Message = fmt.Sprintf("Repaired %d term(s)", len(changes))
+		response.Changes = repairChanges(before, repairTermStates(newDates, newCodes))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+// plannedScheduleTerms summarizes a planned repair the way
+// fetchScheduleTerms would read it back, so it can be validated
+func plannedScheduleTerms(dates []repairLockDate, codes []repairCode) []scheduleTerm {
+	byTerm := make(map[int]*scheduleTerm)
+	term := func(number int) *scheduleTerm {
+		if byTerm[number] == nil {
+			byTerm[number] = &scheduleTerm{Term: number}
+		}
+		return byTerm[number]
+	}
+	for _, d := range dates {
+		t := term(d.Term)
+		t.LockDates++
+		scheduled := d.LockDate.AddDate(0, 0, -d.ExtensionDays)
+		t.ScheduledLockDate = &scheduled
+	}
+	for _, c := range codes {
+		term(c.Term).Codes++
+	}
+	numbers := make([]int, 0, len(byTerm))
+	for number := range byTerm {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	terms := make([]scheduleTerm, 0, len(numbers))
+	for _, number := range numbers {
+		terms = append(terms, *byTerm[number])
+	}
+	return terms
+}
+
+func TestRepairedScheduleValidatesClean(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	expected := calculateLockDates(start, 30, 3, nil)
+	paidAt := start.AddDate(0, 0, 25)
+
+	// Term 1's lock date row carries term 2's date and term 2's row carries
+	// term 1's, paid; term 3 has no lock date; term 2 has no code and its
+	// used code was left on term 5
+	dates := []repairLockDate{
+		{ID: "ld1", Term: 1, LockDate: expected[1]},
+		{ID: "ld2", Term: 2, LockDate: expected[0], PaidAt: &paidAt},
+	}
+	codes := []repairCode{
+		{ID: "ac1", Term: 1, Code: "CODE1"},
+		{ID: "ac3", Term: 3, Code: "CODE3"},
+		{ID: "ac5", Term: 5, Code: "CODE5", IsUsed: true},
+	}
+	if problems := scheduleProblems(3, plannedScheduleTerms(dates, codes)); len(problems) == 0 {
+		t.Fatal("corrupted schedule validates clean; the fixture is wrong")
+	}
+
+	newDates, newCodes := planRepair(3, expected, dates, codes)
+
+	if problems := scheduleProblems(3, plannedScheduleTerms(newDates, newCodes)); len(problems) != 0 {
+		t.Errorf("repaired schedule has problems %v", problemList(problems))
+	}
+	if newDates[0].ID != "ld2" || newDates[0].PaidAt == nil || newDates[1].ID != "ld1" || newDates[1].PaidAt != nil || newDates[2].ID != "" {
+		t.Errorf("lock dates = %+v, want the paid row on term 1, the other on term 2, and a new term 3", newDates)
+	}
+	if newCodes[0].ID != "ac1" || newCodes[1].ID != "ac5" || !newCodes[1].IsUsed || newCodes[2].ID != "ac3" {
+		t.Errorf("codes = %+v, want term 1 and 3 kept and the used orphan moved to term 2", newCodes)
+	}
+	for i, d := range newDates {
+		if !d.LockDate.Equal(expected[i]) {
+			t.Errorf("term %d lock date = %v, want %v", i+1, d.LockDate, expected[i])
+		}
+	}
+}
+
+func TestRepairDryRunWritesNothing(t *testing.T) {
+	mock := mockDB(t)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	expected := calculateLockDates(start, 30, 2, nil)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, emi_term, term_duration, emi_start_date FROM devices").WithArgs("TV100001", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "emi_term", "term_duration", "emi_start_date"}).AddRow("d1", 2, 30, start))
+	mock.ExpectQuery("FROM lock_dates WHERE device_id = \\$1").WithArgs("d1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "term_number", "lock_date", "extension_days", "is_locked", "paid_at", "reminder_sent_at", "created_at"}).
+			AddRow("ld1", 1, expected[1], 0, false, nil, nil, start).
+			AddRow("ld2", 2, expected[0], 0, false, nil, nil, start))
+	mock.ExpectQuery("FROM activation_codes WHERE device_id = \\$1").WithArgs("d1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "term_number", "code", "is_used"}).
+			AddRow("ac1", 1, "CODE1", false).
+			AddRow("ac2", 2, "CODE2", false))
+	mock.ExpectRollback()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/repair?dry_run=true", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body RepairScheduleResponse
+	decodeResponse(t, rec, &body)
+	if !body.DryRun || len(body.Changes) != 2 {
+		t.Fatalf("response = %+v, want a dry run changing both terms", body)
+	}
+	first := body.Changes[0]
+	if first.Before.LockDate == nil || *first.Before.LockDate != expected[1].Format("2006-01-02") ||
+		first.After == nil || first.After.LockDate == nil || *first.After.LockDate != expected[0].Format("2006-01-02") {
+		t.Errorf("term 1 change = %+v, want its lock date moved back to %s", first, expected[0].Format("2006-01-02"))
+	}
+	assertExpectations(t, mock)
+}
+
+func TestRepairThenValidateIsClean(t *testing.T) {
+	conn := integrationDB(t)
+	serialNumber := "IT" + strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+	var deviceID string
+	err := conn.QueryRow(`
+		INSERT INTO devices (serial_number, customer_name, phone_number, emi_term, emi_start_date, term_duration)
+		VALUES ($1, 'Corrupt Customer', '+15551234567', 3, CURRENT_DATE, 30) RETURNING id
+	`, serialNumber).Scan(&deviceID)
+	if err != nil {
+		t.Fatalf("seeding device: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Exec("DELETE FROM devices WHERE id = $1", deviceID)
+	})
+	// Terms 1 and 2 have swapped lock dates, term 2 is paid, term 3 has a
+	// code but no lock date, and term 2 has no code
+	seed := []string{
+		"INSERT INTO activation_codes (device_id, code, term_number) VALUES ($1, $2::text || 'A', 1)",
+		"INSERT INTO activation_codes (device_id, code, term_number) VALUES ($1, $2::text || 'C', 3)",
+		"INSERT INTO lock_dates (device_id, term_number, lock_date) VALUES ($1, 1, CURRENT_DATE + 60)",
+		"INSERT INTO lock_dates (device_id, term_number, lock_date, paid_at) VALUES ($1, 2, CURRENT_DATE + 30, NOW())",
+	}
+	for _, statement := range seed {
+		args := []interface{}{deviceID}
+		if strings.Contains(statement, "$2") {
+			args = append(args, serialNumber)
+		}
+		if _, err = conn.Exec(statement, args...); err != nil {
+			t.Fatalf("seeding schedule: %v", err)
+		}
+	}
+	validate := func() ScheduleValidationResponse {
+		t.Helper()
+		rec := serve(apiRequest(t, http.MethodGet, "/api/device/"+serialNumber+"/validate", ""))
+		assertStatus(t, rec, http.StatusOK)
+		var body ScheduleValidationResponse
+		decodeResponse(t, rec, &body)
+		return body
+	}
+	if validate().Valid {
+		t.Fatal("corrupted schedule validates clean before the repair")
+	}
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/"+serialNumber+"/repair", ""))
+	assertStatus(t, rec, http.StatusOK)
+
+	if body := validate(); !body.Valid || len(body.Problems) != 0 {
+		t.Errorf("after the repair, problems = %v, want none", problemList(body.Problems))
+	}
+	var paidTerm int
+	if err = conn.QueryRow("SELECT term_number FROM lock_dates WHERE device_id = $1 AND paid_at IS NOT NULL", deviceID).Scan(&paidTerm); err != nil || paidTerm != 1 {
+		t.Errorf("paid lock date is on term %d (%v), want term 1, whose date it held", paidTerm, err)
+	}
+}
@@ -1,64 +0,0 @@
-
-CREATE TABLE IF NOT EXISTS devices (
-    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-    serial_number VARCHAR(255) UNIQUE NOT NULL,
-    customer_name VARCHAR(255) NOT NULL,
-    phone_number VARCHAR(50) NOT NULL,
-    emi_term INTEGER NOT NULL,
-    emi_start_date DATE NOT NULL,
-    term_duration INTEGER NOT NULL CHECK (term_duration IN (7, 15, 30)),
-    is_active BOOLEAN DEFAULT false,
-    is_locked BOOLEAN DEFAULT false,
-    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
-);
-
-
-CREATE TABLE IF NOT EXISTS activation_codes (
-    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-    device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
-    code VARCHAR(50) UNIQUE NOT NULL,
-    term_number INTEGER NOT NULL,
-    is_used BOOLEAN DEFAULT false,
-    used_at TIMESTAMP WITH TIME ZONE,
-    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-    UNIQUE(device_id, term_number)
-);
-
-
-CREATE TABLE IF NOT EXISTS lock_dates (
-    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-    device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
-    lock_date DATE NOT NULL,
-    is_locked BOOLEAN DEFAULT false,
-    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
-);
-
-
-CREATE TABLE IF NOT EXISTS remote_locks (
-    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-    device_id UUID NOT NULL UNIQUE REFERENCES devices(id) ON DELETE CASCADE,
-    is_locked BOOLEAN DEFAULT false,
-    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
-);
-
-
-CREATE INDEX IF NOT EXISTS idx_devices_serial_number ON devices(serial_number);
-CREATE INDEX IF NOT EXISTS idx_activation_codes_device_id ON activation_codes(device_id);
-CREATE INDEX IF NOT EXISTS idx_activation_codes_code ON activation_codes(code);
-CREATE INDEX IF NOT EXISTS idx_lock_dates_device_id ON lock_dates(device_id);
-CREATE INDEX IF NOT EXISTS idx_remote_locks_device_id ON remote_locks(device_id);
-
-
-CREATE OR REPLACE FUNCTION update_updated_at_column()
-RETURNS TRIGGER AS $$
-BEGIN
-    NEW.updated_at = NOW();
-    RETURN NEW;
-END;
-$$ language 'plpgsql';
-
-
-CREATE TRIGGER update_remote_locks_updated_at BEFORE UPDATE ON remote_locks
-    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
-
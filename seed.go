@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	defaultSeedCount = 10
+	maxSeedCount     = 500
+)
+
+// Seeded devices cycle through these states so a demo shows each of them
+const (
+	seedStatePending = "pending"
+	seedStateActive  = "active"
+	seedStateLocked  = "locked"
+)
+
+var seedStates = []string{seedStatePending, seedStateActive, seedStateLocked}
+
+var seedFirstNames = []string{"Aarav", "Priya", "Rahul", "Ananya", "Vikram", "Sneha", "Arjun", "Kavya", "Rohan", "Meera"}
+var seedLastNames = []string{"Sharma", "Patel", "Reddy", "Iyer", "Khan", "Gupta", "Nair", "Das", "Singh", "Joshi"}
+
+type SeedDevice struct {
+	DeviceID     string `json:"device_id"`
+	SerialNumber string `json:"serial_number"`
+	State        string `json:"state"`
+}
+
+type SeedResponse struct {
+	Success bool         `json:"success"`
+	Message string       `json:"message"`
+	Total   int          `json:"total"`
+	Devices []SeedDevice `json:"devices"`
+}
+
+// isDevelopment reports whether ENV marks this deployment as a local or
+// demo environment, where the /api/dev routes are registered
+func isDevelopment() bool {
+	return os.Getenv("ENV") == "development" && !isProduction()
+}
+
+// isProduction reports whether either ENV or Vercel's own VERCEL_ENV says
+// this is production, which overrides ENV=development
+func isProduction() bool {
+	return os.Getenv("ENV") == "production" || os.Getenv("VERCEL_ENV") == "production"
+}
+
+// seedRegistration builds a registration with random but valid data
+func seedRegistration() RegisterDeviceRequest {
+	durations := allowedTermDurations()
+	maxPastDays := envNonNegativeInt("EMI_START_MAX_PAST_DAYS", defaultEMIStartMaxPastDays)
+	if maxPastDays > 90 {
+		maxPastDays = 90
+	}
+	start := time.Now().UTC().AddDate(0, 0, -rand.Intn(maxPastDays+1))
+	installmentAmount := float64(500 + 100*rand.Intn(30))
+
+	return RegisterDeviceRequest{
+		SerialNumber:      fmt.Sprintf("DEMO-%08X", rand.Uint32()),
+		CustomerName:      seedFirstNames[rand.Intn(len(seedFirstNames))] + " " + seedLastNames[rand.Intn(len(seedLastNames))],
+		PhoneNumber:       fmt.Sprintf("+9198%08d", rand.Intn(100000000)),
+		EMITerm:           3 + rand.Intn(10),
+		EMIStartDate:      start.Format("2006-01-02"),
+		TermDuration:      durations[rand.Intn(len(durations))],
+		GraceDays:         rand.Intn(4),
+		InstallmentAmount: &installmentAmount,
+	}
+}
+
+// seedDevices registers ?count= demo devices (default 10, at most 500) with
+// random customers and schedules, cycling them through pending, active
+// (first code used), and locked. It is only routed when isDevelopment, and
+// refuses to run in production even if routed by mistake.
+func seedDevices(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if isProduction() {
+		writeError(w, http.StatusForbidden, "seed_disabled", "Seeding is disabled in production")
+		return
+	}
+
+	count, ok := parseNonNegativeInt(r, "count", defaultSeedCount)
+	if !ok || count < 1 || count > maxSeedCount {
+		writeError(w, http.StatusBadRequest, "invalid_count", fmt.Sprintf("count must be between 1 and %d", maxSeedCount))
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "seed_failed", "Failed to seed devices")
+		return
+	}
+	defer tx.Rollback()
+
+	actor := actorFromRequest(r)
+	devices := make([]SeedDevice, 0, count)
+	for i := 0; i < count; i++ {
+		req := seedRegistration()
+		emiStartDate, fieldErrs := validateRegistration(&req)
+		if fieldErrs != nil {
+			// Only a custom SERIAL_NUMBER_PATTERN or an unusual env
+			// configuration can reject the generated data
+			writeValidationErrors(w, fieldErrs)
+			return
+		}
+
+		deviceID, _, err := createDevice(ctx, tx, req, emiStartDate, dealerFromRequest(r), actor)
+		if err != nil {
+			writeAPIError(w, r, err, "seed_failed", "Failed to seed devices")
+			return
+		}
+
+		state := seedStates[i%len(seedStates)]
+		if state != seedStatePending {
+			_, err = tx.ExecContext(ctx, "UPDATE activation_codes SET is_used = true, used_at = $1 WHERE device_id = $2 AND term_number = 1", time.Now(), deviceID)
+			if err == nil {
+				_, err = tx.ExecContext(ctx, "UPDATE devices SET is_active = true WHERE id = $1", deviceID)
+			}
+		}
+		if err == nil && state == seedStateLocked {
+			err = writeRemoteLock(ctx, tx, deviceID, true, actor)
+		}
+		if err != nil {
+			errorf(ctx, "Error setting seeded device state: %v", err)
+			writeDBError(w, r, err, "seed_failed", "Failed to seed devices")
+			return
+		}
+
+		devices = append(devices, SeedDevice{DeviceID: deviceID, SerialNumber: req.SerialNumber, State: state})
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing seed data: %v", err)
+		writeDBError(w, r, err, "seed_failed", "Failed to seed devices")
+		return
+	}
+	logf(ctx, "Seeded %d demo devices", count)
+
+	response := SeedResponse{
+		Success: true,
+		Message: fmt.Sprintf("Seeded %d devices", count),
+		Total:   count,
+		Devices: devices,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSeedRouteAbsentOutsideDevelopment(t *testing.T) {
+	tests := []struct {
+		name, env, vercelEnv string
+	}{
+		{"unset", "", ""},
+		{"staging", "staging", ""},
+		{"production", "production", ""},
+		{"development on a Vercel production deploy", "development", "production"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ENV", tt.env)
+			t.Setenv("VERCEL_ENV", tt.vercelEnv)
+			mock := mockDB(t)
+
+			rec := serve(apiRequest(t, http.MethodPost, "/api/dev/seed?count=5", ""))
+
+			assertStatus(t, rec, http.StatusNotFound)
+			assertExpectations(t, mock)
+		})
+	}
+}
+
+func TestSeedRouteRegisteredInDevelopment(t *testing.T) {
+	t.Setenv("ENV", "development")
+	t.Setenv("VERCEL_ENV", "")
+	mock := mockDB(t)
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/dev/seed?count=0", ""))
+
+	assertStatus(t, rec, http.StatusBadRequest)
+	var body ErrorResponse
+	decodeResponse(t, rec, &body)
+	if body.Error.Code != "invalid_count" {
+		t.Errorf("error = %+v, want invalid_count from the seed handler", body.Error)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestSeedRegistrationIsValid(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		req := seedRegistration()
+		if _, fieldErrs := validateRegistration(&req); fieldErrs != nil {
+			t.Fatalf("seeded registration %+v is invalid: %+v", req, fieldErrs)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SerialHistoryEntry is one customer's time with a device. Status is
+// "archived" for a customer replaced by a reissue, "deleted" for the customer
+// of a soft-deleted device, and "current" otherwise; To is nil for the
+// customer who has the device now.
+type SerialHistoryEntry struct {
+	DeviceID     string     `json:"device_id"`
+	Status       string     `json:"status"`
+	CustomerName string     `json:"customer_name"`
+	PhoneNumber  string     `json:"phone_number"`
+	EMITerm      int        `json:"emi_term"`
+	EMIStartDate string     `json:"emi_start_date"`
+	From         time.Time  `json:"from"`
+	To           *time.Time `json:"to"`
+}
+
+type SerialHistoryResponse struct {
+	Success      bool                 `json:"success"`
+	SerialNumber string               `json:"serial_number"`
+	Total        int                  `json:"total"`
+	History      []SerialHistoryEntry `json:"history"`
+}
+
+// getSerialHistory lists every customer a serial number has been registered
+// to, oldest first: the customers archived by each reissue, the customer of
+// each soft-deleted device, and the current one. An archived customer's
+// period starts when the previous one was archived, or at registration for
+// the first.
+func getSerialHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	rows, err := db.QueryContext(ctx, `
+		WITH serial_devices AS (
+			SELECT id, customer_name, phone_number, emi_term, emi_start_date, created_at, deleted_at
+			FROM devices
+			WHERE serial_number = $1 AND ($2::uuid IS NULL OR dealer_id = $2)
+		)
+		SELECT a.device_id, 'archived', a.customer_name, a.phone_number, a.emi_term, a.emi_start_date,
+			COALESCE(LAG(a.archived_at) OVER (PARTITION BY a.device_id ORDER BY a.archived_at), a.registered_at, d.created_at) AS started_at,
+			a.archived_at AS ended_at
+		FROM device_archives a
+		JOIN serial_devices d ON d.id = a.device_id
+		UNION ALL
+		SELECT d.id, CASE WHEN d.deleted_at IS NULL THEN 'current' ELSE 'deleted' END,
+			d.customer_name, d.phone_number, d.emi_term, d.emi_start_date,
+			COALESCE((SELECT MAX(a.archived_at) FROM device_archives a WHERE a.device_id = d.id), d.created_at),
+			d.deleted_at
+		FROM serial_devices d
+		ORDER BY started_at, ended_at NULLS LAST
+	`, serialNumber, dealerArg(r))
+	if err != nil {
+		errorf(ctx, "Error fetching history for serial %s: %v", serialNumber, err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch serial history")
+		return
+	}
+	defer rows.Close()
+
+	history := make([]SerialHistoryEntry, 0)
+	for rows.Next() {
+		var entry SerialHistoryEntry
+		var emiStartDate time.Time
+		err := rows.Scan(&entry.DeviceID, &entry.Status, &entry.CustomerName, &entry.PhoneNumber,
+			&entry.EMITerm, &emiStartDate, &entry.From, &entry.To)
+		if err != nil {
+			errorf(ctx, "Error scanning serial history: %v", err)
+			continue
+		}
+		entry.EMIStartDate = emiStartDate.Format("2006-01-02")
+		history = append(history, entry)
+	}
+	if err = rows.Err(); err != nil {
+		errorf(ctx, "Error iterating serial history: %v", err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch serial history")
+		return
+	}
+	if len(history) == 0 {
+		writeError(w, http.StatusNotFound, "device_not_found", "Device not found")
+		return
+	}
+
+	response := SerialHistoryResponse{
+		Success:      true,
+		SerialNumber: serialNumber,
+		Total:        len(history),
+		History:      history,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestSerialHistoryListsArchivedAndCurrentCustomers(t *testing.T) {
+	mock := mockDB(t)
+	registered := time.Date(2026, 1, 10, 9, 0, 0, 0, time.UTC)
+	reissued := time.Date(2026, 6, 1, 9, 0, 0, 0, time.UTC)
+	startDate := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FROM device_archives").WithArgs("TV100001", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"device_id", "status", "customer_name", "phone_number", "emi_term", "emi_start_date", "started_at", "ended_at"}).
+			AddRow("d1", "archived", "Old Owner", "+15551234567", 6, startDate, registered, reissued).
+			AddRow("d1", "current", "New Owner", "+15559876543", 3, reissued, reissued, nil))
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/serial/tv100001/history", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body SerialHistoryResponse
+	decodeResponse(t, rec, &body)
+	if body.Total != 2 || len(body.History) != 2 {
+		t.Fatalf("history = %+v, want two entries", body.History)
+	}
+	old, current := body.History[0], body.History[1]
+	if old.Status != "archived" || old.CustomerName != "Old Owner" || !old.From.Equal(registered) || old.To == nil || !old.To.Equal(reissued) {
+		t.Errorf("first entry = %+v, want Old Owner archived at the reissue", old)
+	}
+	if current.Status != "current" || current.CustomerName != "New Owner" || current.To != nil {
+		t.Errorf("second entry = %+v, want New Owner with no end", current)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestSerialHistoryUnknownSerial(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectQuery("FROM device_archives").
+		WillReturnRows(sqlmock.NewRows([]string{"device_id", "status", "customer_name", "phone_number", "emi_term", "emi_start_date", "started_at", "ended_at"}))
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/serial/TV404/history", ""))
+
+	assertStatus(t, rec, http.StatusNotFound)
+	assertExpectations(t, mock)
+}
+
+func TestReissuedSerialHistoryHasBothCustomers(t *testing.T) {
+	conn := integrationDB(t)
+	serialNumber := "IT" + strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+	t.Cleanup(func() {
+		conn.Exec("DELETE FROM devices WHERE serial_number = $1", serialNumber)
+	})
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", registrationBody(serialNumber, 3)))
+	assertStatus(t, rec, http.StatusOK)
+	rec = serve(apiRequest(t, http.MethodPost, "/api/device/"+serialNumber+"/reissue", reissueBody(2)))
+	assertStatus(t, rec, http.StatusOK)
+
+	rec = serve(apiRequest(t, http.MethodGet, "/api/serial/"+serialNumber+"/history", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body SerialHistoryResponse
+	decodeResponse(t, rec, &body)
+	if len(body.History) != 2 {
+		t.Fatalf("history = %+v, want the original and the reissued customer", body.History)
+	}
+	old, current := body.History[0], body.History[1]
+	if old.Status != "archived" || old.CustomerName != "Test Customer" || old.EMITerm != 3 || old.To == nil {
+		t.Errorf("first entry = %+v, want the archived original customer", old)
+	}
+	if current.Status != "current" || current.CustomerName != "New Owner" || current.EMITerm != 2 || current.To != nil {
+		t.Errorf("second entry = %+v, want the current reissued customer", current)
+	}
+	if old.To != nil && current.From.Before(*old.To) {
+		t.Errorf("current customer starts %v, before the original ended %v", current.From, *old.To)
+	}
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// defaultShutdownTimeout bounds how long RunServer waits for in-flight
+// requests to finish after a shutdown signal
+const defaultShutdownTimeout = 15 * time.Second
+
+// RunServer serves the API on addr as a long-lived server rather than a
+// serverless function. On SIGINT or SIGTERM it stops accepting connections,
+// waits up to SHUTDOWN_TIMEOUT for in-flight requests, and then closes the
+// database pool. It returns nil after a clean shutdown.
+func RunServer(addr string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           http.HandlerFunc(Handler),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logf(context.Background(), "Listening on %s", addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		// The server never started or stopped on its own
+		closeDB()
+		return err
+	case <-ctx.Done():
+	}
+
+	timeout := envNonNegativeDuration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout)
+	logf(context.Background(), "Shutting down, waiting up to %s for in-flight requests", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := server.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		warnf(context.Background(), "requests still running after %s, closing them", timeout)
+		server.Close()
+	}
+	if stopErr := <-serveErr; !errors.Is(stopErr, http.ErrServerClosed) {
+		errorf(context.Background(), "Error serving: %v", stopErr)
+	}
+
+	closeDB()
+	logf(context.Background(), "Server stopped")
+	return err
+}
+
+// closeDB closes the database pool, if one was opened, so the next request
+// (if any) would connect again
+func closeDB() {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	if db == nil {
+		return
+	}
+	if err := db.Close(); err != nil {
+		errorf(context.Background(), "Error closing database: %v", err)
+	}
+	db = nil
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunServerShutsDownOnSignal(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectClose()
+	t.Setenv("SHUTDOWN_TIMEOUT", "5s")
+
+	// Find a free port for the server to listen on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- RunServer(addr) }()
+
+	var resp *http.Response
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if resp, err = http.Get("http://" + addr + "/api/ready"); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("server never answered: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		t.Skipf("cannot signal this process: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("RunServer = %v, want nil after a clean shutdown", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("RunServer did not return after SIGTERM")
+	}
+	if db != nil {
+		t.Error("database pool was not closed")
+	}
+	if _, err := http.Get("http://" + addr + "/api/live"); err == nil {
+		t.Error("server still accepts connections after shutdown")
+	}
+	assertExpectations(t, mock)
+}
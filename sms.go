@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// smsTimeout bounds loading and sending a single lock SMS
+const smsTimeout = 5 * time.Second
+
+// SMSSender delivers a text message to a phone number
+type SMSSender interface {
+	Send(ctx context.Context, to, body string) error
+}
+
+// NoopSender discards messages; it is used when no SMS provider is configured
+type NoopSender struct{}
+
+func (NoopSender) Send(ctx context.Context, to, body string) error {
+	debugf(ctx, "SMS not configured, skipping message to %s", to)
+	return nil
+}
+
+// TwilioSender sends messages through the Twilio Messages API
+type TwilioSender struct {
+	AccountSID string
+	AuthToken  string
+	From       string
+	Client     *http.Client
+}
+
+func (s TwilioSender) Send(ctx context.Context, to, body string) error {
+	endpoint := fmt.Sprintf("https://api.twilio.com/2010-04-01/Accounts/%s/Messages.json", s.AccountSID)
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("From", s.From)
+	form.Set("Body", body)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.AccountSID, s.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("twilio returned %s: %s", resp.Status, detail)
+	}
+	return nil
+}
+
+var smsSenderOnce sync.Once
+var smsSender SMSSender
+
+// getSMSSender returns the Twilio sender when TWILIO_SID, TWILIO_TOKEN, and
+// TWILIO_FROM are all set, and a NoopSender otherwise
+func getSMSSender() SMSSender {
+	smsSenderOnce.Do(func() {
+		sid := os.Getenv("TWILIO_SID")
+		token := os.Getenv("TWILIO_TOKEN")
+		from := os.Getenv("TWILIO_FROM")
+		if sid == "" || token == "" || from == "" {
+			smsSender = NoopSender{}
+			return
+		}
+		smsSender = TwilioSender{
+			AccountSID: sid,
+			AuthToken:  token,
+			From:       from,
+			Client:     &http.Client{Timeout: smsTimeout},
+		}
+	})
+	return smsSender
+}
+
+// lockMessage builds the SMS sent when a device locks. overdueTerm is 0 when
+// the lock was not caused by an overdue term.
+func lockMessage(customerName string, overdueTerm int) string {
+	if overdueTerm > 0 {
+		return fmt.Sprintf("Dear %s, your TV has been locked because EMI term %d is overdue. Please make your payment to unlock it.", customerName, overdueTerm)
+	}
+	return fmt.Sprintf("Dear %s, your TV has been locked by your dealer. Please contact them to unlock it.", customerName)
+}
+
+// notifyDeviceLocked texts the customer that their TV was locked. The text
+// goes out in the background with its own timeout, so a slow SMS provider
+// never holds up the response. It is best effort: failures are logged and
+// never affect the caller.
+func notifyDeviceLocked(deviceID string) {
+	sender := getSMSSender()
+	if _, ok := sender.(NoopSender); ok {
+		debugf(context.Background(), "SMS not configured, skipping lock notification for device %s", deviceID)
+		return
+	}
+	go sendLockSMS(db, sender, deviceID)
+}
+
+// notifyDevicesLocked texts the customers of several locked devices, such as
+// a bulk remote lock, one after another from a single background goroutine
+// so a large batch neither holds up the response nor floods the pool
+func notifyDevicesLocked(deviceIDs []string) {
+	if len(deviceIDs) == 0 {
+		return
+	}
+	sender := getSMSSender()
+	if _, ok := sender.(NoopSender); ok {
+		debugf(context.Background(), "SMS not configured, skipping lock notifications for %d device(s)", len(deviceIDs))
+		return
+	}
+	conn := db
+	go func() {
+		for _, deviceID := range deviceIDs {
+			sendLockSMS(conn, sender, deviceID)
+		}
+	}()
+}
+
+// sendLockSMS loads the customer's contact details from conn and sends them
+// the lock message through sender
+func sendLockSMS(conn *sql.DB, sender SMSSender, deviceID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), smsTimeout)
+	defer cancel()
+
+	var customerName, phoneNumber string
+	var overdueTerm sql.NullInt64
+	err := conn.QueryRowContext(ctx, `
+		SELECT d.customer_name, d.phone_number,
+		       (SELECT MIN(ld.term_number) FROM lock_dates ld
+		        WHERE ld.device_id = d.id AND ld.paid_at IS NULL AND ld.lock_date <= $2)
+		FROM devices d
+		WHERE d.id = $1
+	`, deviceID, time.Now()).Scan(&customerName, &phoneNumber, &overdueTerm)
+	if err != nil {
+		logEvent(ctx, slog.LevelError, "sms", fmt.Sprintf("Error loading device for lock notification: %v", err), slog.String("device_id", deviceID))
+		return
+	}
+
+	message := lockMessage(customerName, int(overdueTerm.Int64))
+	if err := sender.Send(ctx, phoneNumber, message); err != nil {
+		logEvent(ctx, slog.LevelError, "sms", fmt.Sprintf("Error sending lock SMS: %v", err), slog.String("device_id", deviceID))
+	}
+}
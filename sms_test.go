@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+type sentSMS struct {
+	to, body string
+}
+
+// fakeSender records messages instead of sending them
+type fakeSender struct {
+	sent chan sentSMS
+}
+
+func (f fakeSender) Send(ctx context.Context, to, body string) error {
+	f.sent <- sentSMS{to, body}
+	return nil
+}
+
+// useFakeSender routes SMS to a fake for the length of the test
+func useFakeSender(t *testing.T) fakeSender {
+	t.Helper()
+	getSMSSender()
+	fake := fakeSender{sent: make(chan sentSMS, 10)}
+	previous := smsSender
+	smsSender = fake
+	t.Cleanup(func() { smsSender = previous })
+	return fake
+}
+
+func TestRemoteLockQueuesSMS(t *testing.T) {
+	mock := mockDB(t)
+	fake := useFakeSender(t)
+	var action, createdAt captured
+
+	expectDeviceLookup(mock, "d1")
+	mock.ExpectBegin()
+	expectRemoteLockWrite(mock, "d1", true, &action, &createdAt)
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT d.customer_name, d.phone_number").WithArgs("d1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"customer_name", "phone_number", "overdue_term"}).
+			AddRow("Jane Doe", "+15551234567", nil))
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/lock", ""))
+	assertStatus(t, rec, http.StatusOK)
+
+	select {
+	case msg := <-fake.sent:
+		if msg.to != "+15551234567" {
+			t.Errorf("SMS sent to %q, want the customer's phone", msg.to)
+		}
+		if !strings.Contains(msg.body, "Jane Doe") || !strings.Contains(msg.body, "locked by your dealer") {
+			t.Errorf("SMS body = %q, want a remote lock message for Jane Doe", msg.body)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no SMS was queued after the lock")
+	}
+	assertExpectations(t, mock)
+}
+
+func TestLockMessageNamesOverdueTerm(t *testing.T) {
+	if got := lockMessage("Jane", 3); !strings.Contains(got, "EMI term 3 is overdue") {
+		t.Errorf("lockMessage = %q, want it to name term 3", got)
+	}
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultMaxSnoozeHours = 72
+
+type SnoozeRequest struct {
+	Hours int `json:"hours"`
+}
+
+type SnoozeResponse struct {
+	Success     bool       `json:"success"`
+	Message     string     `json:"message"`
+	SnoozeUntil *time.Time `json:"snooze_until"`
+}
+
+// snoozeDevice holds off automatic locking of a device for a number of hours,
+// for a customer who has promised to pay, without marking anything paid.
+// Zero hours ends a snooze early. Remote locks are not affected, and a
+// force-locked device cannot be snoozed.
+func snoozeDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	var req SnoozeRequest
+	if bodyErr := decodeJSONBody(r.Body, &req); bodyErr != nil {
+		writeBodyError(w, bodyErr)
+		return
+	}
+	maxHours := envNonNegativeInt("MAX_SNOOZE_HOURS", defaultMaxSnoozeHours)
+	if req.Hours < 0 || req.Hours > maxHours {
+		writeErrorWithDetails(w, http.StatusBadRequest, "invalid_snooze_hours",
+			fmt.Sprintf("hours must be between 0 and %d", maxHours),
+			map[string]interface{}{"max_hours": maxHours})
+		return
+	}
+
+	var snoozeUntil *time.Time
+	if req.Hours > 0 {
+		until := time.Now().Add(time.Duration(req.Hours) * time.Hour)
+		snoozeUntil = &until
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "snooze_failed", "Failed to snooze device")
+		return
+	}
+	defer tx.Rollback()
+
+	var deviceID string
+	var forceLockedAt *time.Time
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, force_locked_at FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2) FOR UPDATE",
+		serialNumber, dealerArg(r),
+	).Scan(&deviceID, &forceLockedAt)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+	if forceLockedAt != nil && snoozeUntil != nil {
+		writeForceLockedError(w, *forceLockedAt)
+		return
+	}
+
+	if _, err = tx.ExecContext(ctx, "UPDATE devices SET snooze_until = $1 WHERE id = $2", snoozeUntil, deviceID); err != nil {
+		errorf(ctx, "Error updating snooze: %v", err)
+		writeDBError(w, r, err, "snooze_failed", "Failed to snooze device")
+		return
+	}
+
+	message := "Snooze cleared"
+	details := "Automatic locking resumed"
+	if snoozeUntil != nil {
+		message = fmt.Sprintf("Automatic locking snoozed for %d hour(s)", req.Hours)
+		details = fmt.Sprintf("Automatic locking snoozed for %d hour(s), until %s", req.Hours, snoozeUntil.UTC().Format(time.RFC3339))
+	}
+	if err = appendAudit(ctx, tx, deviceID, "snooze", actorFromRequest(r), details); err != nil {
+		errorf(ctx, "Error writing audit log: %v", err)
+		writeDBError(w, r, err, "snooze_failed", "Failed to snooze device")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing snooze: %v", err)
+		writeDBError(w, r, err, "snooze_failed", "Failed to snooze device")
+		return
+	}
+
+	response := SnoozeResponse{
+		Success:     true,
+		Message:     message,
+		SnoozeUntil: snoozeUntil,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestSnoozeDeviceIsAudited(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, force_locked_at FROM devices").WithArgs("TV100001", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "force_locked_at"}).AddRow("d1", nil))
+	mock.ExpectExec("UPDATE devices SET snooze_until = \\$1").WithArgs(sqlmock.AnyArg(), "d1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_logs").WithArgs(sqlmock.AnyArg(), "d1", "snooze", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/snooze", `{"hours":24}`))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body SnoozeResponse
+	decodeResponse(t, rec, &body)
+	if body.SnoozeUntil == nil {
+		t.Error("snooze_until = null, want a time 24 hours ahead")
+	}
+	assertExpectations(t, mock)
+}
+
+func TestSnoozeHoursAreCapped(t *testing.T) {
+	t.Setenv("MAX_SNOOZE_HOURS", "48")
+	mock := mockDB(t)
+
+	for _, body := range []string{`{"hours":49}`, `{"hours":-1}`} {
+		rec := serve(apiRequest(t, http.MethodPost, "/api/device/TV100001/snooze", body))
+
+		assertStatus(t, rec, http.StatusBadRequest)
+	}
+	assertExpectations(t, mock)
+}
+
+// The snooze check runs against the database clock, so proving it holds
+// off a lock needs a real database
+func TestSnoozedDeviceDoesNotLockUntilSnoozeExpires(t *testing.T) {
+	conn := integrationDB(t)
+	serialNumber := "IT" + strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+	t.Cleanup(func() {
+		conn.Exec("DELETE FROM devices WHERE serial_number = $1", serialNumber)
+	})
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", registrationBody(serialNumber, 1)))
+	assertStatus(t, rec, http.StatusOK)
+	var deviceID string
+	if err := conn.QueryRow("SELECT id FROM devices WHERE serial_number = $1", serialNumber).Scan(&deviceID); err != nil {
+		t.Fatalf("finding device: %v", err)
+	}
+	if _, err := conn.Exec("UPDATE lock_dates SET lock_date = CURRENT_DATE - 1 WHERE device_id = $1", deviceID); err != nil {
+		t.Fatalf("backdating lock date: %v", err)
+	}
+
+	rec = serve(apiRequest(t, http.MethodPost, "/api/device/"+serialNumber+"/snooze", `{"hours":24}`))
+	assertStatus(t, rec, http.StatusOK)
+
+	if locked, err := evaluateLocks(context.Background(), deviceID); err != nil || locked {
+		t.Fatalf("evaluateLocks while snoozed = %v, %v, want false, nil", locked, err)
+	}
+
+	if _, err := conn.Exec("UPDATE devices SET snooze_until = NOW() - INTERVAL '1 minute' WHERE id = $1", deviceID); err != nil {
+		t.Fatalf("expiring snooze: %v", err)
+	}
+	if locked, err := evaluateLocks(context.Background(), deviceID); err != nil || !locked {
+		t.Errorf("evaluateLocks after the snooze = %v, %v, want true, nil", locked, err)
+	}
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type StatsResponse struct {
+	Success              bool `json:"success"`
+	TotalDevices         int  `json:"total_devices"`
+	ActiveDevices        int  `json:"active_devices"`
+	LockedDevices        int  `json:"locked_devices"`
+	FullyPaidDevices     int  `json:"fully_paid_devices"`
+	OverdueDevices       int  `json:"overdue_devices"`
+	RegisteredLast7Days  int  `json:"registered_last_7_days"`
+	RegisteredLast30Days int  `json:"registered_last_30_days"`
+}
+
+// getStats returns the device counts behind the dashboard header in a single
+// aggregate query. Deleted devices are not counted, and a dealer key only
+// counts the dealer's own devices.
+func getStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var response StatsResponse
+	now := time.Now()
+	err := db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*),
+			COUNT(*) FILTER (WHERE d.is_active),
+			COUNT(*) FILTER (WHERE d.is_locked),
+			COUNT(*) FILTER (WHERE NOT EXISTS (
+				SELECT 1 FROM lock_dates ld WHERE ld.device_id = d.id AND ld.paid_at IS NULL
+			)),
+			COUNT(*) FILTER (WHERE EXISTS (
+				SELECT 1 FROM lock_dates ld
+				WHERE ld.device_id = d.id AND ld.paid_at IS NULL
+				  AND ld.lock_date + d.grace_days <= $2
+			)),
+			COUNT(*) FILTER (WHERE d.created_at >= $3),
+			COUNT(*) FILTER (WHERE d.created_at >= $4)
+		FROM devices d
+		WHERE d.deleted_at IS NULL AND ($1::uuid IS NULL OR d.dealer_id = $1)
+	`, dealerArg(r), now, now.AddDate(0, 0, -7), now.AddDate(0, 0, -30)).Scan(
+		&response.TotalDevices, &response.ActiveDevices, &response.LockedDevices,
+		&response.FullyPaidDevices, &response.OverdueDevices,
+		&response.RegisteredLast7Days, &response.RegisteredLast30Days,
+	)
+	if err != nil {
+		errorf(ctx, "Error fetching stats: %v", err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch stats")
+		return
+	}
+	response.Success = true
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
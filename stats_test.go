@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestStatsWindows(t *testing.T) {
+	mock := mockDB(t)
+	var now, weekAgo, monthAgo captured
+	mock.ExpectQuery("FROM devices d").WithArgs(nil, &now, &weekAgo, &monthAgo).
+		WillReturnRows(sqlmock.NewRows([]string{"total", "active", "locked", "paid", "overdue", "week", "month"}).
+			AddRow(7, 6, 5, 4, 3, 2, 1))
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/stats", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body StatsResponse
+	decodeResponse(t, rec, &body)
+	want := StatsResponse{Success: true, TotalDevices: 7, ActiveDevices: 6, LockedDevices: 5, FullyPaidDevices: 4,
+		OverdueDevices: 3, RegisteredLast7Days: 2, RegisteredLast30Days: 1}
+	if body != want {
+		t.Errorf("response = %+v, want %+v", body, want)
+	}
+	at := now.value.(time.Time)
+	if !weekAgo.value.(time.Time).Equal(at.AddDate(0, 0, -7)) || !monthAgo.value.(time.Time).Equal(at.AddDate(0, 0, -30)) {
+		t.Errorf("windows start %v and %v, want 7 and 30 days before %v", weekAgo.value, monthAgo.value, at)
+	}
+	assertExpectations(t, mock)
+}
+
+// The counts come from FILTER clauses, so they are checked against seeded
+// rows in a real database. A dealer of its own keeps other rows out.
+func TestStatsCountSeededDevices(t *testing.T) {
+	conn := integrationDB(t)
+	dealerID, apiKey := integrationDealer(t, conn)
+
+	seeds := []struct {
+		isActive, isLocked bool
+		createdDaysAgo     int
+		lockDaysFromNow    int
+		paid, deleted      bool
+	}{
+		{true, false, 0, -10, true, false},  // fully paid, registered this week
+		{true, true, 10, -5, false, false},  // overdue, registered this month
+		{false, false, 40, 5, false, false}, // inactive, not yet due
+		{true, true, 1, -5, false, true},    // deleted, never counted
+	}
+	for i, seed := range seeds {
+		var deviceID string
+		err := conn.QueryRow(`
+			INSERT INTO devices (serial_number, customer_name, phone_number, emi_term, emi_start_date, term_duration,
+			                     is_active, is_locked, dealer_id, created_at, deleted_at)
+			VALUES ($1, 'Stats Customer', '+15551234567', 1, CURRENT_DATE, 30, $2, $3, $4,
+			        NOW() - make_interval(days => $5::integer), CASE WHEN $6::boolean THEN NOW() END)
+			RETURNING id
+		`, "ST"+dealerID[:8]+string(rune('A'+i)), seed.isActive, seed.isLocked, dealerID, seed.createdDaysAgo, seed.deleted).Scan(&deviceID)
+		if err != nil {
+			t.Fatalf("seeding device %d: %v", i, err)
+		}
+		_, err = conn.Exec(`
+			INSERT INTO lock_dates (device_id, term_number, lock_date, paid_at)
+			VALUES ($1, 1, CURRENT_DATE + $2::integer, CASE WHEN $3::boolean THEN NOW() END)
+		`, deviceID, seed.lockDaysFromNow, seed.paid)
+		if err != nil {
+			t.Fatalf("seeding lock date %d: %v", i, err)
+		}
+	}
+
+	r := apiRequest(t, http.MethodGet, "/api/stats", "")
+	r.Header.Set("X-API-Key", apiKey)
+	rec := serve(r)
+
+	assertStatus(t, rec, http.StatusOK)
+	var body StatsResponse
+	decodeResponse(t, rec, &body)
+	want := StatsResponse{Success: true, TotalDevices: 3, ActiveDevices: 2, LockedDevices: 1, FullyPaidDevices: 1,
+		OverdueDevices: 1, RegisteredLast7Days: 1, RegisteredLast30Days: 2}
+	if body != want {
+		t.Errorf("response = %+v, want %+v", body, want)
+	}
+}
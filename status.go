@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// deviceStatusMaxAge is how long TVs and proxies may cache a status answer
+const deviceStatusMaxAge = 15 * time.Second
+
+type DeviceStatusResponse struct {
+	IsLocked      bool    `json:"is_locked"`
+	IsActive      bool    `json:"is_active"`
+	NextLockDate  *string `json:"next_lock_date"`
+	DaysUntilLock *int    `json:"days_until_lock"`
+	// Unpaid terms times the installment amount, nil without an amount
+	OutstandingBalance *float64 `json:"outstanding_balance"`
+}
+
+var deviceLocationOnce sync.Once
+var deviceLocation *time.Location
+
+// deviceTimezone returns the DEVICE_TIMEZONE location that decides which
+// calendar day it is for the TVs, falling back to UTC when it is unset or
+// not a valid IANA name
+func deviceTimezone() *time.Location {
+	deviceLocationOnce.Do(func() {
+		deviceLocation = time.UTC
+		name := os.Getenv("DEVICE_TIMEZONE")
+		if name == "" {
+			return
+		}
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			warnf(context.Background(), "Invalid DEVICE_TIMEZONE %q, using UTC: %v", name, err)
+			return
+		}
+		deviceLocation = loc
+	})
+	return deviceLocation
+}
+
+// daysUntilLock counts the days from today in the device timezone until the
+// earliest unpaid term locks the device (its lock date plus grace days). It
+// is 0 on that day, negative once it has passed, and nil when every term is paid.
+func daysUntilLock(earliestUnpaid sql.NullTime, graceDays int, now time.Time) *int {
+	if !earliestUnpaid.Valid {
+		return nil
+	}
+	y, m, d := now.In(deviceTimezone()).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	ly, lm, ld := earliestUnpaid.Time.Date()
+	lockDay := time.Date(ly, lm, ld+graceDays, 0, 0, 0, 0, time.UTC)
+	days := int(lockDay.Sub(today).Hours() / 24)
+	return &days
+}
+
+// getDeviceStatus answers a TV's "should I lock?" poll in a single query.
+// is_locked is true when the device is remotely locked, is due to be relocked
+// after a temporary unlock, or has an unpaid term that is due (after grace
+// days) but not yet enforced on a device that is not retired, so the answer
+// matches what /api/check-lock would enforce without writing anything.
+func getDeviceStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	var response DeviceStatusResponse
+	var deviceID string
+	var nextLockDate, earliestUnpaid sql.NullTime
+	var graceDays, unpaidTerms int
+	var installmentAmount *float64
+	now := time.Now()
+	err := db.QueryRowContext(ctx, `
+		SELECT
+			d.id,
+			COALESCE(rl.is_locked, false) OR COALESCE(d.relock_at <= NOW(), false) OR (EXISTS (
+				SELECT 1 FROM lock_dates ld
+				WHERE ld.device_id = d.id AND ld.is_locked = false AND ld.paid_at IS NULL
+				  AND ld.lock_date + d.grace_days <= $2 AND d.retired_at IS NULL
+			) AND (d.snooze_until IS NULL OR d.snooze_until <= NOW())
+			  AND (d.relock_at IS NULL OR d.relock_at <= NOW())),
+			d.is_active,
+			(SELECT MIN(ld.lock_date) FROM lock_dates ld
+			 WHERE ld.device_id = d.id AND ld.paid_at IS NULL AND ld.lock_date >= $2::date),
+			(SELECT MIN(ld.lock_date) FROM lock_dates ld
+			 WHERE ld.device_id = d.id AND ld.paid_at IS NULL),
+			d.grace_days,
+			d.installment_amount,
+			(SELECT COUNT(*) FROM lock_dates ld WHERE ld.device_id = d.id AND ld.paid_at IS NULL)
+		FROM devices d
+		LEFT JOIN remote_locks rl ON rl.device_id = d.id
+		WHERE d.serial_number = $1 AND d.deleted_at IS NULL
+	`, serialNumber, now).Scan(&deviceID, &response.IsLocked, &response.IsActive, &nextLockDate, &earliestUnpaid, &graceDays, &installmentAmount, &unpaidTerms)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+	if !authorizeDevice(w, r, deviceID) {
+		return
+	}
+	if nextLockDate.Valid {
+		formatted := nextLockDate.Time.Format("2006-01-02")
+		response.NextLockDate = &formatted
+	}
+	response.DaysUntilLock = daysUntilLock(earliestUnpaid, graceDays, now)
+	response.OutstandingBalance = outstandingBalance(installmentAmount, unpaidTerms)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(deviceStatusMaxAge.Seconds())))
+	json.NewEncoder(w).Encode(response)
+}
+
+// maxBatchLockChecks caps the number of serial numbers in one batch check
+const maxBatchLockChecks = 500
+
+type BatchCheckLockRequest struct {
+	SerialNumbers []string `json:"serial_numbers"`
+}
+
+type BatchLockStatus struct {
+	IsLocked     bool    `json:"is_locked"`
+	NextLockDate *string `json:"next_lock_date"`
+}
+
+type BatchCheckLockResponse struct {
+	Success bool                        `json:"success"`
+	Results map[string]*BatchLockStatus `json:"results"`
+}
+
+// checkRemoteLockBatch reports the lock status of many devices in a single
+// query, computed the same way as getDeviceStatus. Serial numbers that are
+// unknown, deleted, or belong to another dealer map to null instead of
+// failing the batch.
+func checkRemoteLockBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req BatchCheckLockRequest
+	if bodyErr := decodeJSONBody(r.Body, &req); bodyErr != nil {
+		writeBodyError(w, bodyErr)
+		return
+	}
+	if len(req.SerialNumbers) == 0 {
+		writeBodyError(w, missingFieldError("serial_numbers"))
+		return
+	}
+	if len(req.SerialNumbers) > maxBatchLockChecks {
+		writeError(w, http.StatusRequestEntityTooLarge, "batch_too_large", fmt.Sprintf("A batch may contain at most %d serial numbers", maxBatchLockChecks))
+		return
+	}
+
+	// Results are keyed by the serial numbers as sent, which may differ from
+	// the stored form only in case or surrounding spaces
+	results := make(map[string]*BatchLockStatus, len(req.SerialNumbers))
+	requested := make(map[string][]string, len(req.SerialNumbers))
+	normalized := make([]string, 0, len(req.SerialNumbers))
+	for _, serialNumber := range req.SerialNumbers {
+		results[serialNumber] = nil
+		key := normalizeSerialNumber(serialNumber)
+		if _, seen := requested[key]; !seen {
+			normalized = append(normalized, key)
+		}
+		requested[key] = append(requested[key], serialNumber)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT
+			d.serial_number,
+			COALESCE(rl.is_locked, false) OR COALESCE(d.relock_at <= NOW(), false) OR (EXISTS (
+				SELECT 1 FROM lock_dates ld
+				WHERE ld.device_id = d.id AND ld.is_locked = false AND ld.paid_at IS NULL
+				  AND ld.lock_date + d.grace_days <= $2 AND d.retired_at IS NULL
+			) AND (d.snooze_until IS NULL OR d.snooze_until <= NOW())
+			  AND (d.relock_at IS NULL OR d.relock_at <= NOW())),
+			(SELECT MIN(ld.lock_date) FROM lock_dates ld
+			 WHERE ld.device_id = d.id AND ld.paid_at IS NULL AND ld.lock_date >= $2::date)
+		FROM devices d
+		LEFT JOIN remote_locks rl ON rl.device_id = d.id
+		WHERE d.serial_number = ANY($1) AND d.deleted_at IS NULL
+		  AND ($3::uuid IS NULL OR d.dealer_id = $3)
+	`, pq.Array(normalized), time.Now(), dealerArg(r))
+	if err != nil {
+		errorf(ctx, "Error fetching batch lock status: %v", err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch lock status")
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var serialNumber string
+		var nextLockDate sql.NullTime
+		status := &BatchLockStatus{}
+		if err := rows.Scan(&serialNumber, &status.IsLocked, &nextLockDate); err != nil {
+			errorf(ctx, "Error scanning batch lock status: %v", err)
+			writeDBError(w, r, err, "fetch_failed", "Failed to fetch lock status")
+			return
+		}
+		if nextLockDate.Valid {
+			formatted := nextLockDate.Time.Format("2006-01-02")
+			status.NextLockDate = &formatted
+		}
+		for _, original := range requested[serialNumber] {
+			results[original] = status
+		}
+	}
+	if err = rows.Err(); err != nil {
+		errorf(ctx, "Error iterating batch lock status: %v", err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch lock status")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(BatchCheckLockResponse{Success: true, Results: results})
+}
@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+// statusRow returns the single row getDeviceStatus selects
+func statusRow(isLocked bool, nextLockDate, earliestUnpaid *time.Time, unpaidTerms int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "is_locked", "is_active", "next_lock_date", "earliest_unpaid", "grace_days", "installment_amount", "unpaid_terms"}).
+		AddRow("d1", isLocked, true, nullable(nextLockDate), nullable(earliestUnpaid), 0, nil, unpaidTerms)
+}
+
+func TestDeviceStatusInOneQuery(t *testing.T) {
+	mock := mockDB(t)
+	nextLock := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 10)
+	// The strict mock fails any statement beyond this one, so passing proves
+	// the status is answered in a single round trip
+	mock.ExpectQuery("FROM devices d\\s+LEFT JOIN remote_locks rl ON rl.device_id = d.id").WithArgs("TV100001", sqlmock.AnyArg()).
+		WillReturnRows(statusRow(false, &nextLock, &nextLock, 3))
+
+	rec := serve(httptest.NewRequest(http.MethodGet, "/api/device/tv100001/status", nil))
+
+	assertStatus(t, rec, http.StatusOK)
+	if got := rec.Header().Get("Cache-Control"); !strings.Contains(got, "max-age=15") {
+		t.Errorf("Cache-Control = %q, want a short max-age", got)
+	}
+	var fields map[string]json.RawMessage
+	decodeResponse(t, rec, &fields)
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	want := []string{"days_until_lock", "is_active", "is_locked", "next_lock_date", "outstanding_balance"}
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("fields = %v, want %v", keys, want)
+	}
+	var body DeviceStatusResponse
+	decodeResponse(t, rec, &body)
+	if body.IsLocked || !body.IsActive || body.NextLockDate == nil || *body.NextLockDate != nextLock.Format("2006-01-02") {
+		t.Errorf("status = %+v, want active and unlocked until %s", body, nextLock.Format("2006-01-02"))
+	}
+	assertExpectations(t, mock)
+}
+
+func TestBatchCheckLockWithUnknownSerials(t *testing.T) {
+	mock := mockDB(t)
+	nextLock := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("WHERE d.serial_number = ANY\\(\\$1\\)").WithArgs(`{"TV100001","TV100002","TV404"}`, sqlmock.AnyArg(), nil).
+		WillReturnRows(sqlmock.NewRows([]string{"serial_number", "is_locked", "next_lock_date"}).
+			AddRow("TV100001", true, nil).
+			AddRow("TV100002", false, nextLock))
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/check-lock/batch", `{"serial_numbers":["TV100001","tv100002","TV404"]}`))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body struct {
+		Results map[string]json.RawMessage `json:"results"`
+	}
+	decodeResponse(t, rec, &body)
+	want := map[string]string{
+		"TV100001": `{"is_locked":true,"next_lock_date":null}`,
+		"tv100002": `{"is_locked":false,"next_lock_date":"2026-03-01"}`,
+		"TV404":    `null`,
+	}
+	if len(body.Results) != len(want) {
+		t.Errorf("results = %v, want an entry per requested serial", body.Results)
+	}
+	for serialNumber, status := range want {
+		if got := string(body.Results[serialNumber]); got != status {
+			t.Errorf("results[%s] = %s, want %s", serialNumber, got, status)
+		}
+	}
+	assertExpectations(t, mock)
+}
+
+func TestBatchCheckLockLimit(t *testing.T) {
+	mock := mockDB(t)
+	serials := make([]string, maxBatchLockChecks+1)
+	for i := range serials {
+		serials[i] = fmt.Sprintf("TV%06d", i)
+	}
+	payload, _ := json.Marshal(BatchCheckLockRequest{SerialNumbers: serials})
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/check-lock/batch", string(payload)))
+
+	assertStatus(t, rec, http.StatusRequestEntityTooLarge)
+	assertExpectations(t, mock)
+}
+
+func TestLockStatusIgnoresOverdueTermsOfRetiredDevices(t *testing.T) {
+	mock := mockDB(t)
+	overdue := "ld.lock_date \\+ d.grace_days <= \\$2 AND d.retired_at IS NULL"
+	mock.ExpectQuery(overdue).WithArgs("TV100001", sqlmock.AnyArg()).
+		WillReturnRows(statusRow(false, nil, nil, 0))
+	mock.ExpectQuery(overdue).WithArgs(`{"TV100001"}`, sqlmock.AnyArg(), nil).
+		WillReturnRows(sqlmock.NewRows([]string{"serial_number", "is_locked", "next_lock_date"}).AddRow("TV100001", false, nil))
+
+	rec := serve(httptest.NewRequest(http.MethodGet, "/api/device/TV100001/status", nil))
+	assertStatus(t, rec, http.StatusOK)
+	rec = serve(apiRequest(t, http.MethodPost, "/api/check-lock/batch", `{"serial_numbers":["TV100001"]}`))
+	assertStatus(t, rec, http.StatusOK)
+
+	assertExpectations(t, mock)
+}
+
+// A retired device is never locked for its terms, so its status must not
+// say otherwise while an unpaid term is past due
+func TestRetiredOverdueDeviceReportsUnlocked(t *testing.T) {
+	conn := integrationDB(t)
+	serialNumber := "IT" + strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+	t.Cleanup(func() {
+		conn.Exec("DELETE FROM devices WHERE serial_number = $1", serialNumber)
+	})
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", registrationBody(serialNumber, 1)))
+	assertStatus(t, rec, http.StatusOK)
+	rec = serve(apiRequest(t, http.MethodPost, "/api/unlock", `{"serial_number":"`+serialNumber+`"}`))
+	assertStatus(t, rec, http.StatusOK)
+	_, err := conn.Exec(
+		"UPDATE lock_dates SET lock_date = CURRENT_DATE - 30 FROM devices d WHERE d.id = lock_dates.device_id AND d.serial_number = $1",
+		serialNumber,
+	)
+	if err != nil {
+		t.Fatalf("backdating lock date: %v", err)
+	}
+
+	rec = serve(httptest.NewRequest(http.MethodGet, "/api/device/"+serialNumber+"/status", nil))
+	assertStatus(t, rec, http.StatusOK)
+	var status DeviceStatusResponse
+	decodeResponse(t, rec, &status)
+	if status.IsLocked {
+		t.Errorf("/status is_locked = true for a retired device")
+	}
+
+	rec = serve(apiRequest(t, http.MethodPost, "/api/check-lock/batch", `{"serial_numbers":["`+serialNumber+`"]}`))
+	assertStatus(t, rec, http.StatusOK)
+	var batch struct {
+		Results map[string]*BatchLockStatus `json:"results"`
+	}
+	decodeResponse(t, rec, &batch)
+	if result := batch.Results[serialNumber]; result == nil || result.IsLocked {
+		t.Errorf("/check-lock/batch result = %+v, want the retired device unlocked", result)
+	}
+}
+
+func TestDaysUntilLock(t *testing.T) {
+	lockDate := time.Date(2026, 5, 10, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name           string
+		earliestUnpaid sql.NullTime
+		now            time.Time
+		want           *int
+	}{
+		{"future", sql.NullTime{Time: lockDate, Valid: true}, lockDate.AddDate(0, 0, -7).Add(23 * time.Hour), intPtr(7)},
+		{"today", sql.NullTime{Time: lockDate, Valid: true}, lockDate.Add(18 * time.Hour), intPtr(0)},
+		{"overdue", sql.NullTime{Time: lockDate, Valid: true}, lockDate.AddDate(0, 0, 4), intPtr(-4)},
+		{"fully paid", sql.NullTime{}, lockDate, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := daysUntilLock(tt.earliestUnpaid, 0, tt.now)
+
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("daysUntilLock = %v, want %v", deref(got), deref(tt.want))
+			}
+		})
+	}
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+// deref formats an optional int for failure messages
+func deref(v *int) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
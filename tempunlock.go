@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type TemporaryUnlockRequest struct {
+	RelockAt *time.Time `json:"relock_at"`
+}
+
+type TemporaryUnlockResponse struct {
+	Success  bool      `json:"success"`
+	Message  string    `json:"message"`
+	RelockAt time.Time `json:"relock_at"`
+}
+
+// temporaryUnlockDevice unlocks a device now and schedules it to be locked
+// again at relock_at. Until then automatic locking is held off; afterwards
+// the device is relocked whatever the state of its terms. A force-locked
+// device must be unlocked explicitly instead.
+func temporaryUnlockDevice(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	var req TemporaryUnlockRequest
+	if bodyErr := decodeJSONBody(r.Body, &req); bodyErr != nil {
+		writeBodyError(w, bodyErr)
+		return
+	}
+	if req.RelockAt == nil {
+		writeBodyError(w, missingFieldError("relock_at"))
+		return
+	}
+	now := time.Now()
+	if !req.RelockAt.After(now) {
+		writeError(w, http.StatusBadRequest, "invalid_relock_at", "relock_at must be in the future")
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		errorf(ctx, "Error starting transaction: %v", err)
+		writeDBError(w, r, err, "temporary_unlock_failed", "Failed to unlock device")
+		return
+	}
+	defer tx.Rollback()
+
+	var deviceID string
+	var forceLockedAt *time.Time
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, force_locked_at FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2) FOR UPDATE",
+		serialNumber, dealerArg(r),
+	).Scan(&deviceID, &forceLockedAt)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+	if forceLockedAt != nil {
+		writeForceLockedError(w, *forceLockedAt)
+		return
+	}
+
+	if _, err = tx.ExecContext(ctx, "UPDATE devices SET is_locked = false, relock_at = $1 WHERE id = $2", *req.RelockAt, deviceID); err != nil {
+		errorf(ctx, "Error unlocking device: %v", err)
+		writeDBError(w, r, err, "temporary_unlock_failed", "Failed to unlock device")
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO remote_locks (id, device_id, is_locked, created_at, updated_at) VALUES ($1, $2, false, $3, $3)
+		ON CONFLICT (device_id) DO UPDATE SET is_locked = false, updated_at = EXCLUDED.updated_at
+	`, uuid.New().String(), deviceID, now)
+	if err != nil {
+		errorf(ctx, "Error updating remote lock: %v", err)
+		writeDBError(w, r, err, "temporary_unlock_failed", "Failed to unlock device")
+		return
+	}
+
+	if err = appendLockEvent(ctx, tx, deviceID, false, lockSourceTemp); err != nil {
+		errorf(ctx, "Error writing lock event: %v", err)
+		writeDBError(w, r, err, "temporary_unlock_failed", "Failed to unlock device")
+		return
+	}
+
+	relockAt := req.RelockAt.UTC().Format(time.RFC3339)
+	if err = appendAudit(ctx, tx, deviceID, "temporary_unlock", actorFromRequest(r), "Device unlocked until "+relockAt); err != nil {
+		errorf(ctx, "Error writing audit log: %v", err)
+		writeDBError(w, r, err, "temporary_unlock_failed", "Failed to unlock device")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		errorf(ctx, "Error committing temporary unlock: %v", err)
+		writeDBError(w, r, err, "temporary_unlock_failed", "Failed to unlock device")
+		return
+	}
+
+	unlocksTotal.Add(1)
+	dispatchWebhook(webhookEventUnlocked, deviceID, serialNumber, map[string]interface{}{
+		"source":    "temporary_unlock",
+		"relock_at": relockAt,
+	})
+
+	response := TemporaryUnlockResponse{
+		Success:  true,
+		Message:  "Device unlocked until " + relockAt,
+		RelockAt: *req.RelockAt,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// relockIfDue locks a device again once the relock_at of its temporary
+// unlock has passed, regardless of its terms, and clears relock_at. It
+// reports whether the device was relocked.
+func relockIfDue(ctx context.Context, deviceID string) (bool, error) {
+	now := time.Now()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var serialNumber string
+	var relockAt time.Time
+	err = tx.QueryRowContext(ctx, `
+		UPDATE devices d SET is_locked = true, relock_at = NULL
+		FROM devices prev
+		WHERE d.id = $1 AND prev.id = d.id AND d.deleted_at IS NULL AND d.relock_at <= $2
+		RETURNING d.serial_number, prev.relock_at
+	`, deviceID, now).Scan(&serialNumber, &relockAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO remote_locks (id, device_id, is_locked, created_at, updated_at) VALUES ($1, $2, true, $3, $3)
+		ON CONFLICT (device_id) DO UPDATE SET is_locked = true, updated_at = EXCLUDED.updated_at
+	`, uuid.New().String(), deviceID, now)
+	if err != nil {
+		return false, err
+	}
+
+	if err = appendLockEvent(ctx, tx, deviceID, true, lockSourceRelock); err != nil {
+		return false, err
+	}
+
+	details := "Relocked automatically: temporary unlock ended at " + relockAt.UTC().Format(time.RFC3339)
+	if err = appendAudit(ctx, tx, deviceID, "relock", systemActor, details); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	logEvent(ctx, slog.LevelInfo, "relock", "Device relocked after temporary unlock", slog.String("device_id", deviceID))
+	locksTotal.Add(1)
+	notifyDeviceLocked(deviceID)
+	dispatchWebhook(webhookEventLocked, deviceID, serialNumber, map[string]interface{}{"source": "relock"})
+	return true, nil
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type DeviceTermsResponse struct {
+	Success      bool         `json:"success"`
+	SerialNumber string       `json:"serial_number"`
+	Terms        []DeviceTerm `json:"terms"`
+}
+
+type AdminDeviceTermsResponse struct {
+	Success      bool                      `json:"success"`
+	SerialNumber string                    `json:"serial_number"`
+	Terms        []TermWithLockDateAndCode `json:"terms"`
+}
+
+// fetchTerms returns a device's payment schedule: each term with its lock
+// date, activation code, and whether it has been used and paid. Codes and
+// lock dates are joined on term_number so each term keeps its own pair.
+func fetchTerms(ctx context.Context, deviceID string) ([]TermWithLockDateAndCode, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT ac.term_number, ac.code, ac.is_used, ac.used_at, ld.lock_date, ld.paid_at IS NOT NULL
+		FROM activation_codes ac
+		JOIN lock_dates ld ON ld.device_id = ac.device_id AND ld.term_number = ac.term_number
+		WHERE ac.device_id = $1
+		ORDER BY ac.term_number
+	`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	terms := make([]TermWithLockDateAndCode, 0)
+	for rows.Next() {
+		var term TermWithLockDateAndCode
+		var usedAt *time.Time
+		var lockDate time.Time
+		if err := rows.Scan(&term.Term, &term.ActivationCode, &term.IsUsed, &usedAt, &lockDate, &term.IsPaid); err != nil {
+			return nil, err
+		}
+		term.LockDate = lockDate.Format("2006-01-02")
+		term.IsExpired = term.IsUsed
+		if usedAt != nil {
+			formatted := usedAt.Format("2006-01-02 15:04:05")
+			term.UsedAt = &formatted
+		}
+		terms = append(terms, term)
+	}
+	return terms, rows.Err()
+}
+
+// withoutCodes strips the activation codes from terms for the TV-facing
+// endpoints
+func withoutCodes(terms []TermWithLockDateAndCode) []DeviceTerm {
+	stripped := make([]DeviceTerm, 0, len(terms))
+	for _, term := range terms {
+		stripped = append(stripped, DeviceTerm{
+			Term:      term.Term,
+			LockDate:  term.LockDate,
+			IsExpired: term.IsExpired,
+			IsUsed:    term.IsUsed,
+			IsPaid:    term.IsPaid,
+			UsedAt:    term.UsedAt,
+		})
+	}
+	return stripped
+}
+
+// getDeviceTerms returns the schedule a TV shows, without activation codes
+func getDeviceTerms(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	var deviceID string
+	err := db.QueryRowContext(ctx,
+		"SELECT id FROM devices WHERE serial_number = $1 AND deleted_at IS NULL",
+		serialNumber,
+	).Scan(&deviceID)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+	if !authorizeDevice(w, r, deviceID) {
+		return
+	}
+
+	terms, err := fetchTerms(ctx, deviceID)
+	if err != nil {
+		errorf(ctx, "Error fetching terms for device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch terms")
+		return
+	}
+
+	response := DeviceTermsResponse{
+		Success:      true,
+		SerialNumber: serialNumber,
+		Terms:        withoutCodes(terms),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// getAdminDeviceTerms returns the schedule with each term's activation code,
+// for dealers handing codes to customers
+func getAdminDeviceTerms(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	var deviceID string
+	err := db.QueryRowContext(ctx,
+		"SELECT id FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2)",
+		serialNumber, dealerArg(r),
+	).Scan(&deviceID)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	terms, err := fetchTerms(ctx, deviceID)
+	if err != nil {
+		errorf(ctx, "Error fetching terms for device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch terms")
+		return
+	}
+
+	response := AdminDeviceTermsResponse{
+		Success:      true,
+		SerialNumber: serialNumber,
+		Terms:        terms,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
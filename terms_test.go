@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// termRows returns rows as selected by fetchTerms
+func termRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"term_number", "code", "is_used", "used_at", "lock_date", "paid"})
+}
+
+func TestFetchTermsKeepsCodesWithDuplicateLockDates(t *testing.T) {
+	mock := mockDB(t)
+	sameDay := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT id FROM devices WHERE serial_number").
+		WithArgs("TV100001", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("d1"))
+	// Terms 2 and 3 share a lock date, which used to shuffle their codes
+	mock.ExpectQuery(regexp.QuoteMeta("ld.term_number = ac.term_number")).
+		WithArgs("d1").
+		WillReturnRows(termRows().
+			AddRow(1, "CODE-ONE", false, nil, sameDay.AddDate(0, -1, 0), false).
+			AddRow(2, "CODE-TWO", false, nil, sameDay, false).
+			AddRow(3, "CODE-THREE", false, nil, sameDay, false))
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/admin/device/TV100001/terms", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body AdminDeviceTermsResponse
+	decodeResponse(t, rec, &body)
+	want := map[int]string{1: "CODE-ONE", 2: "CODE-TWO", 3: "CODE-THREE"}
+	if len(body.Terms) != len(want) {
+		t.Fatalf("got %d terms, want %d", len(body.Terms), len(want))
+	}
+	for _, term := range body.Terms {
+		if term.ActivationCode != want[term.Term] {
+			t.Errorf("term %d code = %q, want %q", term.Term, term.ActivationCode, want[term.Term])
+		}
+	}
+	if body.Terms[1].LockDate != body.Terms[2].LockDate {
+		t.Errorf("terms 2 and 3 lock dates = %s, %s, want the same day", body.Terms[1].LockDate, body.Terms[2].LockDate)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestDeviceTermsEndpoint(t *testing.T) {
+	mock := mockDB(t)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	usedAt := start.AddDate(0, 0, 31)
+
+	expectDeviceLookup(mock, "d1")
+	mock.ExpectQuery(regexp.QuoteMeta("ld.term_number = ac.term_number")).
+		WithArgs("d1").
+		WillReturnRows(termRows().
+			AddRow(1, "CODE-ONE", true, usedAt, start.AddDate(0, 0, 30), true).
+			AddRow(2, "CODE-TWO", false, nil, start.AddDate(0, 0, 60), false))
+
+	rec := serve(httptest.NewRequest(http.MethodGet, "/api/device/TV100001/terms", nil))
+
+	assertStatus(t, rec, http.StatusOK)
+	if strings.Contains(rec.Body.String(), "CODE-") {
+		t.Errorf("TV-facing terms leak activation codes: %s", rec.Body.String())
+	}
+	var body DeviceTermsResponse
+	decodeResponse(t, rec, &body)
+	want := []DeviceTerm{
+		{Term: 1, LockDate: "2026-01-31", IsExpired: true, IsUsed: true, IsPaid: true},
+		{Term: 2, LockDate: "2026-03-02"},
+	}
+	if body.SerialNumber != "TV100001" || len(body.Terms) != len(want) {
+		t.Fatalf("response = %+v, want two terms of TV100001", body)
+	}
+	for i, term := range body.Terms {
+		term.UsedAt = nil
+		if term != want[i] {
+			t.Errorf("term %d = %+v, want %+v", i+1, term, want[i])
+		}
+	}
+	if body.Terms[0].UsedAt == nil || body.Terms[1].UsedAt != nil {
+		t.Errorf("used_at = %v, %v, want only the used term stamped", body.Terms[0].UsedAt, body.Terms[1].UsedAt)
+	}
+	assertExpectations(t, mock)
+}
+
+func TestCheckActivationOmitsCodes(t *testing.T) {
+	mock := mockDB(t)
+	lockDate := time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT id, is_active, retired_at FROM devices").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "is_active", "retired_at"}).AddRow("d1", true, nil))
+	mock.ExpectExec("UPDATE devices SET last_seen_at = NOW\\(\\)").WithArgs("d1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM activation_codes ac").WithArgs("d1").
+		WillReturnRows(termRows().AddRow(1, "CODE-ONE", false, nil, lockDate, false))
+
+	rec := serve(httptest.NewRequest(http.MethodGet, "/api/check?serial_number=TV100001", nil))
+
+	assertStatus(t, rec, http.StatusOK)
+	if strings.Contains(rec.Body.String(), "activation_code") || strings.Contains(rec.Body.String(), "CODE-ONE") {
+		t.Errorf("/api/check leaks activation codes: %s", rec.Body.String())
+	}
+	assertExpectations(t, mock)
+}
+
+func TestAdminTermsIncludeCodes(t *testing.T) {
+	// The admin endpoint is authenticated by API key, not a device token
+	t.Setenv("DEVICE_TOKEN_SECRET", "test-device-secret")
+	mock := mockDB(t)
+	expectDeviceLookup(mock, "d1")
+	mock.ExpectQuery(regexp.QuoteMeta("ld.term_number = ac.term_number")).WithArgs("d1").
+		WillReturnRows(termRows().AddRow(1, "CODE-ONE", false, nil, time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC), false))
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/admin/device/TV100001/terms", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body AdminDeviceTermsResponse
+	decodeResponse(t, rec, &body)
+	if len(body.Terms) != 1 || body.Terms[0].ActivationCode != "CODE-ONE" {
+		t.Errorf("terms = %+v, want the activation code", body.Terms)
+	}
+	assertExpectations(t, mock)
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Window bounds for the upcoming locks worklist, in days
+const (
+	defaultUpcomingLockDays = 7
+	maxUpcomingLockDays     = 90
+)
+
+type UpcomingLock struct {
+	SerialNumber string `json:"serial_number"`
+	CustomerName string `json:"customer_name"`
+	PhoneNumber  string `json:"phone_number"`
+	TermNumber   int    `json:"term_number"`
+	LockDate     string `json:"lock_date"` // Due date of the term; enforced after grace_days
+	GraceDays    int    `json:"grace_days"`
+}
+
+type UpcomingLocksResponse struct {
+	Success bool           `json:"success"`
+	Days    int            `json:"days"`
+	Total   int            `json:"total"`
+	Devices []UpcomingLock `json:"devices"`
+}
+
+// getUpcomingLocks lists devices whose earliest unpaid term falls due between
+// today and today plus days, soonest first, so operators can chase payments
+// before the lock is enforced. Retired devices are never locked and are left
+// out. "Today" is taken in DEVICE_TIMEZONE.
+func getUpcomingLocks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	days, ok := parseNonNegativeInt(r, "days", defaultUpcomingLockDays)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid_days", "days must be a non-negative integer")
+		return
+	}
+	if days > maxUpcomingLockDays {
+		days = maxUpcomingLockDays
+	}
+
+	y, m, d := time.Now().In(deviceTimezone()).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT serial_number, customer_name, phone_number, term_number, lock_date, grace_days
+		FROM (
+			SELECT DISTINCT ON (d.id)
+				d.serial_number, d.customer_name, d.phone_number, ld.term_number, ld.lock_date, d.grace_days
+			FROM devices d
+			JOIN lock_dates ld ON ld.device_id = d.id
+			WHERE d.deleted_at IS NULL AND d.retired_at IS NULL AND ($1::uuid IS NULL OR d.dealer_id = $1)
+			  AND ld.paid_at IS NULL
+			  AND ld.lock_date BETWEEN $2::date AND $3::date
+			ORDER BY d.id, ld.lock_date
+		) upcoming
+		ORDER BY lock_date, serial_number
+	`, dealerArg(r), today, today.AddDate(0, 0, days))
+	if err != nil {
+		errorf(ctx, "Error fetching upcoming locks: %v", err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch upcoming locks")
+		return
+	}
+	defer rows.Close()
+
+	devices := make([]UpcomingLock, 0)
+	for rows.Next() {
+		var upcoming UpcomingLock
+		var lockDate time.Time
+		if err := rows.Scan(&upcoming.SerialNumber, &upcoming.CustomerName, &upcoming.PhoneNumber, &upcoming.TermNumber, &lockDate, &upcoming.GraceDays); err != nil {
+			errorf(ctx, "Error scanning upcoming lock: %v", err)
+			continue
+		}
+		upcoming.LockDate = lockDate.Format("2006-01-02")
+		devices = append(devices, upcoming)
+	}
+	if err = rows.Err(); err != nil {
+		errorf(ctx, "Error iterating upcoming locks: %v", err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to fetch upcoming locks")
+		return
+	}
+
+	response := UpcomingLocksResponse{
+		Success: true,
+		Days:    days,
+		Total:   len(devices),
+		Devices: devices,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestUpcomingLocksWindow(t *testing.T) {
+	y, m, d := time.Now().In(deviceTimezone()).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query    string
+		wantDays int
+	}{
+		{"", defaultUpcomingLockDays},
+		{"?days=3", 3},
+		{"?days=365", maxUpcomingLockDays},
+	}
+	for _, tt := range tests {
+		mock := mockDB(t)
+		mock.ExpectQuery("ld.lock_date BETWEEN \\$2::date AND \\$3::date").WithArgs(nil, today, today.AddDate(0, 0, tt.wantDays)).
+			WillReturnRows(sqlmock.NewRows([]string{"serial_number", "customer_name", "phone_number", "term_number", "lock_date", "grace_days"}).
+				AddRow("TV100001", "Test Customer", "+15551234567", 2, today.AddDate(0, 0, 1), 0))
+
+		rec := serve(apiRequest(t, http.MethodGet, "/api/devices/upcoming-locks"+tt.query, ""))
+
+		assertStatus(t, rec, http.StatusOK)
+		var body UpcomingLocksResponse
+		decodeResponse(t, rec, &body)
+		if body.Days != tt.wantDays || body.Total != 1 || body.Devices[0].LockDate != today.AddDate(0, 0, 1).Format("2006-01-02") {
+			t.Errorf("%q: response = %+v, want a %d day window", tt.query, body, tt.wantDays)
+		}
+		assertExpectations(t, mock)
+	}
+}
+
+func TestUpcomingLocksSkipRetiredDevices(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectQuery("WHERE d.deleted_at IS NULL AND d.retired_at IS NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"serial_number", "customer_name", "phone_number", "term_number", "lock_date", "grace_days"}))
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/devices/upcoming-locks", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	assertExpectations(t, mock)
+}
+
+// Seeds lock dates around the window in a real database, so the window and
+// the earliest-unpaid-term selection are exercised by the query itself
+func TestUpcomingLocksListsOnlyDevicesInWindow(t *testing.T) {
+	conn := integrationDB(t)
+	dealerID, apiKey := integrationDealer(t, conn)
+
+	seeds := []struct {
+		serialNumber string
+		lockDays     []int // days from today of each term's lock date
+		paidTerms    int
+		retired      bool
+	}{
+		{"UPCOMINGA", []int{5}, 0, false},      // in the window
+		{"UPCOMINGB", []int{0}, 0, false},      // due today
+		{"UPCOMINGC", []int{-1}, 0, false},     // already overdue
+		{"UPCOMINGD", []int{8}, 0, false},      // after the window
+		{"UPCOMINGE", []int{-20, 2}, 1, false}, // first term paid, second due soon
+		{"UPCOMINGF", []int{3}, 1, false},      // paid
+		{"UPCOMINGG", []int{4}, 0, true},       // in the window but retired
+	}
+	for _, seed := range seeds {
+		var deviceID string
+		err := conn.QueryRow(`
+			INSERT INTO devices (serial_number, customer_name, phone_number, emi_term, emi_start_date, term_duration, dealer_id, retired_at)
+			VALUES ($1, 'Upcoming Customer', '+15551234567', $2, CURRENT_DATE, 30, $3, CASE WHEN $4::boolean THEN NOW() END) RETURNING id
+		`, seed.serialNumber+dealerID[:8], len(seed.lockDays), dealerID, seed.retired).Scan(&deviceID)
+		if err != nil {
+			t.Fatalf("seeding device: %v", err)
+		}
+		for i, days := range seed.lockDays {
+			_, err = conn.Exec(`
+				INSERT INTO lock_dates (device_id, term_number, lock_date, paid_at)
+				VALUES ($1, $2, CURRENT_DATE + $3::integer, CASE WHEN $4::boolean THEN NOW() END)
+			`, deviceID, i+1, days, i < seed.paidTerms)
+			if err != nil {
+				t.Fatalf("seeding lock date: %v", err)
+			}
+		}
+	}
+
+	r := apiRequest(t, http.MethodGet, "/api/devices/upcoming-locks?days=7", "")
+	r.Header.Set("X-API-Key", apiKey)
+	rec := serve(r)
+
+	assertStatus(t, rec, http.StatusOK)
+	var body UpcomingLocksResponse
+	decodeResponse(t, rec, &body)
+	want := []string{"UPCOMINGB", "UPCOMINGE", "UPCOMINGA"}
+	if len(body.Devices) != len(want) {
+		t.Fatalf("devices = %+v, want %v", body.Devices, want)
+	}
+	for i, serialPrefix := range want {
+		if body.Devices[i].SerialNumber != serialPrefix+dealerID[:8] {
+			t.Errorf("devices[%d] = %s, want %s", i, body.Devices[i].SerialNumber, serialPrefix)
+		}
+	}
+	if body.Devices[1].TermNumber != 2 {
+		t.Errorf("UPCOMINGE term = %d, want the unpaid term 2", body.Devices[1].TermNumber)
+	}
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Schedule problem codes reported by validateSchedule
+const (
+	problemTermCountMismatch       = "term_count_mismatch"
+	problemMissingActivationCode   = "missing_activation_code"
+	problemDuplicateActivationCode = "duplicate_activation_code"
+	problemMissingLockDate         = "missing_lock_date"
+	problemDuplicateLockDate       = "duplicate_lock_date"
+	problemLockDateNotIncreasing   = "lock_date_not_increasing"
+)
+
+// ScheduleProblem is one broken invariant in a device's schedule. Term is
+// nil for a problem with the schedule as a whole.
+type ScheduleProblem struct {
+	Code    string `json:"code"`
+	Term    *int   `json:"term,omitempty"`
+	Message string `json:"message"`
+}
+
+type ScheduleValidationResponse struct {
+	Success      bool              `json:"success"`
+	SerialNumber string            `json:"serial_number"`
+	EMITerm      int               `json:"emi_term"`
+	Valid        bool              `json:"valid"`
+	Problems     []ScheduleProblem `json:"problems"`
+}
+
+// scheduleTerm is what a device's activation codes and lock dates hold for
+// one term number. ScheduledLockDate is the earliest lock date before any
+// extension, nil when the term has no lock date.
+type scheduleTerm struct {
+	Term              int
+	Codes             int
+	LockDates         int
+	ScheduledLockDate *time.Time
+}
+
+// fetchScheduleTerms counts each term number's activation codes and lock
+// dates, including term numbers that appear in only one of the two tables
+func fetchScheduleTerms(ctx context.Context, deviceID string) ([]scheduleTerm, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT COALESCE(ac.term_number, ld.term_number) AS term_number,
+			COALESCE(ac.codes, 0), COALESCE(ld.lock_dates, 0), ld.scheduled_lock_date
+		FROM (
+			SELECT term_number, COUNT(*) AS codes FROM activation_codes WHERE device_id = $1 GROUP BY term_number
+		) ac
+		FULL JOIN (
+			SELECT term_number, COUNT(*) AS lock_dates, MIN(lock_date - extension_days) AS scheduled_lock_date
+			FROM lock_dates WHERE device_id = $1 GROUP BY term_number
+		) ld ON ld.term_number = ac.term_number
+		ORDER BY term_number
+	`, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	terms := make([]scheduleTerm, 0)
+	for rows.Next() {
+		var term scheduleTerm
+		if err := rows.Scan(&term.Term, &term.Codes, &term.LockDates, &term.ScheduledLockDate); err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	return terms, rows.Err()
+}
+
+// scheduleProblems checks a schedule, ordered by term number, against
+// emi_term: there is one term per installment, each with exactly one
+// activation code and one lock date, and lock dates strictly increase from
+// term to term. Lock dates are compared before extensions, since a partial
+// payment legitimately pushes back one term's date.
+func scheduleProblems(emiTerm int, terms []scheduleTerm) []ScheduleProblem {
+	problems := make([]ScheduleProblem, 0)
+	add := func(code string, term *int, format string, args ...interface{}) {
+		problems = append(problems, ScheduleProblem{Code: code, Term: term, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(terms) != emiTerm {
+		add(problemTermCountMismatch, nil, "Schedule has %d terms but emi_term is %d", len(terms), emiTerm)
+	}
+
+	var previous *scheduleTerm
+	for i := range terms {
+		term := &terms[i]
+		number := term.Term
+		switch {
+		case term.Codes == 0:
+			add(problemMissingActivationCode, &number, "Term %d has no activation code", number)
+		case term.Codes > 1:
+			add(problemDuplicateActivationCode, &number, "Term %d has %d activation codes", number, term.Codes)
+		}
+		switch {
+		case term.LockDates == 0:
+			add(problemMissingLockDate, &number, "Term %d has no lock date", number)
+		case term.LockDates > 1:
+			add(problemDuplicateLockDate, &number, "Term %d has %d lock dates", number, term.LockDates)
+		}
+
+		if term.ScheduledLockDate == nil {
+			continue
+		}
+		if previous != nil && !term.ScheduledLockDate.After(*previous.ScheduledLockDate) {
+			add(problemLockDateNotIncreasing, &number, "Term %d lock date %s is not after term %d lock date %s",
+				number, term.ScheduledLockDate.Format("2006-01-02"),
+				previous.Term, previous.ScheduledLockDate.Format("2006-01-02"))
+		}
+		previous = term
+	}
+	return problems
+}
+
+// validateSchedule audits a device's schedule for data left inconsistent by
+// the old index-based pairing of codes and lock dates, and lists every
+// problem found. It only reads; repairSchedule fixes what it reports.
+func validateSchedule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	serialNumber := normalizeSerialNumber(mux.Vars(r)["serial"])
+
+	var deviceID string
+	var emiTerm int
+	err := db.QueryRowContext(ctx,
+		"SELECT id, emi_term FROM devices WHERE serial_number = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR dealer_id = $2)",
+		serialNumber, dealerArg(r),
+	).Scan(&deviceID, &emiTerm)
+	if err != nil {
+		writeDeviceLookupError(w, r, err)
+		return
+	}
+
+	terms, err := fetchScheduleTerms(ctx, deviceID)
+	if err != nil {
+		errorf(ctx, "Error fetching schedule for device %s: %v", deviceID, err)
+		writeDBError(w, r, err, "fetch_failed", "Failed to validate schedule")
+		return
+	}
+
+	problems := scheduleProblems(emiTerm, terms)
+	if len(problems) > 0 {
+		warnf(ctx, "Device %s schedule has %d problems", deviceID, len(problems))
+	}
+
+	response := ScheduleValidationResponse{
+		Success:      true,
+		SerialNumber: serialNumber,
+		EMITerm:      emiTerm,
+		Valid:        len(problems) == 0,
+		Problems:     problems,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
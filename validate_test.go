@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+// problemList renders problems as "code@term" for comparison, with "code"
+// alone for a schedule-wide problem
+func problemList(problems []ScheduleProblem) []string {
+	list := make([]string, len(problems))
+	for i, problem := range problems {
+		list[i] = problem.Code
+		if problem.Term != nil {
+			list[i] += fmt.Sprintf("@%d", *problem.Term)
+		}
+	}
+	return list
+}
+
+func TestScheduleProblems(t *testing.T) {
+	day := func(d int) *time.Time {
+		at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, d)
+		return &at
+	}
+	tests := []struct {
+		name    string
+		emiTerm int
+		terms   []scheduleTerm
+		want    []string
+	}{
+		{"clean", 3, []scheduleTerm{{1, 1, 1, day(30)}, {2, 1, 1, day(60)}, {3, 1, 1, day(90)}}, []string{}},
+		{"too few terms", 3, []scheduleTerm{{1, 1, 1, day(30)}, {2, 1, 1, day(60)}}, []string{problemTermCountMismatch}},
+		{"code without lock date", 2, []scheduleTerm{{1, 1, 1, day(30)}, {2, 1, 0, nil}}, []string{problemMissingLockDate + "@2"}},
+		{"lock date without code", 2, []scheduleTerm{{1, 0, 1, day(30)}, {2, 1, 1, day(60)}}, []string{problemMissingActivationCode + "@1"}},
+		{"duplicates", 1, []scheduleTerm{{1, 2, 2, day(30)}}, []string{problemDuplicateActivationCode + "@1", problemDuplicateLockDate + "@1"}},
+		{"swapped dates", 3, []scheduleTerm{{1, 1, 1, day(60)}, {2, 1, 1, day(30)}, {3, 1, 1, day(90)}}, []string{problemLockDateNotIncreasing + "@2"}},
+		{"same date twice", 2, []scheduleTerm{{1, 1, 1, day(30)}, {2, 1, 1, day(30)}}, []string{problemLockDateNotIncreasing + "@2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := problemList(scheduleProblems(tt.emiTerm, tt.terms)); !slices.Equal(got, tt.want) {
+				t.Errorf("problems = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCorruptedDeviceReportsProblems(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectQuery("SELECT id, emi_term FROM devices").WithArgs("TV100001", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "emi_term"}).AddRow("d1", 4))
+	first, second := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FULL JOIN").WithArgs("d1").
+		WillReturnRows(sqlmock.NewRows([]string{"term_number", "codes", "lock_dates", "scheduled_lock_date"}).
+			AddRow(1, 1, 1, first).
+			AddRow(2, 1, 1, second).
+			AddRow(3, 0, 1, first.AddDate(0, 2, 0)))
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/device/tv100001/validate", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body ScheduleValidationResponse
+	decodeResponse(t, rec, &body)
+	want := []string{problemTermCountMismatch, problemLockDateNotIncreasing + "@2", problemMissingActivationCode + "@3"}
+	if body.Valid || body.EMITerm != 4 || !slices.Equal(problemList(body.Problems), want) {
+		t.Errorf("response = %+v, want invalid with problems %v", body, want)
+	}
+	for _, problem := range body.Problems {
+		if problem.Message == "" {
+			t.Errorf("problem %s has no message", problem.Code)
+		}
+	}
+	assertExpectations(t, mock)
+}
+
+// The term grouping is done in SQL, so a corrupted schedule is seeded in a
+// real database too
+func TestValidateSeededCorruptedDevice(t *testing.T) {
+	conn := integrationDB(t)
+	serialNumber := "IT" + strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+	var deviceID string
+	err := conn.QueryRow(`
+		INSERT INTO devices (serial_number, customer_name, phone_number, emi_term, emi_start_date, term_duration)
+		VALUES ($1, 'Corrupt Customer', '+15551234567', 3, CURRENT_DATE, 30) RETURNING id
+	`, serialNumber).Scan(&deviceID)
+	if err != nil {
+		t.Fatalf("seeding device: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Exec("DELETE FROM devices WHERE id = $1", deviceID)
+	})
+	// Term 1 is whole; term 2 locks before it and has no code; term 3 has a
+	// code but no lock date
+	seed := []string{
+		"INSERT INTO activation_codes (device_id, code, term_number) VALUES ($1, $2::text || 'A', 1)",
+		"INSERT INTO lock_dates (device_id, term_number, lock_date) VALUES ($1, 1, CURRENT_DATE + 60)",
+		"INSERT INTO lock_dates (device_id, term_number, lock_date) VALUES ($1, 2, CURRENT_DATE + 30)",
+		"INSERT INTO activation_codes (device_id, code, term_number) VALUES ($1, $2::text || 'C', 3)",
+	}
+	for _, statement := range seed {
+		args := []interface{}{deviceID}
+		if strings.Contains(statement, "$2") {
+			args = append(args, serialNumber)
+		}
+		if _, err = conn.Exec(statement, args...); err != nil {
+			t.Fatalf("seeding schedule: %v", err)
+		}
+	}
+
+	rec := serve(apiRequest(t, http.MethodGet, "/api/device/"+serialNumber+"/validate", ""))
+
+	assertStatus(t, rec, http.StatusOK)
+	var body ScheduleValidationResponse
+	decodeResponse(t, rec, &body)
+	want := []string{problemMissingActivationCode + "@2", problemLockDateNotIncreasing + "@2", problemMissingLockDate + "@3"}
+	if body.Valid || !slices.Equal(problemList(body.Problems), want) {
+		t.Errorf("problems = %v, want %v", problemList(body.Problems), want)
+	}
+}
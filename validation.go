@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// defaultSerialNumberPattern allows letters, digits, and dashes, 4-64 long
+const defaultSerialNumberPattern = `^[A-Za-z0-9-]{4,64}$`
+
+const maxCustomerNameLength = 100
+
+// maxEMITerm bounds the number of terms, and so the activation codes and
+// lock dates inserted, for one registration
+const maxEMITerm = 60
+
+// Default bounds on how far emi_start_date may be from today, overridable
+// with EMI_START_MAX_PAST_DAYS and EMI_START_MAX_FUTURE_DAYS
+const (
+	defaultEMIStartMaxPastDays   = 365
+	defaultEMIStartMaxFutureDays = 5 * 365
+)
+
+var serialPatternOnce sync.Once
+var serialPattern *regexp.Regexp
+
+// serialNumberPattern returns the compiled SERIAL_NUMBER_PATTERN, falling
+// back to the default when it is unset or invalid
+func serialNumberPattern() *regexp.Regexp {
+	serialPatternOnce.Do(func() {
+		pattern := os.Getenv("SERIAL_NUMBER_PATTERN")
+		if pattern != "" {
+			compiled, err := regexp.Compile(pattern)
+			if err == nil {
+				serialPattern = compiled
+				return
+			}
+			warnf(context.Background(), "Invalid SERIAL_NUMBER_PATTERN %q, using default: %v", pattern, err)
+		}
+		serialPattern = regexp.MustCompile(defaultSerialNumberPattern)
+	})
+	return serialPattern
+}
+
+// normalizeSerialNumber returns the canonical form serial numbers are stored
+// and looked up in: trimmed and uppercase, so a TV reporting "abc123" finds
+// the device registered as "ABC123"
+func normalizeSerialNumber(raw string) string {
+	return strings.ToUpper(strings.TrimSpace(raw))
+}
+
+// validateSerialNumber normalizes a serial number and checks it against the
+// configured pattern
+func validateSerialNumber(raw string) (string, error) {
+	serial := normalizeSerialNumber(raw)
+	if serial == "" {
+		return "", errors.New("serial_number is required")
+	}
+	if !serialNumberPattern().MatchString(serial) {
+		return "", errors.New("serial_number has an invalid format")
+	}
+	return serial, nil
+}
+
+// validateCustomerName trims a customer name and checks its length
+func validateCustomerName(raw string) (string, error) {
+	name := strings.TrimSpace(raw)
+	if name == "" {
+		return "", errors.New("customer_name is required")
+	}
+	if utf8.RuneCountInString(name) > maxCustomerNameLength {
+		return "", fmt.Errorf("customer_name must be at most %d characters", maxCustomerNameLength)
+	}
+	return name, nil
+}
+
+// phoneSeparators are stripped from phone numbers before validation
+var phoneSeparators = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "")
+
+// validatePhone normalizes a phone number to E.164 form ("+" followed by
+// 8-15 digits). Numbers without a leading "+" are prefixed with the
+// DEFAULT_COUNTRY_CODE environment variable, dropping a national trunk "0".
+func validatePhone(raw string) (string, error) {
+	phone := phoneSeparators.Replace(strings.TrimSpace(raw))
+	if phone == "" {
+		return "", errors.New("phone_number is required")
+	}
+
+	var digits string
+	if strings.HasPrefix(phone, "+") {
+		digits = phone[1:]
+	} else {
+		countryCode := strings.TrimPrefix(os.Getenv("DEFAULT_COUNTRY_CODE"), "+")
+		if countryCode == "" {
+			return "", errors.New("phone_number must start with + and a country code")
+		}
+		digits = countryCode + strings.TrimPrefix(phone, "0")
+	}
+
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return "", errors.New("phone_number may only contain digits, spaces, dashes, and a leading +")
+		}
+	}
+	if len(digits) < 8 || len(digits) > 15 {
+		return "", errors.New("phone_number must have between 8 and 15 digits")
+	}
+
+	return "+" + digits, nil
+}
+
+// emiStartDateFormats are the layouts accepted for emi_start_date, tried in
+// order: a plain date, or a full RFC 3339 timestamp as many clients send
+var emiStartDateFormats = []string{"2006-01-02", time.RFC3339}
+
+// parseEMIStartDate parses emi_start_date in any of emiStartDateFormats and
+// normalizes it to midnight UTC. A timestamp keeps the calendar date of its
+// own offset, so "2024-01-15T23:30:00-05:00" is 2024-01-15.
+func parseEMIStartDate(raw string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	for _, layout := range emiStartDateFormats {
+		if t, err := time.Parse(layout, raw); err == nil {
+			y, m, d := t.Date()
+			return time.Date(y, m, d, 0, 0, 0, 0, time.UTC), nil
+		}
+	}
+	return time.Time{}, errors.New("emi_start_date must be a date (YYYY-MM-DD) or an RFC 3339 timestamp (YYYY-MM-DDTHH:MM:SSZ)")
+}
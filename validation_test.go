@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidatePhone(t *testing.T) {
+	tests := []struct {
+		name        string
+		raw         string
+		countryCode string
+		want        string
+		wantErr     bool
+	}{
+		{"e164", "+15551234567", "", "+15551234567", false},
+		{"separators", "+1 (555) 123-4567", "", "+15551234567", false},
+		{"surrounding space", "  +447911123456 ", "", "+447911123456", false},
+		{"default country code", "07911 123456", "44", "+447911123456", false},
+		{"default country code with plus", "5551234567", "+1", "+15551234567", false},
+		{"shortest", "+12345678", "", "+12345678", false},
+		{"longest", "+123456789012345", "", "+123456789012345", false},
+		{"empty", "", "", "", true},
+		{"blank", "   ", "", "", true},
+		{"no country code", "5551234567", "", "", true},
+		{"letters", "+1555CALLNOW", "", "", true},
+		{"inner plus", "+1555+1234567", "", "", true},
+		{"too short", "+1234567", "", "", true},
+		{"too long", "+1234567890123456", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEFAULT_COUNTRY_CODE", tt.countryCode)
+			got, err := validatePhone(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validatePhone(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("validatePhone(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterDeviceRejectsInvalidPhone(t *testing.T) {
+	body := strings.Replace(registrationBody("TV100001", 3), "+15551234567", "555-CALL-NOW", 1)
+
+	fieldErrs := registerInvalid(t, body)
+
+	if len(fieldErrs) != 1 || fieldErrs[0].Field != "phone_number" {
+		t.Errorf("errors = %+v, want one naming phone_number", fieldErrs)
+	}
+}
+
+func TestValidateSerialNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"valid", "TV-1234", "TV-1234", false},
+		{"normalized", "  tv1234 ", "TV1234", false},
+		{"shortest", "ABCD", "ABCD", false},
+		{"longest", strings.Repeat("A", 64), strings.Repeat("A", 64), false},
+		{"empty", "", "", true},
+		{"blank", "   ", "", true},
+		{"too short", "ABC", "", true},
+		{"too long", strings.Repeat("A", 65), "", true},
+		{"space", "TV 1234", "", true},
+		{"underscore", "TV_1234", "", true},
+		{"punctuation", "TV1234;", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateSerialNumber(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateSerialNumber(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("validateSerialNumber(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCustomerName(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"valid", "Jane Doe", "Jane Doe", false},
+		{"trimmed", "  Jane Doe  ", "Jane Doe", false},
+		{"longest", strings.Repeat("é", maxCustomerNameLength), strings.Repeat("é", maxCustomerNameLength), false},
+		{"empty", "", "", true},
+		{"blank", " \t ", "", true},
+		{"too long", strings.Repeat("a", maxCustomerNameLength+1), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateCustomerName(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateCustomerName(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("validateCustomerName(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeSerialNumber(t *testing.T) {
+	for _, raw := range []string{"abc123", "ABC123", "  AbC123\t"} {
+		if got := normalizeSerialNumber(raw); got != "ABC123" {
+			t.Errorf("normalizeSerialNumber(%q) = %q, want ABC123", raw, got)
+		}
+	}
+}
+
+func TestParseEMIStartDate(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"2024-01-15", "2024-01-15"},
+		{" 2024-01-15 ", "2024-01-15"},
+		{"2024-01-15T10:00:00Z", "2024-01-15"},
+		{"2024-01-15T23:30:00-05:00", "2024-01-15"},
+		{"2024-01-15T00:30:00+09:00", "2024-01-15"},
+		{"2024-01-15T10:00:00.123Z", "2024-01-15"},
+	}
+	for _, tt := range tests {
+		got, err := parseEMIStartDate(tt.raw)
+		if err != nil || got.Format("2006-01-02") != tt.want || got.Location() != time.UTC || got.Hour() != 0 {
+			t.Errorf("parseEMIStartDate(%q) = %v, %v, want midnight UTC on %s", tt.raw, got, err, tt.want)
+		}
+	}
+
+	for _, raw := range []string{"", "01/15/2024", "2024-01-15 10:00:00", "2024-02-30", "15 Jan 2024"} {
+		if got, err := parseEMIStartDate(raw); err == nil {
+			t.Errorf("parseEMIStartDate(%q) = %v, want an error", raw, got)
+		}
+	}
+}
+
+func TestRegisterDeviceAcceptsTimestampStartDate(t *testing.T) {
+	mock := mockDB(t)
+	expectRegistration(mock, 1)
+	today := time.Now().UTC().Format("2006-01-02")
+	body := strings.Replace(registrationBody("TV100001", 1), `"`+today+`"`, `"`+today+`T09:15:00Z"`, 1)
+
+	rec := serve(apiRequest(t, http.MethodPost, "/api/register", body))
+
+	assertStatus(t, rec, http.StatusOK)
+	assertExpectations(t, mock)
+}
+
+func TestRegisterDeviceRejectsUnknownDateFormat(t *testing.T) {
+	today := time.Now().UTC()
+	body := strings.Replace(registrationBody("TV100001", 1), today.Format("2006-01-02"), today.Format("01/02/2006"), 1)
+
+	fieldErrs := registerInvalid(t, body)
+
+	if len(fieldErrs) != 1 || fieldErrs[0].Field != "emi_start_date" || fieldErrs[0].Code != "invalid_date" ||
+		!strings.Contains(fieldErrs[0].Message, "YYYY-MM-DD") || !strings.Contains(fieldErrs[0].Message, "RFC 3339") {
+		t.Errorf("errors = %+v, want one on emi_start_date listing both accepted formats", fieldErrs)
+	}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	webhookEventLocked       = "device.locked"
+	webhookEventUnlocked     = "device.unlocked"
+	webhookEventActivated    = "device.activated"
+	webhookEventLockReminder = "device.lock_reminder"
+)
+
+// webhookMaxAttempts and webhookBaseBackoff control redelivery of a webhook
+// that fails; the delay doubles after each attempt
+const (
+	webhookMaxAttempts = 3
+	webhookBaseBackoff = 500 * time.Millisecond
+)
+
+// webhookSignatureHeader carries "sha256=<hex HMAC of the body>" computed
+// with WEBHOOK_SECRET so receivers can verify the sender
+const webhookSignatureHeader = "X-Webhook-Signature"
+
+type WebhookEvent struct {
+	Event        string                 `json:"event"`
+	DeviceID     string                 `json:"device_id"`
+	SerialNumber string                 `json:"serial_number"`
+	Timestamp    time.Time              `json:"timestamp"`
+	Payload      map[string]interface{} `json:"payload"`
+}
+
+var webhookClient = &http.Client{Timeout: 5 * time.Second}
+
+// signWebhook returns the signature header value for a webhook body
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// encodeWebhook builds the body of an event stamped with the current time
+func encodeWebhook(event, deviceID, serialNumber string, payload map[string]interface{}) ([]byte, error) {
+	if payload == nil {
+		payload = map[string]interface{}{}
+	}
+	return json.Marshal(WebhookEvent{
+		Event:        event,
+		DeviceID:     deviceID,
+		SerialNumber: serialNumber,
+		Timestamp:    time.Now().UTC(),
+		Payload:      payload,
+	})
+}
+
+// dispatchWebhook sends an event to WEBHOOK_URL in the background so the API
+// response is never held up by a slow or failing receiver. It does nothing
+// when WEBHOOK_URL is not set.
+func dispatchWebhook(event, deviceID, serialNumber string, payload map[string]interface{}) {
+	webhookURL := os.Getenv("WEBHOOK_URL")
+	if webhookURL == "" {
+		return
+	}
+
+	body, err := encodeWebhook(event, deviceID, serialNumber, payload)
+	if err != nil {
+		logEvent(context.Background(), slog.LevelError, "webhook", fmt.Sprintf("Error encoding webhook %s: %v", event, err),
+			slog.String("device_id", deviceID), slog.String("webhook_event", event))
+		return
+	}
+
+	go deliverWebhook(webhookURL, os.Getenv("WEBHOOK_SECRET"), event, deviceID, body)
+}
+
+// deliverWebhook posts a webhook body, retrying with exponential backoff. It
+// returns the last error when every attempt failed.
+func deliverWebhook(webhookURL, secret, event, deviceID string, body []byte) error {
+	backoff := webhookBaseBackoff
+	var err error
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		err = postWebhook(webhookURL, secret, body)
+		if err == nil {
+			return nil
+		}
+		logEvent(context.Background(), slog.LevelWarn, "webhook", fmt.Sprintf("Webhook %s attempt %d/%d failed: %v", event, attempt, webhookMaxAttempts, err),
+			slog.String("device_id", deviceID), slog.String("webhook_event", event), slog.Int("attempt", attempt))
+		if attempt < webhookMaxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	logEvent(context.Background(), slog.LevelError, "webhook", fmt.Sprintf("Webhook %s dropped after %d attempts", event, webhookMaxAttempts),
+		slog.String("device_id", deviceID), slog.String("webhook_event", event))
+	return err
+}
+
+func postWebhook(webhookURL, secret string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(webhookSignatureHeader, signWebhook(secret, body))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("receiver returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type receivedWebhook struct {
+	signature string
+	body      []byte
+}
+
+// webhookReceiver starts a server that records each webhook it receives and
+// points WEBHOOK_URL at it
+func webhookReceiver(t *testing.T) <-chan receivedWebhook {
+	t.Helper()
+	received := make(chan receivedWebhook, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedWebhook{signature: r.Header.Get(webhookSignatureHeader), body: body}
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("WEBHOOK_URL", server.URL)
+	return received
+}
+
+// nextWebhook waits for the receiver to get a webhook
+func nextWebhook(t *testing.T, received <-chan receivedWebhook) receivedWebhook {
+	t.Helper()
+	select {
+	case hook := <-received:
+		return hook
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook was delivered")
+		return receivedWebhook{}
+	}
+}
+
+func TestDispatchWebhookSignsPayload(t *testing.T) {
+	received := webhookReceiver(t)
+	t.Setenv("WEBHOOK_SECRET", "test-webhook-secret")
+
+	dispatchWebhook(webhookEventLocked, "d1", "TV100001", map[string]interface{}{"source": "remote"})
+	hook := nextWebhook(t, received)
+
+	if want := signWebhook("test-webhook-secret", hook.body); hook.signature != want {
+		t.Errorf("signature = %q, want %q", hook.signature, want)
+	}
+	var event WebhookEvent
+	if err := json.Unmarshal(hook.body, &event); err != nil {
+		t.Fatalf("decoding webhook %q: %v", hook.body, err)
+	}
+	if event.Event != webhookEventLocked || event.DeviceID != "d1" || event.SerialNumber != "TV100001" {
+		t.Errorf("event = %+v, want device.locked for d1/TV100001", event)
+	}
+	if event.Payload["source"] != "remote" {
+		t.Errorf("payload = %v, want source remote", event.Payload)
+	}
+	if event.Timestamp.IsZero() {
+		t.Error("timestamp is not set")
+	}
+}
+
+func TestDispatchWebhookWithoutSecretIsUnsigned(t *testing.T) {
+	received := webhookReceiver(t)
+	t.Setenv("WEBHOOK_SECRET", "")
+
+	dispatchWebhook(webhookEventUnlocked, "d1", "TV100001", nil)
+	hook := nextWebhook(t, received)
+
+	if hook.signature != "" {
+		t.Errorf("signature = %q, want none without WEBHOOK_SECRET", hook.signature)
+	}
+}